@@ -0,0 +1,731 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/decimal128"
+	"github.com/apache/arrow-go/v18/arrow/decimal256"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/shopspring/decimal"
+)
+
+// arrowFileMagic starts every Arrow IPC file (and so every Feather v2 file); streams have no magic
+var arrowFileMagic = []byte("ARROW1")
+
+// ArrowOptions controls how Arrow IPC files are written
+type ArrowOptions struct {
+	// Stream writes the IPC stream format instead of the random-access file (Feather v2) format
+	Stream bool `json:"stream"`
+	// Compression is the record batch body codec: lz4, zstd or none (default)
+	Compression string `json:"compression"`
+}
+
+// arrowWriterOptions returns the IPC writer options for a schema and compression option
+func arrowWriterOptions(schema *arrow.Schema, compression string) ([]ipc.Option, error) {
+	options := []ipc.Option{ipc.WithSchema(schema), ipc.WithAllocator(memory.DefaultAllocator)}
+	switch strings.ToLower(compression) {
+	case "", "none", "uncompressed":
+	case "lz4":
+		options = append(options, ipc.WithLZ4())
+	case "zstd":
+		options = append(options, ipc.WithZstd())
+	default:
+		return nil, fmt.Errorf("unsupported Arrow compression: %q", compression)
+	}
+	return options, nil
+}
+
+// arrowColumnType maps an Arrow type onto a ClickHouse type. Types without a ClickHouse
+// counterpart are read as text.
+func arrowColumnType(dt arrow.DataType) string {
+	switch t := dt.(type) {
+	case *arrow.BooleanType:
+		return "Bool"
+	case *arrow.Int8Type:
+		return "Int8"
+	case *arrow.Int16Type:
+		return "Int16"
+	case *arrow.Int32Type, *arrow.Time32Type:
+		return "Int32"
+	case *arrow.Int64Type, *arrow.Time64Type, *arrow.DurationType:
+		return "Int64"
+	case *arrow.Uint8Type:
+		return "UInt8"
+	case *arrow.Uint16Type:
+		return "UInt16"
+	case *arrow.Uint32Type:
+		return "UInt32"
+	case *arrow.Uint64Type:
+		return "UInt64"
+	case *arrow.Float16Type, *arrow.Float32Type:
+		return "Float32"
+	case *arrow.Float64Type:
+		return "Float64"
+	case *arrow.Decimal128Type:
+		return fmt.Sprintf("Decimal(%d, %d)", t.Precision, t.Scale)
+	case *arrow.Decimal256Type:
+		return fmt.Sprintf("Decimal(%d, %d)", t.Precision, t.Scale)
+	case *arrow.Date32Type, *arrow.Date64Type:
+		return "Date32"
+	case *arrow.TimestampType:
+		if t.Unit == arrow.Second {
+			if t.TimeZone != "" {
+				return fmt.Sprintf("DateTime('%s')", t.TimeZone)
+			}
+			return "DateTime"
+		}
+		precision := map[arrow.TimeUnit]int{arrow.Millisecond: 3, arrow.Microsecond: 6, arrow.Nanosecond: 9}[t.Unit]
+		if t.TimeZone != "" {
+			return fmt.Sprintf("DateTime64(%d, '%s')", precision, t.TimeZone)
+		}
+		return fmt.Sprintf("DateTime64(%d)", precision)
+	case *arrow.FixedSizeBinaryType:
+		return fmt.Sprintf("FixedString(%d)", t.ByteWidth)
+	case *arrow.MapType:
+		return fmt.Sprintf("Map(%s, %s)", arrowColumnType(t.KeyType()), arrowFieldType(t.ItemField()))
+	case arrow.ListLikeType:
+		return fmt.Sprintf("Array(%s)", arrowFieldType(t.ElemField()))
+	case *arrow.StructType:
+		fields := make([]string, len(t.Fields()))
+		for i, field := range t.Fields() {
+			fields[i] = fmt.Sprintf("%s %s", quoteIdentifier(field.Name), arrowFieldType(field))
+		}
+		return fmt.Sprintf("Tuple(%s)", strings.Join(fields, ", "))
+	case *arrow.DictionaryType:
+		return fmt.Sprintf("LowCardinality(%s)", arrowColumnType(t.ValueType))
+	case *arrow.NullType:
+		return "Nullable(String)"
+	}
+	return "String"
+}
+
+// arrowFieldType returns the ClickHouse type of a field, Nullable when the field is. ClickHouse
+// has no Nullable containers, so those read NULL as empty.
+func arrowFieldType(field arrow.Field) string {
+	chType := arrowColumnType(field.Type)
+	if base, _ := unwrapType(chType); field.Nullable && !isContainerType(base) {
+		return nullableType(chType)
+	}
+	return chType
+}
+
+// arrowValue returns the i-th value of an Arrow array as the Go value the ClickHouse driver
+// expects for its arrowColumnType
+func arrowValue(arr arrow.Array, i int) interface{} {
+	if arr.IsNull(i) {
+		return nil
+	}
+
+	switch a := arr.(type) {
+	case *array.Boolean:
+		return a.Value(i)
+	case *array.Int8:
+		return a.Value(i)
+	case *array.Int16:
+		return a.Value(i)
+	case *array.Int32:
+		return a.Value(i)
+	case *array.Int64:
+		return a.Value(i)
+	case *array.Uint8:
+		return a.Value(i)
+	case *array.Uint16:
+		return a.Value(i)
+	case *array.Uint32:
+		return a.Value(i)
+	case *array.Uint64:
+		return a.Value(i)
+	case *array.Float16:
+		return a.Value(i).Float32()
+	case *array.Float32:
+		return a.Value(i)
+	case *array.Float64:
+		return a.Value(i)
+	case *array.Time32:
+		return int32(a.Value(i))
+	case *array.Time64:
+		return int64(a.Value(i))
+	case *array.Duration:
+		return int64(a.Value(i))
+	case *array.Decimal128:
+		scale := a.DataType().(*arrow.Decimal128Type).Scale
+		return decimal.NewFromBigInt(a.Value(i).BigInt(), -scale)
+	case *array.Decimal256:
+		scale := a.DataType().(*arrow.Decimal256Type).Scale
+		return decimal.NewFromBigInt(a.Value(i).BigInt(), -scale)
+	case *array.Date32:
+		return a.Value(i).ToTime()
+	case *array.Date64:
+		return a.Value(i).ToTime()
+	case *array.Timestamp:
+		return a.Value(i).ToTime(a.DataType().(*arrow.TimestampType).Unit)
+	case *array.String:
+		return strings.Clone(a.Value(i))
+	case *array.LargeString:
+		return strings.Clone(a.Value(i))
+	case *array.Binary:
+		return string(a.Value(i))
+	case *array.LargeBinary:
+		return string(a.Value(i))
+	case *array.FixedSizeBinary:
+		return string(a.Value(i))
+	case *array.Map:
+		start, end := a.ValueOffsets(i)
+		keys, items := a.Keys(), a.Items()
+		m := make(map[string]interface{}, end-start)
+		for j := int(start); j < int(end); j++ {
+			m[formatFlatValue(arrowValue(keys, j), "")] = arrowValue(items, j)
+		}
+		return m
+	case array.ListLike:
+		start, end := a.ValueOffsets(i)
+		values := a.ListValues()
+		items := make([]interface{}, 0, end-start)
+		for j := int(start); j < int(end); j++ {
+			items = append(items, arrowValue(values, j))
+		}
+		return items
+	case *array.Struct:
+		fields := a.DataType().(*arrow.StructType).Fields()
+		tuple := make(map[string]interface{}, len(fields))
+		for f, field := range fields {
+			tuple[field.Name] = arrowValue(a.Field(f), i)
+		}
+		return tuple
+	case *array.Dictionary:
+		return arrowValue(a.Dictionary(), a.GetValueIndex(i))
+	}
+	return arr.ValueStr(i)
+}
+
+// arrowReader is the part of the IPC file and stream readers the client uses
+type arrowReader interface {
+	Schema() *arrow.Schema
+	// Read returns the next record batch, valid until the next call, or io.EOF
+	Read() (arrow.RecordBatch, error)
+}
+
+// openArrowFile opens an Arrow IPC file or stream, telling them apart by the file magic. Files
+// need random access, so compressed ones are decompressed to a temporary file first.
+func (f *FlatFileClient) openArrowFile(track bool) (arrowReader, io.Closer, error) {
+	input, err := f.openInput(track)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := make([]byte, len(arrowFileMagic))
+	n, _ := io.ReadFull(input, header)
+
+	if !bytes.Equal(header[:n], arrowFileMagic) {
+		// Streams are read in one pass, so the bytes already read are put back in front
+		reader, err := ipc.NewReader(io.MultiReader(bytes.NewReader(header[:n]), input), ipc.WithAllocator(memory.DefaultAllocator))
+		if err != nil {
+			input.Close()
+			return nil, nil, fmt.Errorf("failed to read Arrow stream: %w", err)
+		}
+		return reader, closerFunc(func() error {
+			reader.Release()
+			return input.Close()
+		}), nil
+	}
+	input.Close()
+
+	file, closer, _, err := f.openRandomAccess()
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := ipc.NewFileReader(file, ipc.WithAllocator(memory.DefaultAllocator))
+	if err != nil {
+		closer.Close()
+		return nil, nil, fmt.Errorf("failed to read Arrow file: %w", err)
+	}
+	return reader, closerFunc(func() error {
+		err := reader.Close()
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}), nil
+}
+
+// closerFunc adapts a function to io.Closer
+type closerFunc func() error
+
+func (c closerFunc) Close() error { return c() }
+
+// arrowSchema returns the top-level fields of an Arrow file with their ClickHouse types
+func (f *FlatFileClient) arrowSchema() ([]Column, error) {
+	reader, closer, err := f.openArrowFile(false)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	fields := reader.Schema().Fields()
+	columns := make([]Column, len(fields))
+	for i, field := range fields {
+		columns[i] = Column{Name: field.Name, Type: arrowFieldType(field)}
+	}
+	return columns, nil
+}
+
+// arrowRecordIterator streams an Arrow file's record batches, split into batches of at most
+// defaultRecordBatchRows rows
+type arrowRecordIterator struct {
+	file   io.Closer
+	reader arrowReader
+	schema []Column
+	// fieldIndices holds the position in the Arrow schema of each schema column
+	fieldIndices []int
+	// record is the Arrow record batch being read and offset the next row in it
+	record arrow.RecordBatch
+	offset int
+}
+
+// Schema returns the selected columns in selection order
+func (it *arrowRecordIterator) Schema() []Column {
+	return it.schema
+}
+
+// Next converts up to defaultRecordBatchRows rows of the current Arrow record batch, reading
+// the next one once it is exhausted
+func (it *arrowRecordIterator) Next() (*RecordBatch, error) {
+	for it.record == nil || it.offset >= int(it.record.NumRows()) {
+		record, err := it.reader.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Arrow record batch: %w", err)
+		}
+		it.record, it.offset = record, 0
+	}
+
+	rows := min(int(it.record.NumRows())-it.offset, defaultRecordBatchRows)
+	batch := NewRecordBatch(it.schema, rows)
+	for r := it.offset; r < it.offset+rows; r++ {
+		values := make([]interface{}, len(it.fieldIndices))
+		for i, field := range it.fieldIndices {
+			values[i] = arrowValue(it.record.Column(field), r)
+		}
+		batch.Append(values)
+	}
+	it.offset += rows
+	return batch, nil
+}
+
+// Close releases the reader and closes the underlying file
+func (it *arrowRecordIterator) Close() error {
+	return it.file.Close()
+}
+
+// streamArrow returns an iterator over the selected columns of an Arrow file, in the order they
+// were selected. Selected columns missing from the file are skipped.
+func (f *FlatFileClient) streamArrow(selectedColumns []string) (RecordIterator, error) {
+	reader, closer, err := f.openArrowFile(true)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := reader.Schema().Fields()
+	// If no columns specified, select all
+	if len(selectedColumns) == 0 {
+		for _, field := range fields {
+			selectedColumns = append(selectedColumns, field.Name)
+		}
+	}
+
+	it := &arrowRecordIterator{file: closer, reader: reader}
+	for _, name := range selectedColumns {
+		for i, field := range fields {
+			if field.Name == name {
+				it.schema = append(it.schema, Column{Name: name, Type: arrowFieldType(field)})
+				it.fieldIndices = append(it.fieldIndices, i)
+				break
+			}
+		}
+	}
+	if len(it.schema) == 0 {
+		closer.Close()
+		return nil, fmt.Errorf("none of the selected columns are in %s", f.config.FileName)
+	}
+
+	return it, nil
+}
+
+// arrowDataType maps a ClickHouse type onto an Arrow type and reports whether it is Nullable.
+// Types without an Arrow counterpart are written as strings.
+func arrowDataType(chType string) (arrow.DataType, bool) {
+	base, nullable := unwrapType(chType)
+	switch base {
+	case "Bool":
+		return arrow.FixedWidthTypes.Boolean, nullable
+	case "Int8":
+		return arrow.PrimitiveTypes.Int8, nullable
+	case "Int16":
+		return arrow.PrimitiveTypes.Int16, nullable
+	case "Int32":
+		return arrow.PrimitiveTypes.Int32, nullable
+	case "Int64":
+		return arrow.PrimitiveTypes.Int64, nullable
+	case "UInt8":
+		return arrow.PrimitiveTypes.Uint8, nullable
+	case "UInt16":
+		return arrow.PrimitiveTypes.Uint16, nullable
+	case "UInt32":
+		return arrow.PrimitiveTypes.Uint32, nullable
+	case "UInt64":
+		return arrow.PrimitiveTypes.Uint64, nullable
+	case "Float32":
+		return arrow.PrimitiveTypes.Float32, nullable
+	case "Float64":
+		return arrow.PrimitiveTypes.Float64, nullable
+	case "Date", "Date32":
+		return arrow.FixedWidthTypes.Date32, nullable
+	}
+
+	switch {
+	case decimalTypePattern.MatchString(base):
+		precision, scale, _ := decimalPrecisionScale(base)
+		if precision > 38 {
+			return &arrow.Decimal256Type{Precision: int32(precision), Scale: int32(scale)}, nullable
+		}
+		return &arrow.Decimal128Type{Precision: int32(precision), Scale: int32(scale)}, nullable
+	case base == "DateTime" || strings.HasPrefix(base, "DateTime(") || strings.HasPrefix(base, "DateTime64("):
+		unit := arrow.Second
+		switch precision := dateTimePrecision(base); {
+		case precision > 6:
+			unit = arrow.Nanosecond
+		case precision > 3:
+			unit = arrow.Microsecond
+		case precision > 0:
+			unit = arrow.Millisecond
+		}
+		return &arrow.TimestampType{Unit: unit, TimeZone: dateTimeZone(base)}, nullable
+	case strings.HasPrefix(base, "Array(") && strings.HasSuffix(base, ")"):
+		elem, elemNullable := arrowDataType(base[len("Array(") : len(base)-1])
+		return arrow.ListOfField(arrow.Field{Name: "item", Type: elem, Nullable: elemNullable}), false
+	case strings.HasPrefix(base, "Map(") && strings.HasSuffix(base, ")"):
+		if args := splitTypeArgs(base); len(args) == 2 {
+			key, _ := arrowDataType(args[0])
+			item, itemNullable := arrowDataType(args[1])
+			m := arrow.MapOf(key, item)
+			m.SetItemNullable(itemNullable)
+			return m, false
+		}
+	case strings.HasPrefix(base, "Tuple(") && tupleFieldNames(base) != nil:
+		fieldTypes := tupleFieldTypes(base)
+		var fields []arrow.Field
+		for _, name := range tupleFieldNames(base) {
+			dt, fieldNullable := arrowDataType(fieldTypes[name])
+			fields = append(fields, arrow.Field{Name: name, Type: dt, Nullable: fieldNullable})
+		}
+		return arrow.StructOf(fields...), false
+	}
+	return arrow.BinaryTypes.String, nullable
+}
+
+// dateTimeZone returns the time zone argument of a DateTime or DateTime64 type, defaulting to UTC
+func dateTimeZone(base string) string {
+	open := strings.Index(base, "(")
+	if open < 0 {
+		return "UTC"
+	}
+	args := splitTypeArgs(base)
+	if strings.HasPrefix(base, "DateTime64(") {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return "UTC"
+	}
+	return strings.Trim(strings.TrimSpace(args[0]), "'")
+}
+
+// appendArrowValue appends a value of ClickHouse type chType to an Arrow builder
+func appendArrowValue(b array.Builder, v interface{}, chType string) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+	base, _ := unwrapType(chType)
+
+	switch b := b.(type) {
+	case *array.BooleanBuilder:
+		flag, ok := v.(bool)
+		if !ok {
+			n, err := toInt64(v)
+			if err != nil {
+				return err
+			}
+			flag = n != 0
+		}
+		b.Append(flag)
+	case *array.Int8Builder, *array.Int16Builder, *array.Int32Builder, *array.Int64Builder,
+		*array.Uint8Builder, *array.Uint16Builder, *array.Uint32Builder, *array.Uint64Builder:
+		n, err := toInt64(v)
+		if err != nil {
+			return err
+		}
+		appendArrowInt(b, n)
+	case *array.Float32Builder:
+		f, err := toFloat64(v)
+		if err != nil {
+			return err
+		}
+		b.Append(float32(f))
+	case *array.Float64Builder:
+		f, err := toFloat64(v)
+		if err != nil {
+			return err
+		}
+		b.Append(f)
+	case *array.Decimal128Builder:
+		unscaled, err := unscaledDecimal(v, b.Type().(*arrow.Decimal128Type).Scale)
+		if err != nil {
+			return err
+		}
+		b.Append(decimal128.FromBigInt(unscaled))
+	case *array.Decimal256Builder:
+		unscaled, err := unscaledDecimal(v, b.Type().(*arrow.Decimal256Type).Scale)
+		if err != nil {
+			return err
+		}
+		b.Append(decimal256.FromBigInt(unscaled))
+	case *array.Date32Builder:
+		t, ok := v.(time.Time)
+		if !ok {
+			return fmt.Errorf("expected a date, got %T", v)
+		}
+		y, m, d := t.Date()
+		b.Append(arrow.Date32FromTime(time.Date(y, m, d, 0, 0, 0, 0, time.UTC)))
+	case *array.TimestampBuilder:
+		t, ok := v.(time.Time)
+		if !ok {
+			return fmt.Errorf("expected a time, got %T", v)
+		}
+		ts, err := arrow.TimestampFromTime(t, b.Type().(*arrow.TimestampType).Unit)
+		if err != nil {
+			return err
+		}
+		b.Append(ts)
+	case *array.MapBuilder:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Map {
+			return fmt.Errorf("expected a map, got %T", v)
+		}
+		args := splitTypeArgs(base)
+		b.Append(true)
+		for _, key := range rv.MapKeys() {
+			if err := appendArrowValue(b.KeyBuilder(), key.Interface(), args[0]); err != nil {
+				return err
+			}
+			if err := appendArrowValue(b.ItemBuilder(), rv.MapIndex(key).Interface(), args[1]); err != nil {
+				return err
+			}
+		}
+	case *array.ListBuilder:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return fmt.Errorf("expected an array, got %T", v)
+		}
+		elemType := base[len("Array(") : len(base)-1]
+		b.Append(true)
+		for i := 0; i < rv.Len(); i++ {
+			if err := appendArrowValue(b.ValueBuilder(), rv.Index(i).Interface(), elemType); err != nil {
+				return err
+			}
+		}
+	case *array.StructBuilder:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Map {
+			return fmt.Errorf("expected a tuple, got %T", v)
+		}
+		fieldTypes := tupleFieldTypes(base)
+		b.Append(true)
+		for i, name := range tupleFieldNames(base) {
+			var field interface{}
+			if value := rv.MapIndex(reflect.ValueOf(name)); value.IsValid() {
+				field = value.Interface()
+			}
+			if err := appendArrowValue(b.FieldBuilder(i), field, fieldTypes[name]); err != nil {
+				return err
+			}
+		}
+	case *array.StringBuilder:
+		if isContainerType(base) {
+			// Unnamed tuples and other nested values are written as their JSON text
+			encoded, err := json.Marshal(toJSONValue(v, base))
+			if err != nil {
+				return err
+			}
+			b.Append(string(encoded))
+			return nil
+		}
+		b.Append(formatFlatValue(v, base))
+	default:
+		return fmt.Errorf("unsupported Arrow builder %T", b)
+	}
+	return nil
+}
+
+// appendArrowInt appends n to an integer builder of any width
+func appendArrowInt(b array.Builder, n int64) {
+	switch b := b.(type) {
+	case *array.Int8Builder:
+		b.Append(int8(n))
+	case *array.Int16Builder:
+		b.Append(int16(n))
+	case *array.Int32Builder:
+		b.Append(int32(n))
+	case *array.Int64Builder:
+		b.Append(n)
+	case *array.Uint8Builder:
+		b.Append(uint8(n))
+	case *array.Uint16Builder:
+		b.Append(uint16(n))
+	case *array.Uint32Builder:
+		b.Append(uint32(n))
+	case *array.Uint64Builder:
+		b.Append(uint64(n))
+	}
+}
+
+// unscaledDecimal returns a decimal value as an integer count of units of its scale
+func unscaledDecimal(v interface{}, scale int32) (*big.Int, error) {
+	d, err := toDecimal(v)
+	if err != nil {
+		return nil, err
+	}
+	return d.Shift(scale).Round(0).BigInt(), nil
+}
+
+// arrowWriter is the part of the IPC file and stream writers the sink uses
+type arrowWriter interface {
+	Write(rec arrow.RecordBatch) error
+	Close() error
+}
+
+// arrowRecordSink streams record batches into an Arrow IPC file or stream, one Arrow record
+// batch per incoming batch. The Arrow schema is settled on the first batch, when any column
+// types left open can be taken from the batch.
+type arrowRecordSink struct {
+	output  io.WriteCloser
+	config  ArrowOptions
+	options FileWriterOptions
+	writer  arrowWriter
+	builder *array.RecordBuilder
+	columns []Column
+	// sourceIndex maps each of columns to its position in the incoming schema, or -1
+	sourceIndex []int
+}
+
+// newArrowRecordSink returns a sink writing Arrow IPC data to output, which it closes when done
+func newArrowRecordSink(output io.WriteCloser, config ArrowOptions, options FileWriterOptions) (RecordSink, error) {
+	if _, err := arrowWriterOptions(nil, config.Compression); err != nil {
+		output.Close()
+		return nil, err
+	}
+	return &arrowRecordSink{output: output, config: config, options: options}, nil
+}
+
+// resolveColumns builds the Arrow schema and writer from the configured columns and the schema
+// of the first batch
+func (s *arrowRecordSink) resolveColumns(schema []Column) error {
+	columns := append([]Column(nil), s.options.Columns...)
+	if len(columns) == 0 {
+		columns = append(columns, schema...)
+	}
+
+	fields := make([]arrow.Field, len(columns))
+	s.sourceIndex = make([]int, len(columns))
+	for i := range columns {
+		s.sourceIndex[i] = columnIndex(schema, columns[i].Name)
+		if columns[i].Type == "" {
+			columns[i].Type = "Nullable(String)"
+			if idx := s.sourceIndex[i]; idx >= 0 {
+				columns[i].Type = schema[idx].Type
+			}
+		}
+		dt, nullable := arrowDataType(columns[i].Type)
+		fields[i] = arrow.Field{Name: columns[i].Name, Type: dt, Nullable: nullable}
+	}
+	s.columns = columns
+
+	arrowSchema := arrow.NewSchema(fields, nil)
+	options, err := arrowWriterOptions(arrowSchema, s.config.Compression)
+	if err != nil {
+		return err
+	}
+	if s.config.Stream {
+		s.writer = ipc.NewWriter(s.output, options...)
+	} else if s.writer, err = ipc.NewFileWriter(s.output, options...); err != nil {
+		return fmt.Errorf("failed to start Arrow file: %w", err)
+	}
+	s.builder = array.NewRecordBuilder(memory.DefaultAllocator, arrowSchema)
+	return nil
+}
+
+// WriteBatch converts a batch to the output column types and writes it as one record batch
+func (s *arrowRecordSink) WriteBatch(batch *RecordBatch) error {
+	if s.writer == nil {
+		if err := s.resolveColumns(batch.Schema); err != nil {
+			return err
+		}
+	}
+
+	for _, source := range batch.Rows {
+		for i, col := range s.columns {
+			var value interface{}
+			var sourceType string
+			if idx := s.sourceIndex[i]; idx >= 0 && idx < len(source) {
+				value, sourceType = source[idx], batch.Schema[idx].Type
+			}
+			value, err := castValue(value, sourceType, col, s.options.NullOnParseError)
+			if err != nil {
+				return err
+			}
+
+			if _, nullable := unwrapType(col.Type); value == nil && !nullable && !isContainerType(col.Type) {
+				return fmt.Errorf("column %s: NULL in a non-Nullable column", col.Name)
+			}
+			if err := appendArrowValue(s.builder.Field(i), value, col.Type); err != nil {
+				return fmt.Errorf("column %s: %w", col.Name, err)
+			}
+		}
+	}
+
+	record := s.builder.NewRecordBatch()
+	defer record.Release()
+	if err := s.writer.Write(record); err != nil {
+		return fmt.Errorf("failed to write Arrow record batch: %w", err)
+	}
+	return nil
+}
+
+// Flush writes the stream end marker or file footer
+func (s *arrowRecordSink) Flush() error {
+	if s.writer == nil {
+		if err := s.resolveColumns(nil); err != nil {
+			return err
+		}
+	}
+	s.builder.Release()
+	if err := s.writer.Close(); err != nil {
+		return fmt.Errorf("failed to finish Arrow file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the underlying file, finishing any compressed stream
+func (s *arrowRecordSink) Close() error {
+	return s.output.Close()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestArrowFileAndStream(t *testing.T) {
+	schema := []Column{{Name: "id", Type: "Int64"}, {Name: "name", Type: "Nullable(String)"}}
+	rows := [][]interface{}{{int64(1), "a"}, {int64(2), nil}, {int64(3), "c"}}
+	tests := []struct {
+		name     string
+		options  ArrowOptions
+		wantFile bool
+	}{
+		{name: "file", wantFile: true},
+		{name: "file with lz4", options: ArrowOptions{Compression: "lz4"}, wantFile: true},
+		{name: "file with zstd", options: ArrowOptions{Compression: "zstd"}, wantFile: true},
+		{name: "stream", options: ArrowOptions{Stream: true}},
+		{name: "stream with zstd", options: ArrowOptions{Stream: true, Compression: "zstd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := FlatFileConfig{FileName: filepath.Join(t.TempDir(), "rows.arrow"), Arrow: tt.options}
+			writeFlatFile(t, config, schema, rows)
+			_, got := readFlatFile(t, config, nil)
+			assertRows(t, got, rows)
+
+			data, err := os.ReadFile(config.FileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The random-access file format starts with its magic; the stream format does not
+			if isFile := bytes.HasPrefix(data, arrowFileMagic); isFile != tt.wantFile {
+				t.Errorf("wrote the file format: %v, want %v", isFile, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestArrowTimestamps(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	tests := []struct {
+		chType   string
+		wantUnit arrow.TimeUnit
+		wantType string
+		want     time.Time
+	}{
+		{"DateTime", arrow.Second, "DateTime('UTC')", at.Truncate(time.Second)},
+		{"DateTime('Europe/Berlin')", arrow.Second, "DateTime('Europe/Berlin')", at.Truncate(time.Second)},
+		{"DateTime64(3)", arrow.Millisecond, "DateTime64(3, 'UTC')", at.Truncate(time.Millisecond)},
+		{"DateTime64(6, 'Asia/Tokyo')", arrow.Microsecond, "DateTime64(6, 'Asia/Tokyo')", at.Truncate(time.Microsecond)},
+		{"DateTime64(9)", arrow.Nanosecond, "DateTime64(9, 'UTC')", at},
+	}
+	for _, tt := range tests {
+		t.Run(tt.chType, func(t *testing.T) {
+			config := FlatFileConfig{FileName: filepath.Join(t.TempDir(), "times.arrow")}
+			writeFlatFile(t, config, []Column{{Name: "at", Type: tt.chType}}, [][]interface{}{{at}})
+
+			reader, closer, err := NewFlatFileClient(config).openArrowFile(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored := reader.Schema().Field(0).Type.(*arrow.TimestampType)
+			closer.Close()
+			if stored.Unit != tt.wantUnit {
+				t.Errorf("stored in %v, want %v", stored.Unit, tt.wantUnit)
+			}
+
+			gotSchema, got := readFlatFile(t, config, nil)
+			if gotSchema[0].Type != tt.wantType {
+				t.Errorf("read back as %s, want %s", gotSchema[0].Type, tt.wantType)
+			}
+			if value, ok := got[0][0].(time.Time); !ok || !value.Equal(tt.want) {
+				t.Errorf("value = %v, want %v", got[0][0], tt.want)
+			}
+		})
+	}
+}
+
+func TestArrowDictionary(t *testing.T) {
+	// Other tools write repetitive text dictionary encoded, which reads as LowCardinality
+	dictType := &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int8, ValueType: arrow.BinaryTypes.String}
+	schema := arrow.NewSchema([]arrow.Field{{Name: "colour", Type: dictType, Nullable: true}}, nil)
+	builder := array.NewDictionaryBuilder(memory.DefaultAllocator, dictType).(*array.BinaryDictionaryBuilder)
+	defer builder.Release()
+	for _, colour := range []string{"red", "green", "red"} {
+		if err := builder.AppendString(colour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	builder.AppendNull()
+	column := builder.NewArray()
+	defer column.Release()
+	record := array.NewRecordBatch(schema, []arrow.Array{column}, int64(column.Len()))
+	defer record.Release()
+
+	name := filepath.Join(t.TempDir(), "colours.arrow")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := ipc.NewFileWriter(file, ipc.WithSchema(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	gotSchema, got := readFlatFile(t, FlatFileConfig{FileName: name}, nil)
+	if want := []Column{{Name: "colour", Type: "LowCardinality(Nullable(String))"}}; !reflect.DeepEqual(gotSchema, want) {
+		t.Errorf("schema = %v, want %v", gotSchema, want)
+	}
+	assertRows(t, got, [][]interface{}{{"red"}, {"green"}, {"red"}, {nil}})
+}
+
+func TestArrowWriterOptions(t *testing.T) {
+	tests := []struct {
+		compression string
+		wantError   bool
+	}{
+		{"", false},
+		{"none", false},
+		{"LZ4", false},
+		{"zstd", false},
+		{"snappy", true},
+	}
+	for _, tt := range tests {
+		if _, err := arrowWriterOptions(nil, tt.compression); (err != nil) != tt.wantError {
+			t.Errorf("arrowWriterOptions(%q) error = %v, want an error: %v", tt.compression, err, tt.wantError)
+		}
+	}
+}
@@ -0,0 +1,679 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/ocf"
+	"github.com/shopspring/decimal"
+)
+
+// defaultAvroRecordName names the record schema of written Avro files
+const defaultAvroRecordName = "Row"
+
+// AvroOptions controls how Avro object container files are written
+type AvroOptions struct {
+	// Codec is the block codec: deflate (default), snappy, zstandard or null
+	Codec string `json:"codec"`
+	// RecordName is the name of the generated record schema; empty uses "Row"
+	RecordName string `json:"recordName"`
+}
+
+// avroCodec returns the OCF codec named by a codec option
+func avroCodec(name string) (ocf.CodecName, error) {
+	switch strings.ToLower(name) {
+	case "", "deflate":
+		return ocf.Deflate, nil
+	case "snappy":
+		return ocf.Snappy, nil
+	case "zstandard", "zstd":
+		return ocf.ZStandard, nil
+	case "null", "none":
+		return ocf.Null, nil
+	}
+	return "", fmt.Errorf("unsupported Avro codec: %q", name)
+}
+
+// avroReadColumn converts the decoded values of one Avro field into ClickHouse values
+type avroReadColumn struct {
+	chType  string
+	convert func(interface{}) interface{}
+	// emptyNull reads NULL through convert, which gives an empty array or map
+	emptyNull bool
+}
+
+// passValue returns a value unchanged
+func passValue(v interface{}) interface{} { return v }
+
+// newAvroReadColumn maps an Avro schema onto a ClickHouse type. Unions with null become
+// Nullable, other unions are read as text.
+func newAvroReadColumn(schema avro.Schema) avroReadColumn {
+	switch s := schema.(type) {
+	case *avro.RefSchema:
+		return newAvroReadColumn(s.Schema())
+	case *avro.UnionSchema:
+		var types []avro.Schema
+		for _, t := range s.Types() {
+			if t.Type() != avro.Null {
+				types = append(types, t)
+			}
+		}
+		nullable := len(types) < len(s.Types())
+		if len(types) != 1 {
+			col := avroReadColumn{chType: "String", convert: jsonText}
+			if nullable {
+				col.chType = "Nullable(String)"
+			}
+			return col
+		}
+		col := newAvroReadColumn(types[0])
+		if _, primitive := types[0].(*avro.PrimitiveSchema); !primitive {
+			col.convert = unwrapAvroBranch(avroBranchName(types[0]), col.convert)
+		}
+		// ClickHouse has no Nullable containers, so a NULL array or map reads as an empty one
+		if base, _ := unwrapType(col.chType); nullable && !isContainerType(base) {
+			col.chType = nullableType(col.chType)
+		} else if nullable && !strings.HasPrefix(base, "Tuple(") {
+			col.emptyNull = true
+		}
+		return col
+	case *avro.RecordSchema:
+		fields := make([]string, len(s.Fields()))
+		columns := make([]avroReadColumn, len(s.Fields()))
+		for i, field := range s.Fields() {
+			columns[i] = newAvroReadColumn(field.Type())
+			fields[i] = fmt.Sprintf("%s %s", quoteIdentifier(field.Name()), columns[i].chType)
+		}
+		return avroReadColumn{chType: fmt.Sprintf("Tuple(%s)", strings.Join(fields, ", ")), convert: func(v interface{}) interface{} {
+			record, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			tuple := make(map[string]interface{}, len(columns))
+			for i, field := range s.Fields() {
+				tuple[field.Name()] = avroConvert(columns[i], record[field.Name()])
+			}
+			return tuple
+		}}
+	case *avro.ArraySchema:
+		items := newAvroReadColumn(s.Items())
+		return avroReadColumn{chType: fmt.Sprintf("Array(%s)", items.chType), convert: func(v interface{}) interface{} {
+			values, _ := v.([]interface{})
+			converted := make([]interface{}, len(values))
+			for i, item := range values {
+				converted[i] = avroConvert(items, item)
+			}
+			return converted
+		}}
+	case *avro.MapSchema:
+		values := newAvroReadColumn(s.Values())
+		return avroReadColumn{chType: fmt.Sprintf("Map(String, %s)", values.chType), convert: func(v interface{}) interface{} {
+			entries, _ := v.(map[string]interface{})
+			converted := make(map[string]interface{}, len(entries))
+			for key, value := range entries {
+				converted[key] = avroConvert(values, value)
+			}
+			return converted
+		}}
+	case *avro.EnumSchema:
+		return avroReadColumn{chType: "LowCardinality(String)", convert: passValue}
+	case *avro.FixedSchema:
+		if dec, ok := s.Logical().(*avro.DecimalLogicalSchema); ok {
+			return avroDecimalColumn(dec)
+		}
+		return avroReadColumn{chType: fmt.Sprintf("FixedString(%d)", s.Size()), convert: func(v interface{}) interface{} {
+			rv := reflect.ValueOf(v)
+			if rv.Kind() != reflect.Array {
+				return nil
+			}
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return string(b)
+		}}
+	case *avro.PrimitiveSchema:
+		return avroPrimitiveColumn(s)
+	}
+	return avroReadColumn{chType: "String", convert: jsonText}
+}
+
+// avroPrimitiveColumn maps a primitive Avro type onto a ClickHouse type, preferring its logical type
+func avroPrimitiveColumn(s *avro.PrimitiveSchema) avroReadColumn {
+	if logical := s.Logical(); logical != nil {
+		switch logical.Type() {
+		case avro.Date:
+			return avroReadColumn{chType: "Date32", convert: passValue}
+		case avro.TimestampMillis, avro.LocalTimestampMillis:
+			return avroTimestampColumn(3, time.UnixMilli)
+		case avro.TimestampMicros, avro.LocalTimestampMicros:
+			return avroTimestampColumn(6, time.UnixMicro)
+		case avro.TimeMillis:
+			// Times of day are kept as their count of units since midnight
+			return avroReadColumn{chType: "Int32", convert: func(v interface{}) interface{} {
+				if d, ok := v.(time.Duration); ok {
+					return int32(d.Milliseconds())
+				}
+				return v
+			}}
+		case avro.TimeMicros:
+			return avroReadColumn{chType: "Int64", convert: func(v interface{}) interface{} {
+				if d, ok := v.(time.Duration); ok {
+					return d.Microseconds()
+				}
+				return v
+			}}
+		case avro.Decimal:
+			if dec, ok := logical.(*avro.DecimalLogicalSchema); ok {
+				return avroDecimalColumn(dec)
+			}
+		case avro.UUID:
+			return avroReadColumn{chType: "UUID", convert: func(v interface{}) interface{} {
+				if id, err := uuid.Parse(fmt.Sprint(v)); err == nil {
+					return id
+				}
+				return v
+			}}
+		}
+	}
+
+	switch s.Type() {
+	case avro.Boolean:
+		return avroReadColumn{chType: "Bool", convert: passValue}
+	case avro.Int:
+		return avroReadColumn{chType: "Int32", convert: func(v interface{}) interface{} {
+			n, err := toInt64(v)
+			if err != nil {
+				return nil
+			}
+			return int32(n)
+		}}
+	case avro.Long:
+		return avroReadColumn{chType: "Int64", convert: func(v interface{}) interface{} {
+			n, err := toInt64(v)
+			if err != nil {
+				return nil
+			}
+			return n
+		}}
+	case avro.Float:
+		return avroReadColumn{chType: "Float32", convert: passValue}
+	case avro.Double:
+		return avroReadColumn{chType: "Float64", convert: passValue}
+	case avro.Bytes:
+		return avroReadColumn{chType: "String", convert: func(v interface{}) interface{} {
+			if b, ok := v.([]byte); ok {
+				return string(b)
+			}
+			return v
+		}}
+	case avro.Null:
+		return avroReadColumn{chType: "Nullable(String)", convert: passValue}
+	}
+	return avroReadColumn{chType: "String", convert: passValue}
+}
+
+// avroTimestampColumn reads timestamps as DateTime64 of the given precision. Local timestamps
+// decode as plain counts of units, which fromUnits turns into times.
+func avroTimestampColumn(precision int, fromUnits func(int64) time.Time) avroReadColumn {
+	return avroReadColumn{chType: fmt.Sprintf("DateTime64(%d)", precision), convert: func(v interface{}) interface{} {
+		if t, ok := v.(time.Time); ok {
+			return t.UTC()
+		}
+		n, err := toInt64(v)
+		if err != nil {
+			return nil
+		}
+		return fromUnits(n).UTC()
+	}}
+}
+
+// avroDecimalColumn reads decimals, which decode as exact rationals, at their declared scale
+func avroDecimalColumn(dec *avro.DecimalLogicalSchema) avroReadColumn {
+	scale := int32(dec.Scale())
+	return avroReadColumn{chType: fmt.Sprintf("Decimal(%d, %d)", dec.Precision(), dec.Scale()), convert: func(v interface{}) interface{} {
+		r, ok := v.(*big.Rat)
+		if !ok {
+			return nil
+		}
+		return decimal.NewFromBigRat(r, scale)
+	}}
+}
+
+// avroBranchName returns the name the decoder gives a union branch: the full name of a
+// named type, otherwise the type itself
+func avroBranchName(schema avro.Schema) string {
+	if ref, ok := schema.(*avro.RefSchema); ok {
+		schema = ref.Schema()
+	}
+	if named, ok := schema.(avro.NamedSchema); ok {
+		return named.FullName()
+	}
+	return string(schema.Type())
+}
+
+// unwrapAvroBranch converts union values, which the decoder wraps in a map keyed by the
+// branch name when the branch is not a primitive type
+func unwrapAvroBranch(name string, convert func(interface{}) interface{}) func(interface{}) interface{} {
+	return func(v interface{}) interface{} {
+		if wrapped, ok := v.(map[string]interface{}); ok && len(wrapped) == 1 {
+			if inner, ok := wrapped[name]; ok {
+				v = inner
+			}
+		}
+		return convert(v)
+	}
+}
+
+// avroConvert converts a decoded value, keeping NULL as nil unless the column reads it as empty
+func avroConvert(col avroReadColumn, v interface{}) interface{} {
+	if v == nil && !col.emptyNull {
+		return nil
+	}
+	return col.convert(v)
+}
+
+// isContainerType reports whether a ClickHouse type is an Array, Map or Tuple
+func isContainerType(base string) bool {
+	return strings.HasPrefix(base, "Array(") || strings.HasPrefix(base, "Map(") || strings.HasPrefix(base, "Tuple(")
+}
+
+// openAvroFile opens the configured file and reads its header, which carries the writer schema
+func (f *FlatFileClient) openAvroFile(track bool) (*flatFileReader, *ocf.Decoder, *avro.RecordSchema, error) {
+	file, err := f.openInput(track)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	dec, err := ocf.NewDecoder(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, fmt.Errorf("failed to read Avro file: %w", err)
+	}
+	record, ok := dec.Schema().(*avro.RecordSchema)
+	if !ok {
+		file.Close()
+		return nil, nil, nil, fmt.Errorf("Avro file holds %s values, expected records", dec.Schema().Type())
+	}
+	return file, dec, record, nil
+}
+
+// avroSchema returns the fields of an Avro file's writer schema with their ClickHouse types
+func (f *FlatFileClient) avroSchema() ([]Column, error) {
+	file, _, record, err := f.openAvroFile(false)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	columns := make([]Column, len(record.Fields()))
+	for i, field := range record.Fields() {
+		columns[i] = Column{Name: field.Name(), Type: newAvroReadColumn(field.Type()).chType}
+	}
+	return columns, nil
+}
+
+// avroRecordIterator streams record batches from an Avro object container file
+type avroRecordIterator struct {
+	file    io.Closer
+	dec     *ocf.Decoder
+	schema  []Column
+	columns []avroReadColumn
+}
+
+// Schema returns the selected columns in selection order
+func (it *avroRecordIterator) Schema() []Column {
+	return it.schema
+}
+
+// Next decodes up to defaultRecordBatchRows records into a batch
+func (it *avroRecordIterator) Next() (*RecordBatch, error) {
+	batch := NewRecordBatch(it.schema, defaultRecordBatchRows)
+	for batch.Len() < defaultRecordBatchRows && it.dec.HasNext() {
+		var record map[string]interface{}
+		if err := it.dec.Decode(&record); err != nil {
+			return nil, fmt.Errorf("failed to decode Avro record: %w", err)
+		}
+
+		values := make([]interface{}, len(it.schema))
+		for i, col := range it.schema {
+			values[i] = avroConvert(it.columns[i], record[col.Name])
+		}
+		batch.Append(values)
+	}
+	if err := it.dec.Error(); err != nil {
+		return nil, fmt.Errorf("failed to read Avro file: %w", err)
+	}
+
+	if batch.Len() == 0 {
+		return nil, io.EOF
+	}
+	return batch, nil
+}
+
+// Close closes the underlying file
+func (it *avroRecordIterator) Close() error {
+	return it.file.Close()
+}
+
+// streamAvro returns an iterator over the selected fields of an Avro file, in the order they were
+// selected. Selected fields missing from the writer schema are skipped.
+func (f *FlatFileClient) streamAvro(selectedColumns []string) (RecordIterator, error) {
+	file, dec, record, err := f.openAvroFile(true)
+	if err != nil {
+		return nil, err
+	}
+
+	// If no columns specified, select all
+	if len(selectedColumns) == 0 {
+		for _, field := range record.Fields() {
+			selectedColumns = append(selectedColumns, field.Name())
+		}
+	}
+
+	it := &avroRecordIterator{file: file, dec: dec}
+	for _, name := range selectedColumns {
+		for _, field := range record.Fields() {
+			if field.Name() == name {
+				col := newAvroReadColumn(field.Type())
+				it.schema = append(it.schema, Column{Name: name, Type: col.chType})
+				it.columns = append(it.columns, col)
+				break
+			}
+		}
+	}
+	if len(it.schema) == 0 {
+		file.Close()
+		return nil, fmt.Errorf("none of the selected columns are in %s", f.config.FileName)
+	}
+
+	return it, nil
+}
+
+// avroWriteColumn converts ClickHouse values into the values of one Avro field
+type avroWriteColumn struct {
+	schema  interface{}
+	convert func(interface{}) (interface{}, error)
+}
+
+// newAvroWriteColumn maps a ClickHouse type onto an Avro schema; Nullable types become unions with null
+func newAvroWriteColumn(chType string) avroWriteColumn {
+	base, nullable := unwrapType(chType)
+	col := avroWriteColumnOf(base)
+	if nullable {
+		col.schema = []interface{}{"null", col.schema}
+	}
+	return col
+}
+
+// avroWriteColumnOf picks the Avro type for a ClickHouse base type; types without an Avro
+// counterpart are written as strings
+func avroWriteColumnOf(base string) avroWriteColumn {
+	switch {
+	case base == "Bool":
+		return avroWriteColumn{schema: "boolean", convert: func(v interface{}) (interface{}, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			n, err := toInt64(v)
+			return n != 0, err
+		}}
+	case base == "Int8" || base == "Int16" || base == "Int32" || base == "UInt8" || base == "UInt16":
+		return avroWriteColumn{schema: "int", convert: func(v interface{}) (interface{}, error) {
+			n, err := toInt64(v)
+			return int32(n), err
+		}}
+	case base == "Int64" || base == "UInt32" || base == "UInt64":
+		return avroWriteColumn{schema: "long", convert: func(v interface{}) (interface{}, error) {
+			if u, ok := v.(uint64); ok && u > math.MaxInt64 {
+				return nil, fmt.Errorf("value %d does not fit an Avro long", u)
+			}
+			return toInt64(v)
+		}}
+	case base == "Float32":
+		return avroWriteColumn{schema: "float", convert: func(v interface{}) (interface{}, error) {
+			f, err := toFloat64(v)
+			return float32(f), err
+		}}
+	case base == "Float64":
+		return avroWriteColumn{schema: "double", convert: func(v interface{}) (interface{}, error) {
+			return toFloat64(v)
+		}}
+	case decimalTypePattern.MatchString(base):
+		precision, scale, _ := decimalPrecisionScale(base)
+		schema := map[string]interface{}{"type": "bytes", "logicalType": "decimal", "precision": precision, "scale": scale}
+		return avroWriteColumn{schema: schema, convert: func(v interface{}) (interface{}, error) {
+			d, err := toDecimal(v)
+			if err != nil {
+				return nil, err
+			}
+			return d.Round(int32(scale)).Rat(), nil
+		}}
+	case base == "Date" || base == "Date32":
+		return avroWriteColumn{schema: map[string]interface{}{"type": "int", "logicalType": "date"}, convert: avroTime}
+	case base == "DateTime" || strings.HasPrefix(base, "DateTime(") || strings.HasPrefix(base, "DateTime64("):
+		logicalType := "timestamp-millis"
+		if dateTimePrecision(base) > 3 {
+			logicalType = "timestamp-micros"
+		}
+		return avroWriteColumn{schema: map[string]interface{}{"type": "long", "logicalType": logicalType}, convert: avroTime}
+	case base == "UUID":
+		return avroWriteColumn{schema: map[string]interface{}{"type": "string", "logicalType": "uuid"}, convert: func(v interface{}) (interface{}, error) {
+			return formatFlatValue(v, base), nil
+		}}
+	case strings.HasPrefix(base, "Array(") && strings.HasSuffix(base, ")"):
+		items := newAvroWriteColumn(base[len("Array(") : len(base)-1])
+		return avroWriteColumn{schema: map[string]interface{}{"type": "array", "items": items.schema}, convert: func(v interface{}) (interface{}, error) {
+			rv := reflect.ValueOf(v)
+			if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+				return nil, fmt.Errorf("expected an array, got %T", v)
+			}
+			converted := make([]interface{}, rv.Len())
+			for i := range converted {
+				item, err := avroWriteValue(items, rv.Index(i).Interface())
+				if err != nil {
+					return nil, err
+				}
+				converted[i] = item
+			}
+			return converted, nil
+		}}
+	case strings.HasPrefix(base, "Map(") && strings.HasSuffix(base, ")"):
+		if args := splitTypeArgs(base); len(args) == 2 {
+			values := newAvroWriteColumn(args[1])
+			return avroWriteColumn{schema: map[string]interface{}{"type": "map", "values": values.schema}, convert: func(v interface{}) (interface{}, error) {
+				rv := reflect.ValueOf(v)
+				if rv.Kind() != reflect.Map {
+					return nil, fmt.Errorf("expected a map, got %T", v)
+				}
+				converted := make(map[string]interface{}, rv.Len())
+				for _, key := range rv.MapKeys() {
+					value, err := avroWriteValue(values, rv.MapIndex(key).Interface())
+					if err != nil {
+						return nil, err
+					}
+					converted[fmt.Sprint(key.Interface())] = value
+				}
+				return converted, nil
+			}}
+		}
+	}
+
+	return avroWriteColumn{schema: "string", convert: func(v interface{}) (interface{}, error) {
+		if isContainerType(base) {
+			// Tuples and other nested values are written as their JSON text
+			encoded, err := json.Marshal(toJSONValue(v, base))
+			return string(encoded), err
+		}
+		return formatFlatValue(v, base), nil
+	}}
+}
+
+// avroTime passes on times for date and timestamp fields
+func avroTime(v interface{}) (interface{}, error) {
+	t, ok := v.(time.Time)
+	if !ok {
+		return nil, fmt.Errorf("expected a time, got %T", v)
+	}
+	return t, nil
+}
+
+// avroWriteValue converts a value for col, keeping NULL as nil
+func avroWriteValue(col avroWriteColumn, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return col.convert(v)
+}
+
+// avroFieldNames returns a valid, unique Avro field name for each column
+func avroFieldNames(columns []Column) []string {
+	names := make([]string, len(columns))
+	seen := make(map[string]bool, len(columns))
+	for i, col := range columns {
+		name := sanitizeColumnName(col.Name)
+		for n := 2; seen[name]; n++ {
+			name = fmt.Sprintf("%s_%d", sanitizeColumnName(col.Name), n)
+		}
+		seen[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// avroRecordSink streams record batches into an Avro object container file. The Avro schema is
+// generated on the first batch, when any column types left open can be taken from the batch.
+type avroRecordSink struct {
+	output  io.WriteCloser
+	config  AvroOptions
+	options FileWriterOptions
+	codec   ocf.CodecName
+	encoder *ocf.Encoder
+	columns []Column
+	// fields holds the Avro field name of each column
+	fields  []string
+	writers []avroWriteColumn
+	// sourceIndex maps each of columns to its position in the incoming schema, or -1
+	sourceIndex []int
+}
+
+// newAvroRecordSink returns a sink writing Avro to output, which it closes when done
+func newAvroRecordSink(output io.WriteCloser, config AvroOptions, options FileWriterOptions) (RecordSink, error) {
+	codec, err := avroCodec(config.Codec)
+	if err != nil {
+		output.Close()
+		return nil, err
+	}
+	if config.RecordName == "" {
+		config.RecordName = defaultAvroRecordName
+	}
+
+	return &avroRecordSink{
+		output:  output,
+		config:  config,
+		options: options,
+		codec:   codec,
+	}, nil
+}
+
+// resolveColumns generates the Avro schema from the configured columns and the schema of the
+// first batch, and starts the file
+func (s *avroRecordSink) resolveColumns(schema []Column) error {
+	columns := append([]Column(nil), s.options.Columns...)
+	if len(columns) == 0 {
+		columns = append(columns, schema...)
+	}
+
+	s.fields = avroFieldNames(columns)
+	s.writers = make([]avroWriteColumn, len(columns))
+	s.sourceIndex = make([]int, len(columns))
+	fields := make([]map[string]interface{}, len(columns))
+	for i := range columns {
+		s.sourceIndex[i] = columnIndex(schema, columns[i].Name)
+		if columns[i].Type == "" {
+			columns[i].Type = "Nullable(String)"
+			if idx := s.sourceIndex[i]; idx >= 0 {
+				columns[i].Type = schema[idx].Type
+			}
+		}
+		s.writers[i] = newAvroWriteColumn(columns[i].Type)
+		fields[i] = map[string]interface{}{"name": s.fields[i], "type": s.writers[i].schema}
+		if _, nullable := unwrapType(columns[i].Type); nullable {
+			fields[i]["default"] = nil
+		}
+	}
+	s.columns = columns
+
+	recordSchema, err := json.Marshal(map[string]interface{}{
+		"type":   "record",
+		"name":   sanitizeColumnName(s.config.RecordName),
+		"fields": fields,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate Avro schema: %w", err)
+	}
+	s.encoder, err = ocf.NewEncoder(string(recordSchema), s.output, ocf.WithCodec(s.codec))
+	if err != nil {
+		return fmt.Errorf("failed to start Avro file: %w", err)
+	}
+	return nil
+}
+
+// WriteBatch converts a batch to the output column types and encodes it
+func (s *avroRecordSink) WriteBatch(batch *RecordBatch) error {
+	if s.encoder == nil {
+		if err := s.resolveColumns(batch.Schema); err != nil {
+			return err
+		}
+	}
+
+	for _, source := range batch.Rows {
+		record := make(map[string]interface{}, len(s.columns))
+		for i, col := range s.columns {
+			var value interface{}
+			var sourceType string
+			if idx := s.sourceIndex[i]; idx >= 0 && idx < len(source) {
+				value, sourceType = source[idx], batch.Schema[idx].Type
+			}
+			value, err := castValue(value, sourceType, col, s.options.NullOnParseError)
+			if err != nil {
+				return err
+			}
+
+			if _, nullable := unwrapType(col.Type); value == nil && !nullable {
+				return fmt.Errorf("column %s: NULL in a non-Nullable column", col.Name)
+			}
+			if record[s.fields[i]], err = avroWriteValue(s.writers[i], value); err != nil {
+				return fmt.Errorf("column %s: %w", col.Name, err)
+			}
+		}
+		if err := s.encoder.Encode(record); err != nil {
+			return fmt.Errorf("failed to write Avro record: %w", err)
+		}
+	}
+	return nil
+}
+
+// Flush writes the remaining block; the file is complete after every flush
+func (s *avroRecordSink) Flush() error {
+	if s.encoder == nil {
+		if err := s.resolveColumns(nil); err != nil {
+			return err
+		}
+	}
+	if err := s.encoder.Flush(); err != nil {
+		return fmt.Errorf("failed to finish Avro file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the underlying file, finishing any compressed stream
+func (s *avroRecordSink) Close() error {
+	return s.output.Close()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/ocf"
+)
+
+func TestAvroWriterOptions(t *testing.T) {
+	schema := []Column{{Name: "id", Type: "Int64"}, {Name: "score", Type: "Nullable(Float64)"}}
+	rows := [][]interface{}{{int64(1), 1.5}, {int64(2), nil}}
+	tests := []struct {
+		name           string
+		options        AvroOptions
+		wantCodec      ocf.CodecName
+		wantRecordName string
+	}{
+		{name: "defaults", wantCodec: ocf.Deflate, wantRecordName: "Row"},
+		{name: "snappy", options: AvroOptions{Codec: "snappy"}, wantCodec: ocf.Snappy, wantRecordName: "Row"},
+		{name: "zstandard", options: AvroOptions{Codec: "zstandard"}, wantCodec: ocf.ZStandard, wantRecordName: "Row"},
+		{name: "uncompressed and named", options: AvroOptions{Codec: "null", RecordName: "Order"}, wantCodec: ocf.Null, wantRecordName: "Order"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := FlatFileConfig{FileName: filepath.Join(t.TempDir(), "rows.avro"), Avro: tt.options}
+			writeFlatFile(t, config, schema, rows)
+			_, got := readFlatFile(t, config, nil)
+			assertRows(t, got, rows)
+
+			file, err := os.Open(config.FileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			dec, err := ocf.NewDecoder(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codec := ocf.CodecName(dec.Metadata()["avro.codec"]); codec != tt.wantCodec {
+				t.Errorf("codec = %s, want %s", codec, tt.wantCodec)
+			}
+			record := dec.Schema().(*avro.RecordSchema)
+			if name := record.Name(); name != tt.wantRecordName {
+				t.Errorf("record name = %s, want %s", name, tt.wantRecordName)
+			}
+			// Nullable columns are written as unions with null, others as the plain type
+			for i, field := range record.Fields() {
+				_, isUnion := field.Type().(*avro.UnionSchema)
+				if _, nullable := unwrapType(schema[i].Type); isUnion != nullable {
+					t.Errorf("field %s is a union: %v, want %v", field.Name(), isUnion, nullable)
+				}
+			}
+		})
+	}
+}
+
+func TestAvroUnions(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		values   []interface{}
+		wantType string
+		want     []interface{}
+	}{
+		{
+			name:     "null first",
+			schema:   `["null", "long"]`,
+			values:   []interface{}{nil, int64(5)},
+			wantType: "Nullable(Int64)",
+			want:     []interface{}{nil, int64(5)},
+		},
+		{
+			name:     "null last",
+			schema:   `["string", "null"]`,
+			values:   []interface{}{"x", nil},
+			wantType: "Nullable(String)",
+			want:     []interface{}{"x", nil},
+		},
+		{
+			name:     "nullable array reads NULL as empty",
+			schema:   `["null", {"type": "array", "items": "int"}]`,
+			values:   []interface{}{nil, []interface{}{int32(1), int32(2)}},
+			wantType: "Array(Int32)",
+			want:     []interface{}{[]interface{}{}, []interface{}{int32(1), int32(2)}},
+		},
+		{
+			name:     "nullable record",
+			schema:   `["null", {"type": "record", "name": "Point", "fields": [{"name": "x", "type": "long"}]}]`,
+			values:   []interface{}{map[string]interface{}{"Point": map[string]interface{}{"x": int64(1)}}, nil},
+			wantType: "Tuple(`x` Int64)",
+			want:     []interface{}{map[string]interface{}{"x": int64(1)}, nil},
+		},
+		{
+			name:     "nullable enum",
+			schema:   `["null", {"type": "enum", "name": "Colour", "symbols": ["red", "green"]}]`,
+			values:   []interface{}{nil, map[string]interface{}{"Colour": "green"}},
+			wantType: "LowCardinality(Nullable(String))",
+			want:     []interface{}{nil, "green"},
+		},
+		{
+			name:     "several types read as text",
+			schema:   `["null", "string", "long"]`,
+			values:   []interface{}{map[string]interface{}{"string": "x"}, map[string]interface{}{"long": int64(5)}, nil},
+			wantType: "Nullable(String)",
+			want:     []interface{}{"x", "5", nil},
+		},
+		{
+			name:     "enum",
+			schema:   `{"type": "enum", "name": "Colour", "symbols": ["red", "green"]}`,
+			values:   []interface{}{"green", "red"},
+			wantType: "LowCardinality(String)",
+			want:     []interface{}{"green", "red"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "unions.avro")
+			file, err := os.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			enc, err := ocf.NewEncoder(`{"type": "record", "name": "Row", "fields": [{"name": "v", "type": `+tt.schema+`}]}`, file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, value := range tt.values {
+				if err := enc.Encode(map[string]interface{}{"v": value}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+
+			gotSchema, got := readFlatFile(t, FlatFileConfig{FileName: name}, nil)
+			if !reflect.DeepEqual(gotSchema, []Column{{Name: "v", Type: tt.wantType}}) {
+				t.Errorf("schema = %v, want v %s", gotSchema, tt.wantType)
+			}
+			var want [][]interface{}
+			for _, value := range tt.want {
+				want = append(want, []interface{}{value})
+			}
+			assertRows(t, got, want)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// defaultInsertBatchSize is the number of rows sent to ClickHouse per INSERT
+	defaultInsertBatchSize = 10000
+	// defaultInsertBatchBytes caps the estimated size of a single INSERT
+	defaultInsertBatchBytes = 32 << 20
+	// defaultBatchRetries is the number of times a failed INSERT is retried
+	defaultBatchRetries = 3
+	// defaultRetryBackoff is the wait before the first retry; it doubles on each attempt
+	defaultRetryBackoff = time.Second
+	// maxRetryBackoff caps the wait between retries
+	maxRetryBackoff = 30 * time.Second
+)
+
+// BatchOptions controls how rows are chunked into INSERTs and how failed INSERTs are retried.
+// Each INSERT carries a deduplication token that stays the same across its retries, so tables that
+// deduplicate inserts (Replicated*MergeTree, or MergeTree with non_replicated_deduplication_window
+// set) keep a batch once even if a timed-out attempt was committed. For other tables retries are
+// at-least-once: such a batch can be inserted twice.
+type BatchOptions struct {
+	// MaxRows is the number of rows per INSERT; 0 uses the default
+	MaxRows int `json:"maxRows"`
+	// MaxBytes caps the estimated size of an INSERT; 0 uses the default
+	MaxBytes int64 `json:"maxBytes"`
+	// MaxRetries is the number of retries per batch; 0 uses the default and a negative value disables retries
+	MaxRetries int `json:"maxRetries"`
+	// RetryBackoffMs is the wait before the first retry in milliseconds; 0 uses the default
+	RetryBackoffMs int `json:"retryBackoffMs"`
+	// ContinueOnError keeps inserting later batches after one fails all its retries
+	ContinueOnError bool `json:"continueOnError"`
+}
+
+// withDefaults fills in unset options
+func (o BatchOptions) withDefaults() BatchOptions {
+	if o.MaxRows <= 0 {
+		o.MaxRows = defaultInsertBatchSize
+	}
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = defaultInsertBatchBytes
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = defaultBatchRetries
+	} else if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.RetryBackoffMs <= 0 {
+		o.RetryBackoffMs = int(defaultRetryBackoff / time.Millisecond)
+	}
+	return o
+}
+
+// BatchResult records the outcome of one INSERT batch
+type BatchResult struct {
+	Number int64 `json:"number"`
+	// FirstRow is the zero-based offset of the batch's first row in the stream
+	FirstRow  int64  `json:"firstRow"`
+	Rows      int    `json:"rows"`
+	Bytes     int64  `json:"bytes"`
+	Attempts  int    `json:"attempts"`
+	Committed bool   `json:"committed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// estimateValueSize approximates the wire size of a value for batch byte limits
+func estimateValueSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 1
+	case string:
+		return int64(len(v)) + 1
+	case []byte:
+		return int64(len(v)) + 1
+	case bool, int8, uint8:
+		return 1
+	case int16, uint16:
+		return 2
+	case int32, uint32, float32:
+		return 4
+	case []interface{}:
+		size := int64(8)
+		for _, item := range v {
+			size += estimateValueSize(item)
+		}
+		return size
+	default:
+		return 8
+	}
+}
+
+// retryAfter waits out the backoff between attempts
+var retryAfter = time.After
+
+// sendWithRetry runs send until it succeeds, the retries are exhausted or ctx is done.
+// It returns the number of attempts made and the last error.
+func sendWithRetry(ctx context.Context, options BatchOptions, batchNumber int64, send func() error) (int, error) {
+	backoff := time.Duration(options.RetryBackoffMs) * time.Millisecond
+	attempts := 0
+	for {
+		attempts++
+		err := send()
+		if err == nil {
+			return attempts, nil
+		}
+		if attempts > options.MaxRetries || ctx.Err() != nil {
+			return attempts, err
+		}
+
+		log.Printf("Batch %d failed (attempt %d), retrying in %s: %v", batchNumber, attempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return attempts, fmt.Errorf("%w (retry aborted: %v)", err, ctx.Err())
+		case <-retryAfter(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
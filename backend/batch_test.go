@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordRetryWaits replaces the retry wait for the rest of the test with one that returns at
+// once, recording each backoff it was asked to wait
+func recordRetryWaits(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var waits []time.Duration
+	original := retryAfter
+	retryAfter = func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		ready := make(chan time.Time, 1)
+		ready <- time.Time{}
+		return ready
+	}
+	t.Cleanup(func() { retryAfter = original })
+	return &waits
+}
+
+func TestSendWithRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		options BatchOptions
+		// failures is how many times send fails before it succeeds
+		failures     int
+		wantAttempts int
+		wantWaits    []time.Duration
+		wantError    bool
+	}{
+		{name: "first attempt", options: BatchOptions{MaxRetries: 3, RetryBackoffMs: 100}, wantAttempts: 1},
+		{
+			name:         "fails then succeeds",
+			options:      BatchOptions{MaxRetries: 3, RetryBackoffMs: 100},
+			failures:     2,
+			wantAttempts: 3,
+			wantWaits:    []time.Duration{100 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			name:         "fails permanently",
+			options:      BatchOptions{MaxRetries: 3, RetryBackoffMs: 100},
+			failures:     10,
+			wantAttempts: 4,
+			wantWaits:    []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond},
+			wantError:    true,
+		},
+		{
+			name:         "backoff is capped",
+			options:      BatchOptions{MaxRetries: 3, RetryBackoffMs: 20000},
+			failures:     10,
+			wantAttempts: 4,
+			wantWaits:    []time.Duration{20 * time.Second, maxRetryBackoff, maxRetryBackoff},
+			wantError:    true,
+		},
+		{name: "retries disabled", options: BatchOptions{MaxRetries: -1, RetryBackoffMs: 100}, failures: 1, wantAttempts: 1, wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waits := recordRetryWaits(t)
+			calls := 0
+			attempts, err := sendWithRetry(t.Context(), tt.options, 1, func() error {
+				calls++
+				if calls <= tt.failures {
+					return errors.New("connection reset")
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantError {
+				t.Errorf("error = %v, want an error: %v", err, tt.wantError)
+			}
+			if attempts != tt.wantAttempts || calls != tt.wantAttempts {
+				t.Errorf("attempts = %d with %d calls, want %d", attempts, calls, tt.wantAttempts)
+			}
+			if !reflect.DeepEqual(*waits, tt.wantWaits) {
+				t.Errorf("waits = %v, want %v", *waits, tt.wantWaits)
+			}
+		})
+	}
+}
+
+func TestSendWithRetryCancelled(t *testing.T) {
+	options := BatchOptions{MaxRetries: 3, RetryBackoffMs: 100}
+	failing := func() error { return errors.New("connection reset") }
+
+	t.Run("before a retry", func(t *testing.T) {
+		waits := recordRetryWaits(t)
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		attempts, err := sendWithRetry(ctx, options, 1, failing)
+		if err == nil || attempts != 1 || len(*waits) != 0 {
+			t.Errorf("attempts = %d, waits = %v, error = %v, want one attempt that failed without waiting", attempts, *waits, err)
+		}
+	})
+
+	t.Run("while waiting", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		original := retryAfter
+		retryAfter = func(time.Duration) <-chan time.Time {
+			cancel()
+			return nil
+		}
+		t.Cleanup(func() { retryAfter = original })
+
+		attempts, err := sendWithRetry(ctx, options, 1, failing)
+		if err == nil || !strings.Contains(err.Error(), "retry aborted") || attempts != 1 {
+			t.Errorf("attempts = %d, error = %v, want one attempt and an aborted retry", attempts, err)
+		}
+	})
+}
+
+// sentBatch is one call to a fake batchSender
+type sentBatch struct {
+	token string
+	// firstID is the id in the batch's first row
+	firstID int64
+	rows    int
+}
+
+func TestClickHouseSinkFlushBatch(t *testing.T) {
+	tests := []struct {
+		name            string
+		dedupPrefix     string
+		continueOnError bool
+		ids             int
+		// failures is how many times the batch starting at each id fails; -1 fails always
+		failures      map[int64]int
+		wantSent      []sentBatch
+		wantCommitted []bool
+		wantAttempts  []int
+		wantWritten   int64
+		wantError     string
+	}{
+		{
+			name:          "retry reuses the token",
+			dedupPrefix:   "job",
+			ids:           4,
+			failures:      map[int64]int{0: 2},
+			wantSent:      []sentBatch{{"job-1", 0, 2}, {"job-1", 0, 2}, {"job-1", 0, 2}, {"job-2", 2, 2}},
+			wantCommitted: []bool{true, true},
+			wantAttempts:  []int{3, 1},
+			wantWritten:   4,
+		},
+		{
+			name:          "server without deduplication",
+			ids:           3,
+			failures:      map[int64]int{2: 1},
+			wantSent:      []sentBatch{{"", 0, 2}, {"", 2, 1}, {"", 2, 1}},
+			wantCommitted: []bool{true, true},
+			wantAttempts:  []int{1, 2},
+			wantWritten:   3,
+		},
+		{
+			name:          "permanent failure stops",
+			dedupPrefix:   "job",
+			ids:           4,
+			failures:      map[int64]int{0: -1},
+			wantSent:      []sentBatch{{"job-1", 0, 2}, {"job-1", 0, 2}, {"job-1", 0, 2}},
+			wantCommitted: []bool{false},
+			wantAttempts:  []int{3},
+			wantError:     "batch 1 failed after 3 attempts",
+		},
+		{
+			name:            "continue past a failed batch",
+			dedupPrefix:     "job",
+			continueOnError: true,
+			ids:             5,
+			failures:        map[int64]int{2: -1},
+			wantSent: []sentBatch{
+				{"job-1", 0, 2}, {"job-2", 2, 2}, {"job-2", 2, 2}, {"job-2", 2, 2}, {"job-3", 4, 1},
+			},
+			wantCommitted: []bool{true, false, true},
+			wantAttempts:  []int{1, 3, 1},
+			wantWritten:   3,
+			wantError:     "1 of 3 batches failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waits := recordRetryWaits(t)
+			var sent []sentBatch
+			failed := map[int64]int{}
+			schema := []Column{{Name: "id", Type: "Int64"}}
+			sink := &clickHouseRecordSink{
+				ctx:         t.Context(),
+				tableName:   "events",
+				options:     TableWriterOptions{Batch: BatchOptions{MaxRows: 2, MaxBytes: 1 << 20, MaxRetries: 2, RetryBackoffMs: 10, ContinueOnError: tt.continueOnError}},
+				columns:     schema,
+				insertNames: []string{"id"},
+				sourceIndex: []int{0},
+				dedupPrefix: tt.dedupPrefix,
+				progress:    NewProgress(),
+				send: func(_ context.Context, query, token string, rows [][]interface{}) error {
+					if query != "INSERT INTO events (`id`)" {
+						t.Errorf("query = %q", query)
+					}
+					first := rows[0][0].(int64)
+					sent = append(sent, sentBatch{token, first, len(rows)})
+					if limit := tt.failures[first]; limit < 0 || failed[first] < limit {
+						failed[first]++
+						return errors.New("connection reset")
+					}
+					return nil
+				},
+			}
+
+			batch := NewRecordBatch(schema, tt.ids)
+			for id := 0; id < tt.ids; id++ {
+				batch.Append([]interface{}{int64(id)})
+			}
+			err := sink.WriteBatch(batch)
+			if err == nil {
+				err = sink.Flush()
+			}
+			if tt.wantError == "" && err != nil || tt.wantError != "" && (err == nil || !strings.Contains(err.Error(), tt.wantError)) {
+				t.Errorf("error = %v, want %q", err, tt.wantError)
+			}
+
+			if !reflect.DeepEqual(sent, tt.wantSent) {
+				t.Errorf("sent %v, want %v", sent, tt.wantSent)
+			}
+			var committed []bool
+			var attempts []int
+			for _, result := range sink.progress.Batches() {
+				committed = append(committed, result.Committed)
+				attempts = append(attempts, result.Attempts)
+			}
+			if !reflect.DeepEqual(committed, tt.wantCommitted) || !reflect.DeepEqual(attempts, tt.wantAttempts) {
+				t.Errorf("batches committed %v after %v attempts, want %v after %v", committed, attempts, tt.wantCommitted, tt.wantAttempts)
+			}
+			if written := sink.progress.RowsWritten(); written != tt.wantWritten {
+				t.Errorf("%d rows written, want %d", written, tt.wantWritten)
+			}
+			// Each batch's retries start again from the configured backoff
+			for _, wait := range *waits {
+				if wait != 10*time.Millisecond && wait != 20*time.Millisecond {
+					t.Errorf("waited %s between attempts", wait)
+				}
+			}
+		})
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -23,16 +26,60 @@ type ClickHouseConfig struct {
 	Username string `json:"username"`
 	JWTToken string `json:"jwtToken"`
 	IsHTTPS  bool   `json:"isHttps"`
+	// HTTPPort is the port of the HTTP interface used to stream CSV; empty uses 8123, or 8443 with IsHTTPS
+	HTTPPort string `json:"httpPort"`
+}
+
+// WriteMode controls what happens to an existing target table before rows are inserted
+type WriteMode string
+
+const (
+	// WriteModeFail refuses to write into a table that already exists
+	WriteModeFail WriteMode = "fail"
+	// WriteModeAppend inserts into the table as-is, creating it if needed
+	WriteModeAppend WriteMode = "append"
+	// WriteModeTruncate empties an existing table before inserting
+	WriteModeTruncate WriteMode = "truncate"
+	// WriteModeRecreate drops an existing table and creates it from the incoming schema
+	WriteModeRecreate WriteMode = "recreate"
+)
+
+// TableOptions holds the DDL clauses used when a table is created. The key and TTL clauses are
+// SQL expressions passed through as written, so they must come from a trusted user; validate
+// only keeps them to a single clause.
+type TableOptions struct {
+	// Engine is an engine name with optional literal or identifier arguments, e.g. ReplacingMergeTree(version)
+	Engine      string `json:"engine"`
+	OrderBy     string `json:"orderBy"`
+	PartitionBy string `json:"partitionBy"`
+	PrimaryKey  string `json:"primaryKey"`
+	TTL         string `json:"ttl"`
+	// Settings maps setting names to number, quoted string or boolean literals
+	Settings map[string]string `json:"settings"`
+}
+
+// tableNamePattern matches plain and database-qualified table names
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// ValidateTableName rejects table names that would need quoting or could inject SQL
+func ValidateTableName(tableName string) error {
+	if !tableNamePattern.MatchString(tableName) {
+		return fmt.Errorf("invalid table name %q", tableName)
+	}
+	return nil
 }
 
 // ClickHouseClient wraps a ClickHouse connection
 type ClickHouseClient struct {
-	conn driver.Conn
-	db   *sql.DB
+	conn     driver.Conn
+	db       *sql.DB
+	progress *Progress
+	// release gives a pooled connection back to the connection manager; nil when the client owns conn
+	release func()
 }
 
-// NewClickHouseClient creates a new client using JWT authentication
-func NewClickHouseClient(config ClickHouseConfig) (*ClickHouseClient, error) {
+// clickHouseOptions returns the driver options for connecting with config
+func clickHouseOptions(config ClickHouseConfig) *clickhouse.Options {
 	// Define protocol based on IsHTTPS
 	// protocol := "http"
 	// if config.IsHTTPS {
@@ -40,7 +87,7 @@ func NewClickHouseClient(config ClickHouseConfig) (*ClickHouseClient, error) {
 	// }
 
 	// Define the connection options for the ClickHouse client
-	options := &clickhouse.Options{
+	return &clickhouse.Options{
 		Addr: []string{fmt.Sprintf("%s:%s", config.Host, config.Port)}, // Replace with your host and port
 		Auth: clickhouse.Auth{
 			Database: config.Database,
@@ -62,15 +109,27 @@ func NewClickHouseClient(config ClickHouseConfig) (*ClickHouseClient, error) {
 			InsecureSkipVerify: true, // Skip verification for simplicity
 		},
 	}
+}
+
+// NewClickHouseClient creates a new client using JWT authentication. Clients share the pooled
+// connection of their connection profile when a connection manager is configured.
+func NewClickHouseClient(config ClickHouseConfig) (*ClickHouseClient, error) {
+	ctx := context.Background()
+	if connections != nil {
+		conn, release, err := connections.Acquire(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		return &ClickHouseClient{conn: conn, release: release}, nil
+	}
 
 	// Establish the connection
-	conn, err := clickhouse.Open(options)
+	conn, err := clickhouse.Open(clickHouseOptions(config))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create clickhouse connection: %w", err)
 	}
 
 	// Test the connection with a simple ping
-	ctx := context.Background()
 	if err := conn.Ping(ctx); err != nil {
 		return nil, fmt.Errorf("failed to ping ClickHouse: %w", err)
 	}
@@ -82,9 +141,11 @@ func NewClickHouseClient(config ClickHouseConfig) (*ClickHouseClient, error) {
 	}, nil
 }
 
-// Close releases all resources
+// Close releases all resources. A pooled connection is returned to its pool rather than closed.
 func (c *ClickHouseClient) Close() error {
-	if c.conn != nil {
+	if c.release != nil {
+		c.release()
+	} else if c.conn != nil {
 		err := c.conn.Close()
 		if err != nil {
 			return err
@@ -96,6 +157,11 @@ func (c *ClickHouseClient) Close() error {
 	return nil
 }
 
+// TrackProgress reports batches sent by subsequent table writers to p
+func (c *ClickHouseClient) TrackProgress(p *Progress) {
+	c.progress = p
+}
+
 func (c *ClickHouseClient) GetTables(ctx context.Context) ([]string, error) {
 	// Execute the query directly using the native protocol connection
 	rows, err := c.conn.Query(ctx, "SHOW TABLES;")
@@ -192,8 +258,111 @@ func (c *ClickHouseClient) ValidateColumns(ctx context.Context, tableName string
 	return validColumns, nil
 }
 
-// FetchData retrieves data from a table with selected columns
-func (c *ClickHouseClient) FetchData(ctx context.Context, tableName string, selectedColumns []string, limit int) ([]map[string]interface{}, error) {
+// clickHouseRecordIterator streams a ClickHouse query result as record batches
+type clickHouseRecordIterator struct {
+	rows   driver.Rows
+	schema []Column
+	done   bool
+	// newTargets builds the scan destinations for one row
+	newTargets func() []interface{}
+}
+
+// newClickHouseRecordIterator wraps a result set, naming its columns after names when they line up
+func newClickHouseRecordIterator(rows driver.Rows, names []string) *clickHouseRecordIterator {
+	columnTypes := rows.ColumnTypes()
+	schema := make([]Column, len(columnTypes))
+	for i, ct := range columnTypes {
+		name := ct.Name()
+		if len(names) == len(columnTypes) {
+			name = names[i]
+		}
+		schema[i] = Column{Name: name, Type: ct.DatabaseTypeName()}
+	}
+
+	return &clickHouseRecordIterator{
+		rows:       rows,
+		schema:     schema,
+		newTargets: func() []interface{} { return scanTargets(columnTypes) },
+	}
+}
+
+// Schema returns the result columns with their ClickHouse types
+func (it *clickHouseRecordIterator) Schema() []Column {
+	return it.schema
+}
+
+// Next scans up to defaultRecordBatchRows result rows into a batch
+func (it *clickHouseRecordIterator) Next() (*RecordBatch, error) {
+	if it.done {
+		return nil, io.EOF
+	}
+
+	batch := NewRecordBatch(it.schema, defaultRecordBatchRows)
+	for batch.Len() < defaultRecordBatchRows {
+		if !it.rows.Next() {
+			it.done = true
+			if err := it.rows.Err(); err != nil {
+				return nil, fmt.Errorf("failed to read rows: %w", err)
+			}
+			break
+		}
+
+		targets := it.newTargets()
+		if err := it.rows.Scan(targets...); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		values := make([]interface{}, len(targets))
+		for i, target := range targets {
+			values[i] = derefScanValue(target)
+		}
+		batch.Append(values)
+	}
+
+	if batch.Len() == 0 {
+		return nil, io.EOF
+	}
+	return batch, nil
+}
+
+// Close releases the underlying result set
+func (it *clickHouseRecordIterator) Close() error {
+	return it.rows.Close()
+}
+
+// scanTargets creates scan destinations of the driver's native type for each column, so values
+// keep their exact ClickHouse representation (Decimals, UUIDs, Nullables, Arrays, Maps)
+func scanTargets(columnTypes []driver.ColumnType) []interface{} {
+	rowValues := make([]interface{}, len(columnTypes))
+	for i, ct := range columnTypes {
+		scanType := ct.ScanType()
+		if scanType == nil {
+			// Nullable(Nothing) and similar have no concrete type
+			var val interface{}
+			rowValues[i] = &val
+			continue
+		}
+		rowValues[i] = reflect.New(scanType).Interface()
+	}
+	return rowValues
+}
+
+// derefScanValue dereferences a scan destination to get the actual value; NULLs become nil
+func derefScanValue(target interface{}) interface{} {
+	v := reflect.ValueOf(target)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// StreamData runs a SELECT over the selected columns and returns an iterator over the result
+func (c *ClickHouseClient) StreamData(ctx context.Context, tableName string, selectedColumns []string, limit int) (RecordIterator, error) {
 	if len(selectedColumns) == 0 {
 		return nil, errors.New("no columns selected")
 	}
@@ -225,126 +394,53 @@ func (c *ClickHouseClient) FetchData(ctx context.Context, tableName string, sele
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
-	defer rows.Close()
 
-	// Get column types
-	columnTypes := rows.ColumnTypes()
+	return newClickHouseRecordIterator(rows, selectedColumns), nil
+}
 
-	results := []map[string]interface{}{}
-	for rows.Next() {
-		// Create a slice of properly typed values based on column types
-		rowValues := make([]interface{}, len(selectedColumns))
-		for i, ct := range columnTypes {
-			// Create appropriate type based on database type
-			typeName := ct.DatabaseTypeName()
-			scanType := ct.ScanType()
-
-			// Check if it's a Nullable type
-			// isNullable := strings.Contains(typeName, "Nullable")
-
-			switch {
-			case strings.Contains(typeName, "UInt8"):
-				var val uint8
-				rowValues[i] = &val
-			case strings.Contains(typeName, "UInt16"):
-				var val uint16
-				rowValues[i] = &val
-			case strings.Contains(typeName, "UInt32"):
-				var val uint32
-				rowValues[i] = &val
-			case strings.Contains(typeName, "UInt64"):
-				var val uint64
-				rowValues[i] = &val
-			case strings.Contains(typeName, "Int8"):
-				var val int8
-				rowValues[i] = &val
-			case strings.Contains(typeName, "Int16"):
-				var val int16
-				rowValues[i] = &val
-			case strings.Contains(typeName, "Int32"):
-				var val int32
-				rowValues[i] = &val
-			case strings.Contains(typeName, "Int64"):
-				var val int64
-				rowValues[i] = &val
-			case strings.Contains(typeName, "UUID"):
-				var val string // UUIDs are typically handled as strings
-				rowValues[i] = &val
-			case strings.Contains(typeName, "DateTime") || strings.Contains(typeName, "Date"):
-				var val time.Time
-				rowValues[i] = &val
-			case scanType.Kind() == reflect.Float64 || strings.Contains(typeName, "Float64"):
-				var val float64
-				rowValues[i] = &val
-			case scanType.Kind() == reflect.Float32 || strings.Contains(typeName, "Float32"):
-				var val float32
-				rowValues[i] = &val
-			case scanType.Kind() == reflect.String || strings.Contains(typeName, "String"):
-				var val string
-				rowValues[i] = &val
-			case strings.Contains(typeName, "Array"):
-				var val []interface{} // Handle arrays as slice of interfaces
-				rowValues[i] = &val
-			case strings.Contains(typeName, "Decimal"):
-				var val float64 // Handle decimals as float64
-				rowValues[i] = &val
-			case strings.Contains(typeName, "Bool") || strings.Contains(typeName, "Boolean"):
-				var val bool
-				rowValues[i] = &val
-			default:
-				// For other types, use a string as a fallback
-				var val string
-				rowValues[i] = &val
-			}
-		}
+// FetchData retrieves data from a table with selected columns
+func (c *ClickHouseClient) FetchData(ctx context.Context, tableName string, selectedColumns []string, limit int) ([]map[string]interface{}, error) {
+	it, err := c.StreamData(ctx, tableName, selectedColumns, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
 
-		if err := rows.Scan(rowValues...); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
-		}
+	return collectRows(it, 0)
+}
 
-		// Create map for this row
-		rowMap := make(map[string]interface{})
-		for i, col := range selectedColumns {
-			// Dereference the pointer to get the actual value
-			switch v := rowValues[i].(type) {
-			case *uint8:
-				rowMap[col] = *v
-			case *uint16:
-				rowMap[col] = *v
-			case *uint32:
-				rowMap[col] = *v
-			case *uint64:
-				rowMap[col] = *v
-			case *int8:
-				rowMap[col] = *v
-			case *int16:
-				rowMap[col] = *v
-			case *int32:
-				rowMap[col] = *v
-			case *int64:
-				rowMap[col] = *v
-			case *float32:
-				rowMap[col] = *v
-			case *float64:
-				rowMap[col] = *v
-			case *string:
-				rowMap[col] = *v
-			case *bool:
-				rowMap[col] = *v
-			case *time.Time:
-				rowMap[col] = *v
-			case *[]interface{}:
-				rowMap[col] = *v
-			case nil:
-				rowMap[col] = nil
-			default:
-				rowMap[col] = v
-			}
-		}
-		results = append(results, rowMap)
+// ServerVersion returns the version of the connected ClickHouse server
+func (c *ClickHouseClient) ServerVersion() (*driver.ServerVersion, error) {
+	version, err := c.conn.ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server version: %w", err)
+	}
+	return version, nil
+}
+
+// GetTableOptions returns the partition, primary and sorting keys of an existing table
+func (c *ClickHouseClient) GetTableOptions(ctx context.Context, tableName string) (TableOptions, error) {
+	database, table := "", tableName
+	if idx := strings.Index(tableName, "."); idx != -1 {
+		database, table = tableName[:idx], tableName[idx+1:]
+	}
+
+	query := "SELECT partition_key, primary_key, sorting_key FROM system.tables WHERE database = if(? = '', currentDatabase(), ?) AND name = ?"
+	var options TableOptions
+	if err := c.conn.QueryRow(ctx, query, database, database, table).Scan(&options.PartitionBy, &options.PrimaryKey, &options.OrderBy); err != nil {
+		return TableOptions{}, fmt.Errorf("failed to read table options for %s: %w", tableName, err)
 	}
+	return options, nil
+}
 
-	return results, nil
+// CountRows returns the number of rows in a table
+func (c *ClickHouseClient) CountRows(ctx context.Context, tableName string) (uint64, error) {
+	var count uint64
+	query := fmt.Sprintf("SELECT count() FROM %s", tableName)
+	if err := c.conn.QueryRow(ctx, query).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count rows in %s: %w", tableName, err)
+	}
+	return count, nil
 }
 
 // TableExists checks if a table exists in the database
@@ -388,8 +484,94 @@ func sanitizeColumnName(name string) string {
 	return sanitized
 }
 
+// settingNamePattern matches the names of table settings
+var settingNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sqlLiteral matches a number or a single-quoted string
+const sqlLiteral = `-?[0-9]+(?:\.[0-9]+)?|'(?:[^'\\]|\\.)*'`
+
+// settingValuePattern matches the literal values a table setting may take
+var settingValuePattern = regexp.MustCompile(`^(?i:` + sqlLiteral + `|true|false)$`)
+
+// engineArgument matches one argument of a table engine: a literal or an identifier
+const engineArgument = `(?:` + sqlLiteral + `|[A-Za-z_][A-Za-z0-9_]*)`
+
+// enginePattern matches an engine name with an optional argument list
+var enginePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(?:\(\s*(?:` + engineArgument + `(?:\s*,\s*` + engineArgument + `)*)?\s*\))?$`)
+
+// validate checks the engine and settings, which have a fixed shape, and rejects key and TTL
+// expressions that could end the CREATE TABLE statement or comment out its rest
+func (o TableOptions) validate() error {
+	if o.Engine != "" && !enginePattern.MatchString(strings.TrimSpace(o.Engine)) {
+		return fmt.Errorf("invalid table engine %q: expected a name with optional literal or identifier arguments", o.Engine)
+	}
+	for key, value := range o.Settings {
+		if !settingNamePattern.MatchString(key) {
+			return fmt.Errorf("invalid table setting name %q", key)
+		}
+		if !settingValuePattern.MatchString(strings.TrimSpace(value)) {
+			return fmt.Errorf("invalid value %q for table setting %s: expected a number, quoted string or boolean", value, key)
+		}
+	}
+
+	clauses := []struct{ name, value string }{
+		{"orderBy", o.OrderBy},
+		{"partitionBy", o.PartitionBy},
+		{"primaryKey", o.PrimaryKey},
+		{"ttl", o.TTL},
+	}
+	for _, clause := range clauses {
+		if strings.Contains(clause.value, ";") || strings.Contains(clause.value, "--") || strings.Contains(clause.value, "/*") {
+			return fmt.Errorf("invalid table option %s: %q may not contain ';' or comments", clause.name, clause.value)
+		}
+	}
+	return nil
+}
+
+// createTableClauses renders the ENGINE and key clauses of a CREATE TABLE statement
+func createTableClauses(options TableOptions) string {
+	engine := options.Engine
+	if engine == "" {
+		engine = "MergeTree()"
+	}
+	orderBy := options.OrderBy
+	if orderBy == "" {
+		orderBy = "tuple()"
+	}
+
+	clauses := []string{"ENGINE = " + engine}
+	if options.PartitionBy != "" {
+		clauses = append(clauses, "PARTITION BY "+options.PartitionBy)
+	}
+	if options.PrimaryKey != "" {
+		clauses = append(clauses, "PRIMARY KEY "+options.PrimaryKey)
+	}
+	clauses = append(clauses, "ORDER BY "+orderBy)
+	if options.TTL != "" {
+		clauses = append(clauses, "TTL "+options.TTL)
+	}
+	if len(options.Settings) > 0 {
+		keys := make([]string, 0, len(options.Settings))
+		for key := range options.Settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		settings := make([]string, len(keys))
+		for i, key := range keys {
+			settings[i] = fmt.Sprintf("%s = %s", key, options.Settings[key])
+		}
+		clauses = append(clauses, "SETTINGS "+strings.Join(settings, ", "))
+	}
+	return strings.Join(clauses, " ")
+}
+
 // CreateTable creates a new table based on the provided schema
-func (c *ClickHouseClient) CreateTable(ctx context.Context, tableName string, columns []Column) error {
+func (c *ClickHouseClient) CreateTable(ctx context.Context, tableName string, columns []Column, options TableOptions) error {
+	if err := options.validate(); err != nil {
+		return err
+	}
+
 	// Build column definitions with proper escaping
 	columnDefs := make([]string, len(columns))
 	for i, col := range columns {
@@ -400,9 +582,10 @@ func (c *ClickHouseClient) CreateTable(ctx context.Context, tableName string, co
 
 	// Create table query
 	query := fmt.Sprintf(
-		"CREATE TABLE IF NOT EXISTS %s (%s) ENGINE = MergeTree() ORDER BY tuple()",
+		"CREATE TABLE IF NOT EXISTS %s (%s) %s",
 		tableName,
-		strings.Join(columnDefs, ", "))
+		strings.Join(columnDefs, ", "),
+		createTableClauses(options))
 
 	// Log the query for debugging
 	log.Printf("Creating table with query: %s", query)
@@ -415,107 +598,384 @@ func (c *ClickHouseClient) CreateTable(ctx context.Context, tableName string, co
 	return nil
 }
 
-// ImportDataFromFlatFile imports data from a flat file to ClickHouse
-func (c *ClickHouseClient) ImportDataFromFlatFile(ctx context.Context, tableName string, data []map[string]interface{}) (int, error) {
-	if len(data) == 0 {
-		return 0, errors.New("no data to import")
+// inferColumnType maps a Go value to the ClickHouse type used when creating a table
+func inferColumnType(val interface{}) string {
+	switch val.(type) {
+	case int, int8, int16, int32, int64:
+		return "Int64"
+	case uint, uint8, uint16, uint32, uint64:
+		return "UInt64"
+	case float32, float64:
+		return "Float64"
+	case bool:
+		return "UInt8" // ClickHouse uses UInt8 for boolean
+	case time.Time:
+		return "DateTime"
+	case []interface{}:
+		return "Array(String)"
+	default:
+		return "String"
 	}
+}
 
-	// Get all column names from the first row
-	columns := make([]string, 0, len(data[0]))
-	for col := range data[0] {
-		columns = append(columns, col)
+// TruncateTable removes all rows from a table
+func (c *ClickHouseClient) TruncateTable(ctx context.Context, tableName string) error {
+	if err := c.conn.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s", tableName)); err != nil {
+		return fmt.Errorf("failed to truncate table %s: %w", tableName, err)
 	}
+	return nil
+}
 
-	log.Printf("table name: %s", tableName)
+// DropTable drops a table if it exists
+func (c *ClickHouseClient) DropTable(ctx context.Context, tableName string) error {
+	if err := c.conn.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", tableName)); err != nil {
+		return fmt.Errorf("failed to drop table %s: %w", tableName, err)
+	}
+	return nil
+}
 
-	// Check if table exists
-	exists, err := c.TableExists(ctx, tableName)
-	if err != nil {
-		return 0, fmt.Errorf("failed to check if table exists: %w", err)
+// PrepareTargetTable applies a write mode to tableName before rows are inserted
+func (c *ClickHouseClient) PrepareTargetTable(ctx context.Context, tableName string, mode WriteMode) error {
+	switch mode {
+	case "", WriteModeAppend:
+		return nil
+	case WriteModeFail:
+		exists, err := c.TableExists(ctx, tableName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("table %s already exists", tableName)
+		}
+		return nil
+	case WriteModeTruncate:
+		exists, err := c.TableExists(ctx, tableName)
+		if err != nil || !exists {
+			return err
+		}
+		log.Printf("Truncating table %s before insert", tableName)
+		return c.TruncateTable(ctx, tableName)
+	case WriteModeRecreate:
+		log.Printf("Dropping table %s so it can be recreated", tableName)
+		return c.DropTable(ctx, tableName)
+	default:
+		return fmt.Errorf("invalid write mode: %q", mode)
 	}
+}
 
-	// If table doesn't exist, create it
-	if !exists {
-		log.Printf("Table %s does not exist. Creating it...", tableName)
+// quoteIdentifier quotes a table or column name for use in a query
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
 
-		// Infer column types from the data
-		tableColumns := make([]Column, len(columns))
-		for i, col := range columns {
-			// Default to String type, but you can improve this by inferring types from data
-			colType := "String"
-
-			// Try to infer type from the first non-nil value
-			for _, row := range data {
-				if val := row[col]; val != nil {
-					switch val.(type) {
-					case int, int8, int16, int32, int64:
-						colType = "Int64"
-					case uint, uint8, uint16, uint32, uint64:
-						colType = "UInt64"
-					case float32, float64:
-						colType = "Float64"
-					case bool:
-						colType = "UInt8" // ClickHouse uses UInt8 for boolean
-					case time.Time:
-						colType = "DateTime"
-					case []interface{}:
-						colType = "Array(String)"
-					}
-					break
-				}
+// TableWriterOptions controls how a table writer maps incoming rows onto a table
+type TableWriterOptions struct {
+	// Columns is the target schema in insert order, matched to incoming columns by name.
+	// When empty the incoming batch schema is used.
+	Columns []Column
+	// NullOnParseError stores string values that do not parse as a Nullable column's type as NULL
+	NullOnParseError bool
+	// Table holds the DDL clauses used if the table has to be created
+	Table TableOptions
+	// Batch controls INSERT sizes and retries
+	Batch BatchOptions
+}
+
+// clickHouseRecordSink streams record batches into a ClickHouse table in bounded INSERTs,
+// retrying failed ones. Each INSERT's rows are kept until it commits so a retry can resend them.
+type clickHouseRecordSink struct {
+	client    *ClickHouseClient
+	ctx       context.Context
+	tableName string
+	options   TableWriterOptions
+	// columns holds the incoming column names with the types of the target columns
+	columns []Column
+	// insertNames holds the target column names, parallel to columns
+	insertNames []string
+	// sourceIndex maps each of columns to its position in the incoming schema, or -1
+	sourceIndex  []int
+	pending      [][]interface{}
+	pendingBytes int64
+	// rowsSeen is the number of rows handed to the sink, used for batch offsets
+	rowsSeen int64
+	// dedupPrefix starts the insert_deduplication_token of every INSERT, so a retry of a batch
+	// the server committed before the connection dropped is not inserted twice. It is empty on
+	// servers too old for the setting.
+	dedupPrefix string
+	// send inserts one batch of rows
+	send     batchSender
+	batches  int64
+	failed   int
+	progress *Progress
+}
+
+// WriteBatch converts a batch to the target column types and queues its rows,
+// creating the table on the first batch
+func (s *clickHouseRecordSink) WriteBatch(batch *RecordBatch) error {
+	if s.columns == nil {
+		if err := s.resolveColumns(batch); err != nil {
+			return err
+		}
+	}
+
+	for _, row := range batch.Rows {
+		// Create values array in the same order as columns
+		values := make([]interface{}, len(s.columns))
+		for i, col := range s.columns {
+			var value interface{}
+			var sourceType string
+			if idx := s.sourceIndex[i]; idx >= 0 && idx < len(row) {
+				value, sourceType = row[idx], batch.Schema[idx].Type
+			}
+			value, err := castValue(value, sourceType, col, s.options.NullOnParseError)
+			if err != nil {
+				return err
 			}
+			values[i] = value
+			s.pendingBytes += estimateValueSize(value)
+		}
+		s.pending = append(s.pending, values)
 
-			tableColumns[i] = Column{Name: col, Type: colType}
+		if len(s.pending) >= s.options.Batch.MaxRows || s.pendingBytes >= s.options.Batch.MaxBytes {
+			if err := s.flushBatch(); err != nil {
+				return err
+			}
 		}
+	}
+	return nil
+}
+
+// flushBatch sends the pending rows as one INSERT, retrying with backoff on failure
+func (s *clickHouseRecordSink) flushBatch() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	s.batches++
+	s.progress.SetBatch(s.batches)
+	result := BatchResult{
+		Number:   s.batches,
+		FirstRow: s.rowsSeen,
+		Rows:     len(s.pending),
+		Bytes:    s.pendingBytes,
+	}
+	rows := s.pending
+	s.rowsSeen += int64(len(rows))
+	s.pending = nil
+	s.pendingBytes = 0
+
+	attempts, err := sendWithRetry(s.ctx, s.options.Batch, result.Number, func() error {
+		return s.sendRows(result.Number, rows)
+	})
+	result.Attempts = attempts
+	result.Committed = err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+	s.progress.RecordBatch(result)
+	if err == nil {
+		// Rows only count as written once the server has acknowledged them
+		s.progress.AddRowsWritten(int64(result.Rows))
+	}
 
-		// Create the table
-		if err := c.CreateTable(ctx, tableName, tableColumns); err != nil {
-			return 0, err
+	if err != nil {
+		s.failed++
+		log.Printf("Batch %d (%d rows from row %d) failed after %d attempts: %v", result.Number, result.Rows, result.FirstRow, attempts, err)
+		if !s.options.Batch.ContinueOnError {
+			return fmt.Errorf("batch %d failed after %d attempts: %w", result.Number, attempts, err)
 		}
 	}
+	return nil
+}
+
+// batchSender runs query as one INSERT of rows. A non-empty dedupToken is sent as the
+// insert_deduplication_token setting.
+type batchSender func(ctx context.Context, query, dedupToken string, rows [][]interface{}) error
+
+// sendRows sends rows as INSERT number
+func (s *clickHouseRecordSink) sendRows(number int64, rows [][]interface{}) error {
+	quoted := make([]string, len(s.insertNames))
+	for i, name := range s.insertNames {
+		quoted[i] = quoteIdentifier(name)
+	}
 
 	// Create the query
-	query := fmt.Sprintf("INSERT INTO %s", tableName)
+	query := fmt.Sprintf("INSERT INTO %s (%s)", s.tableName, strings.Join(quoted, ", "))
 
-	// Log the query for debugging
-	log.Println("Preparing batch with query:", query)
+	// Every attempt at the same batch carries the same token, so the server drops repeats
+	var token string
+	if s.dedupPrefix != "" {
+		token = fmt.Sprintf("%s-%d", s.dedupPrefix, number)
+	}
+	return s.send(s.ctx, query, token, rows)
+}
+
+// insertRows prepares a fresh batch, appends rows and sends it
+func (c *ClickHouseClient) insertRows(ctx context.Context, query, dedupToken string, rows [][]interface{}) error {
+	if dedupToken != "" {
+		ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+			"insert_deduplication_token": dedupToken,
+		}))
+	}
 
 	// Prepare the batch statement using the native connection
 	batch, err := c.conn.PrepareBatch(ctx, query)
 	if err != nil {
-		log.Println("Error preparing batch:", err)
-		return 0, fmt.Errorf("failed to prepare batch: %w", err)
+		return fmt.Errorf("failed to prepare batch: %w", err)
 	}
 
-	// Insert data in batches
-	recordCount := 0
-	for _, row := range data {
-		// Create values array in the same order as columns
-		values := make([]interface{}, len(columns))
-		for i, col := range columns {
-			values[i] = row[col]
-		}
-
-		// Add the row to the batch
+	for _, values := range rows {
 		if err := batch.Append(values...); err != nil {
-			log.Println("Error appending row to batch:", err)
-			return recordCount, fmt.Errorf("failed to append row to batch: %w", err)
+			batch.Abort()
+			return fmt.Errorf("failed to append row to batch: %w", err)
 		}
-		recordCount++
 	}
 
 	// Send the batch to the server
 	if err := batch.Send(); err != nil {
-		log.Println("Error sending batch:", err)
-		return recordCount, fmt.Errorf("failed to send batch: %w", err)
+		return fmt.Errorf("failed to send batch: %w", err)
+	}
+	return nil
+}
+
+// resolveColumns settles the insert schema on the first batch, creating the table if it does not exist
+func (s *clickHouseRecordSink) resolveColumns(first *RecordBatch) error {
+	columns := append([]Column(nil), s.options.Columns...)
+	if len(columns) == 0 {
+		// Take the incoming schema, inferring any type the source left open from the first row
+		columns = append(columns, first.Schema...)
+		for i := range columns {
+			if columns[i].Type == "" && first.Len() > 0 {
+				columns[i].Type = inferColumnType(first.Rows[0][i])
+			}
+		}
 	}
 
-	return recordCount, nil
+	sourceIndex := make([]int, len(columns))
+	for i, col := range columns {
+		sourceIndex[i] = first.ColumnIndex(col.Name)
+	}
+
+	columns, insertNames, err := s.client.resolveInsertColumns(s.ctx, s.tableName, columns, s.options.Table)
+	if err != nil {
+		return err
+	}
+
+	s.columns = columns
+	s.insertNames = insertNames
+	s.sourceIndex = sourceIndex
+
+	// insert_deduplication_token exists from ClickHouse 22.2
+	if version, err := s.client.ServerVersion(); err == nil && supports(version, 22, 2) {
+		if id, err := newJobID(); err == nil {
+			s.dedupPrefix = id
+		}
+	}
+	return nil
 }
 
-// JoinTables executes a join query between multiple tables
-func (c *ClickHouseClient) JoinTables(ctx context.Context, tables []string, joinConditions string, selectedColumns []string, limit int) ([]map[string]interface{}, error) {
+// Flush sends the remaining rows and reports batches that failed along the way
+func (s *clickHouseRecordSink) Flush() error {
+	if err := s.flushBatch(); err != nil {
+		return err
+	}
+	if s.failed > 0 {
+		return fmt.Errorf("%d of %d batches failed", s.failed, s.batches)
+	}
+	return nil
+}
+
+// Close discards any rows that were not flushed
+func (s *clickHouseRecordSink) Close() error {
+	s.pending = nil
+	s.pendingBytes = 0
+	return nil
+}
+
+// NewTableWriter returns a sink that streams record batches into tableName, creating the table on first write
+func (c *ClickHouseClient) NewTableWriter(ctx context.Context, tableName string, options TableWriterOptions) RecordSink {
+	log.Printf("table name: %s", tableName)
+
+	options.Batch = options.Batch.withDefaults()
+	return &clickHouseRecordSink{
+		client:    c,
+		ctx:       ctx,
+		tableName: tableName,
+		options:   options,
+		send:      c.insertRows,
+		progress:  c.progress,
+	}
+}
+
+// resolveInsertColumns matches columns to an existing table by exact or sanitized name, adopting
+// the table's types, or creates the table from them. It returns the columns with their target
+// types and the table column names to insert into.
+func (c *ClickHouseClient) resolveInsertColumns(ctx context.Context, tableName string, columns []Column, options TableOptions) ([]Column, []string, error) {
+	columns = append([]Column(nil), columns...)
+
+	exists, err := c.TableExists(ctx, tableName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check if table exists: %w", err)
+	}
+
+	insertNames := make([]string, len(columns))
+	if exists {
+		tableColumns, err := c.GetTableColumns(ctx, tableName)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Match incoming columns to existing ones by exact or sanitized name and adopt their types
+		for i, col := range columns {
+			match := -1
+			for j, tableCol := range tableColumns {
+				if tableCol.Name == col.Name || tableCol.Name == sanitizeColumnName(col.Name) {
+					match = j
+					break
+				}
+			}
+			if match == -1 {
+				return nil, nil, fmt.Errorf("column %s not found in table %s", col.Name, tableName)
+			}
+			insertNames[i] = tableColumns[match].Name
+			columns[i].Type = tableColumns[match].Type
+		}
+	} else {
+		log.Printf("Table %s does not exist. Creating it...", tableName)
+
+		if err := c.CreateTable(ctx, tableName, columns, options); err != nil {
+			return nil, nil, err
+		}
+		for i, col := range columns {
+			insertNames[i] = sanitizeColumnName(col.Name)
+		}
+	}
+
+	return columns, insertNames, nil
+}
+
+// ImportDataFromFlatFile imports a batch read from a flat file into ClickHouse.
+// The batch's schema gives the column order and, unless the table exists, the column types.
+func (c *ClickHouseClient) ImportDataFromFlatFile(ctx context.Context, tableName string, batch *RecordBatch) (int, error) {
+	if batch.Len() == 0 {
+		return 0, errors.New("no data to import")
+	}
+
+	sink := c.NewTableWriter(ctx, tableName, TableWriterOptions{Columns: batch.Schema})
+	defer sink.Close()
+
+	if err := sink.WriteBatch(batch); err != nil {
+		return 0, err
+	}
+	if err := sink.Flush(); err != nil {
+		return 0, err
+	}
+
+	return batch.Len(), nil
+}
+
+// StreamJoin executes a join query between multiple tables and returns an iterator over the result
+func (c *ClickHouseClient) StreamJoin(ctx context.Context, tables []string, joinConditions string, selectedColumns []string, limit int) (RecordIterator, error) {
 	if len(tables) < 2 {
 		return nil, errors.New("at least two tables are required for a join")
 	}
@@ -540,28 +1000,17 @@ func (c *ClickHouseClient) JoinTables(ctx context.Context, tables []string, join
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute join query: %w", err)
 	}
-	defer rows.Close()
 
-	results := []map[string]interface{}{}
-	for rows.Next() {
-		// Create a slice of interface{} to hold the values
-		rowValues := make([]interface{}, len(selectedColumns))
-		rowValuePtrs := make([]interface{}, len(selectedColumns))
-		for i := range rowValues {
-			rowValuePtrs[i] = &rowValues[i]
-		}
-
-		if err := rows.Scan(rowValuePtrs...); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
-		}
+	return newClickHouseRecordIterator(rows, selectedColumns), nil
+}
 
-		// Create map for this row
-		rowMap := make(map[string]interface{})
-		for i, col := range selectedColumns {
-			rowMap[col] = rowValues[i]
-		}
-		results = append(results, rowMap)
+// JoinTables executes a join query between multiple tables
+func (c *ClickHouseClient) JoinTables(ctx context.Context, tables []string, joinConditions string, selectedColumns []string, limit int) ([]map[string]interface{}, error) {
+	it, err := c.StreamJoin(ctx, tables, joinConditions, selectedColumns, limit)
+	if err != nil {
+		return nil, err
 	}
+	defer it.Close()
 
-	return results, nil
+	return collectRows(it, 0)
 }
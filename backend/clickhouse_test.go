@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// execConn is a driver connection that records the statements it executes
+type execConn struct {
+	driver.Conn
+	queries []string
+}
+
+func (c *execConn) Exec(_ context.Context, query string, _ ...any) error {
+	c.queries = append(c.queries, query)
+	return nil
+}
+
+func TestTableOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   TableOptions
+		wantError string
+	}{
+		{name: "defaults"},
+		{name: "engine without arguments", options: TableOptions{Engine: "MergeTree"}},
+		{name: "engine with identifier", options: TableOptions{Engine: "ReplacingMergeTree(version)"}},
+		{name: "engine with literals", options: TableOptions{Engine: "ReplicatedMergeTree('/clickhouse/tables/{shard}/t', '{replica}')"}},
+		{name: "engine with numbers", options: TableOptions{Engine: "Buffer(db, t, 16, 10, 100, 10000, 1000000, 10000000, 100000000)"}},
+		{name: "quoted semicolon", options: TableOptions{Engine: "ReplicatedMergeTree('/t;x', 'r')"}},
+		{name: "engine with expression", options: TableOptions{Engine: "MergeTree(1 + 1)"}, wantError: "invalid table engine"},
+		{name: "engine with trailing clause", options: TableOptions{Engine: "MergeTree() ORDER BY id"}, wantError: "invalid table engine"},
+		{name: "engine with unclosed string", options: TableOptions{Engine: "ReplicatedMergeTree('/t)"}, wantError: "invalid table engine"},
+		{name: "engine ending the statement", options: TableOptions{Engine: "Memory; DROP TABLE t"}, wantError: "invalid table engine"},
+		{
+			name:    "literal settings",
+			options: TableOptions{Settings: map[string]string{"index_granularity": "8192", "storage_policy": "'hot_cold'", "allow_nullable_key": "true", "ratio": "0.5"}},
+		},
+		{name: "setting name", options: TableOptions{Settings: map[string]string{"a = 1, b": "1"}}, wantError: "invalid table setting name"},
+		{name: "setting expression", options: TableOptions{Settings: map[string]string{"index_granularity": "8192, ttl_only_drop_parts = 1"}}, wantError: "invalid value"},
+		{name: "unquoted setting text", options: TableOptions{Settings: map[string]string{"storage_policy": "hot_cold"}}, wantError: "invalid value"},
+		{name: "setting breaking out of a string", options: TableOptions{Settings: map[string]string{"storage_policy": "'a'; DROP TABLE t; '"}}, wantError: "invalid value"},
+		{name: "key expressions", options: TableOptions{OrderBy: "(id, toDate(at))", PartitionBy: "toYYYYMM(at)", TTL: "at + INTERVAL 1 DAY"}},
+		{name: "statement in ORDER BY", options: TableOptions{OrderBy: "id; DROP TABLE t"}, wantError: "invalid table option orderBy"},
+		{name: "comment in TTL", options: TableOptions{TTL: "at -- rest"}, wantError: "invalid table option ttl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.validate()
+			if tt.wantError == "" && err != nil || tt.wantError != "" && (err == nil || !strings.Contains(err.Error(), tt.wantError)) {
+				t.Errorf("validate() = %v, want %q", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestCreateTable(t *testing.T) {
+	columns := []Column{{Name: "id", Type: "Int64"}, {Name: "at", Type: "DateTime"}}
+	tests := []struct {
+		name    string
+		options TableOptions
+		want    string
+	}{
+		{
+			name: "defaults",
+			want: "CREATE TABLE IF NOT EXISTS events (id Int64, at DateTime) ENGINE = MergeTree() ORDER BY tuple()",
+		},
+		{
+			name: "every clause",
+			options: TableOptions{
+				Engine:      "ReplacingMergeTree(at)",
+				OrderBy:     "id",
+				PartitionBy: "toYYYYMM(at)",
+				PrimaryKey:  "id",
+				TTL:         "at + INTERVAL 30 DAY",
+				Settings:    map[string]string{"storage_policy": "'hot_cold'", "index_granularity": "8192"},
+			},
+			want: "CREATE TABLE IF NOT EXISTS events (id Int64, at DateTime) ENGINE = ReplacingMergeTree(at) " +
+				"PARTITION BY toYYYYMM(at) PRIMARY KEY id ORDER BY id TTL at + INTERVAL 30 DAY " +
+				"SETTINGS index_granularity = 8192, storage_policy = 'hot_cold'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &execConn{}
+			client := &ClickHouseClient{conn: conn}
+			if err := client.CreateTable(t.Context(), "events", columns, tt.options); err != nil {
+				t.Fatal(err)
+			}
+			if len(conn.queries) != 1 || conn.queries[0] != tt.want {
+				t.Errorf("executed %q, want %q", conn.queries, tt.want)
+			}
+		})
+	}
+
+	// Invalid options are rejected before anything is sent
+	conn := &execConn{}
+	client := &ClickHouseClient{conn: conn}
+	if err := client.CreateTable(t.Context(), "events", columns, TableOptions{Engine: "Memory; DROP TABLE t"}); err == nil || len(conn.queries) != 0 {
+		t.Errorf("CreateTable with an invalid engine = %v after %q, want an error and no statement", err, conn.queries)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression codecs for flat files
+const (
+	CompressionNone  = "none"
+	CompressionGzip  = "gzip"
+	CompressionZstd  = "zstd"
+	CompressionBzip2 = "bzip2"
+	CompressionLZ4   = "lz4"
+	CompressionXZ    = "xz"
+)
+
+// compressionExtensions maps file name extensions to the codec they imply
+var compressionExtensions = map[string]string{
+	".gz":   CompressionGzip,
+	".gzip": CompressionGzip,
+	".zst":  CompressionZstd,
+	".zstd": CompressionZstd,
+	".bz2":  CompressionBzip2,
+	".lz4":  CompressionLZ4,
+	".xz":   CompressionXZ,
+}
+
+// compressionMagic lists the leading bytes of each codec's stream. bzip2 is recognised by
+// isBzip2Header, as its short "BZh" signature also starts plain text.
+var compressionMagic = []struct {
+	codec string
+	magic []byte
+}{
+	{CompressionGzip, []byte{0x1f, 0x8b}},
+	{CompressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{CompressionLZ4, []byte{0x04, 0x22, 0x4d, 0x18}},
+	{CompressionXZ, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+// bzip2BlockMagic and bzip2EndMagic follow the "BZh" signature and block size digit of a
+// bzip2 stream: the first is the start of a block, the second ends an empty stream
+var (
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// compressionMagicSize is the number of leading bytes needed to recognise any codec
+const compressionMagicSize = 10
+
+// validateCompression rejects codecs the client cannot read, or write when forWrite is set
+func validateCompression(codec string, forWrite bool) error {
+	switch codec {
+	case "", CompressionNone, CompressionGzip, CompressionZstd, CompressionLZ4, CompressionXZ:
+		return nil
+	case CompressionBzip2:
+		if forWrite {
+			return fmt.Errorf("bzip2 compression is only supported for reading")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported compression: %q", codec)
+}
+
+// compressionFromExtension returns the codec implied by a file name's extension, or none
+func compressionFromExtension(fileName string) string {
+	if codec, ok := compressionExtensions[strings.ToLower(filepath.Ext(fileName))]; ok {
+		return codec
+	}
+	return CompressionNone
+}
+
+// trimCompressionExtension strips a compression extension, so "data.csv.gz" becomes "data.csv"
+func trimCompressionExtension(fileName string) string {
+	if compressionFromExtension(fileName) == CompressionNone {
+		return fileName
+	}
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName))
+}
+
+// compressionFromMagic returns the codec whose magic bytes start header, or ""
+func compressionFromMagic(header []byte) string {
+	for _, m := range compressionMagic {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.codec
+		}
+	}
+	if isBzip2Header(header) {
+		return CompressionBzip2
+	}
+	return ""
+}
+
+// isBzip2Header reports whether header starts a bzip2 stream: "BZh", a block size digit from 1
+// to 9, then the magic of a block or of the end of the stream
+func isBzip2Header(header []byte) bool {
+	if len(header) < compressionMagicSize || !bytes.HasPrefix(header, []byte("BZh")) || header[3] < '1' || header[3] > '9' {
+		return false
+	}
+	return bytes.Equal(header[4:10], bzip2BlockMagic) || bytes.Equal(header[4:10], bzip2EndMagic)
+}
+
+// decompressReader wraps r in a decompressor for codec
+func decompressReader(r io.Reader, codec string) (io.ReadCloser, error) {
+	switch codec {
+	case "", CompressionNone:
+		return io.NopCloser(r), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case CompressionBzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case CompressionLZ4:
+		return io.NopCloser(lz4.NewReader(r)), nil
+	case CompressionXZ:
+		dec, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(dec), nil
+	}
+	return nil, fmt.Errorf("unsupported compression: %q", codec)
+}
+
+// compressWriter wraps w in a compressor for codec. Closing it finishes the stream but leaves w open.
+func compressWriter(w io.Writer, codec string) (io.WriteCloser, error) {
+	switch codec {
+	case "", CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionLZ4:
+		return lz4.NewWriter(w), nil
+	case CompressionXZ:
+		return xz.NewWriter(w)
+	case CompressionBzip2:
+		return nil, fmt.Errorf("bzip2 compression is only supported for reading")
+	}
+	return nil, fmt.Errorf("unsupported compression: %q", codec)
+}
+
+// flatFileReader is the decompressed content of an open flat file
+type flatFileReader struct {
+	io.Reader
+	file    *os.File
+	decoder io.Closer
+}
+
+// Close releases the decompressor and closes the file
+func (r *flatFileReader) Close() error {
+	err := r.decoder.Close()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// flatFileWriter compresses what is written to it into a flat file
+type flatFileWriter struct {
+	io.Writer
+	file *os.File
+	// name is where the file ends up, which differs from the file's own name while it is staged
+	name    string
+	outcome *exportOutcome
+}
+
+// Close finishes the compressed stream and closes the file, moving a staged file into place
+// unless writing it or the export failed
+func (w *flatFileWriter) Close() error {
+	var err error
+	if encoder, ok := w.Writer.(io.Closer); ok {
+		err = encoder.Close()
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	if ferr := finishExportFile(w.file, w.name, err != nil || w.outcome.failed); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// readCompression returns the configured codec, else the one a compression extension names,
+// else the one detected from the file's leading bytes
+func (f *FlatFileClient) readCompression(header []byte) string {
+	if f.config.Compression != "" {
+		return f.config.Compression
+	}
+	if codec := compressionFromExtension(f.config.FileName); codec != CompressionNone {
+		return codec
+	}
+	if codec := compressionFromMagic(header); codec != "" {
+		return codec
+	}
+	return CompressionNone
+}
+
+// writeCompression returns the configured codec, or the one implied by the file's extension
+func (c FlatFileConfig) writeCompression() string {
+	if c.Compression != "" {
+		return c.Compression
+	}
+	return compressionFromExtension(c.FileName)
+}
+
+// openInput opens the file and returns its decompressed content. With track set, the compressed
+// bytes read are reported to the progress tracker against the file size.
+func (f *FlatFileClient) openInput(track bool) (*flatFileReader, error) {
+	file, err := os.Open(f.config.FileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	var input io.Reader = file
+	if track && f.progress != nil {
+		if info, err := file.Stat(); err == nil && !f.part {
+			f.progress.SetTotalBytes(info.Size())
+		}
+		input = countingReader{r: file, progress: f.progress}
+	}
+
+	buffered := bufio.NewReader(input)
+	header, _ := buffered.Peek(compressionMagicSize)
+	codec := f.readCompression(header)
+	decoder, err := decompressReader(buffered, codec)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open %s stream: %w", codec, err)
+	}
+
+	return &flatFileReader{Reader: decoder, file: file, decoder: decoder}, nil
+}
+
+// createOutput creates the file and returns a writer that compresses into it. Compressed bytes
+// written are reported to the progress tracker. In the data directory the file is staged under a
+// temporary name until the writer is closed.
+func (f *FlatFileClient) createOutput() (*flatFileWriter, error) {
+	file, err := createExportFile(f.config.FileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	writer := &flatFileWriter{file: file, name: f.config.FileName, outcome: f.outcome}
+
+	var output io.Writer = file
+	if f.progress != nil {
+		output = countingWriter{w: file, progress: f.progress}
+	}
+	if f.tee != nil {
+		output = io.MultiWriter(output, f.tee)
+	}
+
+	encoder, err := compressWriter(output, f.config.writeCompression())
+	if err != nil {
+		file.Close()
+		finishExportFile(file, f.config.FileName, true)
+		return nil, err
+	}
+
+	writer.Writer = encoder
+	return writer, nil
+}
+
+// spooledFile is a temporary file that is removed when closed
+type spooledFile struct {
+	*os.File
+}
+
+// Close closes and removes the temporary file
+func (s spooledFile) Close() error {
+	err := s.File.Close()
+	if rerr := os.Remove(s.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// openRandomAccess opens the file for formats that need random access, such as Parquet. A
+// compressed file is first decompressed into a temporary file.
+func (f *FlatFileClient) openRandomAccess() (*os.File, io.Closer, int64, error) {
+	file, err := os.Open(f.config.FileName)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	header := make([]byte, compressionMagicSize)
+	n, _ := file.ReadAt(header, 0)
+	codec := f.readCompression(header[:n])
+	if codec == CompressionNone {
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, nil, 0, fmt.Errorf("failed to stat file: %w", err)
+		}
+		return file, file, info.Size(), nil
+	}
+	defer file.Close()
+
+	decoder, err := decompressReader(file, codec)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to open %s stream: %w", codec, err)
+	}
+	defer decoder.Close()
+
+	tmp, err := os.CreateTemp("", "flatfile-*")
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	spooled := spooledFile{tmp}
+
+	size, err := io.Copy(tmp, decoder)
+	if err != nil {
+		spooled.Close()
+		return nil, nil, 0, fmt.Errorf("failed to decompress file: %w", err)
+	}
+	return tmp, spooled, size, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompressionFromMagic(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"gzip", []byte{0x1f, 0x8b, 0x08, 0, 0, 0, 0, 0, 0, 0}, CompressionGzip},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0, 0, 0, 0, 0, 0}, CompressionZstd},
+		{"lz4", []byte{0x04, 0x22, 0x4d, 0x18, 0, 0, 0, 0, 0, 0}, CompressionLZ4},
+		{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0, 0, 0, 0}, CompressionXZ},
+		{"bzip2 block", []byte("BZh91AY&SY"), CompressionBzip2},
+		{"empty bzip2", append([]byte("BZh1"), bzip2EndMagic...), CompressionBzip2},
+		{"text starting with BZh", []byte("BZhCode,Na"), ""},
+		{"BZh with a block size but no block", []byte("BZh9,name\n"), ""},
+		{"short bzip2 prefix", []byte("BZh9"), ""},
+		{"plain csv", []byte("id,name\n1,"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compressionFromMagic(tt.header); got != tt.want {
+				t.Errorf("compressionFromMagic(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCompressionPrecedence(t *testing.T) {
+	gzipHeader := []byte{0x1f, 0x8b, 0x08, 0, 0, 0, 0, 0, 0, 0}
+	tests := []struct {
+		name   string
+		config FlatFileConfig
+		header []byte
+		want   string
+	}{
+		{"option wins", FlatFileConfig{FileName: "a.csv.gz", Compression: CompressionNone}, gzipHeader, CompressionNone},
+		{"extension wins over magic", FlatFileConfig{FileName: "a.csv.zst"}, gzipHeader, CompressionZstd},
+		{"magic without a compression extension", FlatFileConfig{FileName: "a.csv"}, gzipHeader, CompressionGzip},
+		{"neither", FlatFileConfig{FileName: "a.csv"}, []byte("BZhCode,Na"), CompressionNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewFlatFileClient(tt.config).readCompression(tt.header); got != tt.want {
+				t.Errorf("readCompression = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressedCSVRoundTrip(t *testing.T) {
+	schema := []Column{{Name: "BZhCode"}, {Name: "name"}}
+	rows := [][]interface{}{{"1", "a"}, {"2", "b"}}
+	for _, name := range []string{"rows.csv", "rows.csv.gz", "rows.csv.zst", "rows.csv.lz4", "rows.csv.xz"} {
+		t.Run(name, func(t *testing.T) {
+			config := FlatFileConfig{FileName: filepath.Join(t.TempDir(), name)}
+			writeFlatFile(t, config, schema, rows)
+
+			// A compressed file is still detected once renamed to a plain .csv
+			plain := filepath.Join(t.TempDir(), "renamed.csv")
+			if err := os.Rename(config.FileName, plain); err != nil {
+				t.Fatal(err)
+			}
+			_, got := readFlatFile(t, FlatFileConfig{FileName: plain}, nil)
+			if !reflect.DeepEqual(got, rows) {
+				t.Errorf("rows = %#v, want %#v", got, rows)
+			}
+		})
+	}
+}
@@ -16,16 +16,30 @@ const (
 
 // IngestionRequest holds the parameters for data ingestion
 type IngestionRequest struct {
-	Source         SourceType       `json:"source"`
-	Target         SourceType       `json:"target"`
-	ClickHouseConf ClickHouseConfig `json:"clickhouseConfig"`
-	FlatFileConf   FlatFileConfig   `json:"flatFileConfig"`
-	TableName      string           `json:"tableName"`
-	SelectedTables []string         `json:"selectedTables"`
-	JoinCondition  string           `json:"joinCondition"`
+	Source          SourceType       `json:"source"`
+	Target          SourceType       `json:"target"`
+	ClickHouseConf  ClickHouseConfig `json:"clickhouseConfig"`
+	FlatFileConf    FlatFileConfig   `json:"flatFileConfig"`
+	TableName       string           `json:"tableName"`
+	SelectedTables  []string         `json:"selectedTables"`
+	JoinCondition   string           `json:"joinCondition"`
 	SelectedColumns []string         `json:"selectedColumns"`
-	PreviewOnly    bool             `json:"previewOnly"`
-	PreviewLimit   int              `json:"previewLimit"`
+	// SourceClickHouseConf and TargetClickHouseConf override ClickHouseConf for one side of a copy
+	SourceClickHouseConf *ClickHouseConfig `json:"sourceClickhouseConfig"`
+	TargetClickHouseConf *ClickHouseConfig `json:"targetClickhouseConfig"`
+	// SourceFlatFileConf and TargetFlatFileConf override FlatFileConf for one side of a conversion
+	SourceFlatFileConf *FlatFileConfig `json:"sourceFlatFileConfig"`
+	TargetFlatFileConf *FlatFileConfig `json:"targetFlatFileConfig"`
+	// ColumnMappings renames, retypes and defaults columns; when set it replaces SelectedColumns
+	ColumnMappings []ColumnMapping `json:"columnMappings"`
+	// TargetTableName is the ClickHouse table written to; defaults to a name derived from the file name
+	TargetTableName string       `json:"targetTableName"`
+	WriteMode       WriteMode    `json:"writeMode"`
+	TableOptions    TableOptions `json:"tableOptions"`
+	// BatchOptions controls INSERT batch sizes and retries for ClickHouse targets
+	BatchOptions BatchOptions `json:"batchOptions"`
+	PreviewOnly  bool         `json:"previewOnly"`
+	PreviewLimit int          `json:"previewLimit"`
 }
 
 // Response represents a standard API response
@@ -72,6 +86,17 @@ func WriteJSONResponse(w http.ResponseWriter, statusCode int, resp Response) {
 	}
 }
 
+// WriteSSEEvent writes a single Server-Sent Event with a JSON payload
+func WriteSSEEvent(w http.ResponseWriter, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
+
 // ReadJSONBody reads and parses JSON from a request body
 func ReadJSONBody(r *http.Request, v interface{}) error {
 	decoder := json.NewDecoder(r.Body)
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// PoolOptions sizes and ages the connection pool kept for each connection profile
+type PoolOptions struct {
+	// MaxOpenConns limits the connections a profile's pool opens at once
+	MaxOpenConns int
+	// MaxIdleConns limits the connections a profile's pool keeps open between queries
+	MaxIdleConns int
+	// IdleTimeout closes a profile's pool once it has gone unused this long
+	IdleTimeout time.Duration
+	// HealthCheckInterval is how long a pool is trusted before it is pinged again on use
+	HealthCheckInterval time.Duration
+}
+
+// connectionProfile identifies a pool: the server and the user it connects as. The token is
+// deliberately not part of it, so new credentials replace a pool rather than add one.
+type connectionProfile struct {
+	Host     string
+	Port     string
+	Database string
+	Username string
+	IsHTTPS  bool
+}
+
+// profileOf returns the profile a config connects with
+func profileOf(config ClickHouseConfig) connectionProfile {
+	return connectionProfile{
+		Host:     config.Host,
+		Port:     config.Port,
+		Database: config.Database,
+		Username: config.Username,
+		IsHTTPS:  config.IsHTTPS,
+	}
+}
+
+// connectionPool is the driver connection shared by the clients of one profile
+type connectionPool struct {
+	conn driver.Conn
+	// credential fingerprints the token the pool authenticated with
+	credential [32]byte
+	// refs counts the clients using the pool
+	refs        int
+	lastUsed    time.Time
+	lastChecked time.Time
+	// retired pools are closed once their last client releases them
+	retired bool
+}
+
+// ConnectionStatus describes a pooled connection profile without its credentials
+type ConnectionStatus struct {
+	Host     string    `json:"host"`
+	Port     string    `json:"port"`
+	Database string    `json:"database"`
+	Username string    `json:"username"`
+	IsHTTPS  bool      `json:"isHttps"`
+	InUse    int       `json:"inUse"`
+	Open     int       `json:"open"`
+	Idle     int       `json:"idle"`
+	MaxOpen  int       `json:"maxOpen"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// ConnectionManager shares pooled ClickHouse connections between requests for the same profile
+type ConnectionManager struct {
+	options PoolOptions
+	// open connects a new pool
+	open func(context.Context, ClickHouseConfig, PoolOptions) (driver.Conn, error)
+
+	mu    sync.Mutex
+	pools map[connectionProfile]*connectionPool
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewConnectionManager creates a connection manager and starts evicting idle pools
+func NewConnectionManager(options PoolOptions) *ConnectionManager {
+	if options.MaxOpenConns > 0 && options.MaxIdleConns > options.MaxOpenConns {
+		options.MaxIdleConns = options.MaxOpenConns
+	}
+	m := &ConnectionManager{
+		options: options,
+		open:    openClickHouse,
+		pools:   make(map[connectionProfile]*connectionPool),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go m.evictIdle()
+	return m
+}
+
+// connections is the process-wide connection manager, or nil to connect afresh for every client
+var connections *ConnectionManager
+
+// credentialOf fingerprints a config's token so pools do not keep it in the clear
+func credentialOf(config ClickHouseConfig) [32]byte {
+	return sha256.Sum256([]byte(config.JWTToken))
+}
+
+// Acquire returns a connection for config's profile and a function that releases it. A pool
+// opened with a different token is replaced, and one not checked recently is pinged first.
+func (m *ConnectionManager) Acquire(ctx context.Context, config ClickHouseConfig) (driver.Conn, func(), error) {
+	profile, credential := profileOf(config), credentialOf(config)
+
+	m.mu.Lock()
+	pool := m.pools[profile]
+	if pool != nil && pool.credential != credential {
+		log.Printf("Credentials for %s@%s:%s changed, replacing its connections", profile.Username, profile.Host, profile.Port)
+		m.retireLocked(profile, pool)
+		pool = nil
+	}
+	if pool != nil {
+		pool.refs++
+		check := time.Since(pool.lastChecked) >= m.options.HealthCheckInterval
+		m.mu.Unlock()
+
+		if !check {
+			return pool.conn, m.releaser(pool), nil
+		}
+		err := pool.conn.Ping(ctx)
+		if err == nil {
+			m.mu.Lock()
+			pool.lastChecked = time.Now()
+			m.mu.Unlock()
+			return pool.conn, m.releaser(pool), nil
+		}
+		log.Printf("Pooled connection to %s:%s failed its health check, reconnecting: %v", profile.Host, profile.Port, err)
+
+		m.mu.Lock()
+		m.retireLocked(profile, pool)
+		m.releaseLocked(pool)
+	}
+	m.mu.Unlock()
+
+	conn, err := m.open(ctx, config, m.options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing := m.pools[profile]; existing != nil {
+		if existing.credential == credential {
+			// Another request connected first; share its pool
+			existing.refs++
+			go closeConnection(conn)
+			return existing.conn, m.releaser(existing), nil
+		}
+		m.retireLocked(profile, existing)
+	}
+	now := time.Now()
+	pool = &connectionPool{conn: conn, credential: credential, refs: 1, lastUsed: now, lastChecked: now}
+	m.pools[profile] = pool
+	return conn, m.releaser(pool), nil
+}
+
+// releaser returns a function that gives pool back once, however often it is called
+func (m *ConnectionManager) releaser(pool *connectionPool) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.releaseLocked(pool)
+		})
+	}
+}
+
+// releaseLocked drops a reference to pool, closing it if it was retired while in use
+func (m *ConnectionManager) releaseLocked(pool *connectionPool) {
+	pool.refs--
+	pool.lastUsed = time.Now()
+	if pool.retired && pool.refs == 0 {
+		go closeConnection(pool.conn)
+	}
+}
+
+// retireLocked removes pool from the manager; it is closed now if unused, or on its last release
+func (m *ConnectionManager) retireLocked(profile connectionProfile, pool *connectionPool) {
+	if m.pools[profile] == pool {
+		delete(m.pools, profile)
+	}
+	if pool.retired {
+		return
+	}
+	pool.retired = true
+	if pool.refs == 0 {
+		go closeConnection(pool.conn)
+	}
+}
+
+// Invalidate closes the pool of config's profile, so the next client connects afresh. Requests
+// still using it finish first. It reports whether the profile had a pool.
+func (m *ConnectionManager) Invalidate(config ClickHouseConfig) bool {
+	profile := profileOf(config)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pool, ok := m.pools[profile]
+	if ok {
+		m.retireLocked(profile, pool)
+	}
+	return ok
+}
+
+// List describes the pooled profiles, ordered by host, port, database and user
+func (m *ConnectionManager) List() []ConnectionStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]ConnectionStatus, 0, len(m.pools))
+	for profile, pool := range m.pools {
+		stats := pool.conn.Stats()
+		statuses = append(statuses, ConnectionStatus{
+			Host:     profile.Host,
+			Port:     profile.Port,
+			Database: profile.Database,
+			Username: profile.Username,
+			IsHTTPS:  profile.IsHTTPS,
+			InUse:    pool.refs,
+			Open:     stats.Open,
+			Idle:     stats.Idle,
+			MaxOpen:  stats.MaxOpenConns,
+			LastUsed: pool.lastUsed,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		if a.Database != b.Database {
+			return a.Database < b.Database
+		}
+		return a.Username < b.Username
+	})
+	return statuses
+}
+
+// evictIdle periodically closes pools nobody has used for the idle timeout
+func (m *ConnectionManager) evictIdle() {
+	defer close(m.done)
+	if m.options.IdleTimeout <= 0 {
+		<-m.stop
+		return
+	}
+
+	interval := m.options.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.evictExpired()
+		}
+	}
+}
+
+// evictExpired closes the unused pools that have been idle for the idle timeout
+func (m *ConnectionManager) evictExpired() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for profile, pool := range m.pools {
+		if pool.refs == 0 && time.Since(pool.lastUsed) >= m.options.IdleTimeout {
+			m.retireLocked(profile, pool)
+		}
+	}
+}
+
+// CloseAll stops idle eviction and closes every pool; pools in use close on their last release
+func (m *ConnectionManager) CloseAll() {
+	close(m.stop)
+	<-m.done
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for profile, pool := range m.pools {
+		m.retireLocked(profile, pool)
+	}
+}
+
+// closeConnection closes a driver connection, logging failures
+func closeConnection(conn driver.Conn) {
+	if err := conn.Close(); err != nil {
+		log.Printf("Error closing ClickHouse connection: %v", err)
+	}
+}
+
+// openClickHouse connects to ClickHouse with a pool sized by options and checks the connection
+func openClickHouse(ctx context.Context, config ClickHouseConfig, options PoolOptions) (driver.Conn, error) {
+	chOptions := clickHouseOptions(config)
+	chOptions.MaxOpenConns = options.MaxOpenConns
+	chOptions.MaxIdleConns = options.MaxIdleConns
+
+	conn, err := clickhouse.Open(chOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clickhouse connection: %w", err)
+	}
+	if err := conn.Ping(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to ping ClickHouse: %w", err)
+	}
+	log.Println("Successfully connected to ClickHouse!")
+	return conn, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// fakeConn is a driver connection that only pings, reports stats and closes
+type fakeConn struct {
+	driver.Conn
+	pingErr error
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{closed: make(chan struct{})}
+}
+
+func (c *fakeConn) Ping(context.Context) error { return c.pingErr }
+func (c *fakeConn) Stats() driver.Stats        { return driver.Stats{MaxOpenConns: 10} }
+
+func (c *fakeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+// isClosed waits briefly for the connection, which is closed in the background, to close
+func (c *fakeConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	case <-time.After(200 * time.Millisecond):
+		return false
+	}
+}
+
+// newTestConnectionManager returns a manager whose pools are fake connections, recorded in the
+// order they are opened
+func newTestConnectionManager(t *testing.T, options PoolOptions) (*ConnectionManager, *[]*fakeConn) {
+	m := NewConnectionManager(options)
+	t.Cleanup(m.CloseAll)
+	var opened []*fakeConn
+	m.open = func(context.Context, ClickHouseConfig, PoolOptions) (driver.Conn, error) {
+		conn := newFakeConn()
+		opened = append(opened, conn)
+		return conn, nil
+	}
+	return m, &opened
+}
+
+func TestConnectionManagerReuse(t *testing.T) {
+	base := ClickHouseConfig{Host: "ch", Port: "9000", Database: "db", Username: "u", JWTToken: "token"}
+	tests := []struct {
+		name string
+		// second is the config of the second client
+		second func(ClickHouseConfig) ClickHouseConfig
+		// pingErr fails the first pool's health check
+		pingErr     error
+		healthCheck time.Duration
+		wantShared  bool
+		// wantRetired means the first pool is replaced and closed
+		wantRetired bool
+		wantPools   int
+	}{
+		{
+			name:        "same profile",
+			second:      func(c ClickHouseConfig) ClickHouseConfig { return c },
+			healthCheck: time.Hour,
+			wantShared:  true,
+			wantPools:   1,
+		},
+		{
+			name:       "same profile with a passing health check",
+			second:     func(c ClickHouseConfig) ClickHouseConfig { return c },
+			wantShared: true,
+			wantPools:  1,
+		},
+		{
+			name:        "same profile with a failing health check",
+			second:      func(c ClickHouseConfig) ClickHouseConfig { return c },
+			pingErr:     errors.New("connection reset"),
+			wantRetired: true,
+			wantPools:   1,
+		},
+		{
+			name:        "failing connection not checked again yet",
+			second:      func(c ClickHouseConfig) ClickHouseConfig { return c },
+			pingErr:     errors.New("connection reset"),
+			healthCheck: time.Hour,
+			wantShared:  true,
+			wantPools:   1,
+		},
+		{
+			name:        "changed token",
+			second:      func(c ClickHouseConfig) ClickHouseConfig { c.JWTToken = "rotated"; return c },
+			healthCheck: time.Hour,
+			wantRetired: true,
+			wantPools:   1,
+		},
+		{
+			name:        "other database",
+			second:      func(c ClickHouseConfig) ClickHouseConfig { c.Database = "other"; return c },
+			healthCheck: time.Hour,
+			wantPools:   2,
+		},
+		{
+			name:        "other user",
+			second:      func(c ClickHouseConfig) ClickHouseConfig { c.Username = "v"; return c },
+			healthCheck: time.Hour,
+			wantPools:   2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, opened := newTestConnectionManager(t, PoolOptions{HealthCheckInterval: tt.healthCheck})
+
+			first, release, err := m.Acquire(t.Context(), base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			release()
+			first.(*fakeConn).pingErr = tt.pingErr
+
+			second, release, err := m.Acquire(t.Context(), tt.second(base))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer release()
+
+			if shared := second == first; shared != tt.wantShared {
+				t.Errorf("second client shares the first pool: %v, want %v", shared, tt.wantShared)
+			}
+			wantOpened := 2
+			if tt.wantShared {
+				wantOpened = 1
+			}
+			if len(*opened) != wantOpened {
+				t.Errorf("opened %d connections, want %d", len(*opened), wantOpened)
+			}
+			if closed := first.(*fakeConn).isClosed(); closed != tt.wantRetired {
+				t.Errorf("first pool closed: %v, want %v", closed, tt.wantRetired)
+			}
+			if pools := len(m.List()); pools != tt.wantPools {
+				t.Errorf("%d pools listed, want %d", pools, tt.wantPools)
+			}
+		})
+	}
+}
+
+func TestConnectionManagerInvalidateInUse(t *testing.T) {
+	config := ClickHouseConfig{Host: "ch", Port: "9000", Username: "u"}
+	m, _ := newTestConnectionManager(t, PoolOptions{HealthCheckInterval: time.Hour})
+
+	conn, release, err := m.Acquire(t.Context(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses := m.List(); len(statuses) != 1 || statuses[0].InUse != 1 {
+		t.Fatalf("List = %+v, want one pool in use once", statuses)
+	}
+	if !m.Invalidate(config) {
+		t.Fatal("Invalidate found no pool")
+	}
+	if m.Invalidate(config) {
+		t.Error("Invalidate found a pool after it was invalidated")
+	}
+	if len(m.List()) != 0 {
+		t.Error("an invalidated pool is still listed")
+	}
+	if conn.(*fakeConn).isClosed() {
+		t.Fatal("an invalidated pool was closed while in use")
+	}
+
+	// Releasing twice must not count the client twice
+	release()
+	release()
+	if !conn.(*fakeConn).isClosed() {
+		t.Error("an invalidated pool was not closed on its last release")
+	}
+
+	next, release, err := m.Acquire(t.Context(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if next == conn {
+		t.Error("the client after an invalidation reused the closed pool")
+	}
+}
+
+func TestConnectionManagerEvictIdle(t *testing.T) {
+	tests := []struct {
+		name        string
+		idleTimeout time.Duration
+		idleFor     time.Duration
+		inUse       bool
+		wantEvicted bool
+	}{
+		{name: "idle past the timeout", idleTimeout: time.Minute, idleFor: 2 * time.Minute, wantEvicted: true},
+		{name: "idle within the timeout", idleTimeout: time.Minute, idleFor: 30 * time.Second},
+		{name: "in use past the timeout", idleTimeout: time.Minute, idleFor: 2 * time.Minute, inUse: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := ClickHouseConfig{Host: "ch", Port: "9000", Username: "u"}
+			m, _ := newTestConnectionManager(t, PoolOptions{IdleTimeout: tt.idleTimeout, HealthCheckInterval: time.Hour})
+
+			conn, release, err := m.Acquire(t.Context(), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.inUse {
+				release()
+			}
+			m.mu.Lock()
+			m.pools[profileOf(config)].lastUsed = time.Now().Add(-tt.idleFor)
+			m.mu.Unlock()
+
+			m.evictExpired()
+			if evicted := len(m.List()) == 0; evicted != tt.wantEvicted {
+				t.Errorf("evicted: %v, want %v", evicted, tt.wantEvicted)
+			}
+			if closed := conn.(*fakeConn).isClosed(); closed != tt.wantEvicted {
+				t.Errorf("closed: %v, want %v", closed, tt.wantEvicted)
+			}
+			if tt.inUse {
+				release()
+			}
+		})
+	}
+}
+
+func TestNewConnectionManagerCapsIdle(t *testing.T) {
+	tests := []struct {
+		maxOpen, maxIdle, wantIdle int
+	}{
+		{10, 5, 5},
+		{5, 10, 5},
+		{0, 10, 10},
+	}
+	for _, tt := range tests {
+		m := NewConnectionManager(PoolOptions{MaxOpenConns: tt.maxOpen, MaxIdleConns: tt.maxIdle})
+		m.CloseAll()
+		if m.options.MaxIdleConns != tt.wantIdle {
+			t.Errorf("max idle with %d open and %d idle = %d, want %d", tt.maxOpen, tt.maxIdle, m.options.MaxIdleConns, tt.wantIdle)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// typeDowngrade rewrites a type that older servers do not understand into one they do
+type typeDowngrade struct {
+	pattern *regexp.Regexp
+	replace string
+	// major and minor are the first server version that supports the original type
+	major, minor uint64
+}
+
+// typeDowngrades lists the rewrites applied when creating tables on older servers
+var typeDowngrades = []typeDowngrade{
+	{pattern: regexp.MustCompile(`\bBool\b`), replace: "UInt8", major: 21, minor: 12},
+	{pattern: regexp.MustCompile(`\bDate32\b`), replace: "Date", major: 21, minor: 9},
+}
+
+// supports reports whether version is at least major.minor
+func supports(version *driver.ServerVersion, major, minor uint64) bool {
+	if version.Version.Major != major {
+		return version.Version.Major > major
+	}
+	return version.Version.Minor >= minor
+}
+
+// adaptColumnsForServer rewrites column types the target server is too old to create
+func adaptColumnsForServer(columns []Column, version *driver.ServerVersion) []Column {
+	adapted := make([]Column, len(columns))
+	for i, col := range columns {
+		adapted[i] = col
+		for _, d := range typeDowngrades {
+			if !supports(version, d.major, d.minor) && d.pattern.MatchString(col.Type) {
+				adapted[i].Type = d.pattern.ReplaceAllString(adapted[i].Type, d.replace)
+			}
+		}
+		if adapted[i].Type != col.Type {
+			log.Printf("Column %s: using %s instead of %s on ClickHouse %s", col.Name, adapted[i].Type, col.Type, version.Version)
+		}
+	}
+	return adapted
+}
+
+// defaultNativePort is the port of ClickHouse's native protocol when a config leaves it empty
+const defaultNativePort = "9000"
+
+// sameClickHouseTable reports whether two configs and table names point at the same table
+func sameClickHouseTable(a ClickHouseConfig, aTable string, b ClickHouseConfig, bTable string) bool {
+	return serverAddress(a) == serverAddress(b) && qualifiedTableName(a, aTable) == qualifiedTableName(b, bTable)
+}
+
+// serverAddress returns the host and native port of config in a canonical form, so that
+// spellings of the same server compare equal: host names are case-insensitive, every loopback
+// address is localhost and an empty port is the default one
+func serverAddress(config ClickHouseConfig) string {
+	host := strings.TrimSuffix(strings.ToLower(strings.Trim(config.Host, "[]")), ".")
+	if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+		host = "localhost"
+	} else if ip != nil {
+		host = ip.String()
+	}
+	port := config.Port
+	if port == "" {
+		port = defaultNativePort
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// qualifiedTableName returns tableName as database.table, resolving an unqualified name against
+// the config's database, or the server's default database when none is set
+func qualifiedTableName(config ClickHouseConfig, tableName string) string {
+	if strings.Contains(tableName, ".") {
+		return tableName
+	}
+	database := config.Database
+	if database == "" {
+		database = "default"
+	}
+	return database + "." + tableName
+}
+
+// keyExpression wraps a key read from system.tables, which lists a composite key as "a, b", in
+// parentheses so it can be used as a clause
+func keyExpression(key string) string {
+	if key == "" {
+		return ""
+	}
+	return "(" + key + ")"
+}
+
+// copyTargetTableOptions returns the DDL clauses for a table created by a ClickHouse-to-ClickHouse
+// copy. Explicit options win; otherwise the source table's keys are reused when the columns keep
+// their names.
+func copyTargetTableOptions(ctx context.Context, req IngestionRequest, plan ingestionPlan) (TableOptions, error) {
+	options := req.TableOptions
+	if req.Source != SourceClickHouse || plan.renames || isJoinRequest(req) {
+		return options, nil
+	}
+	if options.OrderBy != "" || options.PartitionBy != "" || options.PrimaryKey != "" {
+		return options, nil
+	}
+
+	client, err := NewClickHouseClient(sourceClickHouseConfig(req))
+	if err != nil {
+		return options, fmt.Errorf("failed to connect to ClickHouse source: %w", err)
+	}
+	defer client.Close()
+
+	sourceOptions, err := client.GetTableOptions(ctx, sourceTableName(req))
+	if err != nil {
+		return options, err
+	}
+
+	// Keys can only be reused when every column they reference is copied
+	selected := make(map[string]bool, len(plan.selectedColumns))
+	for _, col := range plan.selectedColumns {
+		selected[col] = true
+	}
+	for _, key := range []string{sourceOptions.OrderBy, sourceOptions.PartitionBy, sourceOptions.PrimaryKey} {
+		for _, col := range keyColumns(key) {
+			if !selected[col] {
+				log.Printf("Not reusing source table keys: %s references %s, which is not copied", key, col)
+				return options, nil
+			}
+		}
+	}
+
+	options.OrderBy = keyExpression(sourceOptions.OrderBy)
+	options.PartitionBy = keyExpression(sourceOptions.PartitionBy)
+	options.PrimaryKey = keyExpression(sourceOptions.PrimaryKey)
+	return options, nil
+}
+
+// keyTokenPattern finds the parts of a key expression that matter for its column references:
+// string and number literals, which are skipped, backquoted names, and bare identifiers
+// followed by an opening parenthesis when they are function calls
+var keyTokenPattern = regexp.MustCompile("'(?:[^'\\\\]|\\\\.)*'|[0-9][0-9A-Za-z_.]*|`((?:[^`\\\\]|\\\\.)*)`|([A-Za-z_][A-Za-z0-9_]*)(\\s*\\()?")
+
+// keyColumns returns the columns a key expression read from system.tables references.
+// Any name called like a function is taken to be one.
+func keyColumns(key string) []string {
+	var columns []string
+	for _, match := range keyTokenPattern.FindAllStringSubmatch(key, -1) {
+		switch {
+		case strings.HasPrefix(match[0], "`"):
+			columns = append(columns, strings.ReplaceAll(match[1], "\\`", "`"))
+		case match[2] != "" && match[3] == "":
+			columns = append(columns, match[2])
+		}
+	}
+	return columns
+}
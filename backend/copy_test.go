@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSameClickHouseTable(t *testing.T) {
+	base := ClickHouseConfig{Host: "localhost", Port: "9000", Database: "analytics"}
+	tests := []struct {
+		name   string
+		config ClickHouseConfig
+		table  string
+		want   bool
+	}{
+		{name: "identical", config: base, table: "events", want: true},
+		{name: "IPv4 loopback", config: ClickHouseConfig{Host: "127.0.0.1", Port: "9000", Database: "analytics"}, table: "events", want: true},
+		{name: "IPv6 loopback", config: ClickHouseConfig{Host: "[::1]", Port: "9000", Database: "analytics"}, table: "events", want: true},
+		{name: "host case", config: ClickHouseConfig{Host: "LOCALHOST", Port: "9000", Database: "analytics"}, table: "events", want: true},
+		{name: "default port", config: ClickHouseConfig{Host: "localhost", Database: "analytics"}, table: "events", want: true},
+		{name: "qualified name", config: ClickHouseConfig{Host: "localhost", Port: "9000"}, table: "analytics.events", want: true},
+		{name: "other port", config: ClickHouseConfig{Host: "localhost", Port: "9001", Database: "analytics"}, table: "events"},
+		{name: "other host", config: ClickHouseConfig{Host: "db.example.com", Port: "9000", Database: "analytics"}, table: "events"},
+		{name: "other database", config: ClickHouseConfig{Host: "localhost", Port: "9000"}, table: "events"},
+		{name: "other table", config: base, table: "events_copy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameClickHouseTable(base, "events", tt.config, tt.table); got != tt.want {
+				t.Errorf("sameClickHouseTable = %v, want %v", got, tt.want)
+			}
+			if got := sameClickHouseTable(tt.config, tt.table, base, "events"); got != tt.want {
+				t.Errorf("sameClickHouseTable with the configs swapped = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyColumns(t *testing.T) {
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{key: "", want: nil},
+		{key: "id", want: []string{"id"}},
+		{key: "id, at", want: []string{"id", "at"}},
+		{key: "toYYYYMM(at)", want: []string{"at"}},
+		{key: "toStartOfInterval(at, toIntervalMinute(15))", want: []string{"at"}},
+		{key: "(region, cityHash64 (user_id))", want: []string{"region", "user_id"}},
+		{key: "tuple()", want: nil},
+		{key: "ifNull(name, 'no name'), `order id`", want: []string{"name", "order id"}},
+		{key: "intDiv(id, 1e3)", want: []string{"id"}},
+	}
+	for _, tt := range tests {
+		if got := keyColumns(tt.key); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("keyColumns(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CSVEscape is how a quote character is written inside a quoted field
+type CSVEscape string
+
+const (
+	// EscapeDouble doubles the quote character: "say ""hi"""
+	EscapeDouble CSVEscape = "double"
+	// EscapeBackslash precedes the quote and backslash with a backslash: "say \"hi\"".
+	// On read \n, \r, \t and \0 stand for their control characters.
+	EscapeBackslash CSVEscape = "backslash"
+)
+
+// QuoteStyle picks which fields are quoted on write
+type QuoteStyle string
+
+const (
+	// QuoteMinimal quotes only fields that would otherwise not read back the same
+	QuoteMinimal QuoteStyle = "minimal"
+	// QuoteAll quotes every field
+	QuoteAll QuoteStyle = "all"
+	// QuoteNonNumeric quotes every field except those of number columns
+	QuoteNonNumeric QuoteStyle = "nonnumeric"
+)
+
+// CSVOptions describes the dialect of delimited files beyond the delimiter, which may be
+// several characters long
+type CSVOptions struct {
+	// Quote is the character enclosing fields; empty means a double quote
+	Quote string `json:"quote"`
+	// Escape is double (default) or backslash
+	Escape CSVEscape `json:"escape"`
+	// LazyQuotes accepts quotes inside unquoted fields and stray quotes inside quoted ones
+	LazyQuotes bool `json:"lazyQuotes"`
+	// Comment is a prefix marking lines to skip on read
+	Comment string `json:"comment"`
+	// TrimLeadingSpace ignores white space at the start of each field on read
+	TrimLeadingSpace bool `json:"trimLeadingSpace"`
+	// LineTerminator ends written records: lf (default), crlf or cr. Reading accepts LF and
+	// CRLF line ends, or only CR when set to cr.
+	LineTerminator string `json:"lineTerminator"`
+	// QuoteStyle is minimal (default), all or nonnumeric
+	QuoteStyle QuoteStyle `json:"quoteStyle"`
+	// WriteBOM starts written files with a byte order mark; one is always stripped on read
+	WriteBOM bool `json:"writeBom"`
+}
+
+// csvDialect is a validated set of CSVOptions together with the delimiter
+type csvDialect struct {
+	delimiter        string
+	quote            rune
+	backslash        bool
+	lazyQuotes       bool
+	comment          string
+	trimLeadingSpace bool
+	terminator       string
+	quoteStyle       QuoteStyle
+	writeBOM         bool
+	// nullTokens are only read as NULL unquoted, and quoted when written as values
+	nullTokens map[string]bool
+}
+
+// newCSVDialect checks options against each other and the delimiter
+func newCSVDialect(delimiter string, options CSVOptions) (csvDialect, error) {
+	dialect := csvDialect{
+		delimiter:        delimiter,
+		quote:            '"',
+		lazyQuotes:       options.LazyQuotes,
+		comment:          options.Comment,
+		trimLeadingSpace: options.TrimLeadingSpace,
+		quoteStyle:       options.QuoteStyle,
+		writeBOM:         options.WriteBOM,
+	}
+
+	if options.Quote != "" {
+		if utf8.RuneCountInString(options.Quote) != 1 {
+			return dialect, fmt.Errorf("the CSV quote must be a single character: %q", options.Quote)
+		}
+		dialect.quote, _ = utf8.DecodeRuneInString(options.Quote)
+	}
+	if dialect.quote == '\r' || dialect.quote == '\n' {
+		return dialect, fmt.Errorf("the CSV quote cannot be a line break")
+	}
+
+	switch options.Escape {
+	case "", EscapeDouble:
+	case EscapeBackslash:
+		dialect.backslash = true
+		if dialect.quote == '\\' {
+			return dialect, fmt.Errorf("a backslash cannot be both the quote and the escape")
+		}
+	default:
+		return dialect, fmt.Errorf("invalid CSV escape: %q", options.Escape)
+	}
+
+	switch strings.ToLower(options.LineTerminator) {
+	case "", "lf":
+		dialect.terminator = "\n"
+	case "crlf":
+		dialect.terminator = "\r\n"
+	case "cr":
+		dialect.terminator = "\r"
+	default:
+		return dialect, fmt.Errorf("invalid CSV line terminator: %q", options.LineTerminator)
+	}
+
+	switch dialect.quoteStyle {
+	case "":
+		dialect.quoteStyle = QuoteMinimal
+	case QuoteMinimal, QuoteAll, QuoteNonNumeric:
+	default:
+		return dialect, fmt.Errorf("invalid CSV quote style: %q", options.QuoteStyle)
+	}
+
+	if delimiter == "" || strings.ContainsAny(delimiter, "\r\n") {
+		return dialect, fmt.Errorf("invalid CSV delimiter: %q", delimiter)
+	}
+	if strings.ContainsRune(delimiter, dialect.quote) {
+		return dialect, fmt.Errorf("the CSV delimiter cannot contain the quote character")
+	}
+	if strings.ContainsAny(options.Comment, "\r\n") || (options.Comment != "" && strings.HasPrefix(delimiter, options.Comment)) {
+		return dialect, fmt.Errorf("invalid CSV comment prefix: %q", options.Comment)
+	}
+	return dialect, nil
+}
+
+// csvDialect returns the file's validated CSV dialect
+func (f *FlatFileClient) csvDialect() (csvDialect, error) {
+	dialect, err := newCSVDialect(f.config.Delimiter, f.config.CSV)
+	dialect.nullTokens = f.nullMarkers().tokens
+	return dialect, err
+}
+
+// standard reports whether encoding/csv can read the dialect. It cannot tell quoted fields
+// from unquoted ones, which NULL tokens need, so files with the default \N token go through
+// dialectReader, which slices plain fields out of the line and so stays within about half
+// again of encoding/csv's time.
+func (d csvDialect) standard() bool {
+	if d.quote != '"' || d.backslash || d.terminator == "\r" || len(d.nullTokens) > 0 {
+		return false
+	}
+	comma, size := utf8.DecodeRuneInString(d.delimiter)
+	if size != len(d.delimiter) || comma == utf8.RuneError || comma == 0 {
+		return false
+	}
+	return utf8.RuneCountInString(d.comment) <= 1
+}
+
+// csvReader reads records of delimited text
+type csvReader interface {
+	Read() ([]string, error)
+}
+
+// quotedFieldReader is a csvReader that tells which fields of the last record were quoted
+type quotedFieldReader interface {
+	csvReader
+	Quoted(i int) bool
+}
+
+// newReader returns a reader over UTF-8 text in the dialect
+func (d csvDialect) newReader(r io.Reader) csvReader {
+	if d.standard() {
+		reader := csv.NewReader(r)
+		reader.Comma, _ = utf8.DecodeRuneInString(d.delimiter)
+		if d.comment != "" {
+			reader.Comment, _ = utf8.DecodeRuneInString(d.comment)
+		}
+		reader.LazyQuotes = d.lazyQuotes
+		reader.TrimLeadingSpace = d.trimLeadingSpace
+		return reader
+	}
+	return &dialectReader{dialect: d, r: bufio.NewReader(r)}
+}
+
+// dialectReader parses the dialects encoding/csv cannot: other quote characters, backslash
+// escapes, multi-character delimiters and comment prefixes, and CR line ends. Like
+// encoding/csv it skips empty lines and requires every record to have as many fields as the first.
+type dialectReader struct {
+	dialect csvDialect
+	r       *bufio.Reader
+	// line is the number of lines read so far
+	line   int
+	fields int
+	// quoted marks the quoted fields of the last record
+	quoted []bool
+}
+
+// readLine returns the next line without its line end
+func (r *dialectReader) readLine() (string, error) {
+	end := byte('\n')
+	if r.dialect.terminator == "\r" {
+		end = '\r'
+	}
+	line, err := r.r.ReadString(end)
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	r.line++
+	line = strings.TrimSuffix(line, string(end))
+	if end == '\n' {
+		line = strings.TrimSuffix(line, "\r")
+	}
+	return line, nil
+}
+
+// Read returns the next record
+func (r *dialectReader) Read() ([]string, error) {
+	var line string
+	for {
+		var err error
+		if line, err = r.readLine(); err != nil {
+			return nil, err
+		}
+		if line != "" && (r.dialect.comment == "" || !strings.HasPrefix(line, r.dialect.comment)) {
+			break
+		}
+	}
+
+	start := r.line
+	record, err := r.parseRecord(line, start)
+	if err != nil {
+		return nil, err
+	}
+	if r.fields == 0 {
+		r.fields = len(record)
+	} else if len(record) != r.fields {
+		return record, &csv.ParseError{StartLine: start, Line: r.line, Column: 1, Err: csv.ErrFieldCount}
+	}
+	return record, nil
+}
+
+// parseRecord splits line, and any further lines a quoted field runs into, into fields
+func (r *dialectReader) parseRecord(line string, start int) ([]string, error) {
+	d := r.dialect
+	record := make([]string, 0, r.fields)
+	r.quoted = r.quoted[:0]
+	pos := 0
+	for {
+		if d.trimLeadingSpace {
+			for pos < len(line) {
+				c, size := utf8.DecodeRuneInString(line[pos:])
+				if !unicode.IsSpace(c) {
+					break
+				}
+				pos += size
+			}
+		}
+
+		var field string
+		var err error
+		c, size := utf8.DecodeRuneInString(line[pos:])
+		quoted := pos < len(line) && c == d.quote
+		if quoted {
+			field, line, pos, err = r.readQuoted(line, pos+size, start)
+		} else {
+			field, pos, err = r.readUnquoted(line, pos, start)
+		}
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, field)
+		r.quoted = append(r.quoted, quoted)
+
+		if pos >= len(line) {
+			return record, nil
+		}
+		pos += len(d.delimiter)
+	}
+}
+
+// Quoted reports whether field i of the last record was quoted
+func (r *dialectReader) Quoted(i int) bool {
+	return i < len(r.quoted) && r.quoted[i]
+}
+
+// readUnquoted reads a field up to the next delimiter or the line end and returns it with the
+// position after it. A field that is a NULL token is returned as written, escapes and all, so
+// a backslash dialect's \N is still matched.
+func (r *dialectReader) readUnquoted(line string, pos, start int) (string, int, error) {
+	d := r.dialect
+	end := strings.Index(line[pos:], d.delimiter)
+	if end < 0 {
+		end = len(line)
+	} else {
+		end += pos
+	}
+	raw := line[pos:end]
+	if !d.backslash || !strings.ContainsRune(raw, '\\') {
+		// Nothing to unescape, so the field is the text up to the delimiter
+		if i := strings.IndexRune(raw, d.quote); i >= 0 && !d.lazyQuotes {
+			return "", pos, &csv.ParseError{StartLine: start, Line: r.line, Column: pos + i + 1, Err: csv.ErrBareQuote}
+		}
+		return raw, end, nil
+	}
+
+	// An escaped delimiter does not end the field, so scan it a character at a time
+	var field strings.Builder
+	begin := pos
+	for pos < len(line) && !strings.HasPrefix(line[pos:], d.delimiter) {
+		c, size := utf8.DecodeRuneInString(line[pos:])
+		switch {
+		case c == '\\' && pos+size < len(line):
+			escaped, esize := utf8.DecodeRuneInString(line[pos+size:])
+			field.WriteRune(unescapeCSV(escaped))
+			size += esize
+		case c == d.quote && !d.lazyQuotes:
+			return "", pos, &csv.ParseError{StartLine: start, Line: r.line, Column: pos + 1, Err: csv.ErrBareQuote}
+		default:
+			field.WriteString(line[pos : pos+size])
+		}
+		pos += size
+	}
+	if raw := line[begin:pos]; d.nullTokens[raw] {
+		return raw, pos, nil
+	}
+	return field.String(), pos, nil
+}
+
+// readQuoted reads a quoted field whose opening quote ends before pos, continuing onto
+// further lines as needed. It returns the field, the line it ended on and the position after it.
+func (r *dialectReader) readQuoted(line string, pos, start int) (string, string, int, error) {
+	d := r.dialect
+	// A field closed on the same line without escapes is the text between the quotes
+	if i := strings.IndexRune(line[pos:], d.quote); i >= 0 {
+		after := pos + i + utf8.RuneLen(d.quote)
+		if (after >= len(line) || strings.HasPrefix(line[after:], d.delimiter)) && (!d.backslash || !strings.ContainsRune(line[pos:pos+i], '\\')) {
+			return line[pos : pos+i], line, after, nil
+		}
+	}
+
+	var field strings.Builder
+	for {
+		if pos >= len(line) {
+			// The line end is part of the field
+			next, err := r.readLine()
+			if err == io.EOF {
+				if d.lazyQuotes {
+					return field.String(), line, pos, nil
+				}
+				return "", line, pos, &csv.ParseError{StartLine: start, Line: r.line, Column: pos + 1, Err: csv.ErrQuote}
+			}
+			if err != nil {
+				return "", line, pos, err
+			}
+			field.WriteByte('\n')
+			line, pos = next, 0
+			continue
+		}
+
+		c, size := utf8.DecodeRuneInString(line[pos:])
+		pos += size
+		switch {
+		case d.backslash && c == '\\':
+			if pos < len(line) {
+				escaped, esize := utf8.DecodeRuneInString(line[pos:])
+				field.WriteRune(unescapeCSV(escaped))
+				pos += esize
+			}
+		case c == d.quote:
+			if !d.backslash && strings.HasPrefix(line[pos:], string(d.quote)) {
+				field.WriteRune(c)
+				pos += size
+				continue
+			}
+			if pos >= len(line) || strings.HasPrefix(line[pos:], d.delimiter) {
+				return field.String(), line, pos, nil
+			}
+			if !d.lazyQuotes {
+				return "", line, pos, &csv.ParseError{StartLine: start, Line: r.line, Column: pos + 1, Err: csv.ErrQuote}
+			}
+			field.WriteRune(c)
+		default:
+			field.WriteString(line[pos-size : pos])
+		}
+	}
+}
+
+// unescapeCSV returns the character a backslash escape stands for
+func unescapeCSV(c rune) rune {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case '0':
+		return 0
+	}
+	return c
+}
+
+// csvWriter writes records of delimited text in a dialect
+type csvWriter struct {
+	dialect csvDialect
+	w       *bufio.Writer
+	// bom is set while a byte order mark is still to be written
+	bom bool
+	err error
+}
+
+// newWriter returns a writer of UTF-8 text in the dialect
+func (d csvDialect) newWriter(w io.Writer) *csvWriter {
+	return &csvWriter{dialect: d, w: bufio.NewWriter(w), bom: d.writeBOM}
+}
+
+// Write writes one record. numeric marks the fields of number columns and null the fields that
+// hold a NULL token, which is never quoted; either may be nil.
+func (w *csvWriter) Write(record []string, numeric, null []bool) error {
+	d := w.dialect
+	if w.bom {
+		w.w.WriteRune('\ufeff')
+		w.bom = false
+	}
+	for i, field := range record {
+		if i > 0 {
+			w.w.WriteString(d.delimiter)
+		}
+		isNull := i < len(null) && null[i]
+		if isNull || !w.needsQuotes(field, i < len(numeric) && numeric[i]) {
+			w.w.WriteString(field)
+			continue
+		}
+
+		w.w.WriteRune(d.quote)
+		for _, c := range field {
+			switch {
+			case c == d.quote && d.backslash:
+				w.w.WriteByte('\\')
+			case c == d.quote:
+				w.w.WriteRune(c)
+			case c == '\\' && d.backslash:
+				w.w.WriteByte('\\')
+			}
+			w.w.WriteRune(c)
+		}
+		w.w.WriteRune(d.quote)
+	}
+	_, err := w.w.WriteString(d.terminator)
+	return err
+}
+
+// needsQuotes reports whether field is quoted under the dialect's quote style
+func (w *csvWriter) needsQuotes(field string, numeric bool) bool {
+	d := w.dialect
+	switch {
+	case d.quoteStyle == QuoteAll:
+		return true
+	case d.quoteStyle == QuoteNonNumeric && !numeric:
+		return true
+	case d.nullTokens[field]:
+		// The value would read back as NULL
+		return true
+	case field == "":
+		return false
+	case strings.Contains(field, d.delimiter), strings.ContainsRune(field, d.quote), strings.ContainsAny(field, "\r\n"):
+		return true
+	case d.backslash && strings.ContainsRune(field, '\\'):
+		return true
+	case d.comment != "" && strings.HasPrefix(field, d.comment):
+		return true
+	}
+	// Leading space would be lost to readers that trim it
+	c, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(c)
+}
+
+// Flush writes any buffered data to the underlying writer
+func (w *csvWriter) Flush() {
+	if err := w.w.Flush(); w.err == nil {
+		w.err = err
+	}
+}
+
+// Error reports any error from a previous Write or Flush
+func (w *csvWriter) Error() error {
+	return w.err
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCSVDialectWrite(t *testing.T) {
+	tests := []struct {
+		name      string
+		delimiter string
+		options   CSVOptions
+		record    []string
+		numeric   []bool
+		want      string
+	}{
+		{"plain fields", ",", CSVOptions{}, []string{"a", "1", ""}, nil, "a,1,\n"},
+		{"delimiter quoted", ",", CSVOptions{}, []string{"a,b", "c"}, nil, "\"a,b\",c\n"},
+		{"quote doubled", ",", CSVOptions{}, []string{`say "hi"`}, nil, "\"say \"\"hi\"\"\"\n"},
+		{"line break quoted", ",", CSVOptions{}, []string{"a\nb"}, nil, "\"a\nb\"\n"},
+		{"leading space quoted", ",", CSVOptions{}, []string{" a"}, nil, "\" a\"\n"},
+		{"comment prefix quoted", ",", CSVOptions{Comment: "#"}, []string{"#a", "b#"}, nil, "\"#a\",b#\n"},
+		{"backslash escape", ",", CSVOptions{Escape: EscapeBackslash}, []string{`say "hi"`, `C:\dir`}, nil, "\"say \\\"hi\\\"\",\"C:\\\\dir\"\n"},
+		{"single quote", ",", CSVOptions{Quote: "'"}, []string{"it's", `"x"`}, nil, "'it''s',\"x\"\n"},
+		{"multi-character delimiter", "||", CSVOptions{}, []string{"a|b", "c||d"}, nil, "a|b||\"c||d\"\n"},
+		{"quote all", ",", CSVOptions{QuoteStyle: QuoteAll}, []string{"a", "1"}, nil, "\"a\",\"1\"\n"},
+		{"quote non-numeric", ",", CSVOptions{QuoteStyle: QuoteNonNumeric}, []string{"a", "1"}, []bool{false, true}, "\"a\",1\n"},
+		{"crlf terminator", ",", CSVOptions{LineTerminator: "crlf"}, []string{"a", "b"}, nil, "a,b\r\n"},
+		{"cr terminator", ",", CSVOptions{LineTerminator: "CR"}, []string{"a", "b"}, nil, "a,b\r"},
+		{"byte order mark", ",", CSVOptions{WriteBOM: true}, []string{"a"}, nil, "\ufeffa\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialect, err := newCSVDialect(tt.delimiter, tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			w := dialect.newWriter(&out)
+			if err := w.Write(tt.record, tt.numeric, nil); err != nil {
+				t.Fatal(err)
+			}
+			w.Flush()
+			if err := w.Error(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("wrote %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVDialectRead(t *testing.T) {
+	tests := []struct {
+		name      string
+		delimiter string
+		options   CSVOptions
+		input     string
+		want      [][]string
+		wantError bool
+	}{
+		{"standard", ",", CSVOptions{}, "a,b\n\"c,d\",\"e\"\"f\"\n", [][]string{{"a", "b"}, {"c,d", `e"f`}}, false},
+		{"crlf line ends", ",", CSVOptions{}, "a,b\r\nc,d\r\n", [][]string{{"a", "b"}, {"c", "d"}}, false},
+		{"cr line ends", ",", CSVOptions{LineTerminator: "cr"}, "a,b\rc,d\r", [][]string{{"a", "b"}, {"c", "d"}}, false},
+		{"quoted line break", ";", CSVOptions{Quote: "'"}, "'a\nb';c\n", [][]string{{"a\nb", "c"}}, false},
+		{"doubled custom quote", ",", CSVOptions{Quote: "'"}, "'it''s',x\n", [][]string{{"it's", "x"}}, false},
+		{"backslash escapes", ",", CSVOptions{Escape: EscapeBackslash}, `"say \"hi\"",a\tb,"C:\\dir"` + "\n", [][]string{{`say "hi"`, "a\tb", `C:\dir`}}, false},
+		{"escaped delimiter", ",", CSVOptions{Escape: EscapeBackslash}, `a\,b,c\` + "\n", [][]string{{"a,b", `c\`}}, false},
+		{"multi-character delimiter", "::", CSVOptions{}, "a:b::\"c::d\"::\n", [][]string{{"a:b", "c::d", ""}}, false},
+		{"comment prefix", ",", CSVOptions{Comment: "--"}, "-- note\na,b\n-a,c\n", [][]string{{"a", "b"}, {"-a", "c"}}, false},
+		{"single-character comment", ",", CSVOptions{Comment: "#"}, "#note\na,b\n", [][]string{{"a", "b"}}, false},
+		{"trim leading space", ",", CSVOptions{TrimLeadingSpace: true}, "a,  b\n", [][]string{{"a", "b"}}, false},
+		{"empty lines skipped", ",", CSVOptions{Quote: "'"}, "a,b\n\nc,d\n", [][]string{{"a", "b"}, {"c", "d"}}, false},
+		{"bare quote rejected", ",", CSVOptions{Quote: "'"}, "a'b,c\n", nil, true},
+		{"bare quote accepted lazily", ",", CSVOptions{Quote: "'", LazyQuotes: true}, "a'b,c\n", [][]string{{"a'b", "c"}}, false},
+		{"unterminated quote rejected", ",", CSVOptions{Quote: "'"}, "'a,b\n", nil, true},
+		{"field count mismatch", ",", CSVOptions{Quote: "'"}, "a,b\nc\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialect, err := newCSVDialect(tt.delimiter, tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := readCSVRecords(dialect, tt.input)
+			if tt.wantError {
+				if err == nil {
+					t.Fatalf("read %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVDialectRoundTrip(t *testing.T) {
+	records := [][]string{
+		{"plain", "", " leading space"},
+		{`quote " inside`, "comma, semicolon; tab\t", `back\slash`},
+		{"line\nbreak", "carriage\r\nreturn", "'single'"},
+		{"#hash", "--dash", "ünïcödé"},
+	}
+	tests := []struct {
+		name      string
+		delimiter string
+		options   CSVOptions
+	}{
+		{"default", ",", CSVOptions{}},
+		{"backslash escape", ",", CSVOptions{Escape: EscapeBackslash}},
+		{"single quote and tab", "\t", CSVOptions{Quote: "'"}},
+		{"multi-character delimiter", "<>", CSVOptions{Comment: "--"}},
+		{"quote all with crlf", ";", CSVOptions{QuoteStyle: QuoteAll, LineTerminator: "crlf", Comment: "#"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialect, err := newCSVDialect(tt.delimiter, tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			w := dialect.newWriter(&out)
+			for _, record := range records {
+				if err := w.Write(record, nil, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w.Flush()
+
+			got, err := readCSVRecords(dialect, out.String())
+			if err != nil {
+				t.Fatalf("reading %q: %v", out.String(), err)
+			}
+			// Line ends inside quoted fields read back as LF
+			want := make([][]string, len(records))
+			for i, record := range records {
+				for _, field := range record {
+					want[i] = append(want[i], strings.ReplaceAll(field, "\r\n", "\n"))
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("read %q back from %q, want %q", got, out.String(), want)
+			}
+		})
+	}
+}
+
+func TestNewCSVDialectErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		delimiter string
+		options   CSVOptions
+	}{
+		{"long quote", ",", CSVOptions{Quote: "''"}},
+		{"line break quote", ",", CSVOptions{Quote: "\n"}},
+		{"backslash quote and escape", ",", CSVOptions{Quote: `\`, Escape: EscapeBackslash}},
+		{"unknown escape", ",", CSVOptions{Escape: "html"}},
+		{"unknown line terminator", ",", CSVOptions{LineTerminator: "nel"}},
+		{"unknown quote style", ",", CSVOptions{QuoteStyle: "some"}},
+		{"empty delimiter", "", CSVOptions{}},
+		{"line break delimiter", "\n", CSVOptions{}},
+		{"quote in delimiter", `,"`, CSVOptions{}},
+		{"comment starting the delimiter", "#,", CSVOptions{Comment: "#"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newCSVDialect(tt.delimiter, tt.options); err == nil {
+				t.Error("newCSVDialect succeeded, want an error")
+			}
+		})
+	}
+}
+
+// readCSVRecords reads every record of input in dialect
+func readCSVRecords(dialect csvDialect, input string) ([][]string, error) {
+	r := dialect.newReader(strings.NewReader(input))
+	var records [][]string
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
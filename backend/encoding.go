@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// textEncoding looks up a character encoding by its WHATWG label (e.g. "windows-1252",
+// "iso-8859-1", "utf-16le", "shift_jis"). It returns nil for UTF-8, which needs no transcoding.
+func textEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "utf-8", "utf8":
+		return nil, nil
+	}
+
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported encoding: %q", name)
+	}
+	if enc == unicode.UTF8 {
+		return nil, nil
+	}
+	return enc, nil
+}
+
+// isUnicodeEncoding reports whether name is UTF-8 or UTF-16, which can carry a byte order mark
+func isUnicodeEncoding(name string) bool {
+	enc, err := textEncoding(name)
+	if err != nil {
+		return false
+	}
+	if enc == nil {
+		return true
+	}
+	canonical, _ := htmlindex.Name(enc)
+	return strings.HasPrefix(canonical, "utf-")
+}
+
+// decodeReader returns a reader that yields UTF-8 from r, which is encoded as name.
+// A leading byte order mark is honoured and stripped.
+func decodeReader(r io.Reader, name string) (io.Reader, error) {
+	enc, err := textEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoder transform.Transformer = transform.Nop
+	if enc != nil {
+		decoder = enc.NewDecoder()
+	}
+	return transform.NewReader(r, unicode.BOMOverride(decoder)), nil
+}
+
+// encodeWriter returns a writer that encodes UTF-8 written to it as name before passing it to w.
+// The returned writer must be closed to flush any partially encoded input.
+func encodeWriter(w io.Writer, name string) (io.WriteCloser, error) {
+	enc, err := textEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
+		return nopWriteCloser{w}, nil
+	}
+	return transform.NewWriter(w, enc.NewEncoder()), nil
+}
+
+// nopWriteCloser adds a no-op Close to a writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing
+func (nopWriteCloser) Close() error {
+	return nil
+}
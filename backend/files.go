@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDataDir is where uploaded files and exports for download are kept
+const defaultDataDir = "data"
+
+// uploadTempPattern names files still being uploaded; they are hidden from listings
+const uploadTempPattern = ".upload-*"
+
+// ErrFileNotFound is returned when no managed file exists for an ID
+var ErrFileNotFound = errors.New("file not found")
+
+// FileInfo describes a file in the managed data directory
+type FileInfo struct {
+	// ID is the file's name in the data directory, used as FlatFileConfig.FileID
+	ID         string     `json:"id"`
+	Size       int64      `json:"size"`
+	Format     FileFormat `json:"format"`
+	ModifiedAt time.Time  `json:"modifiedAt"`
+}
+
+// defaultMaxUploadBytes is the largest upload request accepted unless -max-upload-bytes says otherwise
+const defaultMaxUploadBytes = 4 << 30
+
+// FileStore keeps uploaded files and exports in one directory, addressed by file name
+type FileStore struct {
+	dir string
+	// maxUploadBytes limits the size of an upload request; zero or less means no limit
+	maxUploadBytes int64
+	// mu serialises picking names for new uploads
+	mu sync.Mutex
+}
+
+// NewFileStore creates the data directory if needed and returns a store over it that accepts
+// upload requests of at most maxUploadBytes, or any size when it is zero or less
+func NewFileStore(dir string, maxUploadBytes int64) (*FileStore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve data directory: %w", err)
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	return &FileStore{dir: abs, maxUploadBytes: maxUploadBytes}, nil
+}
+
+// files is the process-wide file store, set up by main from the -data-dir flag
+var files *FileStore
+
+// validFileID reports whether id is a plain, visible file name that cannot leave the data directory
+func validFileID(id string) bool {
+	return id != "" && !strings.HasPrefix(id, ".") && !strings.ContainsAny(id, `/\`) && filepath.Base(id) == id
+}
+
+// Path returns the path of the managed file id, which need not exist yet
+func (s *FileStore) Path(id string) (string, error) {
+	if !validFileID(id) {
+		return "", fmt.Errorf("invalid file id: %q", id)
+	}
+	return filepath.Join(s.dir, id), nil
+}
+
+// Stat returns the details of the managed file id
+func (s *FileStore) Stat(id string) (FileInfo, error) {
+	path, err := s.Path(id)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && !info.Mode().IsRegular()) {
+		return FileInfo{}, fmt.Errorf("%w: %s", ErrFileNotFound, id)
+	}
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return newFileInfo(info), nil
+}
+
+func newFileInfo(info os.FileInfo) FileInfo {
+	return FileInfo{
+		ID:         info.Name(),
+		Size:       info.Size(),
+		Format:     detectFileFormat(info.Name()),
+		ModifiedAt: info.ModTime(),
+	}
+}
+
+// List returns the managed files sorted by name
+func (s *FileStore) List() ([]FileInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+
+	list := []FileInfo{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !validFileID(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since the directory was read
+			continue
+		}
+		list = append(list, newFileInfo(info))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// LimitUpload caps the body of the upload request r at the store's upload limit. Reading past
+// it fails with an *http.MaxBytesError, and Save then removes the partial file.
+func (s *FileStore) LimitUpload(w http.ResponseWriter, r *http.Request) {
+	if s.maxUploadBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxUploadBytes)
+	}
+}
+
+// Save streams r into a new managed file named after name. An existing file is never
+// replaced; the name gets a numeric suffix instead.
+func (s *FileStore) Save(name string, r io.Reader) (FileInfo, error) {
+	tmp, err := os.CreateTemp(s.dir, uploadTempPattern)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("failed to create file: %w", err)
+	}
+	// The upload is written under a hidden name so a partial file is never listed
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		// Temporary files are private; give uploads the mode exports are created with
+		err = tmp.Chmod(0o644)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return FileInfo{}, fmt.Errorf("failed to store file: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := uniqueFileID(s.dir, uploadFileID(name))
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, id)); err != nil {
+		os.Remove(tmp.Name())
+		return FileInfo{}, fmt.Errorf("failed to store file: %w", err)
+	}
+	return s.Stat(id)
+}
+
+// Delete removes the managed file id
+func (s *FileStore) Delete(id string) error {
+	if _, err := s.Stat(id); err != nil {
+		return err
+	}
+	path, _ := s.Path(id)
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+// uploadFileID turns a client-supplied file name into a safe file id, keeping its extension
+func uploadFileID(name string) string {
+	// Browsers on Windows may send the full path
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	id := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+	id = strings.TrimLeft(id, ".")
+	if id == "" {
+		return "upload"
+	}
+	return id
+}
+
+// uniqueFileID returns id, or id with a numeric suffix before its extensions, that is not
+// yet taken in dir. "sales.csv.gz" becomes "sales-2.csv.gz".
+func uniqueFileID(dir, id string) string {
+	stem, ext := id, ""
+	if i := strings.Index(id, "."); i > 0 {
+		stem, ext = id[:i], id[i:]
+	}
+	candidate := id
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(filepath.Join(dir, candidate)); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d%s", stem, n, ext)
+	}
+}
+
+// holds reports whether name is a file directly in the data directory
+func (s *FileStore) holds(name string) bool {
+	abs, err := filepath.Abs(name)
+	return err == nil && filepath.Dir(abs) == s.dir
+}
+
+// exportOutcome is shared by the files of one export, so a failure its caller notices keeps
+// the files still being written from being moved into place
+type exportOutcome struct {
+	failed bool
+}
+
+// createExportFile creates name for an export. A file in the data directory is written under a
+// hidden temporary name instead, like an upload, so it is never listed or downloaded half-written;
+// finishExportFile then moves it into place.
+func createExportFile(name string) (*os.File, error) {
+	if files == nil || !files.holds(name) {
+		return os.Create(name)
+	}
+	tmp, err := os.CreateTemp(files.dir, uploadTempPattern)
+	if err != nil {
+		return nil, err
+	}
+	// Temporary files are private; give exports the mode they would otherwise be created with
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// finishExportFile moves the closed file created for name into place, or removes it when the
+// export failed. A file created in place is left as it is.
+func finishExportFile(file *os.File, name string, failed bool) error {
+	if file.Name() == name {
+		return nil
+	}
+	if failed {
+		os.Remove(file.Name())
+		return nil
+	}
+	if err := os.Rename(file.Name(), name); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+	return nil
+}
+
+// resolveFileID points FileName at the managed file named by FileID, when one is set. An
+// invalid id leaves FileName empty, which validation reports.
+func resolveFileID(config FlatFileConfig) FlatFileConfig {
+	if config.FileID == "" || files == nil {
+		return config
+	}
+	path, err := files.Path(config.FileID)
+	if err != nil {
+		path = ""
+	}
+	config.FileName = path
+	return config
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFileStoreUploadLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int64
+		body      string
+		wantError bool
+	}{
+		{"no limit", 0, "a,b\n1,2\n", false},
+		{"under the limit", 16, "a,b\n1,2\n", false},
+		{"at the limit", 8, "a,b\n1,2\n", false},
+		{"over the limit", 4, "a,b\n1,2\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewFileStore(t.TempDir(), tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodPost, "/api/files?name=rows.csv", strings.NewReader(tt.body))
+			store.LimitUpload(httptest.NewRecorder(), r)
+
+			info, err := store.Save("rows.csv", r.Body)
+			var tooLarge *http.MaxBytesError
+			if tt.wantError != errors.As(err, &tooLarge) || (!tt.wantError && err != nil) {
+				t.Fatalf("Save error = %v, want a size limit error: %v", err, tt.wantError)
+			}
+
+			entries, err := os.ReadDir(store.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantError {
+				// The partial upload is removed, hidden temporary file included
+				if len(entries) != 0 {
+					t.Errorf("data directory holds %d entries after a rejected upload, want none", len(entries))
+				}
+				return
+			}
+			if len(entries) != 1 || info.ID != "rows.csv" || info.Size != int64(len(tt.body)) {
+				t.Errorf("stored %v with %d entries, want rows.csv of %d bytes", info, len(entries), len(tt.body))
+			}
+		})
+	}
+}
+
+// useFileStore makes a store over a temporary directory the process-wide one for the test
+func useFileStore(t *testing.T) *FileStore {
+	t.Helper()
+	store, err := NewFileStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := files
+	files = store
+	t.Cleanup(func() { files = previous })
+	return store
+}
+
+// dirNames returns the names of every entry in dir, hidden ones included
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestManagedExportStaging(t *testing.T) {
+	store := useFileStore(t)
+	source := filepath.Join(t.TempDir(), "in.csv")
+	if err := os.WriteFile(source, []byte("id\n1\n2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	request := func(target FlatFileConfig) IngestionRequest {
+		return IngestionRequest{
+			Source:             SourceFlatFile,
+			Target:             SourceFlatFile,
+			SourceFlatFileConf: &FlatFileConfig{FileName: source, Delimiter: ","},
+			TargetFlatFileConf: &target,
+		}
+	}
+
+	// While the export is written only a hidden file exists
+	client := NewFlatFileClient(FlatFileConfig{FileID: "open.csv"})
+	output, err := client.createOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := dirNames(t, store.dir); len(names) != 1 || !strings.HasPrefix(names[0], ".upload-") {
+		t.Errorf("while writing the directory holds %v, want one hidden file", names)
+	}
+	if list, _ := store.List(); len(list) != 0 {
+		t.Errorf("listed %v while writing", list)
+	}
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if names := dirNames(t, store.dir); !reflect.DeepEqual(names, []string{"open.csv"}) {
+		t.Errorf("after closing the directory holds %v, want open.csv", names)
+	}
+
+	// A finished export replaces the file it names
+	if _, err := runIngestion(t.Context(), request(FlatFileConfig{FileID: "open.csv"}), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(store.dir, "open.csv")); string(got) != "id\n1\n2\n" {
+		t.Errorf("exported %q", got)
+	}
+
+	// A failed export leaves nothing behind and the previous file as it was
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	for _, id := range []string{"open.csv", "failed.csv"} {
+		if _, err := runIngestion(ctx, request(FlatFileConfig{FileID: id}), nil); !errors.Is(err, context.Canceled) {
+			t.Fatalf("export to %s: error = %v, want it cancelled", id, err)
+		}
+	}
+	if names := dirNames(t, store.dir); !reflect.DeepEqual(names, []string{"open.csv"}) {
+		t.Errorf("after failed exports the directory holds %v, want open.csv", names)
+	}
+	if got, _ := os.ReadFile(filepath.Join(store.dir, "open.csv")); string(got) != "id\n1\n2\n" {
+		t.Errorf("failed export changed the file to %q", got)
+	}
+
+	// Split exports stage every part and the manifest
+	split := FlatFileConfig{FileID: "parts.csv", Split: SplitOptions{MaxRows: 1}}
+	if _, err := runIngestion(t.Context(), request(split), nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"open.csv", splitManifestName("parts.csv"), splitPartName("parts.csv", 1), splitPartName("parts.csv", 2)}
+	sort.Strings(want)
+	if names := dirNames(t, store.dir); !reflect.DeepEqual(names, want) {
+		t.Errorf("after a split export the directory holds %v, want %v", names, want)
+	}
+
+	// Files elsewhere are written in place
+	outside := filepath.Join(t.TempDir(), "out.csv")
+	if _, err := runIngestion(t.Context(), request(FlatFileConfig{FileName: outside}), nil); err != nil {
+		t.Fatal(err)
+	}
+	if names := dirNames(t, filepath.Dir(outside)); !reflect.DeepEqual(names, []string{"out.csv"}) {
+		t.Errorf("outside the data directory found %v", names)
+	}
+}
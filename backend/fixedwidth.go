@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Trim rules for fixed-width fields
+const (
+	TrimBoth  = "both"
+	TrimLeft  = "left"
+	TrimRight = "right"
+	TrimNone  = "none"
+)
+
+// Alignments for fixed-width fields
+const (
+	AlignLeft  = "left"
+	AlignRight = "right"
+)
+
+// Overflow rules for values wider than their fixed-width field
+const (
+	OverflowError    = "error"
+	OverflowTruncate = "truncate"
+)
+
+// maxFixedWidthLineBytes bounds the length of a fixed-width record
+const maxFixedWidthLineBytes = 16 * 1024 * 1024
+
+// FixedWidthField is one column of a fixed-width layout. Positions and widths count characters
+// after decoding, so multi-byte text lines up the way it is displayed.
+type FixedWidthField struct {
+	Name string `json:"name"`
+	// Start is the 1-based position of the field's first character; 0 places it right after the previous field
+	Start int `json:"start"`
+	Width int `json:"width"`
+	// Type is the field's ClickHouse type; empty infers it from the data
+	Type string `json:"type"`
+	// Trim removes padding on read: both (default), left, right or none. Both removes spaces from
+	// either end but a pad character only from the side the field is aligned away from.
+	Trim string `json:"trim"`
+	// Align places values on write and tells padding from value on read: left, or right; the
+	// default is right for numbers and zero-padded fields and left otherwise
+	Align string `json:"align"`
+	// Pad is the padding character, a space by default
+	Pad string `json:"pad"`
+}
+
+// FixedWidthOptions describes the record layout of fixed-width files
+type FixedWidthOptions struct {
+	Fields []FixedWidthField `json:"fields"`
+	// SkipLines is the number of leading lines, such as banners or headers, ignored on read
+	SkipLines int `json:"skipLines"`
+	// Overflow handles text wider than its field on write: error (default) or truncate. Numbers
+	// that do not fit are always an error.
+	Overflow string `json:"overflow"`
+}
+
+// fixedWidthField is a field with its position resolved to 0-based rune offsets
+type fixedWidthField struct {
+	FixedWidthField
+	offset int
+	pad    rune
+}
+
+// fixedWidthLayout is a validated layout, with fields in declaration order
+type fixedWidthLayout struct {
+	fields   []fixedWidthField
+	overflow string
+}
+
+// newFixedWidthLayout resolves field positions and rejects layouts that are incomplete or overlap
+func newFixedWidthLayout(options FixedWidthOptions) (fixedWidthLayout, error) {
+	layout := fixedWidthLayout{overflow: options.Overflow}
+	switch layout.overflow {
+	case "":
+		layout.overflow = OverflowError
+	case OverflowError, OverflowTruncate:
+	default:
+		return layout, fmt.Errorf("invalid fixed-width overflow rule: %q", options.Overflow)
+	}
+	if len(options.Fields) == 0 {
+		return layout, fmt.Errorf("a fixed-width layout needs at least one field")
+	}
+	if options.SkipLines < 0 {
+		return layout, fmt.Errorf("skipLines cannot be negative")
+	}
+
+	seen := map[string]bool{}
+	next := 0
+	for _, field := range options.Fields {
+		if field.Name == "" {
+			return layout, fmt.Errorf("fixed-width fields need a name")
+		}
+		if seen[field.Name] {
+			return layout, fmt.Errorf("duplicate fixed-width field: %s", field.Name)
+		}
+		seen[field.Name] = true
+		if field.Width <= 0 {
+			return layout, fmt.Errorf("field %s: width must be positive", field.Name)
+		}
+		if field.Start < 0 {
+			return layout, fmt.Errorf("field %s: start must be 1 or more", field.Name)
+		}
+
+		switch field.Trim {
+		case "", TrimBoth, TrimLeft, TrimRight, TrimNone:
+		default:
+			return layout, fmt.Errorf("field %s: invalid trim rule %q", field.Name, field.Trim)
+		}
+		switch field.Align {
+		case "", AlignLeft, AlignRight:
+		default:
+			return layout, fmt.Errorf("field %s: invalid alignment %q", field.Name, field.Align)
+		}
+
+		resolved := fixedWidthField{FixedWidthField: field, offset: next, pad: ' '}
+		if field.Start > 0 {
+			resolved.offset = field.Start - 1
+		}
+		if field.Pad != "" {
+			if utf8.RuneCountInString(field.Pad) != 1 {
+				return layout, fmt.Errorf("field %s: pad must be a single character", field.Name)
+			}
+			resolved.pad, _ = utf8.DecodeRuneInString(field.Pad)
+		}
+		layout.fields = append(layout.fields, resolved)
+		next = resolved.offset + field.Width
+	}
+
+	// Fields may be declared in any order but must not share characters
+	ordered := layout.byPosition()
+	for i := 1; i < len(ordered); i++ {
+		prev := ordered[i-1]
+		if ordered[i].offset < prev.offset+prev.Width {
+			return layout, fmt.Errorf("fields %s and %s overlap", prev.Name, ordered[i].Name)
+		}
+	}
+	return layout, nil
+}
+
+// byPosition returns the fields ordered by where they start in a record
+func (l fixedWidthLayout) byPosition() []fixedWidthField {
+	ordered := append([]fixedWidthField(nil), l.fields...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].offset < ordered[j].offset })
+	return ordered
+}
+
+// names returns the field names in declaration order
+func (l fixedWidthLayout) names() []string {
+	names := make([]string, len(l.fields))
+	for i, field := range l.fields {
+		names[i] = field.Name
+	}
+	return names
+}
+
+// split cuts a record into its field values, trimmed by each field's rule. Fields past the end
+// of a short record are empty.
+func (l fixedWidthLayout) split(line string) []string {
+	record := []rune(line)
+	values := make([]string, len(l.fields))
+	for i, field := range l.fields {
+		if field.offset >= len(record) {
+			continue
+		}
+		end := min(field.offset+field.Width, len(record))
+		values[i] = field.trim(string(record[field.offset:end]))
+	}
+	return values
+}
+
+// trim removes the field's padding according to its trim rule. Zero-padded numbers keep the
+// zero in front of the decimal point, and a value made only of a non-space pad character keeps one.
+func (f fixedWidthField) trim(value string) string {
+	pad := string(f.pad)
+	trimmed := value
+	switch f.Trim {
+	case "", TrimBoth:
+		// A pad character other than a space can also be part of the value, as the zeros in
+		// 0000100 are, so it is only trimmed where format put the padding
+		switch {
+		case f.pad == ' ':
+			trimmed = strings.Trim(value, pad)
+		case f.alignment() == AlignRight:
+			trimmed = strings.TrimLeft(strings.TrimSpace(value), pad)
+		default:
+			trimmed = strings.TrimRight(strings.TrimSpace(value), pad)
+		}
+	case TrimLeft:
+		trimmed = strings.TrimLeft(value, pad)
+	case TrimRight:
+		trimmed = strings.TrimRight(value, pad)
+	}
+	if f.pad != ' ' {
+		// Lines are often space-filled even when fields are padded with something else
+		trimmed = strings.TrimSpace(trimmed)
+		if trimmed == "" && strings.ContainsRune(value, f.pad) {
+			return pad
+		}
+		if f.pad == '0' && strings.HasPrefix(trimmed, ".") {
+			return pad + trimmed
+		}
+	}
+	return trimmed
+}
+
+// format renders a value into exactly Width characters
+func (f fixedWidthField) format(value string, overflow string) (string, error) {
+	n := utf8.RuneCountInString(value)
+	if n > f.Width {
+		// Cutting digits off a number would change it, so only text is truncated
+		if overflow != OverflowTruncate || f.numeric() {
+			return "", fmt.Errorf("field %s: value %q is wider than %d characters", f.Name, value, f.Width)
+		}
+		return string([]rune(value)[:f.Width]), nil
+	}
+
+	padding := strings.Repeat(string(f.pad), f.Width-n)
+	if f.alignment() == AlignRight {
+		return padding + value, nil
+	}
+	return value + padding, nil
+}
+
+// alignment returns the field's alignment, right-aligning numbers and zero-padded fields by default
+func (f fixedWidthField) alignment() string {
+	if f.Align != "" {
+		return f.Align
+	}
+	if f.numeric() || f.pad == '0' {
+		return AlignRight
+	}
+	return AlignLeft
+}
+
+// numeric reports whether the field holds a number type
+func (f fixedWidthField) numeric() bool {
+	return isNumericType(f.Type)
+}
+
+// fixedWidthLines opens the file and returns a scanner positioned after the skipped lines
+func (f *FlatFileClient) fixedWidthLines(track bool) (io.Closer, *bufio.Scanner, error) {
+	file, err := f.openInput(track)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	decoded, err := decodeReader(file, f.config.Encoding)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	scanner := bufio.NewScanner(decoded)
+	scanner.Buffer(make([]byte, 64*1024), maxFixedWidthLineBytes)
+	for i := 0; i < f.config.FixedWidth.SkipLines; i++ {
+		if !scanner.Scan() {
+			break
+		}
+	}
+	return file, scanner, nil
+}
+
+// fixedWidthSchema returns the layout's fields, typed as declared or inferred from sampled records
+func (f *FlatFileClient) fixedWidthSchema() ([]Column, error) {
+	layout, err := newFixedWidthLayout(f.config.FixedWidth)
+	if err != nil {
+		return nil, err
+	}
+
+	file, scanner, err := f.fixedWidthLines(false)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rows [][]string
+	for len(rows) < f.config.InferSampleSize && scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		rows = append(rows, layout.split(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+
+	schema := inferSchema(layout.names(), rows, f.config.InferConfidence, f.nullMarkers())
+	for i, field := range layout.fields {
+		if field.Type != "" {
+			schema[i].Type = field.Type
+		}
+	}
+	return schema, nil
+}
+
+// fixedWidthRecordIterator streams record batches from a fixed-width file. Every value is read
+// as a String, like delimited files.
+type fixedWidthRecordIterator struct {
+	file    io.Closer
+	scanner *bufio.Scanner
+	layout  fixedWidthLayout
+	schema  []Column
+	// fieldIndices holds the layout position of each schema column
+	fieldIndices []int
+	nulls        nullMarkers
+}
+
+// Schema returns the selected columns in selection order
+func (it *fixedWidthRecordIterator) Schema() []Column {
+	return it.schema
+}
+
+// Next reads up to defaultRecordBatchRows records into a batch, skipping blank lines
+func (it *fixedWidthRecordIterator) Next() (*RecordBatch, error) {
+	batch := NewRecordBatch(it.schema, defaultRecordBatchRows)
+	for batch.Len() < defaultRecordBatchRows && it.scanner.Scan() {
+		line := strings.TrimRight(it.scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		record := it.layout.split(line)
+		values := make([]interface{}, len(it.fieldIndices))
+		for i, idx := range it.fieldIndices {
+			values[i] = it.nulls.value(record[idx])
+		}
+		batch.Append(values)
+	}
+	if err := it.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+
+	if batch.Len() == 0 {
+		return nil, io.EOF
+	}
+	return batch, nil
+}
+
+// Close closes the underlying file
+func (it *fixedWidthRecordIterator) Close() error {
+	return it.file.Close()
+}
+
+// streamFixedWidth returns an iterator over the selected fields of a fixed-width file, in the
+// order they were selected. Selected columns missing from the layout are skipped.
+func (f *FlatFileClient) streamFixedWidth(selectedColumns []string) (RecordIterator, error) {
+	layout, err := newFixedWidthLayout(f.config.FixedWidth)
+	if err != nil {
+		return nil, err
+	}
+
+	names := layout.names()
+	if len(selectedColumns) == 0 {
+		selectedColumns = names
+	}
+	var selected []string
+	var fieldIndices []int
+	for _, name := range selectedColumns {
+		for i, field := range names {
+			if field == name {
+				selected = append(selected, name)
+				fieldIndices = append(fieldIndices, i)
+				break
+			}
+		}
+	}
+
+	file, scanner, err := f.fixedWidthLines(true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fixedWidthRecordIterator{
+		file:         file,
+		scanner:      scanner,
+		layout:       layout,
+		schema:       stringColumns(selected),
+		fieldIndices: fieldIndices,
+		nulls:        f.nullMarkers(),
+	}, nil
+}
+
+// fixedWidthRecordSink writes each row as one fixed-width record in the configured layout
+type fixedWidthRecordSink struct {
+	file    io.WriteCloser
+	encoder io.WriteCloser
+	writer  *bufio.Writer
+	layout  fixedWidthLayout
+	// fields are the layout's fields ordered by position
+	fields  []fixedWidthField
+	nulls   nullMarkers
+	options FileWriterOptions
+}
+
+// newFixedWidthRecordSink returns a sink writing fixed-width records to file, which it closes
+// when done. Each field takes its value from the incoming column of the same name.
+func newFixedWidthRecordSink(file io.WriteCloser, encoding string, config FixedWidthOptions, nulls nullMarkers, options FileWriterOptions) (RecordSink, error) {
+	layout, err := newFixedWidthLayout(config)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	encoder, err := encodeWriter(file, encoding)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &fixedWidthRecordSink{
+		file:    file,
+		encoder: encoder,
+		writer:  bufio.NewWriter(encoder),
+		layout:  layout,
+		fields:  layout.byPosition(),
+		nulls:   nulls,
+		options: options,
+	}, nil
+}
+
+// WriteBatch writes a batch's rows as fixed-width records
+func (s *fixedWidthRecordSink) WriteBatch(batch *RecordBatch) error {
+	indices := make([]int, len(s.fields))
+	for i, field := range s.fields {
+		indices[i] = batch.ColumnIndex(field.Name)
+	}
+
+	var line strings.Builder
+	for _, row := range batch.Rows {
+		line.Reset()
+		position := 0
+		for i, field := range s.fields {
+			var value interface{}
+			sourceType := field.Type
+			if idx := indices[i]; idx >= 0 && idx < len(row) {
+				value, sourceType = row[idx], batch.Schema[idx].Type
+			}
+			if field.Type != "" {
+				cast, err := castValue(value, sourceType, Column{Name: field.Name, Type: field.Type}, s.options.NullOnParseError)
+				if err != nil {
+					return err
+				}
+				value, sourceType = cast, field.Type
+			}
+
+			text, err := field.format(s.nulls.format(value, sourceType), s.layout.overflow)
+			if err != nil {
+				return err
+			}
+			// Gaps between fields are filled with spaces
+			line.WriteString(strings.Repeat(" ", field.offset-position))
+			line.WriteString(text)
+			position = field.offset + field.Width
+		}
+		line.WriteByte('\n')
+
+		if _, err := s.writer.WriteString(line.String()); err != nil {
+			return fmt.Errorf("failed to write record: %w", err)
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered data to the file
+func (s *fixedWidthRecordSink) Flush() error {
+	return s.writer.Flush()
+}
+
+// Close flushes the encoder and closes the underlying file, finishing any compressed stream
+func (s *fixedWidthRecordSink) Close() error {
+	err := s.encoder.Close()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
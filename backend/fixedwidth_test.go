@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFixedWidthFieldTrim(t *testing.T) {
+	tests := []struct {
+		name  string
+		field FixedWidthField
+		value string
+		want  string
+	}{
+		{"spaces both sides", FixedWidthField{Type: "String"}, "  abc  ", "abc"},
+		{"zero-padded integer", FixedWidthField{Type: "Int32", Pad: "0"}, "0000100", "100"},
+		{"zero-padded untyped", FixedWidthField{Pad: "0"}, "0000100", "100"},
+		{"zero-padded decimal", FixedWidthField{Type: "Float64", Pad: "0"}, "0000.50", "0.50"},
+		{"zero-padded zero", FixedWidthField{Type: "Int32", Pad: "0"}, "0000000", "0"},
+		{"zero-padded negative", FixedWidthField{Type: "Int32", Pad: "0"}, "000-120", "-120"},
+		{"left-aligned zero pad", FixedWidthField{Type: "String", Pad: "0", Align: AlignLeft}, "A100000", "A1"},
+		{"star pad on a space-filled line", FixedWidthField{Type: "String", Pad: "*"}, "ab**   ", "ab"},
+		{"trim none", FixedWidthField{Type: "String", Trim: TrimNone}, " a ", " a "},
+		{"trim left", FixedWidthField{Type: "String", Trim: TrimLeft}, " a ", "a "},
+		{"trim right", FixedWidthField{Type: "String", Trim: TrimRight}, " a ", " a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.field.Name, tt.field.Width = "f", len(tt.value)
+			layout, err := newFixedWidthLayout(FixedWidthOptions{Fields: []FixedWidthField{tt.field}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := layout.fields[0].trim(tt.value); got != tt.want {
+				t.Errorf("trim(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixedWidthRoundTrip(t *testing.T) {
+	options := FixedWidthOptions{Fields: []FixedWidthField{
+		{Name: "id", Width: 7, Type: "Int64", Pad: "0"},
+		{Name: "name", Width: 6, Type: "String"},
+		{Name: "amount", Width: 8, Type: "Float64", Pad: "0"},
+		{Name: "code", Width: 5, Type: "String", Pad: "0", Align: AlignRight},
+	}}
+	schema := []Column{
+		{Name: "id", Type: "Int64"},
+		{Name: "name", Type: "String"},
+		{Name: "amount", Type: "Float64"},
+		{Name: "code", Type: "String"},
+	}
+	rows := [][]interface{}{
+		{int64(100), "widget", 12.5, "7"},
+		{int64(0), "a", 0.25, "10"},
+		{int64(-30), "", 1000.0, "100"},
+	}
+	want := [][]string{
+		{"100", "widget", "12.5", "7"},
+		{"0", "a", "0.25", "10"},
+		{"-30", "", "1000", "100"},
+	}
+
+	var out bytes.Buffer
+	sink, err := newFixedWidthRecordSink(nopWriteCloser{&out}, "", options, nullMarkers{}, FileWriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := NewRecordBatch(schema, len(rows))
+	for _, row := range rows {
+		batch.Append(row)
+	}
+	if err := sink.WriteBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	layout, err := newFixedWidthLayout(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("wrote %d records, want %d:\n%s", len(lines), len(want), out.String())
+	}
+	for i, line := range lines {
+		got := layout.split(line)
+		for j := range want[i] {
+			if got[j] != want[i][j] {
+				t.Errorf("record %d (%q) field %s = %q, want %q", i, line, options.Fields[j].Name, got[j], want[i][j])
+			}
+		}
+	}
+}
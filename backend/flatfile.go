@@ -6,123 +6,373 @@ import (
 	"fmt"
 	"io"
 	"os"
-	// "strings"
+	"path/filepath"
+	"strings"
+)
+
+// FileFormat is the layout of a flat file
+type FileFormat string
+
+const (
+	FormatCSV     FileFormat = "csv"
+	FormatParquet FileFormat = "parquet"
+	FormatNDJSON  FileFormat = "ndjson"
+	FormatXLSX    FileFormat = "xlsx"
+	// FormatFixedWidth files need a FixedWidth layout
+	FormatFixedWidth FileFormat = "fixedwidth"
+	// FormatAvro is an Avro object container file
+	FormatAvro FileFormat = "avro"
+	// FormatArrow is an Arrow IPC file (Feather v2) or stream
+	FormatArrow FileFormat = "arrow"
 )
 
 // FlatFileConfig holds configuration for flat file operations
 type FlatFileConfig struct {
 	FileName  string `json:"fileName"`
 	Delimiter string `json:"delimiter"`
-	HasHeader bool   `json:"hasHeader"`
+	HasHeader *bool  `json:"hasHeader"`
+	// ColumnNames name the columns of a file without a header, or replace the header's names
+	ColumnNames []string `json:"columnNames"`
+	// SchemaFile is a JSON list of {"name", "type"} columns, an alternative to ColumnNames whose
+	// types, where given, replace inferred ones
+	SchemaFile string `json:"schemaFile"`
+	// FileID names a file in the managed data directory, as returned by the upload endpoint.
+	// It takes precedence over FileName, which is a path on the server.
+	FileID string `json:"fileId"`
+	// SchemaMismatch is error (default) or fill, for a FileName that is a glob or directory whose
+	// files have different columns
+	SchemaMismatch SchemaMismatch `json:"schemaMismatch"`
+	// FileNameColumn, when set, adds a column holding the name of the file each row came from
+	FileNameColumn string `json:"fileNameColumn"`
+	// Format is the file layout; empty detects it from the file extension
+	Format FileFormat `json:"format"`
+	// CSV holds the quoting, escaping and line rules of delimited files
+	CSV CSVOptions `json:"csv"`
+	// Parquet holds options for writing Parquet files
+	Parquet ParquetOptions `json:"parquet"`
+	// NDJSON holds options for reading newline-delimited JSON files
+	NDJSON NDJSONOptions `json:"ndjson"`
+	// XLSX selects the sheet, header row and cell range of Excel workbooks
+	XLSX XLSXOptions `json:"xlsx"`
+	// FixedWidth is the record layout of fixed-width files
+	FixedWidth FixedWidthOptions `json:"fixedWidth"`
+	// Avro holds options for writing Avro files
+	Avro AvroOptions `json:"avro"`
+	// Arrow holds options for writing Arrow IPC files
+	Arrow ArrowOptions `json:"arrow"`
+	// Split rolls exports over into numbered part files listed in a manifest
+	Split SplitOptions `json:"split"`
+	// NullValues are the field values of CSV and fixed-width files read as NULL, such as "", "\\N"
+	// or "NULL"; the first is written for NULL. CSV files default to "\\N". Other values, including
+	// empty fields in String columns, are never NULL. In CSV only unquoted fields match, and values
+	// equal to a token are written quoted.
+	NullValues []string `json:"nullValues"`
+	// Encoding is the file's character encoding as a WHATWG label; empty means UTF-8
+	Encoding string `json:"encoding"`
+	// Compression is the file's codec: gzip, zstd, bzip2 (read only), lz4, xz or none. Empty
+	// detects it from the magic bytes or extension on read and the extension on write.
+	Compression string `json:"compression"`
+	// InferSampleSize is the number of rows sampled to infer column types
+	InferSampleSize int `json:"inferSampleSize"`
+	// InferConfidence is the share of sampled values (0-1] that must parse as a type for it to be chosen.
+	// Below 1, values that do not parse are tolerated and stored as NULL.
+	InferConfidence float64 `json:"inferConfidence"`
 }
 
 // FlatFileClient manages flat file operations
 type FlatFileClient struct {
-	config FlatFileConfig
+	config   FlatFileConfig
+	progress *Progress
+	// part is set for one file of a multi-file input, whose iterator reports the total size
+	part bool
+	// tee, when set, receives a copy of the bytes written to the output file
+	tee io.Writer
+	// outcome tells the files this client writes whether their export failed
+	outcome *exportOutcome
 }
 
 // NewFlatFileClient creates a new flat file client
 func NewFlatFileClient(config FlatFileConfig) *FlatFileClient {
+	config = resolveFileID(config)
 	// Default delimiter is comma if not specified
 	if config.Delimiter == "" {
 		config.Delimiter = ","
 	}
+	if config.InferSampleSize <= 0 {
+		config.InferSampleSize = defaultInferSampleSize
+	}
+	config.InferConfidence = normalizeConfidence(config.InferConfidence)
+	if config.Format == "" && len(config.FixedWidth.Fields) > 0 {
+		config.Format = FormatFixedWidth
+	}
+	if config.Format == "" {
+		config.Format = detectFileFormat(config.FileName)
+	}
 	
 	return &FlatFileClient{
-		config: config,
+		config:  config,
+		outcome: &exportOutcome{},
+	}
+}
+
+// discardOutputs has the files this client is still writing removed when closed instead of
+// moved into place, because their export failed
+func (f *FlatFileClient) discardOutputs() {
+	f.outcome.failed = true
+}
+
+// detectFileFormat picks a format from a file name's extension, ignoring any compression
+// extension, defaulting to CSV
+func detectFileFormat(fileName string) FileFormat {
+	switch strings.ToLower(filepath.Ext(trimCompressionExtension(fileName))) {
+	case ".parquet", ".parq":
+		return FormatParquet
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	case ".xlsx", ".xlsm":
+		return FormatXLSX
+	case ".avro":
+		return FormatAvro
+	case ".arrow", ".feather", ".ipc", ".arrows":
+		return FormatArrow
+	default:
+		return FormatCSV
+	}
+}
+
+// validateFileFormat rejects formats the client cannot read or write
+func validateFileFormat(format FileFormat) error {
+	switch format {
+	case "", FormatCSV, FormatParquet, FormatNDJSON, FormatXLSX, FormatFixedWidth, FormatAvro, FormatArrow:
+		return nil
 	}
+	return fmt.Errorf("unsupported file format: %q", format)
+}
+
+// validateFlatFileConfig rejects file settings the client cannot read, or write when forWrite is set
+func validateFlatFileConfig(config FlatFileConfig, forWrite bool) error {
+	if config.FileID != "" {
+		if files == nil {
+			return fmt.Errorf("managed files are not available")
+		}
+		if _, err := files.Path(config.FileID); err != nil {
+			return err
+		}
+	}
+	if err := validateFileFormat(config.Format); err != nil {
+		return err
+	}
+	switch config.SchemaMismatch {
+	case "", MismatchError, MismatchFill:
+	default:
+		return fmt.Errorf("invalid schema mismatch rule: %q", config.SchemaMismatch)
+	}
+	if forWrite && (isGlob(config.FileName) || config.FileNameColumn != "") {
+		return fmt.Errorf("a target file cannot be a pattern or add a file name column")
+	}
+	if forWrite && config.Split.enabled() {
+		if err := config.Split.validate(NewFlatFileClient(config).Format()); err != nil {
+			return err
+		}
+		if config.XLSX.SheetPerTable {
+			return fmt.Errorf("sheetPerTable exports cannot be split")
+		}
+	}
+	if _, err := textEncoding(config.Encoding); err != nil {
+		return err
+	}
+
+	compression := config.Compression
+	if forWrite {
+		compression = config.writeCompression()
+	}
+	if err := validateCompression(compression, forWrite); err != nil {
+		return err
+	}
+
+	client := NewFlatFileClient(config)
+	if !forWrite {
+		if _, err := client.declaredColumns(); err != nil {
+			return err
+		}
+		if !config.hasHeader() && config.XLSX.HeaderRow > 0 {
+			return fmt.Errorf("xlsx.headerRow cannot be set for a file without a header")
+		}
+	}
+	switch client.Format() {
+	case FormatFixedWidth:
+		if _, err := newFixedWidthLayout(config.FixedWidth); err != nil {
+			return err
+		}
+	case FormatCSV:
+		if _, err := client.csvDialect(); err != nil {
+			return err
+		}
+		if forWrite && config.CSV.WriteBOM && !isUnicodeEncoding(config.Encoding) {
+			return fmt.Errorf("a byte order mark needs a Unicode encoding, not %q", config.Encoding)
+		}
+	}
+	return nil
+}
+
+// Format returns the file's format, as configured or detected
+func (f *FlatFileClient) Format() FileFormat {
+	return f.config.Format
+}
+
+// TrackProgress reports bytes read and written by subsequent streams to p
+func (f *FlatFileClient) TrackProgress(p *Progress) {
+	f.progress = p
 }
 
 // GetHeaders reads the header row from a flat file
 func (f *FlatFileClient) GetHeaders() ([]string, error) {
-	file, err := os.Open(f.config.FileName)
+	if f.isMultiFile() {
+		columns, err := f.multiFileColumns(func(part *FlatFileClient) ([]Column, error) {
+			headers, err := part.GetHeaders()
+			return stringColumns(headers), err
+		})
+		return columnNames(columns), err
+	}
+	if f.config.Format != FormatCSV {
+		schema, err := f.GetSchema()
+		if err != nil {
+			return nil, err
+		}
+		return columnNames(schema), nil
+	}
+
+	file, err := f.openInput(false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+		return nil, err
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	reader.Comma = []rune(f.config.Delimiter)[0]
+	reader, err := f.newReader(file)
+	if err != nil {
+		return nil, err
+	}
 
 	// Read header row
-	headers, err := reader.Read()
+	first, err := reader.Read()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read headers: %w", err)
 	}
 
-	return headers, nil
+	return f.columnNamesFor(first)
 }
 
-// GetSchema infers the schema from the first few rows
+// GetSchema returns the file's columns. Parquet files carry their own types and fixed-width
+// layouts may declare them; otherwise they are inferred by sampling the first InferSampleSize rows.
+// The files of a glob or directory are reconciled into one schema.
 func (f *FlatFileClient) GetSchema() ([]Column, error) {
-	file, err := os.Open(f.config.FileName)
+	if f.isMultiFile() {
+		return f.multiFileColumns((*FlatFileClient).GetSchema)
+	}
+	switch f.config.Format {
+	case FormatParquet:
+		return f.parquetSchema()
+	case FormatNDJSON:
+		return f.ndjsonSchema()
+	case FormatXLSX:
+		return f.xlsxSchema()
+	case FormatFixedWidth:
+		return f.fixedWidthSchema()
+	case FormatAvro:
+		return f.avroSchema()
+	case FormatArrow:
+		return f.arrowSchema()
+	}
+
+	file, err := f.openInput(false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+		return nil, err
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	reader.Comma = []rune(f.config.Delimiter)[0]
+	reader, err := f.newReader(file)
+	if err != nil {
+		return nil, err
+	}
 
 	// Read header row
-	headers, err := reader.Read()
+	first, err := reader.Read()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read headers: %w", err)
 	}
+	headers, err := f.columnNamesFor(first)
+	if err != nil {
+		return nil, err
+	}
 
-	// Read a few rows to infer types
-	var columns []Column
-	for _, header := range headers {
-		columns = append(columns, Column{
-			Name: header,
-			Type: "String", // Default type
-		})
+	// Read a sample of rows to infer types
+	var rows [][]string
+	if !f.config.hasHeader() {
+		rows = append(rows, first)
+	}
+	for len(rows) < f.config.InferSampleSize {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %w", err)
+		}
+		rows = append(rows, record)
 	}
 
-	return columns, nil
+	return f.applyDeclaredTypes(inferSchema(headers, rows, f.config.InferConfidence, f.nullMarkers()))
 }
 
-// ReadData reads all data from the flat file
-func (f *FlatFileClient) ReadData(selectedColumns []string) ([]map[string]interface{}, error) {
-	file, err := os.Open(f.config.FileName)
+// newReader returns a CSV reader over r that decodes the configured encoding and dialect
+func (f *FlatFileClient) newReader(r io.Reader) (csvReader, error) {
+	dialect, err := f.csvDialect()
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	reader.Comma = []rune(f.config.Delimiter)[0]
-
-	// Read headers
-	headers, err := reader.Read()
+	decoded, err := decodeReader(r, f.config.Encoding)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read headers: %w", err)
+		return nil, err
 	}
+	return dialect.newReader(decoded), nil
+}
 
-	// Map column indices for selected columns
-	colIndices := make(map[string]int)
-	if len(selectedColumns) == 0 {
-		// If no columns specified, select all
-		selectedColumns = headers
-		for i, header := range headers {
-			colIndices[header] = i
-		}
-	} else {
-		// Map only selected columns
-		for i, header := range headers {
-			for _, selectedCol := range selectedColumns {
-				if header == selectedCol {
-					colIndices[header] = i
-					break
-				}
-			}
-		}
-	}
+// InfersLeniently reports whether values that do not match the inferred type should become NULL
+func (f *FlatFileClient) InfersLeniently() bool {
+	return f.config.Format != FormatParquet && f.config.Format != FormatAvro &&
+		f.config.Format != FormatArrow && f.config.InferConfidence < 1
+}
 
-	// Read data rows
-	var data []map[string]interface{}
-	for {
-		record, err := reader.Read()
+// csvRecordIterator streams record batches from a delimited file. Every value is read as a String.
+type csvRecordIterator struct {
+	file   io.Closer
+	reader csvReader
+	schema []Column
+	// fieldIndices holds the position in the file of each schema column
+	fieldIndices []int
+	// pending is the first record of a file without a header, read before streaming began
+	pending []string
+	nulls   nullMarkers
+	// quoted tells which fields of the last record were quoted, when the reader can
+	quoted quotedFieldReader
+}
+
+// Schema returns the selected columns in selection order
+func (it *csvRecordIterator) Schema() []Column {
+	return it.schema
+}
+
+// Next reads up to defaultRecordBatchRows records into a batch
+func (it *csvRecordIterator) Next() (*RecordBatch, error) {
+	batch := NewRecordBatch(it.schema, defaultRecordBatchRows)
+	for batch.Len() < defaultRecordBatchRows {
+		var record []string
+		var err error
+		if it.pending != nil {
+			record, it.pending = it.pending, nil
+		} else {
+			record, err = it.reader.Read()
+		}
 		if err == io.EOF {
 			break
 		}
@@ -130,106 +380,305 @@ func (f *FlatFileClient) ReadData(selectedColumns []string) ([]map[string]interf
 			return nil, fmt.Errorf("error reading file: %w", err)
 		}
 
-		row := make(map[string]interface{})
-		for col, idx := range colIndices {
-			if idx < len(record) {
-				row[col] = record[idx]
+		values := make([]interface{}, len(it.fieldIndices))
+		for i, idx := range it.fieldIndices {
+			switch {
+			case idx >= len(record):
+			case it.quoted != nil && it.quoted.Quoted(idx):
+				// A quoted token is a value
+				values[i] = record[idx]
+			default:
+				values[i] = it.nulls.value(record[idx])
 			}
 		}
-		data = append(data, row)
+		batch.Append(values)
 	}
 
-	return data, nil
+	if batch.Len() == 0 {
+		return nil, io.EOF
+	}
+	return batch, nil
 }
 
-// PreviewData reads the first n rows from the flat file
-func (f *FlatFileClient) PreviewData(limit int) ([]map[string]interface{}, error) {
-	file, err := os.Open(f.config.FileName)
+// Close closes the underlying file
+func (it *csvRecordIterator) Close() error {
+	return it.file.Close()
+}
+
+// StreamData opens the flat file and returns an iterator over the selected columns, in the order
+// they were selected. Selected columns missing from the file are skipped.
+func (f *FlatFileClient) StreamData(selectedColumns []string) (RecordIterator, error) {
+	if f.isMultiFile() {
+		return f.streamMultiFile(selectedColumns)
+	}
+	switch f.config.Format {
+	case FormatParquet:
+		return f.streamParquet(selectedColumns)
+	case FormatNDJSON:
+		return f.streamNDJSON(selectedColumns)
+	case FormatXLSX:
+		return f.streamXLSX(selectedColumns)
+	case FormatFixedWidth:
+		return f.streamFixedWidth(selectedColumns)
+	case FormatAvro:
+		return f.streamAvro(selectedColumns)
+	case FormatArrow:
+		return f.streamArrow(selectedColumns)
+	}
+
+	file, err := f.openInput(true)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	reader.Comma = []rune(f.config.Delimiter)[0]
+	reader, err := f.newReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if std, ok := reader.(*csv.Reader); ok {
+		std.ReuseRecord = true
+	}
 
 	// Read headers
-	headers, err := reader.Read()
+	first, err := reader.Read()
 	if err != nil {
+		file.Close()
 		return nil, fmt.Errorf("failed to read headers: %w", err)
 	}
+	// The first record is reused by the next Read
+	first = append([]string(nil), first...)
+	headers, err := f.columnNamesFor(first)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	var pending []string
+	if !f.config.hasHeader() {
+		pending = first
+	}
 
-	// Read data rows up to limit
-	var data []map[string]interface{}
-	for count := 0; count < limit; count++ {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("error reading file: %w", err)
-		}
+	// If no columns specified, select all
+	if len(selectedColumns) == 0 {
+		selectedColumns = headers
+	}
 
-		row := make(map[string]interface{})
-		for i, value := range record {
-			if i < len(headers) {
-				row[headers[i]] = value
+	var names []string
+	var fieldIndices []int
+	for _, selectedCol := range selectedColumns {
+		for i, header := range headers {
+			if header == selectedCol {
+				names = append(names, header)
+				fieldIndices = append(fieldIndices, i)
+				break
 			}
 		}
-		data = append(data, row)
 	}
 
-	return data, nil
+	quoted, _ := reader.(quotedFieldReader)
+	return &csvRecordIterator{
+		file:         file,
+		reader:       reader,
+		schema:       stringColumns(names),
+		fieldIndices: fieldIndices,
+		pending:      pending,
+		nulls:        f.nullMarkers(),
+		quoted:       quoted,
+	}, nil
 }
 
-// WriteData writes data to a flat file
-func (f *FlatFileClient) WriteData(data []map[string]interface{}, selectedColumns []string) (int, error) {
-	file, err := os.Create(f.config.FileName)
+// ReadData reads all data from the flat file
+func (f *FlatFileClient) ReadData(selectedColumns []string) ([]map[string]interface{}, error) {
+	it, err := f.StreamData(selectedColumns)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
+	defer it.Close()
 
-	writer := csv.NewWriter(file)
-	writer.Comma = []rune(f.config.Delimiter)[0]
-	defer writer.Flush()
+	return collectRows(it, 0)
+}
 
-	// If no columns specified, extract from first data row
-	if len(selectedColumns) == 0 && len(data) > 0 {
-		for col := range data[0] {
-			selectedColumns = append(selectedColumns, col)
+// csvRecordSink streams record batches into a delimited file
+type csvRecordSink struct {
+	file    io.Closer
+	encoder io.WriteCloser
+	writer  *csvWriter
+	// columns are the output columns; empty means the schema of the first batch
+	columns     []string
+	wroteHeader bool
+	// omitHeader leaves the header line out
+	omitHeader bool
+	nulls      nullMarkers
+}
+
+// WriteBatch writes a batch's rows in column order, emitting the header first if needed
+func (s *csvRecordSink) WriteBatch(batch *RecordBatch) error {
+	if !s.wroteHeader {
+		if len(s.columns) == 0 {
+			s.columns = columnNames(batch.Schema)
+		}
+		if err := s.writeHeader(); err != nil {
+			return err
 		}
 	}
 
-	// Write header
-	if err := writer.Write(selectedColumns); err != nil {
-		return 0, fmt.Errorf("failed to write header: %w", err)
+	indices := make([]int, len(s.columns))
+	for i, col := range s.columns {
+		indices[i] = batch.ColumnIndex(col)
 	}
 
-	// Write data rows
-	recordCount := 0
-	for _, row := range data {
-		record := make([]string, len(selectedColumns))
-		for i, col := range selectedColumns {
-			val, ok := row[col]
-			if ok {
-				record[i] = fmt.Sprintf("%v", val)
+	numeric := make([]bool, len(s.columns))
+	for i, idx := range indices {
+		numeric[i] = idx >= 0 && isNumericType(batch.Schema[idx].Type)
+	}
+
+	record := make([]string, len(s.columns))
+	null := make([]bool, len(s.columns))
+	for _, row := range batch.Rows {
+		for i, idx := range indices {
+			// Missing columns are NULL
+			null[i] = idx < 0 || row[idx] == nil
+			if idx >= 0 {
+				record[i] = s.nulls.format(row[idx], batch.Schema[idx].Type)
 			} else {
-				record[i] = "" // Empty value for missing columns
+				record[i] = s.nulls.write
 			}
 		}
 
-		if err := writer.Write(record); err != nil {
-			return recordCount, fmt.Errorf("failed to write record: %w", err)
+		if err := s.writer.Write(record, numeric, null); err != nil {
+			return fmt.Errorf("failed to write record: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *csvRecordSink) writeHeader() error {
+	if s.omitHeader {
+		s.wroteHeader = true
+		return nil
+	}
+	if err := s.writer.Write(s.columns, nil, nil); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	s.wroteHeader = true
+	return nil
+}
+
+// Flush writes any buffered data to the file
+func (s *csvRecordSink) Flush() error {
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+// Close flushes the encoder and closes the underlying file, finishing any compressed stream
+func (s *csvRecordSink) Close() error {
+	err := s.encoder.Close()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// FileWriterOptions controls how a flat file writer lays out incoming batches
+type FileWriterOptions struct {
+	// Columns are the output columns in order; a column without a Type takes it from the
+	// incoming batches. When empty the schema of the first batch is used.
+	Columns []Column
+	// NullOnParseError writes text values that do not parse as a Nullable column's type as NULL
+	NullOnParseError bool
+}
+
+// NewWriter creates the flat file and returns a sink for streaming batches into it
+func (f *FlatFileClient) NewWriter(options FileWriterOptions) (RecordSink, error) {
+	if f.config.Split.enabled() {
+		return newRollingRecordSink(f, options)
+	}
+
+	file, err := f.createOutput()
+	if err != nil {
+		return nil, err
+	}
+
+	switch f.config.Format {
+	case FormatParquet:
+		return newParquetRecordSink(file, f.config.Parquet, options)
+	case FormatNDJSON:
+		return newNDJSONRecordSink(file, f.config.Encoding, options)
+	case FormatXLSX:
+		return newXLSXRecordSink(file, f.config.XLSX, f.config.hasHeader(), options)
+	case FormatFixedWidth:
+		return newFixedWidthRecordSink(file, f.config.Encoding, f.config.FixedWidth, f.nullMarkers(), options)
+	case FormatAvro:
+		return newAvroRecordSink(file, f.config.Avro, options)
+	case FormatArrow:
+		return newArrowRecordSink(file, f.config.Arrow, options)
+	}
+
+	dialect, err := f.csvDialect()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	encoder, err := encodeWriter(file, f.config.Encoding)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	writer := dialect.newWriter(encoder)
+
+	sink := &csvRecordSink{
+		file:    file,
+		encoder: encoder,
+		writer:  writer,
+		columns: columnNames(options.Columns),
+
+		omitHeader: !f.config.hasHeader(),
+		nulls:      f.nullMarkers(),
+	}
+
+	// Write the header up front when the columns are already known
+	if len(sink.columns) > 0 {
+		if err := sink.writeHeader(); err != nil {
+			file.Close()
+			return nil, err
 		}
-		recordCount++
 	}
 
-	return recordCount, nil
+	return sink, nil
+}
+
+// WriteData writes a batch to a flat file, keeping the batch's column order unless
+// selectedColumns is given
+func (f *FlatFileClient) WriteData(batch *RecordBatch, selectedColumns []string) (int, error) {
+	var columns []Column
+	for _, name := range selectedColumns {
+		columns = append(columns, Column{Name: name})
+	}
+
+	sink, err := f.NewWriter(FileWriterOptions{Columns: columns})
+	if err != nil {
+		return 0, err
+	}
+	defer sink.Close()
+
+	if err := sink.WriteBatch(batch); err != nil {
+		return 0, err
+	}
+
+	if err := sink.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to flush file: %w", err)
+	}
+
+	return batch.Len(), nil
 }
 
 // ValidateFile checks if the file exists and is readable
 func (f *FlatFileClient) ValidateFile() error {
+	if f.isMultiFile() {
+		_, err := f.inputFiles()
+		return err
+	}
 	_, err := os.Stat(f.config.FileName)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// writeFlatFile writes rows laid out by schema to the file config describes
+func writeFlatFile(t *testing.T, config FlatFileConfig, schema []Column, rows [][]interface{}) {
+	t.Helper()
+	sink, err := NewFlatFileClient(config).NewWriter(FileWriterOptions{Columns: schema})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	batch := NewRecordBatch(schema, len(rows))
+	for _, row := range rows {
+		batch.Append(row)
+	}
+	if err := sink.WriteBatch(batch); err != nil {
+		t.Fatalf("WriteBatch: %v", err)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+// readFlatFile reads the selected columns of every row of the file config describes
+func readFlatFile(t *testing.T, config FlatFileConfig, selected []string) ([]Column, [][]interface{}) {
+	t.Helper()
+	it, err := NewFlatFileClient(config).StreamData(selected)
+	if err != nil {
+		t.Fatalf("StreamData: %v", err)
+	}
+	defer it.Close()
+
+	var rows [][]interface{}
+	for {
+		batch, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		rows = append(rows, batch.Rows...)
+	}
+	return it.Schema(), rows
+}
+
+// discardSink drops every batch written to it
+type discardSink struct{}
+
+func (discardSink) WriteBatch(*RecordBatch) error { return nil }
+func (discardSink) Flush() error                  { return nil }
+func (discardSink) Close() error                  { return nil }
+
+// assertRows fails t unless got holds the values of want, with the same Go types
+func assertRows(t *testing.T, got, want [][]interface{}) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("read %d rows, want %d: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if len(got[i]) != len(want[i]) {
+			t.Errorf("row %d = %v, want %v", i, got[i], want[i])
+			continue
+		}
+		for j := range want[i] {
+			g, w := fmt.Sprintf("%T %v", got[i][j], got[i][j]), fmt.Sprintf("%T %v", want[i][j], want[i][j])
+			if g != w {
+				t.Errorf("row %d value %d = %s, want %s", i, j, g, w)
+			}
+		}
+	}
+}
+
+func TestTypedFormatRoundTrip(t *testing.T) {
+	schema := []Column{
+		{Name: "id", Type: "Int64"},
+		{Name: "small", Type: "Int32"},
+		{Name: "count", Type: "UInt8"},
+		{Name: "name", Type: "String"},
+		{Name: "score", Type: "Nullable(Float64)"},
+		{Name: "ok", Type: "Bool"},
+		{Name: "day", Type: "Date"},
+		{Name: "at", Type: "DateTime64(3)"},
+		{Name: "price", Type: "Decimal(18, 2)"},
+		{Name: "key", Type: "UUID"},
+		{Name: "tags", Type: "Array(String)"},
+		{Name: "attrs", Type: "Map(String, Int64)"},
+	}
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6e6, time.UTC)
+	key := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+	rows := [][]interface{}{
+		{int64(1), int32(7), uint8(3), "ada", 1.5, true, day, at, "12.50", key, []interface{}{"a", "b"}, map[string]interface{}{"k": int64(1)}},
+		{int64(-2), int32(-7), uint8(255), "", nil, false, day, at, "0.01", key, []interface{}{}, map[string]interface{}{}},
+		{int64(3), int32(0), uint8(0), "ünïcödé", 0.0, true, day, at, "-3.00", key, []interface{}{"c"}, map[string]interface{}{"x": int64(-1), "y": int64(2)}},
+	}
+	// Every format reads dates back as Date32 and decimal text as decimals
+	wantTypes := map[string]string{"day": "Date32"}
+	wantValues := map[string]func(interface{}) interface{}{
+		"price": func(v interface{}) interface{} { return decimal.RequireFromString(v.(string)) },
+	}
+
+	tests := []struct {
+		name string
+		file string
+		// types and values override the expected column types and values where a format
+		// cannot keep them
+		types  map[string]string
+		values map[string]func(interface{}) interface{}
+	}{
+		{name: "parquet", file: "rows.parquet"},
+		{
+			// Avro has no unsigned integers, so those widen
+			name:   "avro",
+			file:   "rows.avro",
+			types:  map[string]string{"count": "Int32"},
+			values: map[string]func(interface{}) interface{}{"count": func(v interface{}) interface{} { return int32(v.(uint8)) }},
+		},
+		{
+			// Timestamps carry their zone, and UUIDs have no Arrow type so they are text
+			name:   "arrow",
+			file:   "rows.arrow",
+			types:  map[string]string{"at": "DateTime64(3, 'UTC')", "key": "String"},
+			values: map[string]func(interface{}) interface{}{"key": func(v interface{}) interface{} { return v.(uuid.UUID).String() }},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wantSchema []Column
+			for _, col := range schema {
+				for _, types := range []map[string]string{wantTypes, tt.types} {
+					if chType, ok := types[col.Name]; ok {
+						col.Type = chType
+					}
+				}
+				wantSchema = append(wantSchema, col)
+			}
+			want := make([][]interface{}, len(rows))
+			for i, row := range rows {
+				for j, value := range row {
+					for _, values := range []map[string]func(interface{}) interface{}{wantValues, tt.values} {
+						if convert, ok := values[schema[j].Name]; ok {
+							value = convert(value)
+						}
+					}
+					want[i] = append(want[i], value)
+				}
+			}
+
+			config := FlatFileConfig{FileName: filepath.Join(t.TempDir(), tt.file)}
+			writeFlatFile(t, config, schema, rows)
+
+			gotSchema, got := readFlatFile(t, config, nil)
+			if !reflect.DeepEqual(gotSchema, wantSchema) {
+				t.Errorf("schema = %v, want %v", gotSchema, wantSchema)
+			}
+			assertRows(t, got, want)
+
+			// A projection reads only the selected columns, in the order selected
+			gotSchema, got = readFlatFile(t, config, []string{"name", "id"})
+			if !reflect.DeepEqual(gotSchema, []Column{wantSchema[3], wantSchema[0]}) {
+				t.Errorf("projected schema = %v, want name then id", gotSchema)
+			}
+			projected := make([][]interface{}, len(want))
+			for i, row := range want {
+				projected[i] = []interface{}{row[3], row[0]}
+			}
+			assertRows(t, got, projected)
+		})
+	}
+}
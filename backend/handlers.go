@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 )
@@ -102,19 +106,14 @@ func handlePreviewData(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	limit := req.PreviewLimit
-	if limit <= 0 {
-		limit = 100 // Default preview limit
-	}
+	limit := req.previewLimit()
 
 	var data []map[string]interface{}
 	var err error
 
-	log.Printf("SelectedColumns: %v", req.Source)
 	switch req.Source {
 	case SourceClickHouse:
-		log.Printf("hiii it's clickhouse")
-		client, err := NewClickHouseClient(req.ClickHouseConf)
+		client, err := NewClickHouseClient(sourceClickHouseConfig(req))
 		if err != nil {
 			WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to connect to ClickHouse", err))
 			return
@@ -134,7 +133,6 @@ func handlePreviewData(w http.ResponseWriter, r *http.Request) {
 				tableName = req.SelectedTables[0]
 			}
 			data, err = client.FetchData(ctx, tableName, req.SelectedColumns, limit)
-			log.Printf("Data: %v", data)
 			if err != nil {
 				WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to fetch data", err))
 				return
@@ -142,9 +140,23 @@ func handlePreviewData(w http.ResponseWriter, r *http.Request) {
 		}
 
 	case SourceFlatFile:
-		log.Printf("hiii it's flatfile")
-		client := NewFlatFileClient(req.FlatFileConf)
-		data, err = client.PreviewData(limit)
+		// Files are read through the ingestion's column plan, so the preview shows the selected and mapped columns
+		plan, err := planIngestion(ctx, req)
+		if err != nil {
+			WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse("Invalid column mapping", err))
+			return
+		}
+		source, err := openIngestionSource(ctx, req, plan, nil)
+		if err != nil {
+			WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to open data source", err))
+			return
+		}
+		defer source.Close()
+		data, err = collectRows(source, limit)
+		if err != nil {
+			WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to preview data", err))
+			return
+		}
 
 	default:
 		WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse("Invalid source type", nil))
@@ -159,7 +171,7 @@ func handlePreviewData(w http.ResponseWriter, r *http.Request) {
 	WriteJSONResponse(w, http.StatusOK, NewSuccessResponse("Data preview successful", data, len(data)))
 }
 
-// handleIngestion handles the data ingestion process
+// handleIngestion starts an ingestion job and returns its ID without waiting for it to finish
 func handleIngestion(w http.ResponseWriter, r *http.Request) {
 	var req IngestionRequest
 	if err := ReadJSONBody(r, &req); err != nil {
@@ -167,98 +179,283 @@ func handleIngestion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
+	if err := validateIngestionRequest(req); err != nil {
+		WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse("Invalid ingestion request", err))
+		return
+	}
 
-	var sourceData []map[string]interface{}
-	var err error
-	var recordCount int
+	// If preview only, return the first rows without ingestion
+	if req.PreviewOnly {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
 
-	// Step 1: Fetch data from source
-	switch req.Source {
-	case SourceClickHouse:
-		// Connect to ClickHouse source
-		sourceClient, err := NewClickHouseClient(req.ClickHouseConf)
+		plan, err := planIngestion(ctx, req)
 		if err != nil {
-			WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to connect to ClickHouse source", err))
+			WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse("Invalid column mapping", err))
 			return
 		}
-		defer sourceClient.Close()
-
-		// Check if it's a join operation
-		if len(req.SelectedTables) > 1 && req.JoinCondition != "" {
-			sourceData, err = sourceClient.JoinTables(ctx, req.SelectedTables, req.JoinCondition, req.SelectedColumns, 0)
-		} else {
-			tableName := req.TableName
-			if tableName == "" && len(req.SelectedTables) > 0 {
-				tableName = req.SelectedTables[0]
-			}
-			sourceData, err = sourceClient.FetchData(ctx, tableName, req.SelectedColumns, 0)
-		}
 
+		source, err := openIngestionSource(ctx, req, plan, nil)
 		if err != nil {
-			WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to fetch data from ClickHouse", err))
+			WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to open data source", err))
 			return
 		}
+		defer source.Close()
 
-	case SourceFlatFile:
-		// Connect to flat file source
-		sourceClient := NewFlatFileClient(req.FlatFileConf)
-		sourceData, err = sourceClient.ReadData(req.SelectedColumns)
+		data, err := collectRows(source, req.previewLimit())
 		if err != nil {
-			WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to read data from flat file", err))
+			WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to preview data", err))
 			return
 		}
+		WriteJSONResponse(w, http.StatusOK, NewSuccessResponse("Data preview successful", data, len(data)))
+		return
+	}
 
-	default:
-		WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse("Invalid source type", nil))
+	job, err := jobs.Submit(req)
+	if err != nil {
+		WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to start ingestion job", err))
 		return
 	}
 
-	// If preview only, return the data without ingestion
-	if req.PreviewOnly {
-		limit := req.PreviewLimit
-		if limit <= 0 || limit > len(sourceData) {
-			limit = len(sourceData)
+	WriteJSONResponse(w, http.StatusAccepted, NewSuccessResponse("Ingestion job started", job.Status(), 0))
+}
+
+// handleListJobs lists all tracked ingestion jobs
+func handleListJobs(w http.ResponseWriter, r *http.Request) {
+	statuses := jobs.List()
+	WriteJSONResponse(w, http.StatusOK, NewSuccessResponse("Retrieved jobs successfully", statuses, len(statuses)))
+}
+
+// handleGetJob returns the state of a single ingestion job
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := jobs.Get(r.PathValue("id"))
+	if err != nil {
+		WriteJSONResponse(w, http.StatusNotFound, NewErrorResponse("Failed to get job", err))
+		return
+	}
+
+	status := job.Status()
+	WriteJSONResponse(w, http.StatusOK, NewSuccessResponse("Retrieved job successfully", status, int(status.RowsProcessed)))
+}
+
+// handleJobEvents streams a job's status and progress as Server-Sent Events until it finishes
+func handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	job, err := jobs.Get(r.PathValue("id"))
+	if err != nil {
+		WriteJSONResponse(w, http.StatusNotFound, NewErrorResponse("Failed to get job", err))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Streaming is not supported", nil))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(progressEventInterval)
+	defer ticker.Stop()
+
+	for {
+		// Check before taking the snapshot so the final event carries the final counters
+		done := job.Done()
+		event := "progress"
+		if done {
+			event = "done"
+		}
+
+		// The full batch log is only sent once the job is done to keep ticks small
+		status := job.Status()
+		if !done {
+			status.Batches = nil
+		}
+
+		if err := WriteSSEEvent(w, event, status); err != nil {
+			log.Printf("Failed to write progress event: %v", err)
+			return
 		}
-		WriteJSONResponse(w, http.StatusOK, NewSuccessResponse("Data preview successful", sourceData[:limit], len(sourceData)))
+		flusher.Flush()
+
+		if done {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleCancelJob cancels a queued or running ingestion job
+func handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	job, err := jobs.Cancel(r.PathValue("id"))
+	if errors.Is(err, ErrJobNotFound) {
+		WriteJSONResponse(w, http.StatusNotFound, NewErrorResponse("Failed to cancel job", err))
+		return
+	}
+	if err != nil {
+		WriteJSONResponse(w, http.StatusConflict, NewErrorResponse("Failed to cancel job", err))
 		return
 	}
 
-	// Step 2: Write data to target
-	switch req.Target {
-	case SourceClickHouse:
-		// Connect to ClickHouse target
-		targetClient, err := NewClickHouseClient(req.ClickHouseConf)
-		log.Printf("hiii it's clickhouse as target")
-		log.Printf("this is req tablename %v", req.FlatFileConf.FileName)
+	WriteJSONResponse(w, http.StatusAccepted, NewSuccessResponse("Job cancellation requested", job.Status(), 0))
+}
+
+// handleListConnections lists the pooled ClickHouse connection profiles
+func handleListConnections(w http.ResponseWriter, r *http.Request) {
+	statuses := []ConnectionStatus{}
+	if connections != nil {
+		statuses = connections.List()
+	}
+	WriteJSONResponse(w, http.StatusOK, NewSuccessResponse("Retrieved connections successfully", statuses, len(statuses)))
+}
+
+// handleInvalidateConnection closes the pooled connections of a connection profile, e.g. after its
+// credentials change, so the next request connects afresh
+func handleInvalidateConnection(w http.ResponseWriter, r *http.Request) {
+	var config ClickHouseConfig
+	if err := ReadJSONBody(r, &config); err != nil {
+		WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse("Invalid request body", err))
+		return
+	}
+
+	if connections == nil || !connections.Invalidate(config) {
+		WriteJSONResponse(w, http.StatusOK, NewSuccessResponse("No pooled connection for this profile", map[string]bool{"invalidated": false}, 0))
+		return
+	}
+	WriteJSONResponse(w, http.StatusOK, NewSuccessResponse("Connection invalidated", map[string]bool{"invalidated": true}, 1))
+}
+
+// handleListFiles lists the files in the managed data directory
+func handleListFiles(w http.ResponseWriter, r *http.Request) {
+	list, err := files.List()
+	if err != nil {
+		WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to list files", err))
+		return
+	}
+
+	WriteJSONResponse(w, http.StatusOK, NewSuccessResponse("Retrieved files successfully", list, len(list)))
+}
+
+// handleUploadFiles stores uploaded files in the managed data directory and returns their IDs.
+// It accepts multipart form uploads, or a raw request body named by the name query parameter.
+func handleUploadFiles(w http.ResponseWriter, r *http.Request) {
+	var uploaded []FileInfo
+	files.LimitUpload(w, r)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		// Parts are streamed to disk one at a time rather than parsed into memory
+		reader, err := r.MultipartReader()
 		if err != nil {
-			WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to connect to ClickHouse target", err))
+			WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse("Invalid multipart upload", err))
 			return
 		}
-		defer targetClient.Close()
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				WriteJSONResponse(w, uploadErrorStatus(err, http.StatusBadRequest), NewErrorResponse("Invalid multipart upload", err))
+				return
+			}
+			if part.FileName() == "" {
+				// Plain form fields carry no file
+				part.Close()
+				continue
+			}
 
-		recordCount, err = targetClient.ImportDataFromFlatFile(ctx, SanitizeTableNameFromFileName(req.FlatFileConf.FileName), sourceData)
-		if err != nil {
-			WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to import data to ClickHouse", err))
+			info, err := files.Save(part.FileName(), part)
+			part.Close()
+			if err != nil {
+				WriteJSONResponse(w, uploadErrorStatus(err, http.StatusInternalServerError), NewErrorResponse("Failed to upload file", err))
+				return
+			}
+			uploaded = append(uploaded, info)
+		}
+	} else {
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse("Invalid upload", errors.New("a name query parameter is required")))
 			return
 		}
 
-	case SourceFlatFile:
-		// Write to flat file target
-		targetClient := NewFlatFileClient(req.FlatFileConf)
-		recordCount, err = targetClient.WriteData(sourceData, req.SelectedColumns)
+		info, err := files.Save(name, r.Body)
 		if err != nil {
-			WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to write data to flat file", err))
+			WriteJSONResponse(w, uploadErrorStatus(err, http.StatusInternalServerError), NewErrorResponse("Failed to upload file", err))
 			return
 		}
+		uploaded = append(uploaded, info)
+	}
 
-	default:
-		WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse("Invalid target type", nil))
+	if len(uploaded) == 0 {
+		WriteJSONResponse(w, http.StatusBadRequest, NewErrorResponse("Invalid upload", errors.New("no files in request")))
+		return
+	}
+
+	log.Printf("Uploaded %d file(s) to the data directory", len(uploaded))
+	WriteJSONResponse(w, http.StatusCreated, NewSuccessResponse("Files uploaded successfully", uploaded, len(uploaded)))
+}
+
+// uploadErrorStatus returns the HTTP status for a failed upload: 413 when the request was larger
+// than the upload limit, otherwise fallback
+func uploadErrorStatus(err error, fallback int) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return fallback
+}
+
+// fileErrorStatus returns the HTTP status for a file store error
+func fileErrorStatus(err error) int {
+	if errors.Is(err, ErrFileNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// handleDownloadFile sends a managed file, such as an export, as an attachment
+func handleDownloadFile(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	info, err := files.Stat(id)
+	if err != nil {
+		WriteJSONResponse(w, fileErrorStatus(err), NewErrorResponse("Failed to get file", err))
+		return
+	}
+
+	path, _ := files.Path(id)
+	file, err := os.Open(path)
+	if err != nil {
+		WriteJSONResponse(w, http.StatusInternalServerError, NewErrorResponse("Failed to open file", err))
 		return
 	}
+	defer file.Close()
 
-	WriteJSONResponse(w, http.StatusOK, NewSuccessResponse("Data ingestion completed successfully", nil, recordCount))
+	// ServeContent handles range requests, so interrupted downloads can resume
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.ID}))
+	http.ServeContent(w, r, info.ID, info.ModifiedAt, file)
+}
+
+// handleDeleteFile removes a managed file
+func handleDeleteFile(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := files.Delete(id); err != nil {
+		status := fileErrorStatus(err)
+		if !errors.Is(err, ErrFileNotFound) && validFileID(id) {
+			status = http.StatusInternalServerError
+		}
+		WriteJSONResponse(w, status, NewErrorResponse("Failed to delete file", err))
+		return
+	}
+
+	WriteJSONResponse(w, http.StatusOK, NewSuccessResponse("File deleted successfully", nil, 0))
 }
 
 // SanitizeTableNameFromFileName creates a valid table name from a file name
@@ -289,7 +486,7 @@ func SanitizeTableNameFromFileName(fileName string) string {
 func enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		if r.Method == "OPTIONS" {
@@ -308,14 +505,28 @@ func setupRoutes() http.Handler {
 	// ClickHouse routes
 	mux.HandleFunc("/api/clickhouse/tables", handleGetClickHouseTables)
 	mux.HandleFunc("/api/clickhouse/columns", handleGetClickHouseColumns)
+	mux.HandleFunc("GET /api/clickhouse/connections", handleListConnections)
+	mux.HandleFunc("POST /api/clickhouse/connections/invalidate", handleInvalidateConnection)
 
 	// Flat file routes
 	mux.HandleFunc("/api/flatfile/schema", handleGetFlatFileSchema)
 
+	// Managed file routes
+	mux.HandleFunc("GET /api/files", handleListFiles)
+	mux.HandleFunc("POST /api/files", handleUploadFiles)
+	mux.HandleFunc("GET /api/files/{id}", handleDownloadFile)
+	mux.HandleFunc("DELETE /api/files/{id}", handleDeleteFile)
+
 	// Data preview and ingestion routes
 	mux.HandleFunc("/api/preview", handlePreviewData)
 	mux.HandleFunc("/api/ingest", handleIngestion)
 
+	// Ingestion job routes
+	mux.HandleFunc("GET /api/jobs", handleListJobs)
+	mux.HandleFunc("GET /api/jobs/{id}", handleGetJob)
+	mux.HandleFunc("GET /api/jobs/{id}/events", handleJobEvents)
+	mux.HandleFunc("POST /api/jobs/{id}/cancel", handleCancelJob)
+
 	// Static file server for frontend
 	fs := http.FileServer(http.Dir("./frontend/build"))
 	mux.Handle("/", fs)
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlePreviewDataFlatFile(t *testing.T) {
+	var text strings.Builder
+	text.WriteString("id,name,extra\n")
+	for i := 0; i < maxPreviewLimit+50; i++ {
+		fmt.Fprintf(&text, "%d,n%d,x\n", i, i)
+	}
+	name := filepath.Join(t.TempDir(), "rows.csv")
+	if err := os.WriteFile(name, []byte(text.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		limit    int
+		selected []string
+		mappings []ColumnMapping
+		wantRows int
+		// wantKeys are the columns of every previewed row
+		wantKeys   []string
+		wantStatus int
+	}{
+		{name: "default limit", wantRows: defaultPreviewLimit, wantKeys: []string{"extra", "id", "name"}, wantStatus: http.StatusOK},
+		{name: "requested limit", limit: 20, wantRows: 20, wantKeys: []string{"extra", "id", "name"}, wantStatus: http.StatusOK},
+		{name: "limit is capped", limit: maxPreviewLimit * 2, wantRows: maxPreviewLimit, wantKeys: []string{"extra", "id", "name"}, wantStatus: http.StatusOK},
+		{name: "selected columns", limit: 5, selected: []string{"name"}, wantRows: 5, wantKeys: []string{"name"}, wantStatus: http.StatusOK},
+		{
+			name:       "column mappings",
+			limit:      5,
+			mappings:   []ColumnMapping{{Source: "id", Target: "key"}, {Source: "name", Target: "label"}},
+			wantRows:   5,
+			wantKeys:   []string{"key", "label"},
+			wantStatus: http.StatusOK,
+		},
+		{name: "unknown mapped column", mappings: []ColumnMapping{{Source: "missing"}}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(IngestionRequest{
+				Source:          SourceFlatFile,
+				FlatFileConf:    FlatFileConfig{FileName: name, Delimiter: ","},
+				SelectedColumns: tt.selected,
+				ColumnMappings:  tt.mappings,
+				PreviewLimit:    tt.limit,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := httptest.NewRecorder()
+			handlePreviewData(recorder, httptest.NewRequest(http.MethodPost, "/api/preview", bytes.NewReader(body)))
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Data []map[string]interface{} `json:"data"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Data) != tt.wantRows {
+				t.Fatalf("previewed %d rows, want %d", len(resp.Data), tt.wantRows)
+			}
+			for _, row := range resp.Data {
+				var keys []string
+				for key := range row {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				if !reflect.DeepEqual(keys, tt.wantKeys) {
+					t.Fatalf("row has columns %v, want %v", keys, tt.wantKeys)
+				}
+			}
+		})
+	}
+}
+
+func TestPreviewLimit(t *testing.T) {
+	tests := []struct {
+		limit int
+		want  int
+	}{
+		{0, defaultPreviewLimit},
+		{-5, defaultPreviewLimit},
+		{1, 1},
+		{maxPreviewLimit, maxPreviewLimit},
+		{maxPreviewLimit + 1, maxPreviewLimit},
+	}
+	for _, tt := range tests {
+		if got := (IngestionRequest{PreviewLimit: tt.limit}).previewLimit(); got != tt.want {
+			t.Errorf("previewLimit(%d) = %d, want %d", tt.limit, got, tt.want)
+		}
+	}
+}
+
+// sseEvent is one Server-Sent Event with its job status payload
+type sseEvent struct {
+	name   string
+	status JobStatus
+}
+
+// readSSEEvents parses the job status events in body
+func readSSEEvents(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		var event sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.status); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestHandleJobEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		// finishAfter is how long the job keeps running once the stream starts; 0 has it finished already
+		finishAfter time.Duration
+		wantEvents  []string
+	}{
+		{name: "finished job", wantEvents: []string{"done"}},
+		{name: "running job", finishAfter: progressEventInterval + progressEventInterval/2, wantEvents: []string{"progress", "progress", "done"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			job := &Job{status: JobStatus{ID: "events-" + strings.ReplaceAll(tt.name, " ", "-")}, ctx: ctx, cancel: cancel}
+			job.start()
+			job.progress.AddRowsRead(5)
+			job.progress.RecordBatch(BatchResult{Number: 1, Rows: 5, Committed: true})
+			finish := func() {
+				job.progress.AddRowsWritten(5)
+				job.finish(nil)
+			}
+			if tt.finishAfter == 0 {
+				finish()
+			} else {
+				time.AfterFunc(tt.finishAfter, finish)
+			}
+
+			jobs.mu.Lock()
+			jobs.jobs[job.status.ID] = job
+			jobs.mu.Unlock()
+			t.Cleanup(func() {
+				jobs.mu.Lock()
+				delete(jobs.jobs, job.status.ID)
+				jobs.mu.Unlock()
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/api/jobs/"+job.status.ID+"/events", nil)
+			r.SetPathValue("id", job.status.ID)
+			recorder := httptest.NewRecorder()
+			handleJobEvents(recorder, r)
+
+			if got := recorder.Header().Get("Content-Type"); got != "text/event-stream" {
+				t.Errorf("content type = %q", got)
+			}
+			events := readSSEEvents(t, recorder.Body.String())
+			var names []string
+			for _, event := range events {
+				names = append(names, event.name)
+			}
+			if !reflect.DeepEqual(names, tt.wantEvents) {
+				t.Fatalf("events = %v, want %v", names, tt.wantEvents)
+			}
+			for _, event := range events[:len(events)-1] {
+				if event.status.State != JobRunning || event.status.Progress.RowsRead != 5 || event.status.Batches != nil {
+					t.Errorf("progress event = %+v, want a running job with its rows and no batch log", event.status)
+				}
+			}
+			last := events[len(events)-1].status
+			if last.State != JobSucceeded || last.RowsProcessed != 5 || len(last.Batches) != 1 {
+				t.Errorf("done event = %+v, want the final counters and batch log", last)
+			}
+		})
+	}
+
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/jobs/missing/events", nil)
+	r.SetPathValue("id", "missing")
+	handleJobEvents(recorder, r)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown job status = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// hasHeader reports whether the file's first row names its columns. Unset means it does, so
+// requests from before the setting existed keep their meaning.
+func (c FlatFileConfig) hasHeader() bool {
+	return c.HasHeader == nil || *c.HasHeader
+}
+
+// declaredColumns returns the columns given by ColumnNames or listed in SchemaFile, or nil when
+// neither is set. Columns from ColumnNames have no type.
+func (f *FlatFileClient) declaredColumns() ([]Column, error) {
+	var columns []Column
+	switch {
+	case len(f.config.ColumnNames) > 0 && f.config.SchemaFile != "":
+		return nil, fmt.Errorf("set either columnNames or schemaFile, not both")
+	case len(f.config.ColumnNames) > 0:
+		for _, name := range f.config.ColumnNames {
+			columns = append(columns, Column{Name: name})
+		}
+	case f.config.SchemaFile != "":
+		data, err := os.ReadFile(f.config.SchemaFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema file: %w", err)
+		}
+		if err := json.Unmarshal(data, &columns); err != nil {
+			return nil, fmt.Errorf("invalid schema file %s: expected a JSON list of {\"name\", \"type\"} columns: %w", f.config.SchemaFile, err)
+		}
+		if len(columns) == 0 {
+			return nil, fmt.Errorf("schema file %s lists no columns", f.config.SchemaFile)
+		}
+	default:
+		return nil, nil
+	}
+
+	seen := map[string]bool{}
+	for _, col := range columns {
+		if col.Name == "" {
+			return nil, fmt.Errorf("declared columns need a name")
+		}
+		if seen[col.Name] {
+			return nil, fmt.Errorf("duplicate declared column: %s", col.Name)
+		}
+		seen[col.Name] = true
+	}
+	return columns, nil
+}
+
+// generatedColumnNames names n columns c1..cN
+func generatedColumnNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = "c" + strconv.Itoa(i+1)
+	}
+	return names
+}
+
+// columnNamesFor returns the names of a file's columns given its first row: the declared names,
+// the row itself when it is a header, or generated names
+func (f *FlatFileClient) columnNamesFor(first []string) ([]string, error) {
+	declared, err := f.declaredColumns()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case declared != nil:
+		if len(declared) != len(first) {
+			return nil, fmt.Errorf("%d columns are declared but the file has %d", len(declared), len(first))
+		}
+		return columnNames(declared), nil
+	case f.config.hasHeader():
+		return append([]string(nil), first...), nil
+	}
+	return generatedColumnNames(len(first)), nil
+}
+
+// applyDeclaredTypes replaces inferred types with the types a schema file gives
+func (f *FlatFileClient) applyDeclaredTypes(schema []Column) ([]Column, error) {
+	declared, err := f.declaredColumns()
+	if err != nil {
+		return nil, err
+	}
+	for i := range schema {
+		if i < len(declared) && declared[i].Type != "" {
+			schema[i].Type = declared[i].Type
+		}
+	}
+	return schema, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestColumnNamesFor(t *testing.T) {
+	dir := t.TempDir()
+	schemaFiles := map[string]string{
+		"schema.json":    `[{"name": "id", "type": "UInt32"}, {"name": "label"}]`,
+		"invalid.json":   `{"id": "UInt32"}`,
+		"empty.json":     `[]`,
+		"duplicate.json": `[{"name": "id"}, {"name": "id"}]`,
+	}
+	for name, text := range schemaFiles {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	noHeader, header := false, true
+	first := []string{"a", "b"}
+
+	tests := []struct {
+		name      string
+		config    FlatFileConfig
+		want      []string
+		wantError string
+	}{
+		{name: "header by default", want: []string{"a", "b"}},
+		{name: "header", config: FlatFileConfig{HasHeader: &header}, want: []string{"a", "b"}},
+		{name: "no header", config: FlatFileConfig{HasHeader: &noHeader}, want: []string{"c1", "c2"}},
+		{name: "names without a header", config: FlatFileConfig{HasHeader: &noHeader, ColumnNames: []string{"id", "label"}}, want: []string{"id", "label"}},
+		{name: "names replacing the header", config: FlatFileConfig{ColumnNames: []string{"id", "label"}}, want: []string{"id", "label"}},
+		{name: "too few names", config: FlatFileConfig{ColumnNames: []string{"id"}}, wantError: "1 columns are declared but the file has 2"},
+		{name: "duplicate names", config: FlatFileConfig{ColumnNames: []string{"id", "id"}}, wantError: "duplicate declared column: id"},
+		{name: "schema file", config: FlatFileConfig{HasHeader: &noHeader, SchemaFile: filepath.Join(dir, "schema.json")}, want: []string{"id", "label"}},
+		{
+			name:      "names and schema file",
+			config:    FlatFileConfig{ColumnNames: []string{"id", "label"}, SchemaFile: filepath.Join(dir, "schema.json")},
+			wantError: "set either columnNames or schemaFile, not both",
+		},
+		{name: "missing schema file", config: FlatFileConfig{SchemaFile: filepath.Join(dir, "missing.json")}, wantError: "failed to read schema file"},
+		{name: "invalid schema file", config: FlatFileConfig{SchemaFile: filepath.Join(dir, "invalid.json")}, wantError: "expected a JSON list"},
+		{name: "empty schema file", config: FlatFileConfig{SchemaFile: filepath.Join(dir, "empty.json")}, wantError: "lists no columns"},
+		{name: "duplicate in schema file", config: FlatFileConfig{SchemaFile: filepath.Join(dir, "duplicate.json")}, wantError: "duplicate declared column: id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewFlatFileClient(tt.config).columnNamesFor(first)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("error = %v, want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("names = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderlessFiles(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "rows.csv")
+	if err := os.WriteFile(input, []byte("1,ada\n2,bob\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	schemaFile := filepath.Join(dir, "schema.json")
+	if err := os.WriteFile(schemaFile, []byte(`[{"name": "id", "type": "UInt32"}, {"name": "name"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	noHeader := false
+
+	// Without a header the first line is data, under generated names or the schema file's, whose
+	// types replace the inferred ones
+	tests := []struct {
+		name       string
+		config     FlatFileConfig
+		wantSchema []Column
+	}{
+		{
+			name:       "generated names",
+			config:     FlatFileConfig{FileName: input, Delimiter: ",", HasHeader: &noHeader},
+			wantSchema: []Column{{Name: "c1", Type: "Int64"}, {Name: "c2", Type: "String"}},
+		},
+		{
+			name:       "schema file",
+			config:     FlatFileConfig{FileName: input, Delimiter: ",", HasHeader: &noHeader, SchemaFile: schemaFile},
+			wantSchema: []Column{{Name: "id", Type: "UInt32"}, {Name: "name", Type: "String"}},
+		},
+	}
+	for _, tt := range tests {
+		schema, err := NewFlatFileClient(tt.config).GetSchema()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(schema, tt.wantSchema) {
+			t.Errorf("%s: schema = %v, want %v", tt.name, schema, tt.wantSchema)
+		}
+		streamed, rows := readFlatFile(t, tt.config, nil)
+		if !reflect.DeepEqual(columnNames(streamed), columnNames(tt.wantSchema)) {
+			t.Errorf("%s: streamed columns %v, want %v", tt.name, streamed, tt.wantSchema)
+		}
+		assertRows(t, rows, [][]interface{}{{"1", "ada"}, {"2", "bob"}})
+	}
+
+	// Exports leave the header out when asked to
+	output := FlatFileConfig{FileName: filepath.Join(dir, "out.csv"), Delimiter: ",", HasHeader: &noHeader}
+	writeFlatFile(t, output, []Column{{Name: "id", Type: "Int64"}, {Name: "name", Type: "String"}}, [][]interface{}{{int64(1), "ada"}})
+	if got, err := os.ReadFile(output.FileName); err != nil || string(got) != "1,ada\n" {
+		t.Errorf("wrote %q, %v, want only the row", got, err)
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultInferSampleSize is the number of rows sampled per file when inferring a schema
+	defaultInferSampleSize = 1000
+	// defaultInferConfidence is the share of sampled values that must parse as a type for it to be chosen
+	defaultInferConfidence = 1.0
+	// lowCardinalityMaxDistinct caps the distinct values a String column may have to become LowCardinality
+	lowCardinalityMaxDistinct = 1000
+	// lowCardinalityMaxRatio caps the distinct/total ratio for a String column to become LowCardinality
+	lowCardinalityMaxRatio = 0.1
+	// lowCardinalityMinSamples avoids LowCardinality guesses on tiny samples
+	lowCardinalityMinSamples = 100
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// dateLayout is the only layout recognised as a Date
+const dateLayout = "2006-01-02"
+
+// dateTimeLayouts are the layouts recognised as DateTime/DateTime64, most common first
+var dateTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700",
+}
+
+// columnSample accumulates sampled values for one column
+type columnSample struct {
+	values   []string
+	hasEmpty bool
+	// hasNull is set when a value matched one of the file's NULL tokens
+	hasNull  bool
+	distinct map[string]struct{}
+}
+
+func newColumnSample() *columnSample {
+	return &columnSample{distinct: make(map[string]struct{})}
+}
+
+// add records one raw value from the file
+func (s *columnSample) add(raw string) {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		s.hasEmpty = true
+		return
+	}
+
+	s.values = append(s.values, value)
+	if len(s.distinct) <= lowCardinalityMaxDistinct {
+		s.distinct[value] = struct{}{}
+	}
+}
+
+// inferType picks the most specific ClickHouse type that at least confidence of the samples parse as
+func (s *columnSample) inferType(confidence float64) string {
+	if len(s.values) == 0 {
+		if s.hasNull {
+			return "Nullable(String)"
+		}
+		return "String"
+	}
+
+	baseType, matched := s.inferBaseType(confidence)
+	if baseType == "String" {
+		// Empty strings are values; only NULL tokens make a String column Nullable
+		stringType := "String"
+		if s.hasNull {
+			stringType = "Nullable(String)"
+		}
+		if s.isLowCardinality() {
+			return "LowCardinality(" + stringType + ")"
+		}
+		return stringType
+	}
+
+	// Empty cells, NULL tokens, or values tolerated by a confidence below 1, are stored as NULL
+	if s.hasEmpty || s.hasNull || matched < 1 {
+		return fmt.Sprintf("Nullable(%s)", baseType)
+	}
+	return baseType
+}
+
+// inferBaseType returns the chosen type and the share of samples that matched it
+func (s *columnSample) inferBaseType(confidence float64) (string, float64) {
+	if f := s.fraction(isBoolValue); f >= confidence {
+		return "Bool", f
+	}
+	if f := s.fraction(isInt64Value); f >= confidence {
+		return "Int64", f
+	}
+	if f := s.fraction(isUInt64Value); f >= confidence {
+		return "UInt64", f
+	}
+	if f := s.fraction(isFloatValue); f >= confidence {
+		if decimalType, ok := s.decimalType(); ok {
+			return decimalType, f
+		}
+		return "Float64", f
+	}
+	if f := s.fraction(isDateValue); f >= confidence {
+		if s.allWithinYears(isDateValue, 1970, 2149) {
+			return "Date", f
+		}
+		return "Date32", f
+	}
+	if f := s.fraction(isDateTimeValue); f >= confidence {
+		if precision := s.fractionalSecondDigits(); precision > 0 {
+			return fmt.Sprintf("DateTime64(%d)", precision), f
+		}
+		if s.allWithinYears(isDateTimeValue, 1970, 2105) {
+			return "DateTime", f
+		}
+		return "DateTime64(0)", f
+	}
+	if f := s.fraction(isUUIDValue); f >= confidence {
+		return "UUID", f
+	}
+	if f := s.fraction(isIPv4Value); f >= confidence {
+		return "IPv4", f
+	}
+	return "String", 1
+}
+
+// fraction returns the share of sampled values accepted by match
+func (s *columnSample) fraction(match func(string) bool) float64 {
+	matched := 0
+	for _, v := range s.values {
+		if match(v) {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(s.values))
+}
+
+// decimalType returns a Decimal type when every fractional value has the same scale,
+// which is the usual shape of money and other fixed-point data
+func (s *columnSample) decimalType() (string, bool) {
+	scale := -1
+	maxIntDigits := 1
+	for _, v := range s.values {
+		if !isPlainDecimal(v) {
+			if isFloatValue(v) {
+				// Exponents, NaN and Inf only fit a float
+				return "", false
+			}
+			continue
+		}
+
+		intPart, fracPart, hasFrac := strings.Cut(strings.TrimLeft(v, "+-"), ".")
+		if hasFrac {
+			if scale == -1 {
+				scale = len(fracPart)
+			} else if scale != len(fracPart) {
+				return "", false
+			}
+		}
+		if digits := len(strings.TrimLeft(intPart, "0")); digits > maxIntDigits {
+			maxIntDigits = digits
+		}
+	}
+	if scale <= 0 {
+		return "", false
+	}
+
+	// Leave headroom for unsampled rows by rounding precision up to the next Decimal width
+	precision := 18
+	if maxIntDigits+scale > 12 {
+		precision = 38
+	}
+	if scale >= precision {
+		return "", false
+	}
+	return fmt.Sprintf("Decimal(%d, %d)", precision, scale), true
+}
+
+// fractionalSecondDigits returns the DateTime64 precision needed for the sampled values (0, 3, 6 or 9)
+func (s *columnSample) fractionalSecondDigits() int {
+	maxDigits := 0
+	for _, v := range s.values {
+		if !isDateTimeValue(v) {
+			continue
+		}
+		if idx := strings.LastIndex(v, "."); idx != -1 {
+			digits := 0
+			for _, r := range v[idx+1:] {
+				if r < '0' || r > '9' {
+					break
+				}
+				digits++
+			}
+			if digits > maxDigits {
+				maxDigits = digits
+			}
+		}
+	}
+
+	switch {
+	case maxDigits == 0:
+		return 0
+	case maxDigits <= 3:
+		return 3
+	case maxDigits <= 6:
+		return 6
+	default:
+		return 9
+	}
+}
+
+// allWithinYears reports whether every matching value falls within [minYear, maxYear]
+func (s *columnSample) allWithinYears(match func(string) bool, minYear, maxYear int) bool {
+	for _, v := range s.values {
+		if !match(v) {
+			continue
+		}
+		t, err := parseDateTimeValue(v)
+		if err != nil {
+			continue
+		}
+		if t.Year() < minYear || t.Year() > maxYear {
+			return false
+		}
+	}
+	return true
+}
+
+// isLowCardinality reports whether a String column repeats few enough values to dictionary-encode
+func (s *columnSample) isLowCardinality() bool {
+	if len(s.values) < lowCardinalityMinSamples || len(s.distinct) > lowCardinalityMaxDistinct {
+		return false
+	}
+	return float64(len(s.distinct))/float64(len(s.values)) <= lowCardinalityMaxRatio
+}
+
+// inferSchema infers a ClickHouse column type for each header from sampled rows, in which values
+// matching nulls are NULL
+func inferSchema(headers []string, rows [][]string, confidence float64, nulls nullMarkers) []Column {
+	samples := make([]*columnSample, len(headers))
+	for i := range samples {
+		samples[i] = newColumnSample()
+	}
+
+	for _, record := range rows {
+		for i := range headers {
+			if i < len(record) && nulls.match(record[i]) {
+				samples[i].hasNull = true
+			} else if i < len(record) {
+				samples[i].add(record[i])
+			} else {
+				samples[i].hasEmpty = true
+			}
+		}
+	}
+
+	columns := make([]Column, len(headers))
+	for i, header := range headers {
+		columns[i] = Column{
+			Name: header,
+			Type: samples[i].inferType(confidence),
+		}
+	}
+	return columns
+}
+
+func isBoolValue(v string) bool {
+	switch strings.ToLower(v) {
+	case "true", "false":
+		return true
+	}
+	return false
+}
+
+func isInt64Value(v string) bool {
+	if hasLeadingZero(v) {
+		return false
+	}
+	_, err := strconv.ParseInt(v, 10, 64)
+	return err == nil
+}
+
+func isUInt64Value(v string) bool {
+	if hasLeadingZero(v) {
+		return false
+	}
+	_, err := strconv.ParseUint(v, 10, 64)
+	return err == nil
+}
+
+// hasLeadingZero catches identifiers such as zip codes that would lose digits as numbers
+func hasLeadingZero(v string) bool {
+	digits := strings.TrimLeft(v, "+-")
+	return len(digits) > 1 && digits[0] == '0' && digits[1] != '.'
+}
+
+func isFloatValue(v string) bool {
+	if hasLeadingZero(v) {
+		return false
+	}
+	_, err := strconv.ParseFloat(v, 64)
+	return err == nil
+}
+
+// isPlainDecimal reports whether v is written in fixed-point notation
+func isPlainDecimal(v string) bool {
+	digits := strings.TrimLeft(v, "+-")
+	if len(digits) == 0 || len(v)-len(digits) > 1 {
+		return false
+	}
+	seenDot := false
+	for _, r := range digits {
+		switch {
+		case r == '.' && !seenDot:
+			seenDot = true
+		case r < '0' || r > '9':
+			return false
+		}
+	}
+	return digits != "."
+}
+
+func isDateValue(v string) bool {
+	_, err := time.Parse(dateLayout, v)
+	return err == nil
+}
+
+func isDateTimeValue(v string) bool {
+	for _, layout := range dateTimeLayouts {
+		if _, err := time.Parse(layout, v); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func isUUIDValue(v string) bool {
+	return uuidPattern.MatchString(v)
+}
+
+func isIPv4Value(v string) bool {
+	ip := net.ParseIP(v)
+	return ip != nil && ip.To4() != nil && !strings.Contains(v, ":")
+}
+
+// parseDateTimeValue parses a Date or DateTime value in any recognised layout
+func parseDateTimeValue(v string) (time.Time, error) {
+	if t, err := time.Parse(dateLayout, v); err == nil {
+		return t, nil
+	}
+	for _, layout := range dateTimeLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date/time %q", v)
+}
+
+// unwrapType strips Nullable and LowCardinality wrappers from a ClickHouse type
+func unwrapType(chType string) (base string, nullable bool) {
+	base = strings.TrimSpace(chType)
+	for {
+		switch {
+		case strings.HasPrefix(base, "LowCardinality(") && strings.HasSuffix(base, ")"):
+			base = base[len("LowCardinality(") : len(base)-1]
+		case strings.HasPrefix(base, "Nullable(") && strings.HasSuffix(base, ")"):
+			base = base[len("Nullable(") : len(base)-1]
+			nullable = true
+		default:
+			return base, nullable
+		}
+	}
+}
+
+// isNumericType reports whether chType, unwrapped, is an integer, float or decimal type
+func isNumericType(chType string) bool {
+	base, _ := unwrapType(chType)
+	for _, prefix := range []string{"Int", "UInt", "Float", "Decimal"} {
+		if strings.HasPrefix(base, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// convertFlatValue converts a raw flat-file value into the Go type the driver expects for chType
+func convertFlatValue(raw string, chType string) (interface{}, error) {
+	base, nullable := unwrapType(chType)
+	value := strings.TrimSpace(raw)
+
+	// An empty field is NULL unless it can be an empty string; NULL tokens arrive as nil
+	if value == "" && nullable && base != "String" && !strings.HasPrefix(base, "FixedString") {
+		return nil, nil
+	}
+
+	switch {
+	case base == "String" || strings.HasPrefix(base, "FixedString") || strings.HasPrefix(base, "Enum"):
+		// Keep strings untouched, including surrounding whitespace
+		return raw, nil
+	case base == "Bool":
+		switch strings.ToLower(value) {
+		case "true", "1":
+			return true, nil
+		case "false", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid Bool value %q", raw)
+	case strings.HasPrefix(base, "UInt"):
+		bits, err := intBits(base[len("UInt"):])
+		if err != nil {
+			return raw, nil
+		}
+		n, err := strconv.ParseUint(value, 10, bits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q", base, raw)
+		}
+		return sizedUint(n, bits), nil
+	case strings.HasPrefix(base, "Int"):
+		bits, err := intBits(base[len("Int"):])
+		if err != nil {
+			return raw, nil
+		}
+		n, err := strconv.ParseInt(value, 10, bits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q", base, raw)
+		}
+		return sizedInt(n, bits), nil
+	case base == "Float32":
+		f, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Float32 value %q", raw)
+		}
+		return float32(f), nil
+	case base == "Float64":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Float64 value %q", raw)
+		}
+		return f, nil
+	case strings.HasPrefix(base, "Decimal"):
+		if !isPlainDecimal(value) {
+			return nil, fmt.Errorf("invalid %s value %q", base, raw)
+		}
+		// The driver parses decimal strings without losing precision
+		return value, nil
+	case base == "Date" || base == "Date32" || strings.HasPrefix(base, "DateTime"):
+		t, err := parseDateTimeValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q", base, raw)
+		}
+		return t, nil
+	case base == "UUID":
+		if !isUUIDValue(value) {
+			return nil, fmt.Errorf("invalid UUID value %q", raw)
+		}
+		return value, nil
+	case base == "IPv4":
+		if !isIPv4Value(value) {
+			return nil, fmt.Errorf("invalid IPv4 value %q", raw)
+		}
+		return value, nil
+	default:
+		// Let the driver handle any type we do not parse ourselves
+		return raw, nil
+	}
+}
+
+// intBits parses the width suffix of an IntN/UIntN type name
+func intBits(suffix string) (int, error) {
+	switch suffix {
+	case "8", "16", "32", "64":
+		return strconv.Atoi(suffix)
+	}
+	return 0, fmt.Errorf("unsupported integer width %q", suffix)
+}
+
+func sizedInt(n int64, bits int) interface{} {
+	switch bits {
+	case 8:
+		return int8(n)
+	case 16:
+		return int16(n)
+	case 32:
+		return int32(n)
+	default:
+		return n
+	}
+}
+
+func sizedUint(n uint64, bits int) interface{} {
+	switch bits {
+	case 8:
+		return uint8(n)
+	case 16:
+		return uint16(n)
+	case 32:
+		return uint32(n)
+	default:
+		return n
+	}
+}
+
+// normalizeConfidence clamps a user supplied confidence into (0, 1]
+func normalizeConfidence(confidence float64) float64 {
+	if confidence <= 0 || math.IsNaN(confidence) {
+		return defaultInferConfidence
+	}
+	return math.Min(confidence, 1)
+}
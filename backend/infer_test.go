@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// cycleValues returns n values that repeat distinct different strings
+func cycleValues(n, distinct int) []string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = fmt.Sprintf("v%d", i%distinct)
+	}
+	return values
+}
+
+func TestInferColumnType(t *testing.T) {
+	oneBadInTen := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "x"}
+	tests := []struct {
+		name       string
+		values     []string
+		confidence float64
+		nulls      []string
+		want       string
+	}{
+		{name: "bool", values: []string{"true", "FALSE"}, want: "Bool"},
+		{name: "int", values: []string{"1", "-2", "+3"}, want: "Int64"},
+		{name: "uint beyond int64", values: []string{"18446744073709551615", "1"}, want: "UInt64"},
+		{name: "leading zeros stay strings", values: []string{"007", "010"}, want: "String"},
+		{name: "surrounding space ignored", values: []string{" 1 ", "2"}, want: "Int64"},
+		{name: "empty cell makes a number nullable", values: []string{"1", ""}, want: "Nullable(Int64)"},
+		{name: "NULL token makes a number nullable", values: []string{"1", "NULL"}, nulls: []string{"NULL"}, want: "Nullable(Int64)"},
+		{name: "empty cell keeps a string non-nullable", values: []string{"a", ""}, want: "String"},
+		{name: "NULL token makes a string nullable", values: []string{"a", "NULL"}, nulls: []string{"NULL"}, want: "Nullable(String)"},
+		{name: "only NULL tokens", values: []string{"NULL"}, nulls: []string{"NULL"}, want: "Nullable(String)"},
+		{name: "only empty cells", values: []string{"", ""}, want: "String"},
+
+		{name: "one bad value at full confidence", values: oneBadInTen, confidence: 1, want: "String"},
+		{name: "one bad value in ten at 0.9", values: oneBadInTen, confidence: 0.9, want: "Nullable(Int64)"},
+		{name: "one bad value in ten at 0.95", values: oneBadInTen, confidence: 0.95, want: "String"},
+		{name: "mixed ints and floats", values: []string{"1", "2.5e3"}, want: "Float64"},
+
+		{name: "fixed scale decimal", values: []string{"1.50", "20.25", "3"}, want: "Decimal(18, 2)"},
+		{name: "twelve digit decimal", values: []string{"1234567890.25"}, want: "Decimal(18, 2)"},
+		{name: "thirteen digit decimal", values: []string{"12345678901.25"}, want: "Decimal(38, 2)"},
+		{name: "mixed scales", values: []string{"1.5", "1.25"}, want: "Float64"},
+		{name: "exponent rules out decimal", values: []string{"1.50", "1e3"}, want: "Float64"},
+
+		{name: "date", values: []string{"2024-01-02"}, want: "Date"},
+		{name: "date before 1970", values: []string{"1969-12-31", "2024-01-02"}, want: "Date32"},
+		{name: "date after 2149", values: []string{"2150-01-01"}, want: "Date32"},
+		{name: "datetime", values: []string{"2024-01-02 03:04:05", "2024-01-02T03:04:05Z"}, want: "DateTime"},
+		{name: "datetime after 2105", values: []string{"2106-01-01 00:00:00"}, want: "DateTime64(0)"},
+		{name: "millisecond datetime", values: []string{"2024-01-02T03:04:05.12Z"}, want: "DateTime64(3)"},
+		{name: "microsecond datetime", values: []string{"2024-01-02 03:04:05.1234", "2024-01-02 03:04:05"}, want: "DateTime64(6)"},
+		{name: "nanosecond datetime", values: []string{"2024-01-02 03:04:05.1234567"}, want: "DateTime64(9)"},
+
+		{name: "uuid", values: []string{"123e4567-e89b-12d3-a456-426614174000"}, want: "UUID"},
+		{name: "ipv4", values: []string{"10.0.0.1", "192.168.1.254"}, want: "IPv4"},
+		{name: "ipv6 is a string", values: []string{"::1"}, want: "String"},
+
+		{name: "low cardinality", values: cycleValues(100, 10), want: "LowCardinality(String)"},
+		{name: "low cardinality nullable", values: append(cycleValues(100, 10), "NULL"), nulls: []string{"NULL"}, want: "LowCardinality(Nullable(String))"},
+		{name: "too few samples for low cardinality", values: cycleValues(99, 9), want: "String"},
+		{name: "too many distinct values for low cardinality", values: cycleValues(100, 11), want: "String"},
+		{name: "too many distinct values overall", values: cycleValues(20000, 1001), want: "String"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := make([][]string, len(tt.values))
+			for i, v := range tt.values {
+				rows[i] = []string{v}
+			}
+			config := FlatFileConfig{NullValues: tt.nulls}
+			confidence := normalizeConfidence(tt.confidence)
+
+			columns := inferSchema([]string{"c"}, rows, confidence, NewFlatFileClient(config).nullMarkers())
+			if got := columns[0].Type; got != tt.want {
+				t.Errorf("inferred %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInferSchemaShortRows(t *testing.T) {
+	rows := [][]string{{"1", "a"}, {"2"}}
+	columns := inferSchema([]string{"id", "name"}, rows, defaultInferConfidence, nullMarkers{})
+	want := []Column{{Name: "id", Type: "Int64"}, {Name: "name", Type: "String"}}
+	for i := range want {
+		if columns[i] != want[i] {
+			t.Errorf("column %d = %v, want %v", i, columns[i], want[i])
+		}
+	}
+}
+
+func TestNormalizeConfidence(t *testing.T) {
+	tests := []struct {
+		in, want float64
+	}{
+		{0, defaultInferConfidence},
+		{-0.5, defaultInferConfidence},
+		{math.NaN(), defaultInferConfidence},
+		{0.8, 0.8},
+		{1, 1},
+		{1.5, 1},
+	}
+	for _, tt := range tests {
+		if got := normalizeConfidence(tt.in); got != tt.want {
+			t.Errorf("normalizeConfidence(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestConvertFlatValue(t *testing.T) {
+	tests := []struct {
+		raw       string
+		chType    string
+		want      interface{}
+		wantError bool
+	}{
+		{raw: " padded ", chType: "String", want: " padded "},
+		{raw: "", chType: "Nullable(String)", want: ""},
+		{raw: "", chType: "Nullable(Int64)", want: nil},
+		{raw: " ", chType: "LowCardinality(Nullable(Float64))", want: nil},
+		{raw: "", chType: "Int64", wantError: true},
+		{raw: "TRUE", chType: "Bool", want: true},
+		{raw: "0", chType: "Bool", want: false},
+		{raw: "yes", chType: "Bool", wantError: true},
+		{raw: " 42 ", chType: "Int64", want: int64(42)},
+		{raw: "-128", chType: "Int8", want: int8(-128)},
+		{raw: "128", chType: "Int8", wantError: true},
+		{raw: "65535", chType: "UInt16", want: uint16(65535)},
+		{raw: "-1", chType: "UInt32", wantError: true},
+		{raw: "123456789012345678901234567890", chType: "Int128", want: "123456789012345678901234567890"},
+		{raw: "0.25", chType: "Float32", want: float32(0.25)},
+		{raw: "1e3", chType: "Float64", want: 1000.0},
+		{raw: "abc", chType: "Float64", wantError: true},
+		{raw: "12.50", chType: "Decimal(18, 2)", want: "12.50"},
+		{raw: "1,5", chType: "Decimal(18, 2)", wantError: true},
+		{raw: "2024-01-02", chType: "Date", want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{raw: "2024-01-02 03:04:05", chType: "DateTime", want: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{raw: "02/01/2024", chType: "Date", wantError: true},
+		{raw: "123e4567-e89b-12d3-a456-426614174000", chType: "UUID", want: "123e4567-e89b-12d3-a456-426614174000"},
+		{raw: "not-a-uuid", chType: "UUID", wantError: true},
+		{raw: "10.0.0.1", chType: "IPv4", want: "10.0.0.1"},
+		{raw: "10.0.0.256", chType: "IPv4", wantError: true},
+		{raw: "red", chType: "Enum8('red' = 1)", want: "red"},
+		{raw: "[1,2]", chType: "Array(Int64)", want: "[1,2]"},
+	}
+	for _, tt := range tests {
+		got, err := convertFlatValue(tt.raw, tt.chType)
+		if tt.wantError {
+			if err == nil {
+				t.Errorf("convertFlatValue(%q, %s) = %v, want an error", tt.raw, tt.chType, got)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprintf("%T %v", got, got) != fmt.Sprintf("%T %v", tt.want, tt.want) {
+			t.Errorf("convertFlatValue(%q, %s) = %T %v, %v, want %T %v", tt.raw, tt.chType, got, got, err, tt.want, tt.want)
+		}
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ownedIterator closes the client that produced a RecordIterator along with it
+type ownedIterator struct {
+	RecordIterator
+	owner io.Closer
+}
+
+// Close closes the iterator and then its owning client
+func (it ownedIterator) Close() error {
+	err := it.RecordIterator.Close()
+	if cerr := it.owner.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ownedSink closes the client that produced a RecordSink along with it
+type ownedSink struct {
+	RecordSink
+	owner io.Closer
+}
+
+// Close closes the sink and then its owning client
+func (s ownedSink) Close() error {
+	err := s.RecordSink.Close()
+	if cerr := s.owner.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// exportSink is a flat file sink that discards what it wrote when it is closed without having
+// been flushed, which only happens once every row has been copied
+type exportSink struct {
+	RecordSink
+	client  *FlatFileClient
+	flushed bool
+}
+
+// Flush flushes the sink and notes that the export is complete
+func (s *exportSink) Flush() error {
+	err := s.RecordSink.Flush()
+	s.flushed = err == nil
+	return err
+}
+
+// Close closes the sink, discarding staged files first when the export failed
+func (s *exportSink) Close() error {
+	if !s.flushed {
+		s.client.discardOutputs()
+	}
+	return s.RecordSink.Close()
+}
+
+// validateIngestionRequest rejects requests whose source, target or column mappings are invalid
+func validateIngestionRequest(req IngestionRequest) error {
+	switch req.Source {
+	case SourceClickHouse:
+	case SourceFlatFile:
+		if err := validateFlatFileConfig(sourceFlatFileConfig(req), false); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid source type: %q", req.Source)
+	}
+
+	if err := validateColumnMappings(req.ColumnMappings); err != nil {
+		return err
+	}
+
+	if req.PreviewOnly {
+		return nil
+	}
+
+	switch req.Target {
+	case SourceClickHouse:
+		tableName := targetTableName(req)
+		if tableName == "" && req.Source == SourceClickHouse {
+			return fmt.Errorf("a target table name is required when copying a join")
+		}
+		if err := ValidateTableName(tableName); err != nil {
+			return err
+		}
+		if err := req.TableOptions.validate(); err != nil {
+			return err
+		}
+		if req.Source == SourceClickHouse && !isJoinRequest(req) &&
+			sameClickHouseTable(sourceClickHouseConfig(req), sourceTableName(req), targetClickHouseConfig(req), tableName) {
+			return fmt.Errorf("source and target are the same table: %s", tableName)
+		}
+	case SourceFlatFile:
+		target := targetFlatFileConfig(req)
+		if err := validateFlatFileConfig(target, true); err != nil {
+			return err
+		}
+		if target.FileName == "" {
+			return fmt.Errorf("a target file name is required")
+		}
+		if target.XLSX.SheetPerTable {
+			if NewFlatFileClient(target).Format() != FormatXLSX {
+				return fmt.Errorf("sheetPerTable requires an xlsx target")
+			}
+			if len(req.ColumnMappings) > 0 {
+				return fmt.Errorf("column mappings cannot be combined with sheetPerTable")
+			}
+		}
+		if req.Source == SourceFlatFile && sameFile(sourceFlatFileConfig(req).FileName, target.FileName) {
+			return fmt.Errorf("source and target are the same file: %s", target.FileName)
+		}
+	default:
+		return fmt.Errorf("invalid target type: %q", req.Target)
+	}
+
+	switch req.WriteMode {
+	case "", WriteModeFail, WriteModeAppend, WriteModeTruncate, WriteModeRecreate:
+	default:
+		return fmt.Errorf("invalid write mode: %q", req.WriteMode)
+	}
+	return nil
+}
+
+const (
+	// defaultPreviewLimit is the number of rows a preview returns when the request sets none
+	defaultPreviewLimit = 100
+	// maxPreviewLimit caps the rows a preview holds in memory
+	maxPreviewLimit = 10000
+)
+
+// previewLimit returns the number of rows a preview of req returns: PreviewLimit, defaulted when
+// unset and capped at maxPreviewLimit, so a preview never reads a whole source into memory
+func (req IngestionRequest) previewLimit() int {
+	switch {
+	case req.PreviewLimit <= 0:
+		return defaultPreviewLimit
+	case req.PreviewLimit > maxPreviewLimit:
+		return maxPreviewLimit
+	}
+	return req.PreviewLimit
+}
+
+// targetTableName returns the ClickHouse table written by req. ClickHouse copies default to
+// the source table name, which only makes sense on a different server or database.
+func targetTableName(req IngestionRequest) string {
+	if req.TargetTableName != "" {
+		return req.TargetTableName
+	}
+	if req.Source == SourceClickHouse {
+		if isJoinRequest(req) {
+			return ""
+		}
+		return sourceTableName(req)
+	}
+	source := sourceFlatFileConfig(req)
+	if source.FileID != "" {
+		return SanitizeTableNameFromFileName(trimCompressionExtension(source.FileID))
+	}
+	return SanitizeTableNameFromFileName(source.FileName)
+}
+
+// sourceFlatFileConfig returns the file read by a flat file source
+func sourceFlatFileConfig(req IngestionRequest) FlatFileConfig {
+	if req.SourceFlatFileConf != nil {
+		return resolveFileID(*req.SourceFlatFileConf)
+	}
+	return resolveFileID(req.FlatFileConf)
+}
+
+// targetFlatFileConfig returns the file written by a flat file target
+func targetFlatFileConfig(req IngestionRequest) FlatFileConfig {
+	if req.TargetFlatFileConf != nil {
+		return resolveFileID(*req.TargetFlatFileConf)
+	}
+	return resolveFileID(req.FlatFileConf)
+}
+
+// sameFile reports whether two paths name the same file, following links when both exist
+func sameFile(a, b string) bool {
+	if ai, err := os.Stat(a); err == nil {
+		if bi, err := os.Stat(b); err == nil {
+			return os.SameFile(ai, bi)
+		}
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// isJoinRequest reports whether req reads a join of several ClickHouse tables
+func isJoinRequest(req IngestionRequest) bool {
+	return len(req.SelectedTables) > 1 && req.JoinCondition != ""
+}
+
+// sourceClickHouseConfig returns the connection used to read a ClickHouse source
+func sourceClickHouseConfig(req IngestionRequest) ClickHouseConfig {
+	if req.SourceClickHouseConf != nil {
+		return *req.SourceClickHouseConf
+	}
+	return req.ClickHouseConf
+}
+
+// targetClickHouseConfig returns the connection used to write a ClickHouse target
+func targetClickHouseConfig(req IngestionRequest) ClickHouseConfig {
+	if req.TargetClickHouseConf != nil {
+		return *req.TargetClickHouseConf
+	}
+	return req.ClickHouseConf
+}
+
+// sourceTableName returns the single ClickHouse table read by req
+func sourceTableName(req IngestionRequest) string {
+	if req.TableName == "" && len(req.SelectedTables) > 0 {
+		return req.SelectedTables[0]
+	}
+	return req.TableName
+}
+
+// openIngestionSource opens a row stream over the columns selected by plan.
+// progress may be nil; when set, the source also reports its expected size.
+func openIngestionSource(ctx context.Context, req IngestionRequest, plan ingestionPlan, progress *Progress) (RecordIterator, error) {
+	var source RecordIterator
+	switch req.Source {
+	case SourceClickHouse:
+		// Connect to ClickHouse source
+		client, err := NewClickHouseClient(sourceClickHouseConfig(req))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to ClickHouse source: %w", err)
+		}
+
+		var it RecordIterator
+		// Check if it's a join operation
+		if isJoinRequest(req) {
+			it, err = client.StreamJoin(ctx, req.SelectedTables, req.JoinCondition, plan.selectedColumns, 0)
+		} else {
+			tableName := sourceTableName(req)
+			it, err = client.StreamData(ctx, tableName, plan.selectedColumns, 0)
+			if err == nil && progress != nil {
+				// The row count only feeds the ETA, so a failure here is not fatal
+				if total, cerr := client.CountRows(ctx, tableName); cerr == nil {
+					progress.SetTotalRows(int64(total))
+				}
+			}
+		}
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to fetch data from ClickHouse: %w", err)
+		}
+		source = ownedIterator{RecordIterator: it, owner: client}
+
+	case SourceFlatFile:
+		client := NewFlatFileClient(sourceFlatFileConfig(req))
+		client.TrackProgress(progress)
+		it, err := client.StreamData(plan.selectedColumns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read data from flat file: %w", err)
+		}
+		source = it
+
+	default:
+		return nil, fmt.Errorf("invalid source type: %q", req.Source)
+	}
+
+	if plan.renames {
+		source = newMappingIterator(source, plan.mappings)
+	}
+	return source, nil
+}
+
+// openIngestionTarget opens a row sink on the target described by req, laid out as planned
+func openIngestionTarget(ctx context.Context, req IngestionRequest, plan ingestionPlan, progress *Progress) (RecordSink, error) {
+	switch req.Target {
+	case SourceClickHouse:
+		// Connect to ClickHouse target
+		client, err := NewClickHouseClient(targetClickHouseConfig(req))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to ClickHouse target: %w", err)
+		}
+		client.TrackProgress(progress)
+
+		// Tables created by a copy keep the source's keys and only use types the target understands
+		tableName := targetTableName(req)
+		tableOptions, err := copyTargetTableOptions(ctx, req, plan)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		columns := plan.targetColumns
+		if version, verr := client.ServerVersion(); verr == nil {
+			columns = adaptColumnsForServer(columns, version)
+		}
+
+		if _, err := prepareTargetTable(ctx, client, tableName, req.WriteMode, plan, columns, tableOptions); err != nil {
+			client.Close()
+			return nil, err
+		}
+
+		sink := client.NewTableWriter(ctx, tableName, TableWriterOptions{
+			Columns:          columns,
+			NullOnParseError: plan.nullOnParseError,
+			Table:            tableOptions,
+			Batch:            req.BatchOptions,
+		})
+		return ownedSink{RecordSink: sink, owner: client}, nil
+
+	case SourceFlatFile:
+		client := NewFlatFileClient(targetFlatFileConfig(req))
+		client.TrackProgress(progress)
+		// Without a known schema the output columns take their types from the incoming batches
+		columns := plan.targetColumns
+		if columns == nil {
+			for _, name := range plan.targetNames() {
+				columns = append(columns, Column{Name: name})
+			}
+		}
+		sink, err := client.NewWriter(FileWriterOptions{Columns: columns, NullOnParseError: plan.nullOnParseError})
+		if err != nil {
+			return nil, fmt.Errorf("failed to write data to flat file: %w", err)
+		}
+		return &exportSink{RecordSink: sink, client: client}, nil
+
+	default:
+		return nil, fmt.Errorf("invalid target type: %q", req.Target)
+	}
+}
+
+// prepareTargetTable validates the plan against the target table, applies the write mode, so an
+// invalid mapping never truncates or drops anything, and then creates the table from columns if it
+// does not exist. Creating it up front means an empty or failing source still leaves a table, and
+// a recreated one is never left dropped. It returns the table column names columns insert into.
+// Without columns the table is created on the first batch and no names are returned.
+func prepareTargetTable(ctx context.Context, client *ClickHouseClient, tableName string, mode WriteMode, plan ingestionPlan, columns []Column, options TableOptions) ([]string, error) {
+	switch mode {
+	case WriteModeFail, WriteModeRecreate:
+		// Either the table must not exist or it is rebuilt from the plan, so its layout is irrelevant
+	default:
+		if err := validateTargetTable(ctx, client, tableName, plan); err != nil {
+			return nil, err
+		}
+	}
+	if err := client.PrepareTargetTable(ctx, tableName, mode); err != nil {
+		return nil, err
+	}
+
+	if columns == nil {
+		return nil, nil
+	}
+	_, insertNames, err := client.resolveInsertColumns(ctx, tableName, columns, options)
+	return insertNames, err
+}
+
+// runIngestion streams every row selected by req from its source into its target.
+// progress, if set, receives live row, byte and batch counts.
+func runIngestion(ctx context.Context, req IngestionRequest, progress *Progress) (int, error) {
+	if isSheetPerTableRequest(req) {
+		return runSheetPerTable(ctx, req, progress)
+	}
+
+	// Resolve and validate the column layout before any data moves
+	plan, err := planIngestion(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+
+	// Plain CSV transfers skip row decoding and let ClickHouse parse or render the file
+	if isNativeCSVTransfer(req, plan) {
+		var rows int
+		if req.Source == SourceFlatFile {
+			rows, err = runNativeCSVImport(ctx, req, plan, progress)
+		} else {
+			rows, err = runNativeCSVExport(ctx, req, plan, progress)
+		}
+		if !errors.Is(err, errFastPathUnavailable) {
+			return rows, err
+		}
+		log.Printf("Moving rows one by one: %v", err)
+	}
+
+	source, err := openIngestionSource(ctx, req, plan, progress)
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+
+	sink, err := openIngestionTarget(ctx, req, plan, progress)
+	if err != nil {
+		return 0, err
+	}
+
+	if progress != nil {
+		source = countingIterator{RecordIterator: source, progress: progress}
+		// ClickHouse sinks count their rows once each INSERT is acknowledged
+		if req.Target != SourceClickHouse {
+			sink = countingSink{RecordSink: sink, progress: progress}
+		}
+	}
+
+	recordCount, err := CopyRecords(ctx, source, sink)
+	// Closing a file sink finishes compressed streams, so its error matters too
+	if cerr := sink.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to close target: %w", cerr)
+	}
+	return recordCount, err
+}
+
+// isSheetPerTableRequest reports whether req exports several ClickHouse tables to one sheet each
+func isSheetPerTableRequest(req IngestionRequest) bool {
+	return req.Source == SourceClickHouse && req.Target == SourceFlatFile &&
+		len(req.SelectedTables) > 1 && targetFlatFileConfig(req).XLSX.SheetPerTable
+}
+
+// tableSheetColumns returns the selected columns that belong to table. Columns qualified as
+// "table.column" go to that table; unqualified ones to every table that has them.
+func tableSheetColumns(schema []Column, table string, selected []string) []string {
+	if len(selected) == 0 {
+		return columnNames(schema)
+	}
+
+	var columns []string
+	for _, name := range selected {
+		if qualifier, column, ok := strings.Cut(name, "."); ok {
+			if qualifier == table && columnIndex(schema, column) >= 0 {
+				columns = append(columns, column)
+			}
+			continue
+		}
+		if columnIndex(schema, name) >= 0 {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+// runSheetPerTable exports each selected table to its own sheet of an XLSX workbook
+func runSheetPerTable(ctx context.Context, req IngestionRequest, progress *Progress) (int, error) {
+	client, err := NewClickHouseClient(sourceClickHouseConfig(req))
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to ClickHouse source: %w", err)
+	}
+	defer client.Close()
+
+	target := NewFlatFileClient(targetFlatFileConfig(req))
+	target.TrackProgress(progress)
+	sink, err := target.NewWriter(FileWriterOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to write data to flat file: %w", err)
+	}
+	sheets, ok := sink.(sheetSink)
+	if !ok {
+		sink.Close()
+		return 0, fmt.Errorf("sheetPerTable requires an xlsx target")
+	}
+
+	recordCount, err := copyTablesToSheets(ctx, client, req, sheets, progress)
+	if err != nil {
+		target.discardOutputs()
+	}
+	if cerr := sink.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to close target: %w", cerr)
+	}
+	return recordCount, err
+}
+
+// copyTablesToSheets streams the selected columns of each table into a sheet named after it
+func copyTablesToSheets(ctx context.Context, client *ClickHouseClient, req IngestionRequest, sheets sheetSink, progress *Progress) (int, error) {
+	recordCount := 0
+	for _, table := range req.SelectedTables {
+		schema, err := client.GetTableColumns(ctx, table)
+		if err != nil {
+			return recordCount, err
+		}
+		columns := tableSheetColumns(schema, table, req.SelectedColumns)
+		if len(columns) == 0 {
+			log.Printf("Skipping table %s: none of the selected columns belong to it", table)
+			continue
+		}
+
+		// Typed columns give even an empty table a header row
+		var sheetColumns []Column
+		for _, name := range columns {
+			sheetColumns = append(sheetColumns, schema[columnIndex(schema, name)])
+		}
+		if err := sheets.StartSheet(table, sheetColumns); err != nil {
+			return recordCount, err
+		}
+
+		it, err := client.StreamData(ctx, table, columns, 0)
+		if err != nil {
+			return recordCount, fmt.Errorf("failed to fetch data from %s: %w", table, err)
+		}
+		var source RecordIterator = it
+		var sink RecordSink = sheets
+		if progress != nil {
+			source = countingIterator{RecordIterator: it, progress: progress}
+			sink = countingSink{RecordSink: sheets, progress: progress}
+		}
+
+		n, err := CopyRecords(ctx, source, sink)
+		it.Close()
+		recordCount += n
+		if err != nil {
+			return recordCount, fmt.Errorf("table %s: %w", table, err)
+		}
+	}
+	return recordCount, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFlatFileConversion(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		source   FlatFileConfig
+		target   FlatFileConfig
+		selected []string
+		mappings []ColumnMapping
+		want     string
+	}{
+		{
+			name:   "delimiter",
+			input:  "id,name\n1,ada\n2,\"b, c\"\n",
+			source: FlatFileConfig{FileName: "in.csv", Delimiter: ","},
+			target: FlatFileConfig{FileName: "out.tsv", Delimiter: "\t"},
+			want:   "id\tname\n1\tada\n2\tb, c\n",
+		},
+		{
+			name:     "projection and order",
+			input:    "id,name,extra\n1,ada,x\n2,bob,y\n",
+			source:   FlatFileConfig{FileName: "in.csv", Delimiter: ","},
+			target:   FlatFileConfig{FileName: "out.csv", Delimiter: ","},
+			selected: []string{"name", "id"},
+			want:     "name,id\nada,1\nbob,2\n",
+		},
+		{
+			name:     "renamed columns",
+			input:    "id,name\n1,ada\n",
+			source:   FlatFileConfig{FileName: "in.csv", Delimiter: ","},
+			target:   FlatFileConfig{FileName: "out.csv", Delimiter: ","},
+			mappings: []ColumnMapping{{Source: "name", Target: "label"}},
+			want:     "label\nada\n",
+		},
+		{
+			name:   "dialect",
+			input:  "id;note\n1;'it''s'\n",
+			source: FlatFileConfig{FileName: "in.csv", Delimiter: ";", CSV: CSVOptions{Quote: "'"}},
+			target: FlatFileConfig{FileName: "out.csv", Delimiter: "|"},
+			want:   "id|note\n1|it's\n",
+		},
+		{
+			name:   "encoding",
+			input:  "id,name\n1,café\n",
+			source: FlatFileConfig{FileName: "in.csv", Delimiter: ","},
+			target: FlatFileConfig{FileName: "out.csv", Delimiter: ",", Encoding: "windows-1252"},
+			want:   "id,name\n1,caf\xe9\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.source.FileName = filepath.Join(dir, tt.source.FileName)
+			tt.target.FileName = filepath.Join(dir, tt.target.FileName)
+			if err := os.WriteFile(tt.source.FileName, []byte(tt.input), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			req := IngestionRequest{
+				Source:             SourceFlatFile,
+				Target:             SourceFlatFile,
+				SourceFlatFileConf: &tt.source,
+				TargetFlatFileConf: &tt.target,
+				SelectedColumns:    tt.selected,
+				ColumnMappings:     tt.mappings,
+			}
+			if err := validateIngestionRequest(req); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := runIngestion(t.Context(), req, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(tt.target.FileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("wrote %q, want %q", got, tt.want)
+			}
+			// The source is left as it was
+			if input, _ := os.ReadFile(tt.source.FileName); string(input) != tt.input {
+				t.Errorf("source changed to %q", input)
+			}
+		})
+	}
+}
+
+func TestFlatFileConversionToOtherFormat(t *testing.T) {
+	dir := t.TempDir()
+	source := FlatFileConfig{FileName: filepath.Join(dir, "in.csv"), Delimiter: ","}
+	target := FlatFileConfig{FileName: filepath.Join(dir, "out.parquet")}
+	if err := os.WriteFile(source.FileName, []byte("id,name\n1,ada\n2,\\N\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	req := IngestionRequest{Source: SourceFlatFile, Target: SourceFlatFile, SourceFlatFileConf: &source, TargetFlatFileConf: &target}
+	if _, err := runIngestion(t.Context(), req, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, got := readFlatFile(t, target, nil)
+	if names := strings.Join(columnNames(schema), ","); names != "id,name" {
+		t.Errorf("columns = %s, want id,name", names)
+	}
+	assertRows(t, got, [][]interface{}{{int64(1), "ada"}, {int64(2), nil}})
+}
+
+func TestValidateFlatFileConversion(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(input, []byte("id\n1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.csv")
+	if err := os.Symlink(input, link); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		target    string
+		wantError string
+	}{
+		{name: "separate file", target: filepath.Join(dir, "out.csv")},
+		{name: "same file", target: input, wantError: "source and target are the same file"},
+		{name: "same file through a link", target: link, wantError: "source and target are the same file"},
+		{name: "no target", wantError: "a target file name is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := IngestionRequest{
+				Source:             SourceFlatFile,
+				Target:             SourceFlatFile,
+				SourceFlatFileConf: &FlatFileConfig{FileName: input, Delimiter: ","},
+				TargetFlatFileConf: &FlatFileConfig{FileName: tt.target, Delimiter: ","},
+			}
+			err := validateIngestionRequest(req)
+			if tt.wantError == "" && err != nil || tt.wantError != "" && (err == nil || !strings.Contains(err.Error(), tt.wantError)) {
+				t.Errorf("error = %v, want %q", err, tt.wantError)
+			}
+		})
+	}
+}
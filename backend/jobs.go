@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// JobState represents the lifecycle state of an ingestion job
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+const (
+	// maxConcurrentJobs is the number of ingestion jobs allowed to run at once
+	maxConcurrentJobs = 4
+	// maxRetainedJobs is the number of finished jobs kept for status queries
+	maxRetainedJobs = 200
+)
+
+// ErrJobNotFound is returned when no job exists for an ID
+var ErrJobNotFound = errors.New("job not found")
+
+// JobStatus is the externally visible state of an ingestion job
+type JobStatus struct {
+	ID            string            `json:"id"`
+	State         JobState          `json:"state"`
+	Source        SourceType        `json:"source"`
+	Target        SourceType        `json:"target"`
+	RowsProcessed int64             `json:"rowsProcessed"`
+	Progress      *ProgressSnapshot `json:"progress,omitempty"`
+	// Batches lists every ClickHouse INSERT batch with whether it committed
+	Batches    []BatchResult `json:"batches,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	StartedAt  *time.Time    `json:"startedAt,omitempty"`
+	FinishedAt *time.Time    `json:"finishedAt,omitempty"`
+}
+
+// Job is an ingestion running in the background
+type Job struct {
+	mu       sync.Mutex
+	status   JobStatus
+	progress *Progress
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// Status returns a snapshot of the job's current state
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := j.status
+	if j.progress != nil {
+		snap := j.progress.Snapshot()
+		status.Progress = &snap
+		status.RowsProcessed = snap.RowsWritten
+		status.Batches = j.progress.Batches()
+	}
+	return status
+}
+
+// Done reports whether the job has reached a terminal state
+func (j *Job) Done() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	switch j.status.State {
+	case JobSucceeded, JobFailed, JobCancelled:
+		return true
+	}
+	return false
+}
+
+func (j *Job) start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	j.status.State = JobRunning
+	j.status.StartedAt = &now
+	j.progress = NewProgress()
+}
+
+// finish records the outcome of the job and releases its context
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	j.status.FinishedAt = &now
+
+	switch {
+	case errors.Is(j.ctx.Err(), context.Canceled):
+		j.status.State = JobCancelled
+		j.status.Error = "job cancelled"
+	case err != nil:
+		j.status.State = JobFailed
+		j.status.Error = err.Error()
+	default:
+		j.status.State = JobSucceeded
+	}
+	j.cancel()
+}
+
+// JobManager tracks ingestion jobs and limits how many run concurrently
+type JobManager struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	order []string
+	slots chan struct{}
+}
+
+// NewJobManager creates a job manager that runs at most maxConcurrent jobs at a time
+func NewJobManager(maxConcurrent int) *JobManager {
+	return &JobManager{
+		jobs:  make(map[string]*Job),
+		slots: make(chan struct{}, maxConcurrent),
+	}
+}
+
+// jobs is the process-wide job manager used by the HTTP handlers
+var jobs = NewJobManager(maxConcurrentJobs)
+
+// newJobID generates a random job identifier
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Submit queues an ingestion request and returns the job tracking it
+func (m *JobManager) Submit(req IngestionRequest) (*Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		status: JobStatus{
+			ID:        id,
+			State:     JobQueued,
+			Source:    req.Source,
+			Target:    req.Target,
+			CreatedAt: time.Now(),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	m.mu.Lock()
+	m.jobs[id] = job
+	m.order = append(m.order, id)
+	m.pruneLocked()
+	m.mu.Unlock()
+
+	go m.run(job, req)
+
+	return job, nil
+}
+
+// run waits for a free slot and then executes the job
+func (m *JobManager) run(job *Job, req IngestionRequest) {
+	select {
+	case m.slots <- struct{}{}:
+	case <-job.ctx.Done():
+		job.finish(job.ctx.Err())
+		return
+	}
+	defer func() { <-m.slots }()
+
+	job.start()
+	log.Printf("Job %s started (%s -> %s)", job.status.ID, req.Source, req.Target)
+
+	_, err := runIngestion(job.ctx, req, job.progress)
+	job.finish(err)
+
+	status := job.Status()
+	log.Printf("Job %s %s after %d rows", status.ID, status.State, status.RowsProcessed)
+}
+
+// Get returns the job with the given ID
+func (m *JobManager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// List returns the status of every tracked job, oldest first
+func (m *JobManager) List() []JobStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(m.order))
+	for _, id := range m.order {
+		statuses = append(statuses, m.jobs[id].Status())
+	}
+	return statuses
+}
+
+// Cancel cancels the context of a queued or running job
+func (m *JobManager) Cancel(id string) (*Job, error) {
+	job, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Done() {
+		return job, fmt.Errorf("job %s has already finished", id)
+	}
+
+	job.cancel()
+	return job, nil
+}
+
+// CancelAll cancels every job that has not finished yet
+func (m *JobManager) CancelAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, job := range m.jobs {
+		job.cancel()
+	}
+}
+
+// pruneLocked drops the oldest finished jobs once more than maxRetainedJobs are tracked
+func (m *JobManager) pruneLocked() {
+	excess := len(m.order) - maxRetainedJobs
+	if excess <= 0 {
+		return
+	}
+
+	kept := m.order[:0]
+	for _, id := range m.order {
+		if excess > 0 && m.jobs[id].Done() {
+			delete(m.jobs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	m.order = kept
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForJob waits for job to reach a terminal state and returns its status
+func waitForJob(t *testing.T, job *Job) JobStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !job.Done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s did not finish: %+v", job.Status().ID, job.Status())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return job.Status()
+}
+
+// conversionRequest returns a request converting source into a CSV file in dir
+func conversionRequest(dir, source string) IngestionRequest {
+	return IngestionRequest{
+		Source:             SourceFlatFile,
+		Target:             SourceFlatFile,
+		SourceFlatFileConf: &FlatFileConfig{FileName: source, Delimiter: ","},
+		TargetFlatFileConf: &FlatFileConfig{FileName: filepath.Join(dir, "out.csv"), Delimiter: ","},
+	}
+}
+
+func TestJobOutcomes(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(source, []byte("id,name\n1,a\n2,b\n3,c\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		req       IngestionRequest
+		wantState JobState
+		wantRows  int64
+		wantError string
+	}{
+		{name: "succeeded", req: conversionRequest(dir, source), wantState: JobSucceeded, wantRows: 3},
+		{name: "failed", req: conversionRequest(dir, filepath.Join(dir, "missing.csv")), wantState: JobFailed, wantError: "missing.csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := NewJobManager(1).Submit(tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			status := waitForJob(t, job)
+			if status.State != tt.wantState || status.RowsProcessed != tt.wantRows {
+				t.Errorf("job %s after %d rows, want %s after %d", status.State, status.RowsProcessed, tt.wantState, tt.wantRows)
+			}
+			if !strings.Contains(status.Error, tt.wantError) || (tt.wantError == "") != (status.Error == "") {
+				t.Errorf("error = %q, want %q", status.Error, tt.wantError)
+			}
+			if status.StartedAt == nil || status.FinishedAt == nil || status.Progress == nil {
+				t.Errorf("status lacks its start, finish or progress: %+v", status)
+			}
+		})
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "in.csv")
+	if err := os.WriteFile(source, []byte("id\n1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// With its only slot taken, the job waits in the queue until it is cancelled
+	m := NewJobManager(1)
+	m.slots <- struct{}{}
+	queued, err := m.Submit(conversionRequest(dir, source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := queued.Status().State; state != JobQueued {
+		t.Errorf("state = %s, want %s", state, JobQueued)
+	}
+	if _, err := m.Cancel(queued.Status().ID); err != nil {
+		t.Fatal(err)
+	}
+	status := waitForJob(t, queued)
+	if status.State != JobCancelled || status.StartedAt != nil {
+		t.Errorf("cancelled job is %s, started at %v", status.State, status.StartedAt)
+	}
+	if _, err := m.Cancel(status.ID); err == nil {
+		t.Error("cancelling a finished job succeeded")
+	}
+	if _, err := m.Cancel("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("cancelling an unknown job = %v, want %v", err, ErrJobNotFound)
+	}
+
+	// Freeing the slot lets the next job run
+	<-m.slots
+	next, err := m.Submit(conversionRequest(dir, source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitForJob(t, next); status.State != JobSucceeded {
+		t.Errorf("next job %s: %s", status.State, status.Error)
+	}
+
+	var ids []string
+	for _, status := range m.List() {
+		ids = append(ids, status.ID)
+	}
+	if len(ids) != 2 || ids[0] != queued.Status().ID || ids[1] != next.Status().ID {
+		t.Errorf("listed %v, want the cancelled job then the next one", ids)
+	}
+}
+
+func TestJobPruning(t *testing.T) {
+	m := NewJobManager(1)
+	// The oldest job is still running, so the oldest finished ones go first
+	for i := 0; i < maxRetainedJobs+2; i++ {
+		state := JobSucceeded
+		if i == 0 {
+			state = JobRunning
+		}
+		id, err := newJobID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.jobs[id] = &Job{status: JobStatus{ID: id, State: state}}
+		m.order = append(m.order, id)
+	}
+	first, second, third := m.order[0], m.order[1], m.order[2]
+
+	m.mu.Lock()
+	m.pruneLocked()
+	m.mu.Unlock()
+	if len(m.order) != maxRetainedJobs || len(m.jobs) != maxRetainedJobs {
+		t.Fatalf("kept %d jobs, want %d", len(m.order), maxRetainedJobs)
+	}
+	if m.order[0] != first || m.order[1] == second || m.order[1] == third {
+		t.Error("pruning did not keep the running job and drop the two oldest finished ones")
+	}
+}
@@ -13,8 +13,28 @@ import (
 
 func main() {
 	port := flag.Int("port", 8080, "Port to serve the application")
+	dataDir := flag.String("data-dir", defaultDataDir, "Directory for uploaded files and exports")
+	maxUpload := flag.Int64("max-upload-bytes", defaultMaxUploadBytes, "Largest upload request accepted, in bytes (0 for no limit)")
+	maxOpen := flag.Int("ch-max-open", 10, "Maximum open ClickHouse connections per connection profile")
+	maxIdle := flag.Int("ch-max-idle", 5, "Maximum idle ClickHouse connections kept per connection profile")
+	idleTimeout := flag.Duration("ch-idle-timeout", 5*time.Minute, "Close a connection profile's pool after it is unused this long")
+	healthCheck := flag.Duration("ch-health-check", 30*time.Second, "Ping a pooled connection on use when it was last checked this long ago")
 	flag.Parse()
 
+	store, err := NewFileStore(*dataDir, *maxUpload)
+	if err != nil {
+		log.Fatalf("Failed to set up data directory: %v", err)
+	}
+	files = store
+
+	// Share ClickHouse connections between requests for the same connection profile
+	connections = NewConnectionManager(PoolOptions{
+		MaxOpenConns:        *maxOpen,
+		MaxIdleConns:        *maxIdle,
+		IdleTimeout:         *idleTimeout,
+		HealthCheckInterval: *healthCheck,
+	})
+
 	// Set up the server
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", *port),
@@ -37,6 +57,9 @@ func main() {
 	<-quit
 	log.Println("Shutting down server...")
 
+	// Stop any ingestion jobs that are still running
+	jobs.CancelAll()
+
 	// Create a context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -46,5 +69,8 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	// Close pooled ClickHouse connections once requests have finished
+	connections.CloseAll()
+
 	log.Println("Server exited")
 }
\ No newline at end of file
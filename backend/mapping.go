@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ColumnMapping maps one source column onto a target column
+type ColumnMapping struct {
+	Source string `json:"source"`
+	// Target is the target column name; empty keeps the source name
+	Target string `json:"target"`
+	// Type forces the target ClickHouse type; empty keeps the source (or inferred) type
+	Type string `json:"type"`
+	// Default replaces values that are missing or NULL in the source
+	Default *string `json:"default"`
+	// DefaultOnEmpty also replaces empty strings with Default
+	DefaultOnEmpty bool `json:"defaultOnEmpty"`
+	// Nullable makes the target column Nullable
+	Nullable bool `json:"nullable"`
+}
+
+// TargetName returns the name the column is written under
+func (m ColumnMapping) TargetName() string {
+	if m.Target != "" {
+		return m.Target
+	}
+	return m.Source
+}
+
+// validateColumnMappings checks a mapping spec for mistakes that do not need a connection
+func validateColumnMappings(mappings []ColumnMapping) error {
+	seen := make(map[string]bool, len(mappings))
+	for i, m := range mappings {
+		if m.Source == "" {
+			return fmt.Errorf("column mapping %d has no source column", i)
+		}
+		target := m.TargetName()
+		if seen[target] {
+			return fmt.Errorf("target column %s is mapped more than once", target)
+		}
+		seen[target] = true
+	}
+	return nil
+}
+
+// ingestionPlan is the resolved column layout of an ingestion, computed before any data moves
+type ingestionPlan struct {
+	// selectedColumns are the source columns to read
+	selectedColumns []string
+	// mappings are the effective mappings, identity ones when none were requested
+	mappings []ColumnMapping
+	// renames is set when rows must be rewritten through the mappings
+	renames bool
+	// targetColumns is the target schema in write order, or nil when it can only be inferred from data
+	targetColumns []Column
+	// nullOnParseError is set when the source tolerates values that do not match their type
+	nullOnParseError bool
+}
+
+// targetNames returns the target column names in write order
+func (p ingestionPlan) targetNames() []string {
+	names := make([]string, len(p.mappings))
+	for i, m := range p.mappings {
+		names[i] = m.TargetName()
+	}
+	return names
+}
+
+// loadSourceSchema returns the columns of req's source, or nil when they cannot be known up front
+func loadSourceSchema(ctx context.Context, req IngestionRequest) ([]Column, bool, error) {
+	switch req.Source {
+	case SourceFlatFile:
+		client := NewFlatFileClient(sourceFlatFileConfig(req))
+		schema, err := client.GetSchema()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to infer flat file schema: %w", err)
+		}
+		return schema, client.InfersLeniently(), nil
+
+	case SourceClickHouse:
+		// Join results have no single table to describe
+		if isJoinRequest(req) {
+			return nil, false, nil
+		}
+
+		client, err := NewClickHouseClient(sourceClickHouseConfig(req))
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to connect to ClickHouse source: %w", err)
+		}
+		defer client.Close()
+
+		schema, err := client.GetTableColumns(ctx, sourceTableName(req))
+		if err != nil {
+			return nil, false, err
+		}
+		return schema, false, nil
+	}
+
+	return nil, false, fmt.Errorf("invalid source type: %q", req.Source)
+}
+
+// planIngestion resolves which source columns are read and how they land in the target,
+// validating the column mappings against the source schema
+func planIngestion(ctx context.Context, req IngestionRequest) (ingestionPlan, error) {
+	if err := validateColumnMappings(req.ColumnMappings); err != nil {
+		return ingestionPlan{}, err
+	}
+
+	schema, lenient, err := loadSourceSchema(ctx, req)
+	if err != nil {
+		return ingestionPlan{}, err
+	}
+
+	plan := ingestionPlan{
+		mappings:         req.ColumnMappings,
+		renames:          len(req.ColumnMappings) > 0,
+		nullOnParseError: lenient,
+	}
+
+	// Without explicit mappings every selected column maps onto itself
+	if !plan.renames {
+		selected := req.SelectedColumns
+		if len(selected) == 0 {
+			for _, col := range schema {
+				selected = append(selected, col.Name)
+			}
+		}
+		for _, name := range selected {
+			plan.mappings = append(plan.mappings, ColumnMapping{Source: name})
+		}
+	}
+
+	for _, m := range plan.mappings {
+		plan.selectedColumns = append(plan.selectedColumns, m.Source)
+	}
+
+	if schema == nil {
+		// Type overrides and nullability still need a schema, so only fall back
+		// to inferring from the data when nothing was requested
+		if !plan.renames {
+			return plan, nil
+		}
+		schema = []Column{}
+	}
+
+	sourceTypes := make(map[string]string, len(schema))
+	for _, col := range schema {
+		sourceTypes[col.Name] = col.Type
+	}
+
+	var missing []string
+	for _, m := range plan.mappings {
+		sourceType, ok := sourceTypes[m.Source]
+		if !ok && len(schema) > 0 {
+			missing = append(missing, m.Source)
+			continue
+		}
+
+		targetType := m.Type
+		if targetType == "" {
+			targetType = sourceType
+		}
+		if targetType == "" {
+			targetType = "String"
+		}
+		if m.Nullable {
+			targetType = nullableType(targetType)
+		}
+		plan.targetColumns = append(plan.targetColumns, Column{Name: m.TargetName(), Type: targetType})
+	}
+	if len(missing) > 0 {
+		return ingestionPlan{}, fmt.Errorf("source columns not found: %s", strings.Join(missing, ", "))
+	}
+
+	return plan, nil
+}
+
+// nullableType wraps a ClickHouse type in Nullable, keeping LowCardinality outermost
+func nullableType(chType string) string {
+	if _, nullable := unwrapType(chType); nullable {
+		return chType
+	}
+	if strings.HasPrefix(chType, "LowCardinality(") && strings.HasSuffix(chType, ")") {
+		inner := chType[len("LowCardinality(") : len(chType)-1]
+		return fmt.Sprintf("LowCardinality(Nullable(%s))", inner)
+	}
+	return fmt.Sprintf("Nullable(%s)", chType)
+}
+
+// validateTargetTable checks the planned columns against an existing target table: every
+// target column must exist and forced types must match. A missing table is fine since it
+// will be created from the plan.
+func validateTargetTable(ctx context.Context, client *ClickHouseClient, tableName string, plan ingestionPlan) error {
+	exists, err := client.TableExists(ctx, tableName)
+	if err != nil || !exists {
+		return err
+	}
+
+	tableColumns, err := client.GetTableColumns(ctx, tableName)
+	if err != nil {
+		return err
+	}
+	tableTypes := make(map[string]string, len(tableColumns))
+	for _, col := range tableColumns {
+		tableTypes[col.Name] = col.Type
+	}
+
+	var problems []string
+	for i, m := range plan.mappings {
+		name := m.TargetName()
+		tableType, ok := tableTypes[name]
+		if !ok {
+			tableType, ok = tableTypes[sanitizeColumnName(name)]
+		}
+		if !ok {
+			problems = append(problems, fmt.Sprintf("column %s not found", name))
+			continue
+		}
+		if m.Type != "" && i < len(plan.targetColumns) && plan.targetColumns[i].Type != tableType {
+			problems = append(problems, fmt.Sprintf("column %s is %s in the table, not %s", name, tableType, plan.targetColumns[i].Type))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("target table %s does not match the column mapping: %s", tableName, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// mappingIterator rewrites source batches into target columns according to a mapping spec
+type mappingIterator struct {
+	RecordIterator
+	mappings []ColumnMapping
+	schema   []Column
+	// sourceIndex holds the position of each mapping's source column in the source schema, or -1
+	sourceIndex []int
+}
+
+// newMappingIterator wraps source so its batches come out laid out as mappings. Values keep their
+// source types; forced types are applied by the target.
+func newMappingIterator(source RecordIterator, mappings []ColumnMapping) *mappingIterator {
+	sourceSchema := source.Schema()
+	it := &mappingIterator{
+		RecordIterator: source,
+		mappings:       mappings,
+		schema:         make([]Column, len(mappings)),
+		sourceIndex:    make([]int, len(mappings)),
+	}
+	for i, m := range mappings {
+		idx := columnIndex(sourceSchema, m.Source)
+		colType := "Nullable(String)"
+		if idx >= 0 {
+			colType = sourceSchema[idx].Type
+		}
+		it.schema[i] = Column{Name: m.TargetName(), Type: colType}
+		it.sourceIndex[i] = idx
+	}
+	return it
+}
+
+// Schema returns the target columns in mapping order
+func (it *mappingIterator) Schema() []Column {
+	return it.schema
+}
+
+// Next reads the next source batch and returns it laid out as the target columns
+func (it *mappingIterator) Next() (*RecordBatch, error) {
+	batch, err := it.RecordIterator.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	mapped := NewRecordBatch(it.schema, batch.Len())
+	for _, row := range batch.Rows {
+		values := make([]interface{}, len(it.mappings))
+		for i, m := range it.mappings {
+			var value interface{}
+			if idx := it.sourceIndex[i]; idx >= 0 && idx < len(row) {
+				value = row[idx]
+			}
+			if m.Default != nil && (value == nil || m.DefaultOnEmpty && value == "") {
+				value = *m.Default
+			}
+			values[i] = value
+		}
+		mapped.Append(values)
+	}
+	return mapped, nil
+}
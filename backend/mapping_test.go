@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// batchIterator returns fixed batches and then io.EOF
+type batchIterator struct {
+	schema  []Column
+	batches []*RecordBatch
+}
+
+func (it *batchIterator) Schema() []Column { return it.schema }
+
+func (it *batchIterator) Next() (*RecordBatch, error) {
+	if len(it.batches) == 0 {
+		return nil, io.EOF
+	}
+	batch := it.batches[0]
+	it.batches = it.batches[1:]
+	return batch, nil
+}
+
+func (it *batchIterator) Close() error { return nil }
+
+func TestMappingIterator(t *testing.T) {
+	schema := []Column{{Name: "id", Type: "Int64"}, {Name: "name", Type: "Nullable(String)"}}
+	fallback := "unknown"
+	tests := []struct {
+		name       string
+		mappings   []ColumnMapping
+		wantSchema []Column
+		want       [][]interface{}
+	}{
+		{
+			name:       "rename and reorder",
+			mappings:   []ColumnMapping{{Source: "name", Target: "label"}, {Source: "id"}},
+			wantSchema: []Column{{Name: "label", Type: "Nullable(String)"}, {Name: "id", Type: "Int64"}},
+			want:       [][]interface{}{{"ada", int64(1)}, {nil, int64(2)}, {"", int64(3)}},
+		},
+		{
+			name:       "default replaces NULL only",
+			mappings:   []ColumnMapping{{Source: "name", Default: &fallback}},
+			wantSchema: []Column{{Name: "name", Type: "Nullable(String)"}},
+			want:       [][]interface{}{{"ada"}, {"unknown"}, {""}},
+		},
+		{
+			name:       "default on empty",
+			mappings:   []ColumnMapping{{Source: "name", Default: &fallback, DefaultOnEmpty: true}},
+			wantSchema: []Column{{Name: "name", Type: "Nullable(String)"}},
+			want:       [][]interface{}{{"ada"}, {"unknown"}, {"unknown"}},
+		},
+		{
+			name:       "missing source column",
+			mappings:   []ColumnMapping{{Source: "id"}, {Source: "region", Default: &fallback}, {Source: "note"}},
+			wantSchema: []Column{{Name: "id", Type: "Int64"}, {Name: "region", Type: "Nullable(String)"}, {Name: "note", Type: "Nullable(String)"}},
+			want:       [][]interface{}{{int64(1), "unknown", nil}, {int64(2), "unknown", nil}, {int64(3), "unknown", nil}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Rows arrive over two batches to check each is mapped
+			first, second := NewRecordBatch(schema, 2), NewRecordBatch(schema, 1)
+			first.Append([]interface{}{int64(1), "ada"})
+			first.Append([]interface{}{int64(2), nil})
+			second.Append([]interface{}{int64(3), ""})
+
+			it := newMappingIterator(&batchIterator{schema: schema, batches: []*RecordBatch{first, second}}, tt.mappings)
+			if !reflect.DeepEqual(it.Schema(), tt.wantSchema) {
+				t.Errorf("schema = %v, want %v", it.Schema(), tt.wantSchema)
+			}
+			var got [][]interface{}
+			for {
+				batch, err := it.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, batch.Rows...)
+			}
+			assertRows(t, got, tt.want)
+		})
+	}
+}
+
+func TestPlanIngestion(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rows.csv")
+	if err := os.WriteFile(name, []byte("id,name,score\n1,ada,1.5\n2,bob,2.5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		selected     []string
+		mappings     []ColumnMapping
+		wantSelected []string
+		wantRenames  bool
+		wantTargets  []Column
+		wantError    string
+	}{
+		{
+			name:         "every column",
+			wantSelected: []string{"id", "name", "score"},
+			wantTargets:  []Column{{Name: "id", Type: "Int64"}, {Name: "name", Type: "String"}, {Name: "score", Type: "Decimal(18, 1)"}},
+		},
+		{
+			name:         "selected columns",
+			selected:     []string{"score", "id"},
+			wantSelected: []string{"score", "id"},
+			wantTargets:  []Column{{Name: "score", Type: "Decimal(18, 1)"}, {Name: "id", Type: "Int64"}},
+		},
+		{
+			name:         "mappings",
+			selected:     []string{"score"},
+			mappings:     []ColumnMapping{{Source: "name", Target: "label", Type: "LowCardinality(String)", Nullable: true}, {Source: "id", Type: "UInt32"}},
+			wantSelected: []string{"name", "id"},
+			wantRenames:  true,
+			wantTargets:  []Column{{Name: "label", Type: "LowCardinality(Nullable(String))"}, {Name: "id", Type: "UInt32"}},
+		},
+		{name: "unknown source column", mappings: []ColumnMapping{{Source: "id"}, {Source: "region"}}, wantError: "source columns not found: region"},
+		{name: "no source column", mappings: []ColumnMapping{{Target: "id"}}, wantError: "column mapping 0 has no source column"},
+		{name: "duplicate target", mappings: []ColumnMapping{{Source: "id"}, {Source: "name", Target: "id"}}, wantError: "target column id is mapped more than once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := IngestionRequest{
+				Source:          SourceFlatFile,
+				FlatFileConf:    FlatFileConfig{FileName: name, Delimiter: ","},
+				SelectedColumns: tt.selected,
+				ColumnMappings:  tt.mappings,
+			}
+			plan, err := planIngestion(t.Context(), req)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("error = %v, want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(plan.selectedColumns, tt.wantSelected) {
+				t.Errorf("selected %v, want %v", plan.selectedColumns, tt.wantSelected)
+			}
+			if plan.renames != tt.wantRenames {
+				t.Errorf("renames = %v, want %v", plan.renames, tt.wantRenames)
+			}
+			if !reflect.DeepEqual(plan.targetColumns, tt.wantTargets) {
+				t.Errorf("target columns = %v, want %v", plan.targetColumns, tt.wantTargets)
+			}
+		})
+	}
+}
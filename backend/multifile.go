@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SchemaMismatch decides what happens when the files of a multi-file input have different columns
+type SchemaMismatch string
+
+const (
+	// MismatchError rejects files whose column names differ from the first file's
+	MismatchError SchemaMismatch = "error"
+	// MismatchFill takes the union of all files' columns; a file's missing columns read as NULL
+	MismatchFill SchemaMismatch = "fill"
+)
+
+// isGlob reports whether a file name is a pattern
+func isGlob(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// isMultiFile reports whether the input is read as a union of files: a glob, a directory, or a
+// single file that adds a file name column
+func (f *FlatFileClient) isMultiFile() bool {
+	if f.config.FileNameColumn != "" || isGlob(f.config.FileName) {
+		return true
+	}
+	info, err := os.Stat(f.config.FileName)
+	return err == nil && info.IsDir()
+}
+
+// inputFiles returns the visible regular files the input names, sorted by path
+func (f *FlatFileClient) inputFiles() ([]string, error) {
+	name := f.config.FileName
+	var paths []string
+	if isGlob(name) {
+		matches, err := filepath.Glob(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file pattern %q: %w", name, err)
+		}
+		paths = matches
+	} else if info, err := os.Stat(name); err == nil && info.IsDir() {
+		entries, err := os.ReadDir(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory: %w", err)
+		}
+		for _, entry := range entries {
+			paths = append(paths, filepath.Join(name, entry.Name()))
+		}
+	} else {
+		return []string{name}, nil
+	}
+
+	var regular []string
+	for _, path := range paths {
+		// Hidden files include uploads still in progress
+		if strings.HasPrefix(filepath.Base(path), ".") {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			regular = append(regular, path)
+		}
+	}
+	if len(regular) == 0 {
+		return nil, fmt.Errorf("no files match %s", name)
+	}
+	sort.Strings(regular)
+	return regular, nil
+}
+
+// partClient returns a client for one file of a multi-file input
+func (f *FlatFileClient) partClient(path string) *FlatFileClient {
+	config := f.config
+	config.FileName, config.FileID, config.FileNameColumn = path, "", ""
+	// A format detected from the pattern's extension, or the CSV default for a directory, is
+	// detected again from each file's name
+	if config.Format == detectFileFormat(f.config.FileName) {
+		config.Format = ""
+	}
+	part := NewFlatFileClient(config)
+	part.progress = f.progress
+	part.part = true
+	return part
+}
+
+// multiFileColumns reads each file's columns with read and reconciles them into one schema,
+// followed by the file name column if one is configured
+func (f *FlatFileClient) multiFileColumns(read func(*FlatFileClient) ([]Column, error)) ([]Column, error) {
+	paths, err := f.inputFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var union []Column
+	var seen map[string]bool
+	for i, path := range paths {
+		columns, err := read(f.partClient(path))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if i == 0 {
+			union = append(union, columns...)
+			seen = map[string]bool{}
+			for _, col := range columns {
+				seen[col.Name] = true
+			}
+			continue
+		}
+		if union, err = f.mergeColumns(union, seen, columns, paths[0], path); err != nil {
+			return nil, err
+		}
+	}
+
+	if name := f.config.FileNameColumn; name != "" {
+		if columnIndex(union, name) >= 0 {
+			return nil, fmt.Errorf("file name column %s is already a column of the input", name)
+		}
+		union = append(union, Column{Name: name, Type: "String"})
+	}
+	return union, nil
+}
+
+// mergeColumns adds the columns of the file at path to union, widening types where they differ.
+// seen tracks the names in union across calls.
+func (f *FlatFileClient) mergeColumns(union []Column, seen map[string]bool, columns []Column, first, path string) ([]Column, error) {
+	var missing, extra []string
+	present := map[string]bool{}
+	for _, col := range columns {
+		present[col.Name] = true
+		if !seen[col.Name] {
+			extra = append(extra, col.Name)
+		}
+	}
+	for _, col := range union {
+		if !present[col.Name] {
+			missing = append(missing, col.Name)
+		}
+	}
+
+	if f.config.SchemaMismatch != MismatchFill && (len(missing) > 0 || len(extra) > 0) {
+		var differences []string
+		if len(missing) > 0 {
+			differences = append(differences, "missing "+strings.Join(missing, ", "))
+		}
+		if len(extra) > 0 {
+			differences = append(differences, "extra "+strings.Join(extra, ", "))
+		}
+		return nil, fmt.Errorf("columns of %s differ from %s (%s); set schemaMismatch to fill to combine them",
+			path, first, strings.Join(differences, "; "))
+	}
+
+	for i, col := range union {
+		if idx := columnIndex(columns, col.Name); idx >= 0 {
+			union[i].Type = widerColumnType(col.Type, columns[idx].Type)
+		} else {
+			union[i].Type = fillableType(col.Type)
+		}
+	}
+	for _, name := range extra {
+		// Earlier files lack the column
+		col := columns[columnIndex(columns, name)]
+		union = append(union, Column{Name: col.Name, Type: fillableType(col.Type)})
+		seen[name] = true
+	}
+	return union, nil
+}
+
+// fillableType returns chType made Nullable, so files without the column can leave it NULL.
+// Containers cannot be Nullable; they are left empty instead.
+func fillableType(chType string) string {
+	if base, _ := unwrapType(chType); isContainerType(base) {
+		return chType
+	}
+	return nullableType(chType)
+}
+
+// widerColumnType returns a type that holds values of both a and b: the wider number or date
+// type when both are numbers or dates, String otherwise
+func widerColumnType(a, b string) string {
+	if a == b {
+		return a
+	}
+	baseA, nullA := unwrapType(a)
+	baseB, nullB := unwrapType(b)
+
+	var base string
+	switch {
+	case baseA == baseB:
+		base = baseA
+	case isNumericType(baseA) && isNumericType(baseB):
+		base = "Float64"
+		if isIntegerType(baseA) && isIntegerType(baseB) {
+			base = "Int64"
+			if strings.HasPrefix(baseA, "UInt") && strings.HasPrefix(baseB, "UInt") {
+				base = "UInt64"
+			}
+		}
+	case dateTypeRank(baseA) >= 0 && dateTypeRank(baseB) >= 0:
+		base = baseA
+		rankA, rankB := dateTypeRank(baseA), dateTypeRank(baseB)
+		if rankB > rankA || (rankB == rankA && dateTimePrecision(baseB) > dateTimePrecision(baseA)) {
+			base = baseB
+		}
+	default:
+		base = "String"
+	}
+
+	if nullA || nullB {
+		return nullableType(base)
+	}
+	return base
+}
+
+// isIntegerType reports whether base is a signed or unsigned integer type
+func isIntegerType(base string) bool {
+	return strings.HasPrefix(base, "Int") || strings.HasPrefix(base, "UInt")
+}
+
+// dateTypeRank orders date types by the values they hold, or returns -1 for other types
+func dateTypeRank(base string) int {
+	switch {
+	case base == "Date":
+		return 0
+	case base == "Date32":
+		return 1
+	case strings.HasPrefix(base, "DateTime64"):
+		return 3
+	case strings.HasPrefix(base, "DateTime"):
+		return 2
+	}
+	return -1
+}
+
+// multiFileIterator streams the files of a multi-file input one after another under one schema
+type multiFileIterator struct {
+	parent *FlatFileClient
+	paths  []string
+	// selected are the columns requested of each file
+	selected []string
+	schema   []Column
+	// fileColumn is the position of the file name column in schema, or -1
+	fileColumn int
+
+	current     RecordIterator
+	currentPath string
+	next        int
+}
+
+// streamMultiFile returns an iterator over the selected columns of every file in the input.
+// Each column takes its type from the first file; values of files where it differs are
+// passed on as text.
+func (f *FlatFileClient) streamMultiFile(selectedColumns []string) (RecordIterator, error) {
+	paths, err := f.inputFiles()
+	if err != nil {
+		return nil, err
+	}
+	union, err := f.multiFileColumns(func(part *FlatFileClient) ([]Column, error) {
+		headers, err := part.GetHeaders()
+		return stringColumns(headers), err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if len(selectedColumns) == 0 {
+		names = columnNames(union)
+	}
+	for _, name := range selectedColumns {
+		if columnIndex(union, name) >= 0 {
+			names = append(names, name)
+		}
+	}
+
+	it := &multiFileIterator{parent: f, paths: paths, fileColumn: -1}
+	for _, name := range names {
+		if name == f.config.FileNameColumn {
+			it.fileColumn = len(it.schema)
+		} else {
+			it.selected = append(it.selected, name)
+		}
+		it.schema = append(it.schema, Column{Name: name, Type: "String"})
+	}
+
+	if f.progress != nil {
+		var total int64
+		for _, path := range paths {
+			if info, err := os.Stat(path); err == nil {
+				total += info.Size()
+			}
+		}
+		f.progress.SetTotalBytes(total)
+	}
+
+	// The first file is opened up front to type the schema and report errors early
+	if err := it.open(); err != nil {
+		return nil, err
+	}
+	for _, col := range it.current.Schema() {
+		if idx := columnIndex(it.schema, col.Name); idx >= 0 {
+			it.schema[idx].Type = col.Type
+		}
+	}
+	return it, nil
+}
+
+// open starts streaming the next file
+func (it *multiFileIterator) open() error {
+	path := it.paths[it.next]
+	it.next++
+	current, err := it.parent.partClient(path).StreamData(it.selected)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	it.current, it.currentPath = current, path
+	return nil
+}
+
+// Schema returns the selected columns in selection order
+func (it *multiFileIterator) Schema() []Column {
+	return it.schema
+}
+
+// Next returns the next batch of the current file, moving on to the next file at its end
+func (it *multiFileIterator) Next() (*RecordBatch, error) {
+	for {
+		if it.current == nil {
+			if it.next >= len(it.paths) {
+				return nil, io.EOF
+			}
+			if err := it.open(); err != nil {
+				return nil, err
+			}
+		}
+
+		batch, err := it.current.Next()
+		if err == io.EOF {
+			err = it.current.Close()
+			it.current = nil
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", it.currentPath, err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", it.currentPath, err)
+		}
+		return it.convert(batch), nil
+	}
+}
+
+// convert lays out a batch of the current file in the iterator's schema
+func (it *multiFileIterator) convert(batch *RecordBatch) *RecordBatch {
+	indices := make([]int, len(it.schema))
+	asText := make([]bool, len(it.schema))
+	for i, col := range it.schema {
+		indices[i] = batch.ColumnIndex(col.Name)
+		asText[i] = indices[i] >= 0 && batch.Schema[indices[i]].Type != col.Type
+	}
+	fileName := filepath.Base(it.currentPath)
+
+	out := NewRecordBatch(it.schema, batch.Len())
+	for _, row := range batch.Rows {
+		values := make([]interface{}, len(it.schema))
+		for i, idx := range indices {
+			switch {
+			case i == it.fileColumn:
+				values[i] = fileName
+			case idx < 0:
+				// The file lacks the column
+			case asText[i] && row[idx] != nil:
+				values[i] = formatFlatValue(row[idx], batch.Schema[idx].Type)
+			default:
+				values[i] = row[idx]
+			}
+		}
+		out.Append(values)
+	}
+	return out
+}
+
+// Close closes the file being read
+func (it *multiFileIterator) Close() error {
+	if it.current == nil {
+		return nil
+	}
+	return it.current.Close()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates files with the given contents under dir, keyed by slash-separated path
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMultiFileInputFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"b.csv":         "x\n",
+		"a.csv":         "x\n",
+		"c10.csv":       "x\n",
+		"c9.csv":        "x\n",
+		"notes.txt":     "x\n",
+		".upload-1234":  "x\n",
+		".hidden.csv":   "x\n",
+		"nested/d.csv":  "x\n",
+		"empty/.keep":   "",
+		"dir.csv/e.csv": "x\n",
+	})
+
+	tests := []struct {
+		name      string
+		fileName  string
+		want      []string
+		wantError bool
+	}{
+		{name: "glob sorted by name", fileName: "*.csv", want: []string{"a.csv", "b.csv", "c10.csv", "c9.csv"}},
+		{name: "character class", fileName: "[ab].csv", want: []string{"a.csv", "b.csv"}},
+		{name: "single character wildcard", fileName: "c?.csv", want: []string{"c9.csv"}},
+		{name: "glob into subdirectories", fileName: "*/*.csv", want: []string{"dir.csv/e.csv", "nested/d.csv"}},
+		{name: "directory", fileName: ".", want: []string{"a.csv", "b.csv", "c10.csv", "c9.csv", "notes.txt"}},
+		{name: "plain file", fileName: "b.csv", want: []string{"b.csv"}},
+		{name: "no matches", fileName: "*.parquet", wantError: true},
+		{name: "only hidden files", fileName: "empty", wantError: true},
+		{name: "bad pattern", fileName: "[a.csv", wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFlatFileClient(FlatFileConfig{FileName: filepath.Join(dir, tt.fileName)})
+			paths, err := f.inputFiles()
+			if tt.wantError {
+				if err == nil {
+					t.Fatalf("inputFiles = %v, want an error", paths)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, path := range paths {
+				rel, err := filepath.Rel(dir, path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, filepath.ToSlash(rel))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("inputFiles = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiFileSchemaMismatch(t *testing.T) {
+	files := map[string]string{
+		"1.csv": "id,name\n1,a\n",
+		"2.csv": "id,name\n2,b\n",
+		"3.csv": "name,score\nc,1.5\n",
+	}
+	tests := []struct {
+		name       string
+		pattern    string
+		mismatch   SchemaMismatch
+		fileColumn string
+		wantSchema []Column
+		wantRows   [][]interface{}
+		wantError  string
+	}{
+		{
+			name:       "same columns",
+			pattern:    "[12].csv",
+			wantSchema: []Column{{Name: "id", Type: "String"}, {Name: "name", Type: "String"}},
+			wantRows:   [][]interface{}{{"1", "a"}, {"2", "b"}},
+		},
+		{
+			name:      "different columns rejected",
+			pattern:   "*.csv",
+			wantError: "missing id; extra score",
+		},
+		{
+			name:      "explicit error mode",
+			pattern:   "*.csv",
+			mismatch:  MismatchError,
+			wantError: "set schemaMismatch to fill",
+		},
+		{
+			name:     "different columns filled",
+			pattern:  "*.csv",
+			mismatch: MismatchFill,
+			wantSchema: []Column{
+				{Name: "id", Type: "String"},
+				{Name: "name", Type: "String"},
+				{Name: "score", Type: "String"},
+			},
+			wantRows: [][]interface{}{{"1", "a", nil}, {"2", "b", nil}, {nil, "c", "1.5"}},
+		},
+		{
+			name:       "file name column",
+			pattern:    "[12].csv",
+			fileColumn: "source",
+			wantSchema: []Column{
+				{Name: "id", Type: "String"},
+				{Name: "name", Type: "String"},
+				{Name: "source", Type: "String"},
+			},
+			wantRows: [][]interface{}{{"1", "a", "1.csv"}, {"2", "b", "2.csv"}},
+		},
+		{
+			name:       "file name column clashes with a column",
+			pattern:    "[12].csv",
+			fileColumn: "name",
+			wantError:  "already a column",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, files)
+			config := FlatFileConfig{
+				FileName:       filepath.Join(dir, tt.pattern),
+				SchemaMismatch: tt.mismatch,
+				FileNameColumn: tt.fileColumn,
+			}
+
+			if tt.wantError != "" {
+				_, err := NewFlatFileClient(config).StreamData(nil)
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("StreamData error = %v, want one containing %q", err, tt.wantError)
+				}
+				return
+			}
+			schema, rows := readFlatFile(t, config, nil)
+			if !reflect.DeepEqual(schema, tt.wantSchema) {
+				t.Errorf("schema = %v, want %v", schema, tt.wantSchema)
+			}
+			if !reflect.DeepEqual(rows, tt.wantRows) {
+				t.Errorf("rows = %#v, want %#v", rows, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestMultiFileSchemaUnification(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"1.csv": "id,amount,day,tags\n1,10,2024-01-02,a\n",
+		"2.csv": "id,amount,day,extra\n2,2.5,2024-01-02 03:04:05,7\n",
+	})
+	config := FlatFileConfig{FileName: filepath.Join(dir, "*.csv"), SchemaMismatch: MismatchFill}
+	schema, err := NewFlatFileClient(config).GetSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Column{
+		{Name: "id", Type: "Int64"},
+		{Name: "amount", Type: "Float64"},
+		{Name: "day", Type: "DateTime"},
+		{Name: "tags", Type: "Nullable(String)"},
+		{Name: "extra", Type: "Nullable(Int64)"},
+	}
+	if !reflect.DeepEqual(schema, want) {
+		t.Errorf("schema = %v, want %v", schema, want)
+	}
+}
+
+func TestWiderColumnType(t *testing.T) {
+	tests := []struct {
+		a, b, want string
+	}{
+		{"Int64", "Int64", "Int64"},
+		{"Int32", "Int64", "Int64"},
+		{"UInt8", "UInt64", "UInt64"},
+		{"UInt64", "Int64", "Int64"},
+		{"Int64", "Float64", "Float64"},
+		{"Decimal(18, 2)", "Int64", "Float64"},
+		{"Int64", "Nullable(Int64)", "Nullable(Int64)"},
+		{"Nullable(Int32)", "Float32", "Nullable(Float64)"},
+		{"Date", "Date32", "Date32"},
+		{"Date32", "DateTime", "DateTime"},
+		{"DateTime", "DateTime64(3)", "DateTime64(3)"},
+		{"DateTime64(6)", "DateTime64(3)", "DateTime64(6)"},
+		{"Date", "Nullable(DateTime64(9))", "Nullable(DateTime64(9))"},
+		{"Int64", "Date", "String"},
+		{"UUID", "String", "String"},
+		{"Bool", "Int64", "String"},
+		{"LowCardinality(String)", "String", "String"},
+	}
+	for _, tt := range tests {
+		for _, args := range [][2]string{{tt.a, tt.b}, {tt.b, tt.a}} {
+			if got := widerColumnType(args[0], args[1]); got != tt.want {
+				t.Errorf("widerColumnType(%s, %s) = %s, want %s", args[0], args[1], got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// clickHouseHTTP runs queries over ClickHouse's HTTP interface, which, unlike the native
+// protocol, can take and return data in any ClickHouse format as a raw byte stream
+type clickHouseHTTP struct {
+	config ClickHouseConfig
+	client *http.Client
+}
+
+// newClickHouseHTTP returns an HTTP client for the server described by config
+func newClickHouseHTTP(config ClickHouseConfig) *clickHouseHTTP {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Match the native connection, which skips verification too
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return &clickHouseHTTP{config: config, client: &http.Client{Transport: transport}}
+}
+
+// errFastPathUnavailable marks fast path failures that happen before any data has moved, so the
+// transfer can still go row by row
+var errFastPathUnavailable = errors.New("CSV fast path unavailable")
+
+// httpURL returns the base URL of the server's HTTP interface
+func (c ClickHouseConfig) httpURL() string {
+	scheme, port := "http", "8123"
+	if c.IsHTTPS {
+		scheme, port = "https", "8443"
+	}
+	if c.HTTPPort != "" {
+		port = c.HTTPPort
+	}
+	return fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(c.Host, port))
+}
+
+// do runs query with settings, sending body as its input data when set. The caller closes the
+// response body; a failed query is returned as an error carrying the server's message.
+func (h *clickHouseHTTP) do(ctx context.Context, query string, settings map[string]string, body io.Reader) (*http.Response, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	if h.config.Database != "" {
+		params.Set("database", h.config.Database)
+	}
+	for name, value := range settings {
+		params.Set(name, value)
+	}
+
+	// Read-only users may only send GET requests
+	method := http.MethodGet
+	if body != nil {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, h.config.httpURL()+"?"+params.Encode(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build ClickHouse request: %w", err)
+	}
+	req.Header.Set("X-ClickHouse-User", h.config.Username)
+	if h.config.JWTToken != "" {
+		if req.URL.Scheme != "https" {
+			return nil, fmt.Errorf("refusing to send credentials to %s over plain HTTP; set isHttps", req.URL.Host)
+		}
+		req.Header.Set("X-ClickHouse-Key", h.config.JWTToken)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ClickHouse HTTP request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("ClickHouse returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// Ping checks that the HTTP interface is reachable and accepts the configured credentials
+func (h *clickHouseHTTP) Ping(ctx context.Context) error {
+	resp, err := h.do(ctx, "SELECT 1", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// csvSettings returns the settings that make ClickHouse read and write CSV the way
+// FlatFileClient does: the configured delimiter and NULL token, empty by default
+func csvSettings(delimiter, null string) map[string]string {
+	return map[string]string{
+		"format_csv_delimiter":              delimiter,
+		"format_csv_null_representation":    null,
+		"input_format_csv_empty_as_default": "1",
+		"date_time_input_format":            "best_effort",
+	}
+}
+
+// InsertCSV streams CSV data, header line included, into columns of a table and returns the
+// number of rows written: as the server reports it, or as counted in data when it does not.
+// Fields are matched to columns by position, not by the header.
+func (h *clickHouseHTTP) InsertCSV(ctx context.Context, tableName string, columns []string, delimiter, null string, data io.Reader) (int64, error) {
+	quoted := make([]string, len(columns))
+	for i, name := range columns {
+		quoted[i] = quoteIdentifier(name)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) FORMAT CSVWithNames", tableName, strings.Join(quoted, ", "))
+
+	settings := csvSettings(delimiter, null)
+	settings["input_format_with_names_use_header"] = "0"
+	counter := &csvRecordCounter{header: true}
+	resp, err := h.do(ctx, query, settings, countingRecordReader{r: data, counter: counter})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	// The summary header reports what the finished INSERT wrote
+	var summary struct {
+		WrittenRows string `json:"written_rows"`
+	}
+	if err := json.Unmarshal([]byte(resp.Header.Get("X-ClickHouse-Summary")), &summary); err == nil {
+		if written, err := strconv.ParseInt(summary.WrittenRows, 10, 64); err == nil {
+			return written, nil
+		}
+	}
+	// Without a summary, every record sent was written
+	return counter.records, nil
+}
+
+// SelectCSV runs a SELECT and returns its result as CSV without a header line. A query that
+// fails after the server has started sending rows fails the read at the end of the result.
+func (h *clickHouseHTTP) SelectCSV(ctx context.Context, query string, delimiter, null string) (io.ReadCloser, error) {
+	resp, err := h.do(ctx, query+" FORMAT CSV", csvSettings(delimiter, null), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &exceptionCheckingReader{r: resp.Body}, nil
+}
+
+// maxExceptionTail is how many trailing bytes of a streamed result are held back to check
+// for an exception appended to it
+const maxExceptionTail = 64 << 10
+
+// streamExceptionPattern matches the error ClickHouse appends to a result it cannot finish
+// once the 200 status is sent: a "Code: NNN. DB::Exception" line, or the __exception__ block
+// newer servers frame it with
+var streamExceptionPattern = regexp.MustCompile(`(?m)^Code: \d+\. DB::Exception: |__exception__`)
+
+// exceptionCheckingReader passes a streamed result through but holds back its last bytes
+// until the end, so an exception the server appends there fails the read instead of being
+// written out as data
+type exceptionCheckingReader struct {
+	r       io.ReadCloser
+	pending []byte
+	eof     bool
+}
+
+func (e *exceptionCheckingReader) Read(p []byte) (int, error) {
+	if !e.eof {
+		chunk := make([]byte, 32<<10)
+		for len(e.pending) < maxExceptionTail+len(p) {
+			n, err := e.r.Read(chunk)
+			e.pending = append(e.pending, chunk[:n]...)
+			if err == io.EOF {
+				e.eof = true
+				if loc := streamExceptionPattern.FindIndex(e.pending); loc != nil {
+					return 0, streamException(e.pending[loc[0]:])
+				}
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	available := len(e.pending)
+	if !e.eof {
+		available -= maxExceptionTail
+	}
+	if available == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, e.pending[:available])
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+func (e *exceptionCheckingReader) Close() error {
+	return e.r.Close()
+}
+
+// streamException returns the error for an exception found at the end of a result, keeping
+// the line with the server's message
+func streamException(text []byte) error {
+	message := strings.TrimSpace(strings.ReplaceAll(string(text), "__exception__", ""))
+	for _, line := range strings.Split(message, "\n") {
+		if strings.Contains(line, "DB::Exception") {
+			message = strings.TrimSpace(line)
+			break
+		}
+	}
+	if len(message) > 1024 {
+		message = message[:1024]
+	}
+	return fmt.Errorf("ClickHouse failed while sending the result: %s", message)
+}
+
+// csvRecordCounter counts CSV records in a byte stream by their line ends outside quotes
+type csvRecordCounter struct {
+	quoted  bool
+	partial bool
+	records int64
+	// header is set while a header line is still to be skipped
+	header bool
+}
+
+// count scans p and returns the number of records it completed
+func (c *csvRecordCounter) count(p []byte) int64 {
+	var completed int64
+	for _, b := range p {
+		switch {
+		case b == '"':
+			// An escaped quote toggles twice, leaving the state unchanged
+			c.quoted = !c.quoted
+			c.partial = true
+		case b == '\n' && !c.quoted:
+			if c.partial && c.header {
+				c.header = false
+			} else if c.partial {
+				completed++
+			}
+			c.partial = false
+		case b != '\r':
+			c.partial = true
+		}
+	}
+	c.records += completed
+	return completed
+}
+
+// finish counts a last record that has no line end
+func (c *csvRecordCounter) finish() int64 {
+	if !c.partial || c.header {
+		return 0
+	}
+	c.partial = false
+	c.records++
+	return 1
+}
+
+// countingRecordReader reports the CSV records read through it as rows read
+type countingRecordReader struct {
+	r        io.Reader
+	counter  *csvRecordCounter
+	progress *Progress
+}
+
+func (c countingRecordReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.progress.AddRowsRead(c.counter.count(b[:n]))
+	if err == io.EOF {
+		c.progress.AddRowsRead(c.counter.finish())
+	}
+	return n, err
+}
+
+// isNativeCSVTransfer reports whether req can take the fast path, streaming CSV bytes as-is
+// between a file and a single ClickHouse table: no mappings, no tolerated parse errors, no custom
+// batching, a file in the default CSV dialect with a header line, and for imports a file whose
+// columns are all selected in file order
+func isNativeCSVTransfer(req IngestionRequest, plan ingestionPlan) bool {
+	if plan.renames || req.BatchOptions != (BatchOptions{}) {
+		return false
+	}
+	// ClickHouse takes a single NULL token
+	if len(sourceFlatFileConfig(req).NullValues) > 1 || len(targetFlatFileConfig(req).NullValues) > 1 {
+		return false
+	}
+
+	switch {
+	case req.Source == SourceFlatFile && req.Target == SourceClickHouse:
+		file := NewFlatFileClient(sourceFlatFileConfig(req))
+		if file.Format() != FormatCSV || file.isMultiFile() || !file.config.hasHeader() || len(file.config.Delimiter) != 1 || file.config.CSV != (CSVOptions{}) || plan.nullOnParseError || plan.targetColumns == nil {
+			return false
+		}
+		headers, err := file.GetHeaders()
+		if err != nil || len(headers) != len(plan.selectedColumns) {
+			return false
+		}
+		for i, header := range headers {
+			if header != plan.selectedColumns[i] {
+				return false
+			}
+		}
+		return true
+
+	case req.Source == SourceClickHouse && req.Target == SourceFlatFile:
+		file := NewFlatFileClient(targetFlatFileConfig(req))
+		return file.Format() == FormatCSV && len(file.config.Delimiter) == 1 && file.config.CSV == (CSVOptions{}) && !file.config.Split.enabled() && file.config.hasHeader() &&
+			!isJoinRequest(req) && !isSheetPerTableRequest(req) && len(plan.selectedColumns) > 0
+	}
+	return false
+}
+
+// runNativeCSVImport streams a CSV file into ClickHouse as INSERT ... FORMAT CSVWithNames
+func runNativeCSVImport(ctx context.Context, req IngestionRequest, plan ingestionPlan, progress *Progress) (int, error) {
+	insert := newClickHouseHTTP(targetClickHouseConfig(req))
+	if err := insert.Ping(ctx); err != nil {
+		return 0, fmt.Errorf("%w: %v", errFastPathUnavailable, err)
+	}
+
+	client, err := NewClickHouseClient(targetClickHouseConfig(req))
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to ClickHouse target: %w", err)
+	}
+	defer client.Close()
+
+	tableName := targetTableName(req)
+	tableOptions, err := copyTargetTableOptions(ctx, req, plan)
+	if err != nil {
+		return 0, err
+	}
+	insertNames, err := prepareTargetTable(ctx, client, tableName, req.WriteMode, plan, plan.targetColumns, tableOptions)
+	if err != nil {
+		return 0, err
+	}
+
+	file := NewFlatFileClient(sourceFlatFileConfig(req))
+	file.TrackProgress(progress)
+	input, err := file.openInput(true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read data from flat file: %w", err)
+	}
+	defer input.Close()
+
+	decoded, err := decodeReader(input, file.config.Encoding)
+	if err != nil {
+		return 0, err
+	}
+	var data io.Reader = decoded
+	if progress != nil {
+		data = countingRecordReader{r: decoded, counter: &csvRecordCounter{header: true}, progress: progress}
+	}
+
+	log.Printf("Streaming %s into %s as CSV", file.config.FileName, tableName)
+	written, err := insert.InsertCSV(ctx, tableName, insertNames, file.config.Delimiter, file.nullMarkers().write, data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert CSV into %s: %w", tableName, err)
+	}
+	progress.AddRowsWritten(written)
+	return int(written), nil
+}
+
+// countingRecordWriter reports the CSV records written through it as rows read and written
+type countingRecordWriter struct {
+	w        io.Writer
+	counter  *csvRecordCounter
+	progress *Progress
+}
+
+func (c countingRecordWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	rows := c.counter.count(b[:n])
+	c.progress.AddRowsRead(rows)
+	c.progress.AddRowsWritten(rows)
+	return n, err
+}
+
+// runNativeCSVExport streams SELECT ... FORMAT CSV results from ClickHouse straight into a file
+func runNativeCSVExport(ctx context.Context, req IngestionRequest, plan ingestionPlan, progress *Progress) (int, error) {
+	tableName := sourceTableName(req)
+	if progress != nil {
+		// The row count only feeds the ETA, so a failure here is not fatal
+		if client, err := NewClickHouseClient(sourceClickHouseConfig(req)); err == nil {
+			if total, cerr := client.CountRows(ctx, tableName); cerr == nil {
+				progress.SetTotalRows(int64(total))
+			}
+			client.Close()
+		}
+	}
+
+	file := NewFlatFileClient(targetFlatFileConfig(req))
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(plan.selectedColumns, ", "), tableName)
+	result, err := newClickHouseHTTP(sourceClickHouseConfig(req)).SelectCSV(ctx, query, file.config.Delimiter, file.nullMarkers().write)
+	if err != nil {
+		// Nothing has been written yet
+		return 0, fmt.Errorf("%w: %v", errFastPathUnavailable, err)
+	}
+	defer result.Close()
+
+	file.TrackProgress(progress)
+	output, err := file.createOutput()
+	if err != nil {
+		return 0, fmt.Errorf("failed to write data to flat file: %w", err)
+	}
+	encoder, err := encodeWriter(output, file.config.Encoding)
+	if err != nil {
+		file.discardOutputs()
+		output.Close()
+		return 0, err
+	}
+
+	// The header is written the way the row-by-row writer does it
+	header := csv.NewWriter(encoder)
+	header.Comma = rune(file.config.Delimiter[0])
+	header.Write(plan.targetNames())
+	header.Flush()
+
+	counter := &csvRecordCounter{}
+	_, err = io.Copy(countingRecordWriter{w: encoder, counter: counter, progress: progress}, result)
+	if err == nil {
+		rows := counter.finish()
+		progress.AddRowsRead(rows)
+		progress.AddRowsWritten(rows)
+	}
+	if err == nil {
+		err = header.Error()
+	}
+	if cerr := encoder.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		file.discardOutputs()
+	}
+	if cerr := output.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return int(counter.records), fmt.Errorf("failed to stream CSV to %s: %w", file.config.FileName, err)
+	}
+	return int(counter.records), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSelectCSVStreamException(t *testing.T) {
+	// Enough rows that the exception arrives well after the held-back tail was first filled
+	rows := strings.Repeat("1,\"a, b\"\n", 20000)
+	tests := []struct {
+		name      string
+		body      string
+		wantError string
+	}{
+		{name: "complete result", body: rows},
+		{name: "short result", body: "1,a\n"},
+		{name: "empty result"},
+		{name: "exception text inside a value", body: rows + "2,\"Code: 1. DB::Exception: quoted\"\n"},
+		{
+			name:      "exception after rows",
+			body:      rows + "Code: 241. DB::Exception: Memory limit (total) exceeded. (MEMORY_LIMIT_EXCEEDED) (version 24.3.1.1)\n",
+			wantError: "Code: 241. DB::Exception: Memory limit (total) exceeded.",
+		},
+		{
+			name:      "exception block after rows",
+			body:      rows + "__exception__\r\nxk2nd83hs0rmq1vz\r\nCode: 159. DB::Exception: Timeout exceeded. (TIMEOUT_EXCEEDED)\r\n70 xk2nd83hs0rmq1vz\r\n__exception__\r\n",
+			wantError: "Code: 159. DB::Exception: Timeout exceeded.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.URL.Query().Get("query"); got != "SELECT a, b FROM t FORMAT CSV" {
+					t.Errorf("query = %q", got)
+				}
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+			address, _ := url.Parse(server.URL)
+			host, port, _ := net.SplitHostPort(address.Host)
+
+			result, err := newClickHouseHTTP(ClickHouseConfig{Host: host, HTTPPort: port}).SelectCSV(t.Context(), "SELECT a, b FROM t", ",", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer result.Close()
+			got, err := io.ReadAll(result)
+
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantError)
+				}
+				if strings.Contains(string(got), "Exception") {
+					t.Error("the exception was passed on as data")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.body {
+				t.Errorf("read %d bytes, want the %d sent", len(got), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestIsNativeCSVTransferExport(t *testing.T) {
+	plan := ingestionPlan{selectedColumns: []string{"a", "b"}}
+	noHeader := false
+	tests := []struct {
+		name string
+		file FlatFileConfig
+		plan ingestionPlan
+		// batch sets custom batching
+		batch bool
+		want  bool
+	}{
+		{name: "plain CSV", file: FlatFileConfig{FileName: "out.csv", Delimiter: ","}, plan: plan, want: true},
+		{name: "tab separated", file: FlatFileConfig{FileName: "out.tsv", Delimiter: "\t"}, plan: plan, want: true},
+		{name: "renamed columns", file: FlatFileConfig{FileName: "out.csv", Delimiter: ","}, plan: ingestionPlan{selectedColumns: []string{"a"}, renames: true}},
+		{name: "custom batching", file: FlatFileConfig{FileName: "out.csv", Delimiter: ","}, plan: plan, batch: true},
+		{name: "no header", file: FlatFileConfig{FileName: "out.csv", Delimiter: ",", HasHeader: &noHeader}, plan: plan},
+		{name: "multi-character delimiter", file: FlatFileConfig{FileName: "out.csv", Delimiter: "||"}, plan: plan},
+		{name: "custom dialect", file: FlatFileConfig{FileName: "out.csv", Delimiter: ",", CSV: CSVOptions{Quote: "'"}}, plan: plan},
+		{name: "several NULL tokens", file: FlatFileConfig{FileName: "out.csv", Delimiter: ",", NullValues: []string{"", "NULL"}}, plan: plan},
+		{name: "other format", file: FlatFileConfig{FileName: "out.parquet"}, plan: plan},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := IngestionRequest{Source: SourceClickHouse, Target: SourceFlatFile, TableName: "t", FlatFileConf: tt.file}
+			if tt.batch {
+				req.BatchOptions = BatchOptions{MaxRows: 10}
+			}
+			if got := isNativeCSVTransfer(req, tt.plan); got != tt.want {
+				t.Errorf("isNativeCSVTransfer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,867 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Nested object handling for NDJSON imports
+const (
+	// NestedFlatten turns nested object fields into their own "parent.child" columns
+	NestedFlatten = "flatten"
+	// NestedTuple stores nested objects in named Tuple columns
+	NestedTuple = "tuple"
+	// NestedMap stores nested objects in Map(String, T) columns
+	NestedMap = "map"
+	// NestedJSON stores nested objects in JSON columns
+	NestedJSON = "json"
+)
+
+// NDJSONOptions controls how newline-delimited JSON files are read
+type NDJSONOptions struct {
+	// Nested is how nested objects become columns: flatten (default), tuple, map or json
+	Nested string `json:"nested"`
+}
+
+// nestedMode returns the configured nested object handling
+func (o NDJSONOptions) nestedMode() (string, error) {
+	switch o.Nested {
+	case "":
+		return NestedFlatten, nil
+	case NestedFlatten, NestedTuple, NestedMap, NestedJSON:
+		return o.Nested, nil
+	}
+	return "", fmt.Errorf("invalid nested object mode: %q", o.Nested)
+}
+
+// jsonObject is a decoded JSON object that remembers the order of its keys
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// MarshalJSON writes the object with its keys in their original order
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// readJSONValue reads the next value from dec, keeping object key order and numbers as json.Number
+func readJSONValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := &jsonObject{values: make(map[string]interface{})}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, _ := keyTok.(string)
+				value, err := readJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				if _, seen := obj.values[key]; !seen {
+					obj.keys = append(obj.keys, key)
+				}
+				obj.values[key] = value
+			}
+			_, err := dec.Token() // closing brace
+			return obj, err
+		case '[':
+			items := []interface{}{}
+			for dec.More() {
+				item, err := readJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			_, err := dec.Token() // closing bracket
+			return items, err
+		}
+		return nil, fmt.Errorf("unexpected %v", t)
+	default:
+		return t, nil
+	}
+}
+
+// readJSONObject reads the next top-level object, or io.EOF at the end of the file
+func readJSONObject(dec *json.Decoder) (*jsonObject, error) {
+	value, err := readJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := value.(*jsonObject)
+	if !ok {
+		return nil, fmt.Errorf("expected a JSON object on each line, got %T", value)
+	}
+	return obj, nil
+}
+
+// newJSONDecoder returns a decoder over r that keeps numbers exact
+func newJSONDecoder(r io.Reader) *json.Decoder {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return dec
+}
+
+// jsonFieldSample accumulates what was seen for one field across sampled objects
+type jsonFieldSample struct {
+	present, nulls                     int
+	bools, ints, uints, floats, others int
+	strings                            *columnSample
+	stringCount                        int
+	// elements samples the items of arrays seen in this field
+	arrays   int
+	elements *jsonFieldSample
+	// fields samples the keys of objects seen in this field, values samples all their values
+	objects int
+	fields  *jsonObjectSample
+	values  *jsonFieldSample
+}
+
+// jsonObjectSample accumulates the fields of sampled objects in first-seen order
+type jsonObjectSample struct {
+	count  int
+	order  []string
+	fields map[string]*jsonFieldSample
+}
+
+func newJSONObjectSample() *jsonObjectSample {
+	return &jsonObjectSample{fields: make(map[string]*jsonFieldSample)}
+}
+
+// add records one sampled object
+func (o *jsonObjectSample) add(obj *jsonObject) {
+	o.count++
+	for _, key := range obj.keys {
+		field, ok := o.fields[key]
+		if !ok {
+			field = &jsonFieldSample{}
+			o.fields[key] = field
+			o.order = append(o.order, key)
+		}
+		field.add(obj.values[key])
+	}
+}
+
+// add records one sampled value
+func (f *jsonFieldSample) add(value interface{}) {
+	f.present++
+	switch v := value.(type) {
+	case nil:
+		f.nulls++
+	case bool:
+		f.bools++
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			f.ints++
+		} else if _, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			f.uints++
+		} else {
+			f.floats++
+		}
+	case string:
+		if f.strings == nil {
+			f.strings = newColumnSample()
+		}
+		f.strings.add(v)
+		f.stringCount++
+	case []interface{}:
+		f.arrays++
+		if f.elements == nil {
+			f.elements = &jsonFieldSample{}
+		}
+		for _, item := range v {
+			f.elements.add(item)
+		}
+	case *jsonObject:
+		f.objects++
+		if f.fields == nil {
+			f.fields = newJSONObjectSample()
+			f.values = &jsonFieldSample{}
+		}
+		f.fields.add(v)
+		for _, key := range v.keys {
+			f.values.add(v.values[key])
+		}
+	default:
+		f.others++
+	}
+}
+
+// ndjsonColumn reads one column out of decoded objects
+type ndjsonColumn struct {
+	name string
+	// path is the chain of keys leading to the value
+	path    []string
+	chType  string
+	convert func(interface{}) interface{}
+}
+
+// value extracts and converts the column's value from a decoded object
+func (c ndjsonColumn) value(obj *jsonObject) interface{} {
+	var current interface{} = obj
+	for _, key := range c.path {
+		o, ok := current.(*jsonObject)
+		if !ok {
+			return nil
+		}
+		current = o.values[key]
+	}
+	if current == nil {
+		return nil
+	}
+	return c.convert(current)
+}
+
+// columns turns the sampled object into columns, flattening nested objects when asked to
+func (o *jsonObjectSample) columns(prefix []string, mode string, total int, confidence float64) []ndjsonColumn {
+	var columns []ndjsonColumn
+	for _, key := range o.order {
+		field := o.fields[key]
+		path := append(append([]string(nil), prefix...), key)
+
+		if mode == NestedFlatten && field.objects > 0 && field.objects+field.nulls == field.present {
+			columns = append(columns, field.fields.columns(path, mode, total, confidence)...)
+			continue
+		}
+
+		chType, convert := field.column(mode, true, total, confidence)
+		columns = append(columns, ndjsonColumn{
+			name:    strings.Join(path, "."),
+			path:    path,
+			chType:  chType,
+			convert: convert,
+		})
+	}
+	return columns
+}
+
+// column picks a ClickHouse type for the field and returns a converter for its values.
+// Date, time and UUID detection only applies to top-level strings, which the target parses;
+// values inside containers are converted here and keep plain types.
+func (f *jsonFieldSample) column(mode string, topLevel bool, total int, confidence float64) (string, func(interface{}) interface{}) {
+	nullable := f.nulls > 0 || f.present < total
+	scalars := f.bools + f.ints + f.uints + f.floats + f.stringCount + f.others
+
+	var base string
+	var convert func(interface{}) interface{}
+	switch {
+	case f.arrays > 0 && scalars == 0 && f.objects == 0:
+		elemType, elemConvert := f.elements.column(mode, false, f.elements.present, confidence)
+		return fmt.Sprintf("Array(%s)", elemType), func(v interface{}) interface{} {
+			items, ok := v.([]interface{})
+			if !ok {
+				return nil
+			}
+			converted := make([]interface{}, len(items))
+			for i, item := range items {
+				if item != nil {
+					converted[i] = elemConvert(item)
+				}
+			}
+			return converted
+		}
+	case f.objects > 0 && scalars == 0 && f.arrays == 0:
+		return f.objectColumn(mode, confidence)
+	case f.present == f.nulls:
+		base, convert = "String", jsonText
+	case scalars == f.bools:
+		base, convert = "Bool", func(v interface{}) interface{} { return v }
+	case scalars == f.ints:
+		base, convert = "Int64", jsonNumber(func(n json.Number) (interface{}, error) { return n.Int64() })
+	case scalars == f.ints+f.uints:
+		base, convert = "UInt64", jsonNumber(func(n json.Number) (interface{}, error) { return strconv.ParseUint(n.String(), 10, 64) })
+	case scalars == f.ints+f.uints+f.floats:
+		base, convert = "Float64", jsonNumber(func(n json.Number) (interface{}, error) { return n.Float64() })
+	case scalars == f.stringCount && topLevel:
+		base, convert = inferJSONStringType(f.strings, confidence), jsonText
+		if _, inferredNullable := unwrapType(base); inferredNullable {
+			return base, convert
+		}
+	default:
+		base, convert = "String", jsonText
+	}
+
+	if nullable {
+		return nullableType(base), convert
+	}
+	return base, convert
+}
+
+// objectColumn maps a nested object field onto a Tuple, Map or JSON column
+func (f *jsonFieldSample) objectColumn(mode string, confidence float64) (string, func(interface{}) interface{}) {
+	switch mode {
+	case NestedTuple:
+		fields := make([]string, len(f.fields.order))
+		converters := make([]func(interface{}) interface{}, len(f.fields.order))
+		for i, key := range f.fields.order {
+			fieldType, convert := f.fields.fields[key].column(mode, false, f.fields.count, confidence)
+			fields[i] = fmt.Sprintf("%s %s", quoteIdentifier(key), fieldType)
+			converters[i] = convert
+		}
+		return fmt.Sprintf("Tuple(%s)", strings.Join(fields, ", ")), func(v interface{}) interface{} {
+			obj, ok := v.(*jsonObject)
+			if !ok {
+				return nil
+			}
+			tuple := make(map[string]interface{}, len(f.fields.order))
+			for i, key := range f.fields.order {
+				var value interface{}
+				if raw := obj.values[key]; raw != nil {
+					value = converters[i](raw)
+				}
+				tuple[key] = value
+			}
+			return tuple
+		}
+	case NestedMap:
+		valueType, convert := f.values.column(mode, false, f.values.present, confidence)
+		return fmt.Sprintf("Map(String, %s)", valueType), func(v interface{}) interface{} {
+			obj, ok := v.(*jsonObject)
+			if !ok {
+				return nil
+			}
+			m := make(map[string]interface{}, len(obj.keys))
+			for _, key := range obj.keys {
+				var value interface{}
+				if raw := obj.values[key]; raw != nil {
+					value = convert(raw)
+				}
+				m[key] = value
+			}
+			return m
+		}
+	default:
+		return "JSON", jsonText
+	}
+}
+
+// inferJSONStringType infers a type for string values, keeping numbers and booleans that were
+// quoted in the JSON as strings
+func inferJSONStringType(sample *columnSample, confidence float64) string {
+	if sample == nil {
+		return "String"
+	}
+	inferred := sample.inferType(confidence)
+	base, _ := unwrapType(inferred)
+	switch {
+	case base == "Bool", base == "Int64", base == "UInt64", base == "Float64", strings.HasPrefix(base, "Decimal"):
+		return "String"
+	}
+	return inferred
+}
+
+// jsonText returns strings as they are and any other value as its JSON text
+func jsonText(v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	text, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(text)
+}
+
+// jsonNumber converts JSON numbers with parse; anything else is passed on as text for the target to parse
+func jsonNumber(parse func(json.Number) (interface{}, error)) func(interface{}) interface{} {
+	return func(v interface{}) interface{} {
+		switch n := v.(type) {
+		case json.Number:
+			if value, err := parse(n); err == nil {
+				return value
+			}
+			return n.String()
+		case bool:
+			digit := json.Number("0")
+			if n {
+				digit = "1"
+			}
+			value, _ := parse(digit)
+			return value
+		}
+		return jsonText(v)
+	}
+}
+
+// ndjsonColumns samples the start of the file and returns its columns
+func (f *FlatFileClient) ndjsonColumns() ([]ndjsonColumn, error) {
+	mode, err := f.config.NDJSON.nestedMode()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := f.openInput(false)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	input, err := decodeReader(file, f.config.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := newJSONDecoder(input)
+	sample := newJSONObjectSample()
+	for sample.count < f.config.InferSampleSize {
+		obj, err := readJSONObject(dec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %w", err)
+		}
+		sample.add(obj)
+	}
+
+	return sample.columns(nil, mode, sample.count, f.config.InferConfidence), nil
+}
+
+// ndjsonSchema infers the columns of an NDJSON file from sampled objects
+func (f *FlatFileClient) ndjsonSchema() ([]Column, error) {
+	columns, err := f.ndjsonColumns()
+	if err != nil {
+		return nil, err
+	}
+
+	schema := make([]Column, len(columns))
+	for i, col := range columns {
+		schema[i] = Column{Name: col.name, Type: col.chType}
+	}
+	return schema, nil
+}
+
+// ndjsonKeyTree holds the key paths an NDJSON file's columns were inferred from. A key maps to
+// the tree of an object flattened into columns, or to nil when its value fills one column.
+type ndjsonKeyTree map[string]ndjsonKeyTree
+
+// newNDJSONKeyTree returns the key paths read by columns
+func newNDJSONKeyTree(columns []ndjsonColumn) ndjsonKeyTree {
+	root := ndjsonKeyTree{}
+	for _, col := range columns {
+		tree := root
+		for i, key := range col.path {
+			if i == len(col.path)-1 {
+				tree[key] = nil
+				break
+			}
+			if tree[key] == nil {
+				tree[key] = ndjsonKeyTree{}
+			}
+			tree = tree[key]
+		}
+	}
+	return root
+}
+
+// unknown calls found with the dotted path of every key in obj that no column reads
+func (t ndjsonKeyTree) unknown(obj *jsonObject, prefix string, found func(string)) {
+	for _, key := range obj.keys {
+		sub, ok := t[key]
+		switch {
+		case !ok:
+			found(prefix + key)
+		case sub != nil:
+			if nested, isObject := obj.values[key].(*jsonObject); isObject {
+				sub.unknown(nested, prefix+key+".", found)
+			}
+		}
+	}
+}
+
+// maxReportedNDJSONKeys bounds the unknown keys named in the log
+const maxReportedNDJSONKeys = 10
+
+// ndjsonRecordIterator streams record batches from a newline-delimited JSON file
+type ndjsonRecordIterator struct {
+	file     io.Closer
+	fileName string
+	decoder  *json.Decoder
+	schema   []Column
+	columns  []ndjsonColumn
+	// known holds the key paths of all the file's columns; keys outside it were not in the
+	// sample the columns were inferred from and are not imported
+	known          ndjsonKeyTree
+	unknownKeys    []string
+	unknownObjects int64
+}
+
+// Schema returns the selected columns in selection order
+func (it *ndjsonRecordIterator) Schema() []Column {
+	return it.schema
+}
+
+// Next reads up to defaultRecordBatchRows objects into a batch
+func (it *ndjsonRecordIterator) Next() (*RecordBatch, error) {
+	batch := NewRecordBatch(it.schema, defaultRecordBatchRows)
+	for batch.Len() < defaultRecordBatchRows {
+		obj, err := readJSONObject(it.decoder)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %w", err)
+		}
+
+		values := make([]interface{}, len(it.columns))
+		for i, col := range it.columns {
+			values[i] = col.value(obj)
+		}
+		batch.Append(values)
+		it.checkKeys(obj)
+	}
+
+	if batch.Len() == 0 {
+		return nil, io.EOF
+	}
+	return batch, nil
+}
+
+// checkKeys counts an object with keys outside the inferred columns and remembers their names
+func (it *ndjsonRecordIterator) checkKeys(obj *jsonObject) {
+	seen := false
+	it.known.unknown(obj, "", func(key string) {
+		seen = true
+		if len(it.unknownKeys) < maxReportedNDJSONKeys && !slices.Contains(it.unknownKeys, key) {
+			it.unknownKeys = append(it.unknownKeys, key)
+		}
+	})
+	if seen {
+		it.unknownObjects++
+	}
+}
+
+// Close closes the underlying file, logging keys that were skipped because the sample missed them
+func (it *ndjsonRecordIterator) Close() error {
+	if it.unknownObjects > 0 {
+		log.Printf("%s: %d objects had keys that were not in the first objects sampled for columns and were skipped: %s; raise inferSampleSize to import them",
+			it.fileName, it.unknownObjects, strings.Join(it.unknownKeys, ", "))
+	}
+	return it.file.Close()
+}
+
+// streamNDJSON returns an iterator over the selected columns of an NDJSON file, in the order they
+// were selected. A selected column missing from the sampled objects is an error, except in one file
+// of a multi-file input, where other files may have it. Keys of later objects that the sample
+// missed are counted and logged when the iterator is closed.
+func (f *FlatFileClient) streamNDJSON(selectedColumns []string) (RecordIterator, error) {
+	available, err := f.ndjsonColumns()
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []ndjsonColumn
+	if len(selectedColumns) == 0 {
+		columns = available
+	}
+	for _, name := range selectedColumns {
+		found := false
+		for _, col := range available {
+			if col.name == name {
+				columns = append(columns, col)
+				found = true
+				break
+			}
+		}
+		if !found && !f.part {
+			return nil, fmt.Errorf("column %s is not a key of the first %d objects; raise inferSampleSize if it appears later", name, f.config.InferSampleSize)
+		}
+	}
+
+	file, err := f.openInput(true)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := decodeReader(file, f.config.Encoding)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	schema := make([]Column, len(columns))
+	for i, col := range columns {
+		schema[i] = Column{Name: col.name, Type: col.chType}
+	}
+
+	return &ndjsonRecordIterator{
+		file:     file,
+		fileName: f.config.FileName,
+		decoder:  newJSONDecoder(decoded),
+		schema:   schema,
+		columns:  columns,
+		known:    newNDJSONKeyTree(available),
+	}, nil
+}
+
+// ndjsonRecordSink writes each row as one JSON object per line
+type ndjsonRecordSink struct {
+	file    io.WriteCloser
+	encoder io.WriteCloser
+	writer  *bufio.Writer
+	options FileWriterOptions
+	columns []Column
+	// keys holds the JSON-encoded column names, parallel to columns
+	keys [][]byte
+	// sourceIndex maps each of columns to its position in the incoming schema, or -1
+	sourceIndex []int
+}
+
+// newNDJSONRecordSink returns a sink writing NDJSON to file, which it closes when done
+func newNDJSONRecordSink(file io.WriteCloser, encoding string, options FileWriterOptions) (RecordSink, error) {
+	encoder, err := encodeWriter(file, encoding)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &ndjsonRecordSink{
+		file:    file,
+		encoder: encoder,
+		writer:  bufio.NewWriter(encoder),
+		options: options,
+	}, nil
+}
+
+// resolveColumns settles the output columns from the configured columns and the first batch
+func (s *ndjsonRecordSink) resolveColumns(schema []Column) {
+	columns := append([]Column(nil), s.options.Columns...)
+	if len(columns) == 0 {
+		columns = append(columns, schema...)
+	}
+
+	s.keys = make([][]byte, len(columns))
+	s.sourceIndex = make([]int, len(columns))
+	for i := range columns {
+		s.sourceIndex[i] = columnIndex(schema, columns[i].Name)
+		if columns[i].Type == "" && s.sourceIndex[i] >= 0 {
+			columns[i].Type = schema[s.sourceIndex[i]].Type
+		}
+		s.keys[i], _ = json.Marshal(columns[i].Name)
+	}
+	s.columns = columns
+}
+
+// WriteBatch writes one JSON object per row with keys in column order
+func (s *ndjsonRecordSink) WriteBatch(batch *RecordBatch) error {
+	if s.columns == nil {
+		s.resolveColumns(batch.Schema)
+	}
+
+	for _, row := range batch.Rows {
+		s.writer.WriteByte('{')
+		for i, col := range s.columns {
+			var value interface{}
+			var sourceType string
+			if idx := s.sourceIndex[i]; idx >= 0 && idx < len(row) {
+				value, sourceType = row[idx], batch.Schema[idx].Type
+			}
+			if col.Type != "" {
+				// Text values from delimited sources are typed before they are written
+				converted, err := castValue(value, sourceType, col, s.options.NullOnParseError)
+				if err != nil {
+					return err
+				}
+				value, sourceType = converted, col.Type
+			}
+
+			encoded, err := json.Marshal(toJSONValue(value, sourceType))
+			if err != nil {
+				return fmt.Errorf("column %s: %w", col.Name, err)
+			}
+			if i > 0 {
+				s.writer.WriteByte(',')
+			}
+			s.writer.Write(s.keys[i])
+			s.writer.WriteByte(':')
+			s.writer.Write(encoded)
+		}
+		s.writer.WriteByte('}')
+		if err := s.writer.WriteByte('\n'); err != nil {
+			return fmt.Errorf("failed to write record: %w", err)
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered data to the file
+func (s *ndjsonRecordSink) Flush() error {
+	return s.writer.Flush()
+}
+
+// Close flushes the encoder and closes the underlying file
+func (s *ndjsonRecordSink) Close() error {
+	err := s.encoder.Close()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// toJSONValue converts a ClickHouse value of chType into something encoding/json writes faithfully
+func toJSONValue(value interface{}, chType string) interface{} {
+	switch v := value.(type) {
+	case string:
+		// Decimals parsed from text stay strings until here so no precision is lost
+		if base, _ := unwrapType(chType); strings.HasPrefix(base, "Decimal") && isPlainDecimal(v) {
+			return json.Number(v)
+		}
+		return v
+	case nil, bool, json.Number, *jsonObject:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return formatTimeValue(v, chType)
+	case decimal.Decimal:
+		return json.Number(v.String())
+	case float32:
+		return jsonFloat(float64(v))
+	case float64:
+		return jsonFloat(v)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	base, _ := unwrapType(chType)
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return toJSONValue(rv.Elem().Interface(), chType)
+	case reflect.Slice, reflect.Array:
+		elemType := ""
+		if strings.HasPrefix(base, "Array(") {
+			elemType = base[len("Array(") : len(base)-1]
+		}
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			items[i] = toJSONValue(rv.Index(i).Interface(), elemType)
+		}
+		return items
+	case reflect.Map:
+		// Named tuples keep their declared field order; other maps have none, so keys are sorted
+		fieldTypes := tupleFieldTypes(base)
+		obj := &jsonObject{values: make(map[string]interface{}, rv.Len())}
+		for _, key := range rv.MapKeys() {
+			name := fmt.Sprintf("%v", key.Interface())
+			obj.keys = append(obj.keys, name)
+			obj.values[name] = toJSONValue(rv.MapIndex(key).Interface(), fieldTypes[name])
+		}
+		sort.Strings(obj.keys)
+		if order := tupleFieldNames(base); len(order) == len(obj.keys) {
+			obj.keys = order
+		}
+		return obj
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// jsonFloat returns f, or nil for values JSON cannot represent
+func jsonFloat(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+	return f
+}
+
+// splitTypeArgs splits the arguments of a parameterised type such as Tuple(a String, b Int64)
+// at its top-level commas
+func splitTypeArgs(chType string) []string {
+	open := strings.IndexByte(chType, '(')
+	if open < 0 || !strings.HasSuffix(chType, ")") {
+		return nil
+	}
+
+	var args []string
+	depth, quoted, start := 0, false, open+1
+	inner := chType[:len(chType)-1]
+	for i := open + 1; i < len(inner); i++ {
+		switch c := inner[i]; {
+		case c == '`' || c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(inner[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(inner[start:]))
+}
+
+// tupleFieldNames returns the field names of a named Tuple type in order, or nil
+func tupleFieldNames(chType string) []string {
+	if !strings.HasPrefix(chType, "Tuple(") {
+		return nil
+	}
+	var names []string
+	for _, arg := range splitTypeArgs(chType) {
+		name, _, ok := strings.Cut(arg, " ")
+		if !ok {
+			return nil
+		}
+		names = append(names, strings.Trim(name, "`"))
+	}
+	return names
+}
+
+// tupleFieldTypes returns the field types of a named Tuple type keyed by field name
+func tupleFieldTypes(chType string) map[string]string {
+	types := map[string]string{}
+	if !strings.HasPrefix(chType, "Tuple(") {
+		return types
+	}
+	for _, arg := range splitTypeArgs(chType) {
+		if name, fieldType, ok := strings.Cut(arg, " "); ok {
+			types[strings.Trim(name, "`")] = strings.TrimSpace(fieldType)
+		}
+	}
+	return types
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNDJSONRoundTrip(t *testing.T) {
+	config := FlatFileConfig{FileName: filepath.Join(t.TempDir(), "rows.ndjson")}
+	schema := []Column{
+		{Name: "id", Type: "Int64"},
+		{Name: "name", Type: "String"},
+		{Name: "score", Type: "Nullable(Float64)"},
+		{Name: "tags", Type: "Array(String)"},
+	}
+	rows := [][]interface{}{
+		{int64(1), "ada", 1.5, []interface{}{"a", "b"}},
+		{int64(2), "", nil, []interface{}{}},
+	}
+	writeFlatFile(t, config, schema, rows)
+
+	gotSchema, got := readFlatFile(t, config, nil)
+	if !reflect.DeepEqual(gotSchema, schema) {
+		t.Errorf("schema = %v, want %v", gotSchema, schema)
+	}
+	if !reflect.DeepEqual(got, rows) {
+		t.Errorf("rows = %#v, want %#v", got, rows)
+	}
+}
+
+// writeNDJSON writes lines to a new NDJSON file and returns a config sampling its first sample objects
+func writeNDJSON(t *testing.T, sample int, lines ...string) FlatFileConfig {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "input.ndjson")
+	if err := os.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return FlatFileConfig{FileName: name, InferSampleSize: sample}
+}
+
+func TestNDJSONSelectedColumnOutsideSample(t *testing.T) {
+	config := writeNDJSON(t, 1, `{"a": 1}`, `{"a": 2, "late": "x"}`)
+	_, err := NewFlatFileClient(config).StreamData([]string{"a", "late"})
+	if err == nil || !strings.Contains(err.Error(), "late") {
+		t.Fatalf("StreamData error = %v, want one naming the late column", err)
+	}
+}
+
+func TestNDJSONUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		lines       []string
+		wantKeys    []string
+		wantObjects int64
+	}{
+		{
+			name:  "all keys sampled",
+			lines: []string{`{"a": 1, "b": {"x": 1}}`, `{"a": 2, "b": {"x": 2}}`},
+		},
+		{
+			name:        "top-level key after the sample",
+			lines:       []string{`{"a": 1}`, `{"a": 2}`, `{"a": 3, "late": true}`, `{"late": false, "later": 1}`},
+			wantKeys:    []string{"late", "later"},
+			wantObjects: 2,
+		},
+		{
+			name:        "flattened key after the sample",
+			lines:       []string{`{"b": {"x": 1}}`, `{"b": {"x": 2}}`, `{"b": {"x": 3, "y": 4}}`},
+			wantKeys:    []string{"b.y"},
+			wantObjects: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := writeNDJSON(t, 2, tt.lines...)
+			source, err := NewFlatFileClient(config).StreamData(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer source.Close()
+			if _, err := CopyRecords(t.Context(), source, discardSink{}); err != nil {
+				t.Fatal(err)
+			}
+
+			it := source.(*ndjsonRecordIterator)
+			if it.unknownObjects != tt.wantObjects || !reflect.DeepEqual(it.unknownKeys, tt.wantKeys) {
+				t.Errorf("unknown keys = %v in %d objects, want %v in %d", it.unknownKeys, it.unknownObjects, tt.wantKeys, tt.wantObjects)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+// defaultCSVNullToken stands for NULL in CSV files without configured tokens, as it does in
+// ClickHouse's own CSV, so NULL and empty strings stay apart
+const defaultCSVNullToken = `\N`
+
+// nullMarkers holds the field values of a text file that stand for NULL. Without tokens nothing
+// is matched: empty fields then read as NULL only in Nullable non-String columns and as empty
+// strings otherwise, and NULL is written as an empty field.
+type nullMarkers struct {
+	tokens map[string]bool
+	// write is the token written for NULL
+	write string
+}
+
+// nullMarkers returns the file's NULL tokens; the first one is written for NULL. CSV files
+// without configured tokens use defaultCSVNullToken.
+func (f *FlatFileClient) nullMarkers() nullMarkers {
+	values := f.config.NullValues
+	if len(values) == 0 && f.config.Format == FormatCSV {
+		values = []string{defaultCSVNullToken}
+	}
+	markers := nullMarkers{}
+	if len(values) == 0 {
+		return markers
+	}
+	markers.tokens = map[string]bool{}
+	for _, token := range values {
+		markers.tokens[token] = true
+	}
+	markers.write = values[0]
+	return markers
+}
+
+// match reports whether a raw field value is a NULL token
+func (n nullMarkers) match(raw string) bool {
+	return n.tokens[raw]
+}
+
+// value returns raw, or nil for a NULL token
+func (n nullMarkers) value(raw string) interface{} {
+	if n.match(raw) {
+		return nil
+	}
+	return raw
+}
+
+// format renders a value for the file, writing nil as the NULL token
+func (n nullMarkers) format(value interface{}, chType string) string {
+	if value == nil {
+		return n.write
+	}
+	return formatFlatValue(value, chType)
+}
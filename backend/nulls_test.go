@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCSVNullRoundTrip(t *testing.T) {
+	schema := []Column{{Name: "id", Type: "String"}, {Name: "note", Type: "Nullable(String)"}}
+	rows := [][]interface{}{
+		{"1", nil},
+		{"2", ""},
+		{"3", `\N`},
+		{"4", "NULL"},
+		{"5", "text"},
+	}
+	tests := []struct {
+		name   string
+		config FlatFileConfig
+		want   string
+	}{
+		{
+			name: "default token",
+			want: "id,note\n1,\\N\n2,\n3,\"\\N\"\n4,NULL\n5,text\n",
+		},
+		{
+			name:   "empty token",
+			config: FlatFileConfig{NullValues: []string{""}},
+			want:   "id,note\n1,\n2,\"\"\n3,\\N\n4,NULL\n5,text\n",
+		},
+		{
+			name:   "several tokens",
+			config: FlatFileConfig{NullValues: []string{"NULL", `\N`}},
+			want:   "id,note\n1,NULL\n2,\n3,\"\\N\"\n4,\"NULL\"\n5,text\n",
+		},
+		{
+			name:   "quote all leaves NULL unquoted",
+			config: FlatFileConfig{CSV: CSVOptions{QuoteStyle: QuoteAll}},
+			want:   "\"id\",\"note\"\n\"1\",\\N\n\"2\",\"\"\n\"3\",\"\\N\"\n\"4\",\"NULL\"\n\"5\",\"text\"\n",
+		},
+		{
+			name:   "backslash escape",
+			config: FlatFileConfig{CSV: CSVOptions{Escape: EscapeBackslash}},
+			want:   "id,note\n1,\\N\n2,\n3,\"\\\\N\"\n4,NULL\n5,text\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.FileName = filepath.Join(t.TempDir(), "nulls.csv")
+			writeFlatFile(t, config, schema, rows)
+
+			data, err := os.ReadFile(config.FileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("file = %q, want %q", data, tt.want)
+			}
+
+			_, got := readFlatFile(t, config, nil)
+			if !reflect.DeepEqual(got, rows) {
+				t.Errorf("rows = %#v, want %#v", got, rows)
+			}
+		})
+	}
+}
@@ -0,0 +1,1216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"github.com/parquet-go/parquet-go/format"
+	"github.com/shopspring/decimal"
+)
+
+// defaultParquetRowGroupSize is the number of rows written per Parquet row group
+const defaultParquetRowGroupSize = 128 * 1024
+
+// julianDayOfUnixEpoch is the Julian day number of 1970-01-01, used by legacy INT96 timestamps
+const julianDayOfUnixEpoch = 2440588
+
+// ParquetOptions controls how Parquet files are written
+type ParquetOptions struct {
+	// RowGroupSize is the number of rows per row group; 0 uses the default
+	RowGroupSize int64 `json:"rowGroupSize"`
+	// Compression is the column codec: snappy (default), gzip, zstd, lz4, brotli or none
+	Compression string `json:"compression"`
+}
+
+// parquetCodec returns the codec named by a compression option
+func parquetCodec(name string) (compress.Codec, error) {
+	switch strings.ToLower(name) {
+	case "", "snappy":
+		return &parquet.Snappy, nil
+	case "gzip":
+		return &parquet.Gzip, nil
+	case "zstd":
+		return &parquet.Zstd, nil
+	case "lz4":
+		return &parquet.Lz4Raw, nil
+	case "brotli":
+		return &parquet.Brotli, nil
+	case "none", "uncompressed":
+		return &parquet.Uncompressed, nil
+	}
+	return nil, fmt.Errorf("unsupported Parquet compression: %q", name)
+}
+
+// openParquetFile opens the configured file for reading, decompressing it first if needed
+func (f *FlatFileClient) openParquetFile() (io.Closer, *parquet.File, error) {
+	file, closer, size, err := f.openRandomAccess()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pf, err := parquet.OpenFile(file, size)
+	if err != nil {
+		closer.Close()
+		return nil, nil, fmt.Errorf("failed to read Parquet file: %w", err)
+	}
+	return closer, pf, nil
+}
+
+// parquetSchema returns the top-level columns of a Parquet file with their ClickHouse types
+func (f *FlatFileClient) parquetSchema() ([]Column, error) {
+	file, pf, err := f.openParquetFile()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var columns []Column
+	for _, field := range pf.Schema().Fields() {
+		root, err := newParquetReadField(field)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, Column{Name: field.Name(), Type: root.chType})
+	}
+	return columns, nil
+}
+
+// parquetReadColumn converts the values of one Parquet leaf column into ClickHouse values
+type parquetReadColumn struct {
+	chType  string
+	convert func(parquet.Value) interface{}
+}
+
+// parquetNodeKind is the shape of a parquetReadNode
+type parquetNodeKind int
+
+const (
+	parquetLeafNode parquetNodeKind = iota
+	parquetListNode
+	parquetMapNode
+	parquetTupleNode
+)
+
+// parquetReadNode converts the leaf values of a Parquet field back into a ClickHouse value:
+// a leaf's own value, an Array for a LIST or repeated field, a Map for a MAP and a named Tuple
+// for any other group
+type parquetReadNode struct {
+	parquetReadColumn
+	kind parquetNodeKind
+	// def is the definition level at which the node holds a value, or for lists and maps at
+	// least one entry; rep is the repetition level of the entries of lists and maps
+	def, rep int
+	// first and leaves locate the node's leaf columns among those of its top-level field
+	first, leaves int
+	children      []*parquetReadNode
+	// names are the field names of a tuple
+	names []string
+}
+
+// newParquetReadField maps a top-level Parquet field onto a ClickHouse type
+func newParquetReadField(field parquet.Field) (*parquetReadNode, error) {
+	leaves := 0
+	return newParquetReadNode(field, field.Name(), 0, 0, &leaves)
+}
+
+// newParquetReadNode maps a Parquet node below column name onto a ClickHouse type. def and rep
+// are the levels of its parent and leaves counts the leaf columns already mapped.
+func newParquetReadNode(field parquet.Node, name string, def, rep int, leaves *int) (*parquetReadNode, error) {
+	if field.Repeated() {
+		// A repeated field without a LIST around it is a list of its values
+		element, err := newParquetReadNode(parquet.Required(field), name, def+1, rep+1, leaves)
+		if err != nil {
+			return nil, err
+		}
+		return parquetContainerNode(parquetListNode, fmt.Sprintf("Array(%s)", element.chType), def+1, rep+1, element), nil
+	}
+
+	if field.Optional() {
+		def++
+	}
+	if field.Leaf() {
+		col := parquetReadColumnOf(field.Type())
+		if field.Optional() {
+			col.chType = nullableType(col.chType)
+		}
+		*leaves++
+		return &parquetReadNode{parquetReadColumn: col, def: def, first: *leaves - 1, leaves: 1}, nil
+	}
+
+	unsupported := fmt.Errorf("column %s has a nested Parquet layout that is not supported", name)
+	fields := field.Fields()
+	lt := field.Type().LogicalType()
+	switch {
+	case lt != nil && lt.List != nil:
+		if len(fields) != 1 || !fields[0].Repeated() {
+			return nil, unsupported
+		}
+		// The repeated group usually wraps the element; in older files it is the element itself
+		var elementNode parquet.Node = parquet.Required(fields[0])
+		if !fields[0].Leaf() && len(fields[0].Fields()) == 1 {
+			elementNode = fields[0].Fields()[0]
+		}
+		element, err := newParquetReadNode(elementNode, name, def+1, rep+1, leaves)
+		if err != nil {
+			return nil, err
+		}
+		return parquetContainerNode(parquetListNode, fmt.Sprintf("Array(%s)", element.chType), def+1, rep+1, element), nil
+
+	case lt != nil && lt.Map != nil:
+		if len(fields) != 1 || !fields[0].Repeated() || fields[0].Leaf() || len(fields[0].Fields()) != 2 {
+			return nil, unsupported
+		}
+		entry := fields[0].Fields()
+		key, err := newParquetReadNode(entry[0], name, def+1, rep+1, leaves)
+		if err != nil {
+			return nil, err
+		}
+		value, err := newParquetReadNode(entry[1], name, def+1, rep+1, leaves)
+		if err != nil {
+			return nil, err
+		}
+		return parquetContainerNode(parquetMapNode, fmt.Sprintf("Map(%s, %s)", key.chType, value.chType), def+1, rep+1, key, value), nil
+
+	case len(fields) > 0:
+		node := &parquetReadNode{kind: parquetTupleNode, def: def, first: *leaves}
+		types := make([]string, len(fields))
+		for i, f := range fields {
+			child, err := newParquetReadNode(f, name, def, rep, leaves)
+			if err != nil {
+				return nil, err
+			}
+			node.children = append(node.children, child)
+			node.names = append(node.names, f.Name())
+			types[i] = fmt.Sprintf("%s %s", quoteIdentifier(f.Name()), child.chType)
+		}
+		node.chType = fmt.Sprintf("Tuple(%s)", strings.Join(types, ", "))
+		node.leaves = *leaves - node.first
+		return node, nil
+	}
+	return nil, unsupported
+}
+
+// parquetContainerNode returns a list or map node over children. ClickHouse has no Nullable
+// arrays or maps, so a NULL one reads as empty.
+func parquetContainerNode(kind parquetNodeKind, chType string, def, rep int, children ...*parquetReadNode) *parquetReadNode {
+	last := children[len(children)-1]
+	return &parquetReadNode{
+		parquetReadColumn: parquetReadColumn{chType: chType},
+		kind:              kind,
+		def:               def,
+		rep:               rep,
+		first:             children[0].first,
+		leaves:            last.first + last.leaves - children[0].first,
+		children:          children,
+	}
+}
+
+// parquetLeafValue is a converted leaf value with its levels
+type parquetLeafValue struct {
+	value    interface{}
+	rep, def int
+}
+
+// parquetRowCursor walks the leaf values of a nested field, one per leaf column
+type parquetRowCursor struct {
+	values [][]parquetLeafValue
+	pos    []int
+}
+
+// continues reports whether the next value of a leaf is another entry of a list or map whose
+// entries repeat at rep
+func (c *parquetRowCursor) continues(leaf, rep int) bool {
+	return c.pos[leaf] < len(c.values[leaf]) && c.values[leaf][c.pos[leaf]].rep == rep
+}
+
+// assemble builds the node's value from the leaf values at the cursor, moving past them
+func (n *parquetReadNode) assemble(c *parquetRowCursor) interface{} {
+	if c.values[n.first][c.pos[n.first]].def < n.def {
+		// A NULL or empty node has exactly one value in each of its leaves
+		for leaf := n.first; leaf < n.first+n.leaves; leaf++ {
+			c.pos[leaf]++
+		}
+		switch n.kind {
+		case parquetListNode:
+			return []interface{}{}
+		case parquetMapNode:
+			return map[string]interface{}{}
+		}
+		return nil
+	}
+
+	switch n.kind {
+	case parquetListNode:
+		var items []interface{}
+		for {
+			items = append(items, n.children[0].assemble(c))
+			if !c.continues(n.first, n.rep) {
+				return items
+			}
+		}
+	case parquetMapNode:
+		entries := map[string]interface{}{}
+		for {
+			key := n.children[0].assemble(c)
+			entries[formatFlatValue(key, "")] = n.children[1].assemble(c)
+			if !c.continues(n.first, n.rep) {
+				return entries
+			}
+		}
+	case parquetTupleNode:
+		tuple := make(map[string]interface{}, len(n.children))
+		for i, child := range n.children {
+			tuple[n.names[i]] = child.assemble(c)
+		}
+		return tuple
+	}
+	value := c.values[n.first][c.pos[n.first]].value
+	c.pos[n.first]++
+	return value
+}
+
+// leafNodes returns the leaves under the node in column order
+func (n *parquetReadNode) leafNodes() []*parquetReadNode {
+	if n.kind == parquetLeafNode {
+		return []*parquetReadNode{n}
+	}
+	var leaves []*parquetReadNode
+	for _, child := range n.children {
+		leaves = append(leaves, child.leafNodes()...)
+	}
+	return leaves
+}
+
+// parquetReadColumnOf picks the ClickHouse type for a Parquet type, preferring its logical type
+func parquetReadColumnOf(t parquet.Type) parquetReadColumn {
+	if lt := t.LogicalType(); lt != nil {
+		switch {
+		case lt.UTF8 != nil, lt.Enum != nil, lt.Json != nil:
+			return parquetReadColumn{chType: "String", convert: func(v parquet.Value) interface{} {
+				return string(v.ByteArray())
+			}}
+		case lt.UUID != nil:
+			return parquetReadColumn{chType: "UUID", convert: func(v parquet.Value) interface{} {
+				id, err := uuid.FromBytes(v.ByteArray())
+				if err != nil {
+					return nil
+				}
+				return id
+			}}
+		case lt.Date != nil:
+			return parquetReadColumn{chType: "Date32", convert: func(v parquet.Value) interface{} {
+				return time.Unix(int64(v.Int32())*86400, 0).UTC()
+			}}
+		case lt.Timestamp != nil:
+			return parquetTimestampColumn(lt.Timestamp.Unit)
+		case lt.Decimal != nil:
+			return parquetDecimalColumn(t, int(lt.Decimal.Precision), int(lt.Decimal.Scale))
+		case lt.Integer != nil:
+			return parquetIntegerColumn(int(lt.Integer.BitWidth), lt.Integer.IsSigned)
+		}
+	}
+
+	switch t.Kind() {
+	case parquet.Boolean:
+		return parquetReadColumn{chType: "Bool", convert: func(v parquet.Value) interface{} { return v.Boolean() }}
+	case parquet.Int32:
+		return parquetIntegerColumn(32, true)
+	case parquet.Int64:
+		return parquetIntegerColumn(64, true)
+	case parquet.Int96:
+		// Legacy timestamps: nanoseconds within the day followed by the Julian day
+		return parquetReadColumn{chType: "DateTime64(9)", convert: func(v parquet.Value) interface{} {
+			i := v.Int96()
+			nanos := int64(uint64(i[1])<<32 | uint64(i[0]))
+			days := int64(i[2]) - julianDayOfUnixEpoch
+			return time.Unix(days*86400, nanos).UTC()
+		}}
+	case parquet.Float:
+		return parquetReadColumn{chType: "Float32", convert: func(v parquet.Value) interface{} { return v.Float() }}
+	case parquet.Double:
+		return parquetReadColumn{chType: "Float64", convert: func(v parquet.Value) interface{} { return v.Double() }}
+	case parquet.FixedLenByteArray:
+		return parquetReadColumn{chType: fmt.Sprintf("FixedString(%d)", t.Length()), convert: func(v parquet.Value) interface{} {
+			return string(v.ByteArray())
+		}}
+	default:
+		return parquetReadColumn{chType: "String", convert: func(v parquet.Value) interface{} {
+			return string(v.ByteArray())
+		}}
+	}
+}
+
+// parquetIntegerColumn reads an INT32 or INT64 column as a sized ClickHouse integer
+func parquetIntegerColumn(bits int, signed bool) parquetReadColumn {
+	if signed {
+		return parquetReadColumn{chType: fmt.Sprintf("Int%d", bits), convert: func(v parquet.Value) interface{} {
+			if bits == 64 {
+				return v.Int64()
+			}
+			return sizedInt(int64(v.Int32()), bits)
+		}}
+	}
+	return parquetReadColumn{chType: fmt.Sprintf("UInt%d", bits), convert: func(v parquet.Value) interface{} {
+		if bits == 64 {
+			return v.Uint64()
+		}
+		return sizedUint(uint64(v.Uint32()), bits)
+	}}
+}
+
+// parquetTimestampColumn reads an INT64 timestamp as DateTime64 with the unit's precision
+func parquetTimestampColumn(unit format.TimeUnit) parquetReadColumn {
+	switch {
+	case unit.Millis != nil:
+		return parquetReadColumn{chType: "DateTime64(3)", convert: func(v parquet.Value) interface{} {
+			return time.UnixMilli(v.Int64()).UTC()
+		}}
+	case unit.Micros != nil:
+		return parquetReadColumn{chType: "DateTime64(6)", convert: func(v parquet.Value) interface{} {
+			return time.UnixMicro(v.Int64()).UTC()
+		}}
+	default:
+		return parquetReadColumn{chType: "DateTime64(9)", convert: func(v parquet.Value) interface{} {
+			return time.Unix(0, v.Int64()).UTC()
+		}}
+	}
+}
+
+// parquetDecimalColumn reads an unscaled decimal stored as an integer or big-endian bytes
+func parquetDecimalColumn(t parquet.Type, precision, scale int) parquetReadColumn {
+	return parquetReadColumn{chType: fmt.Sprintf("Decimal(%d, %d)", precision, scale), convert: func(v parquet.Value) interface{} {
+		switch t.Kind() {
+		case parquet.Int32:
+			return decimal.New(int64(v.Int32()), int32(-scale))
+		case parquet.Int64:
+			return decimal.New(v.Int64(), int32(-scale))
+		default:
+			return decimal.NewFromBigInt(twosComplementInt(v.ByteArray()), int32(-scale))
+		}
+	}}
+}
+
+// twosComplementInt decodes a big-endian two's complement integer
+func twosComplementInt(b []byte) *big.Int {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return n
+}
+
+// parquetReadField reads the values of one top-level field from the current row group
+type parquetReadField struct {
+	root *parquetReadNode
+	// columns holds the file's leaf column index of each of root's leaves
+	columns []int
+	leaves  []*parquetReadNode
+	readers []parquet.ColumnChunkValueReader
+	buffer  []parquet.Value
+	// Nested fields keep the values read but not yet assembled, the number of rows begun in
+	// them and whether each leaf column is read to its end
+	cursor parquetRowCursor
+	starts []int
+	eof    []bool
+}
+
+// newParquetReadFieldAt returns a reader for the field whose first leaf column is first
+func newParquetReadFieldAt(root *parquetReadNode, first int) *parquetReadField {
+	f := &parquetReadField{root: root, leaves: root.leafNodes(), buffer: make([]parquet.Value, defaultRecordBatchRows)}
+	for i := range f.leaves {
+		f.columns = append(f.columns, first+i)
+	}
+	f.cursor.values = make([][]parquetLeafValue, len(f.leaves))
+	f.cursor.pos = make([]int, len(f.leaves))
+	f.starts = make([]int, len(f.leaves))
+	f.eof = make([]bool, len(f.leaves))
+	return f
+}
+
+// open positions the readers at the start of a row group
+func (f *parquetReadField) open(chunks []parquet.ColumnChunk) {
+	f.readers = make([]parquet.ColumnChunkValueReader, len(f.columns))
+	for i, column := range f.columns {
+		f.readers[i] = parquet.NewColumnChunkValueReader(chunks[column])
+		f.cursor.values[i], f.cursor.pos[i], f.starts[i], f.eof[i] = f.cursor.values[i][:0], 0, 0, false
+	}
+}
+
+func (f *parquetReadField) close() {
+	for _, reader := range f.readers {
+		reader.Close()
+	}
+	f.readers = nil
+}
+
+// read reads up to len(out) rows of the field into out, stopping early only at the end of
+// the row group
+func (f *parquetReadField) read(out []interface{}) (int, error) {
+	if f.root.kind == parquetLeafNode {
+		return f.readFlat(out)
+	}
+	return f.readNested(out)
+}
+
+// readFlat reads a column with one value per row. Values are converted as soon as they are
+// read since their bytes are only valid until the reader moves to the next page.
+func (f *parquetReadField) readFlat(out []interface{}) (int, error) {
+	n := 0
+	for n < len(out) {
+		k, err := f.readers[0].ReadValues(f.buffer[:min(len(f.buffer), len(out)-n)])
+		for _, v := range f.buffer[:k] {
+			out[n] = nil
+			if !v.IsNull() {
+				out[n] = f.root.convert(v)
+			}
+			n++
+		}
+		if err == io.EOF || (err == nil && k == 0) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readNested reads the leaf columns of a nested field until a row past the ones requested has
+// begun in each, so every row assembled is complete
+func (f *parquetReadField) readNested(out []interface{}) (int, error) {
+	for i, reader := range f.readers {
+		for !f.eof[i] && f.starts[i] <= len(out) {
+			k, err := reader.ReadValues(f.buffer)
+			for _, v := range f.buffer[:k] {
+				value := parquetLeafValue{rep: v.RepetitionLevel(), def: v.DefinitionLevel()}
+				if !v.IsNull() {
+					value.value = f.leaves[i].convert(v)
+				}
+				if value.rep == 0 {
+					f.starts[i]++
+				}
+				f.cursor.values[i] = append(f.cursor.values[i], value)
+			}
+			if err == io.EOF || (err == nil && k == 0) {
+				f.eof[i] = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	rows := len(out)
+	for i := range f.readers {
+		complete := f.starts[i]
+		if !f.eof[i] {
+			complete--
+		}
+		rows = min(rows, complete)
+	}
+	for r := 0; r < rows; r++ {
+		out[r] = f.root.assemble(&f.cursor)
+	}
+	for i := range f.readers {
+		f.cursor.values[i] = append(f.cursor.values[i][:0], f.cursor.values[i][f.cursor.pos[i]:]...)
+		f.cursor.pos[i] = 0
+		f.starts[i] -= rows
+	}
+	return rows, nil
+}
+
+// parquetRecordIterator streams a Parquet file's row groups as record batches.
+// Only the column chunks of the selected columns are read.
+type parquetRecordIterator struct {
+	file   io.Closer
+	pf     *parquet.File
+	schema []Column
+	fields []*parquetReadField
+	// rowGroup is the index of the row group the readers are positioned in, once open
+	rowGroup int
+	open     bool
+	values   [][]interface{}
+}
+
+// Schema returns the selected columns in selection order
+func (it *parquetRecordIterator) Schema() []Column {
+	return it.schema
+}
+
+// Next reads up to defaultRecordBatchRows rows from the current row group, moving on to the
+// next row group once it is exhausted
+func (it *parquetRecordIterator) Next() (*RecordBatch, error) {
+	for {
+		if !it.open {
+			if it.rowGroup >= len(it.pf.RowGroups()) {
+				return nil, io.EOF
+			}
+			chunks := it.pf.RowGroups()[it.rowGroup].ColumnChunks()
+			for _, field := range it.fields {
+				field.open(chunks)
+			}
+			it.open = true
+		}
+
+		rows := -1
+		for i, field := range it.fields {
+			n, err := field.read(it.values[i])
+			if err != nil {
+				return nil, fmt.Errorf("failed to read column %s: %w", it.schema[i].Name, err)
+			}
+			if rows == -1 || n < rows {
+				rows = n
+			}
+		}
+
+		if rows <= 0 {
+			it.closeReaders()
+			it.rowGroup++
+			continue
+		}
+
+		batch := NewRecordBatch(it.schema, rows)
+		for r := 0; r < rows; r++ {
+			values := make([]interface{}, len(it.fields))
+			for i := range it.fields {
+				values[i] = it.values[i][r]
+			}
+			batch.Append(values)
+		}
+		return batch, nil
+	}
+}
+
+func (it *parquetRecordIterator) closeReaders() {
+	for _, field := range it.fields {
+		field.close()
+	}
+	it.open = false
+}
+
+// Close releases the column readers and the underlying file
+func (it *parquetRecordIterator) Close() error {
+	it.closeReaders()
+	return it.file.Close()
+}
+
+// parquetLeafCount returns the number of leaf columns under a node
+func parquetLeafCount(node parquet.Node) int {
+	if node.Leaf() {
+		return 1
+	}
+	n := 0
+	for _, field := range node.Fields() {
+		n += parquetLeafCount(field)
+	}
+	return n
+}
+
+// streamParquet returns an iterator over the selected columns of a Parquet file, in the order
+// they were selected. Selected columns missing from the file are skipped.
+func (f *FlatFileClient) streamParquet(selectedColumns []string) (RecordIterator, error) {
+	file, pf, err := f.openParquetFile()
+	if err != nil {
+		return nil, err
+	}
+	if f.progress != nil {
+		f.progress.SetTotalRows(pf.NumRows())
+	}
+
+	fields := pf.Schema().Fields()
+	// If no columns specified, select all
+	if len(selectedColumns) == 0 {
+		for _, field := range fields {
+			selectedColumns = append(selectedColumns, field.Name())
+		}
+	}
+
+	// Leaf columns are numbered depth first, so each field's start after the leaves before it
+	firstLeaf := make(map[string]int, len(fields))
+	leaves := 0
+	for _, field := range fields {
+		firstLeaf[field.Name()] = leaves
+		leaves += parquetLeafCount(field)
+	}
+
+	it := &parquetRecordIterator{file: file, pf: pf}
+	for _, name := range selectedColumns {
+		for _, field := range fields {
+			if field.Name() != name {
+				continue
+			}
+			root, err := newParquetReadField(field)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			it.schema = append(it.schema, Column{Name: name, Type: root.chType})
+			it.fields = append(it.fields, newParquetReadFieldAt(root, firstLeaf[name]))
+			it.values = append(it.values, make([]interface{}, defaultRecordBatchRows))
+			break
+		}
+	}
+	if len(it.schema) == 0 {
+		file.Close()
+		return nil, fmt.Errorf("none of the selected columns are in %s", f.config.FileName)
+	}
+
+	return it, nil
+}
+
+// parquetWriteColumn converts ClickHouse values into the values of a Parquet field's leaf
+// columns. Leaves convert each value; nested fields write their leaves themselves.
+type parquetWriteColumn struct {
+	node    parquet.Node
+	convert func(interface{}) (parquet.Value, error)
+	// leaves is the number of leaf columns under node, set with write
+	leaves int
+	// write appends the leaf values of v to columns, one slice per leaf column. rep is the
+	// repetition level of v's first value, def the definition level of v's parent and depth
+	// the number of repeated fields above v.
+	write func(columns [][]parquet.Value, v interface{}, rep, def, depth int) error
+}
+
+// leafCount returns the number of leaf columns the column writes
+func (c parquetWriteColumn) leafCount() int {
+	if c.write == nil {
+		return 1
+	}
+	return c.leaves
+}
+
+// writeValue appends the leaf values of v to columns
+func (c parquetWriteColumn) writeValue(columns [][]parquet.Value, v interface{}, rep, def, depth int) error {
+	if c.write != nil {
+		return c.write(columns, v, rep, def, depth)
+	}
+	if v == nil {
+		return fmt.Errorf("NULL in a non-Nullable column")
+	}
+	pv, err := c.convert(v)
+	if err != nil {
+		return err
+	}
+	columns[0] = append(columns[0], pv.Level(rep, def, 0))
+	return nil
+}
+
+// appendParquetNulls marks a NULL, or an empty array or map, with one value in each leaf column
+func appendParquetNulls(columns [][]parquet.Value, rep, def int) {
+	for i := range columns {
+		columns[i] = append(columns[i], parquet.NullValue().Level(rep, def, 0))
+	}
+}
+
+// decimalTypePattern matches Decimal(P, S) and the DecimalN(S) shorthands
+var decimalTypePattern = regexp.MustCompile(`^Decimal(32|64|128|256)?\((\d+)(?:,\s*(\d+))?\)$`)
+
+// newParquetWriteColumn maps a ClickHouse type onto a Parquet logical type
+func newParquetWriteColumn(chType string) parquetWriteColumn {
+	base, nullable := unwrapType(chType)
+	col := parquetWriteColumnOf(base)
+	if !nullable {
+		return col
+	}
+	leaves := col.leafCount()
+	return parquetWriteColumn{node: parquet.Optional(col.node), leaves: leaves, write: func(columns [][]parquet.Value, v interface{}, rep, def, depth int) error {
+		if v == nil {
+			appendParquetNulls(columns[:leaves], rep, def)
+			return nil
+		}
+		return col.writeValue(columns, v, rep, def+1, depth)
+	}}
+}
+
+// parquetListColumn writes arrays as LIST fields of element
+func parquetListColumn(element parquetWriteColumn) parquetWriteColumn {
+	leaves := element.leafCount()
+	return parquetWriteColumn{node: parquet.List(element.node), leaves: leaves, write: func(columns [][]parquet.Value, v interface{}, rep, def, depth int) error {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return fmt.Errorf("expected an array, got %T", v)
+		}
+		if rv.Len() == 0 {
+			appendParquetNulls(columns[:leaves], rep, def)
+			return nil
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if i > 0 {
+				rep = depth + 1
+			}
+			if err := element.writeValue(columns, rv.Index(i).Interface(), rep, def+1, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+}
+
+// parquetMapColumn writes maps as MAP fields, their entries ordered by key
+func parquetMapColumn(key, value parquetWriteColumn) parquetWriteColumn {
+	keyLeaves := key.leafCount()
+	leaves := keyLeaves + value.leafCount()
+	return parquetWriteColumn{node: parquet.Map(key.node, value.node), leaves: leaves, write: func(columns [][]parquet.Value, v interface{}, rep, def, depth int) error {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Map {
+			return fmt.Errorf("expected a map, got %T", v)
+		}
+		if rv.Len() == 0 {
+			appendParquetNulls(columns[:leaves], rep, def)
+			return nil
+		}
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface()) })
+		for i, k := range keys {
+			if i > 0 {
+				rep = depth + 1
+			}
+			if err := key.writeValue(columns[:keyLeaves], k.Interface(), rep, def+1, depth+1); err != nil {
+				return err
+			}
+			if err := value.writeValue(columns[keyLeaves:], rv.MapIndex(k).Interface(), rep, def+1, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+}
+
+// parquetTupleColumn writes named tuples, given as maps by field name or as slices in field
+// order, as groups of their fields
+func parquetTupleColumn(names []string, fields []parquetWriteColumn) parquetWriteColumn {
+	group := parquet.Group{}
+	nodes := make([]parquet.Field, len(fields))
+	offsets := make([]int, len(fields)+1)
+	for i, field := range fields {
+		group[names[i]] = field.node
+		nodes[i] = parquetField{Node: field.node, name: names[i]}
+		offsets[i+1] = offsets[i] + field.leafCount()
+	}
+	node := orderedGroup{Group: group, fields: nodes}
+	return parquetWriteColumn{node: node, leaves: offsets[len(fields)], write: func(columns [][]parquet.Value, v interface{}, rep, def, depth int) error {
+		rv := reflect.ValueOf(v)
+		for i, field := range fields {
+			var value interface{}
+			switch rv.Kind() {
+			case reflect.Map:
+				if fv := rv.MapIndex(reflect.ValueOf(names[i])); fv.IsValid() {
+					value = fv.Interface()
+				}
+			case reflect.Slice, reflect.Array:
+				if i < rv.Len() {
+					value = rv.Index(i).Interface()
+				}
+			default:
+				return fmt.Errorf("expected a tuple, got %T", v)
+			}
+			if err := field.writeValue(columns[offsets[i]:offsets[i+1]], value, rep, def, depth); err != nil {
+				return fmt.Errorf("%s: %w", names[i], err)
+			}
+		}
+		return nil
+	}}
+}
+
+// parquetWriteColumnOf picks the Parquet type for a ClickHouse base type; types without a
+// Parquet counterpart are written as strings
+func parquetWriteColumnOf(base string) parquetWriteColumn {
+	switch {
+	case base == "Bool":
+		return parquetWriteColumn{node: parquet.Leaf(parquet.BooleanType), convert: func(v interface{}) (parquet.Value, error) {
+			b, ok := v.(bool)
+			if !ok {
+				n, err := toInt64(v)
+				if err != nil {
+					return parquet.Value{}, err
+				}
+				b = n != 0
+			}
+			return parquet.BooleanValue(b), nil
+		}}
+	case base == "Int8" || base == "Int16" || base == "Int32" || base == "Int64" ||
+		base == "UInt8" || base == "UInt16" || base == "UInt32" || base == "UInt64":
+		signed := strings.HasPrefix(base, "Int")
+		bits, _ := strconv.Atoi(strings.TrimPrefix(strings.TrimPrefix(base, "U"), "Int"))
+		node := parquet.Int(bits)
+		if !signed {
+			node = parquet.Uint(bits)
+		}
+		return parquetWriteColumn{node: node, convert: func(v interface{}) (parquet.Value, error) {
+			n, err := toInt64(v)
+			if err != nil {
+				return parquet.Value{}, err
+			}
+			if bits == 64 {
+				return parquet.Int64Value(n), nil
+			}
+			return parquet.Int32Value(int32(n)), nil
+		}}
+	case base == "Float32":
+		return parquetWriteColumn{node: parquet.Leaf(parquet.FloatType), convert: func(v interface{}) (parquet.Value, error) {
+			f, err := toFloat64(v)
+			return parquet.FloatValue(float32(f)), err
+		}}
+	case base == "Float64":
+		return parquetWriteColumn{node: parquet.Leaf(parquet.DoubleType), convert: func(v interface{}) (parquet.Value, error) {
+			f, err := toFloat64(v)
+			return parquet.DoubleValue(f), err
+		}}
+	case decimalTypePattern.MatchString(base):
+		if col, ok := parquetDecimalWriteColumn(base); ok {
+			return col
+		}
+	case base == "Date" || base == "Date32":
+		return parquetWriteColumn{node: parquet.Date(), convert: func(v interface{}) (parquet.Value, error) {
+			t, ok := v.(time.Time)
+			if !ok {
+				return parquet.Value{}, fmt.Errorf("expected a date, got %T", v)
+			}
+			y, m, d := t.Date()
+			days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400
+			return parquet.Int32Value(int32(days)), nil
+		}}
+	case base == "DateTime" || strings.HasPrefix(base, "DateTime(") || strings.HasPrefix(base, "DateTime64("):
+		return parquetTimestampWriteColumn(dateTimePrecision(base))
+	case base == "UUID":
+		return parquetWriteColumn{node: parquet.UUID(), convert: func(v interface{}) (parquet.Value, error) {
+			id, ok := v.(uuid.UUID)
+			if !ok {
+				var err error
+				if id, err = uuid.Parse(formatFlatValue(v, "UUID")); err != nil {
+					return parquet.Value{}, err
+				}
+			}
+			return parquet.FixedLenByteArrayValue(id[:]), nil
+		}}
+	}
+
+	switch {
+	case strings.HasPrefix(base, "Array(") && strings.HasSuffix(base, ")"):
+		return parquetListColumn(newParquetWriteColumn(base[len("Array(") : len(base)-1]))
+	case strings.HasPrefix(base, "Map(") && strings.HasSuffix(base, ")"):
+		if args := splitTypeArgs(base); len(args) == 2 {
+			return parquetMapColumn(newParquetWriteColumn(args[0]), newParquetWriteColumn(args[1]))
+		}
+	case tupleFieldNames(base) != nil:
+		names, types := tupleFieldNames(base), tupleFieldTypes(base)
+		fields := make([]parquetWriteColumn, len(names))
+		for i, name := range names {
+			fields[i] = newParquetWriteColumn(types[name])
+		}
+		return parquetTupleColumn(names, fields)
+	}
+
+	if isContainerType(base) {
+		// Unnamed tuples and other nested values are written as their JSON text
+		return parquetWriteColumn{node: parquet.JSON(), convert: func(v interface{}) (parquet.Value, error) {
+			switch reflect.ValueOf(v).Kind() {
+			case reflect.Slice, reflect.Array, reflect.Map:
+				data, err := json.Marshal(toJSONValue(v, base))
+				return parquet.ByteArrayValue(data), err
+			}
+			return parquet.ByteArrayValue([]byte(formatFlatValue(v, base))), nil
+		}}
+	}
+	return parquetWriteColumn{node: parquet.String(), convert: func(v interface{}) (parquet.Value, error) {
+		return parquet.ByteArrayValue([]byte(formatFlatValue(v, base))), nil
+	}}
+}
+
+// parquetTimestampWriteColumn writes times as timestamps in the smallest unit that keeps precision
+func parquetTimestampWriteColumn(precision int) parquetWriteColumn {
+	unit, toInt := parquet.Millisecond, func(t time.Time) int64 { return t.UnixMilli() }
+	switch {
+	case precision > 6:
+		unit, toInt = parquet.Nanosecond, func(t time.Time) int64 { return t.UnixNano() }
+	case precision > 3:
+		unit, toInt = parquet.Microsecond, func(t time.Time) int64 { return t.UnixMicro() }
+	}
+	return parquetWriteColumn{node: parquet.Timestamp(unit), convert: func(v interface{}) (parquet.Value, error) {
+		t, ok := v.(time.Time)
+		if !ok {
+			return parquet.Value{}, fmt.Errorf("expected a time, got %T", v)
+		}
+		return parquet.Int64Value(toInt(t)), nil
+	}}
+}
+
+// decimalPrecisionScale returns the precision and scale of a Decimal type
+func decimalPrecisionScale(base string) (precision, scale int, ok bool) {
+	m := decimalTypePattern.FindStringSubmatch(base)
+	if m == nil {
+		return 0, 0, false
+	}
+	precision, _ = strconv.Atoi(m[2])
+	scale, _ = strconv.Atoi(m[3])
+	if m[1] != "" {
+		// DecimalN(S) carries only the scale
+		scale = precision
+		precision = map[string]int{"32": 9, "64": 18, "128": 38, "256": 76}[m[1]]
+	}
+	return precision, scale, true
+}
+
+// parquetDecimalWriteColumn writes decimals as unscaled integers sized by their precision
+func parquetDecimalWriteColumn(base string) (parquetWriteColumn, bool) {
+	precision, scale, _ := decimalPrecisionScale(base)
+	if precision > 38 {
+		return parquetWriteColumn{}, false
+	}
+
+	physical := parquet.FixedLenByteArrayType(16)
+	switch {
+	case precision <= 9:
+		physical = parquet.Int32Type
+	case precision <= 18:
+		physical = parquet.Int64Type
+	}
+
+	// Unscaled values have at most precision digits, which also keeps them within the physical type
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+	return parquetWriteColumn{node: parquet.Decimal(scale, precision, physical), convert: func(v interface{}) (parquet.Value, error) {
+		d, err := toDecimal(v)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		unscaled := d.Shift(int32(scale)).Round(0).BigInt()
+		if unscaled.CmpAbs(limit) >= 0 {
+			return parquet.Value{}, fmt.Errorf("%s is out of range for %s", d, base)
+		}
+		switch physical.Kind() {
+		case parquet.Int32:
+			return parquet.Int32Value(int32(unscaled.Int64())), nil
+		case parquet.Int64:
+			return parquet.Int64Value(unscaled.Int64()), nil
+		default:
+			return parquet.FixedLenByteArrayValue(twosComplementBytes(unscaled, 16)), nil
+		}
+	}}, true
+}
+
+// twosComplementBytes encodes n as a big-endian two's complement integer of size bytes
+func twosComplementBytes(n *big.Int, size int) []byte {
+	v := new(big.Int).Set(n)
+	if v.Sign() < 0 {
+		v.Add(v, new(big.Int).Lsh(big.NewInt(1), uint(size*8)))
+	}
+	return v.FillBytes(make([]byte, size))
+}
+
+// toInt64 converts any Go integer, boolean or numeric string to an int64
+func toInt64(v interface{}) (int64, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), nil
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1, nil
+		}
+		return 0, nil
+	case reflect.String:
+		return strconv.ParseInt(strings.TrimSpace(rv.String()), 10, 64)
+	}
+	return 0, fmt.Errorf("expected an integer, got %T", v)
+}
+
+// toFloat64 converts any Go number or numeric string to a float64
+func toFloat64(v interface{}) (float64, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return strconv.ParseFloat(strings.TrimSpace(rv.String()), 64)
+	}
+	if d, ok := v.(decimal.Decimal); ok {
+		f, _ := d.Float64()
+		return f, nil
+	}
+	n, err := toInt64(v)
+	return float64(n), err
+}
+
+// toDecimal converts a decimal, number or numeric string to a decimal.Decimal
+func toDecimal(v interface{}) (decimal.Decimal, error) {
+	switch d := v.(type) {
+	case decimal.Decimal:
+		return d, nil
+	case string:
+		return decimal.NewFromString(strings.TrimSpace(d))
+	case float32:
+		return decimal.NewFromFloat32(d), nil
+	case float64:
+		return decimal.NewFromFloat(d), nil
+	}
+	n, err := toInt64(v)
+	return decimal.NewFromInt(n), err
+}
+
+// parquetField names a node inside an orderedGroup
+type parquetField struct {
+	parquet.Node
+	name string
+}
+
+func (f parquetField) Name() string { return f.name }
+
+func (f parquetField) Value(base reflect.Value) reflect.Value {
+	return base.MapIndex(reflect.ValueOf(f.name))
+}
+
+// orderedGroup is a Parquet group that keeps its fields in the given order rather than sorting
+// them by name, so written files keep the source's column order
+type orderedGroup struct {
+	parquet.Group
+	fields []parquet.Field
+}
+
+func (g orderedGroup) Fields() []parquet.Field { return g.fields }
+
+// parquetRecordSink streams record batches into a Parquet file. The Parquet schema is settled
+// on the first batch, when any column types left open can be taken from the batch.
+type parquetRecordSink struct {
+	output  io.WriteCloser
+	config  ParquetOptions
+	options FileWriterOptions
+	codec   compress.Codec
+	writer  *parquet.Writer
+	columns []Column
+	writers []parquetWriteColumn
+	// sourceIndex maps each of columns to its position in the incoming schema, or -1
+	sourceIndex []int
+	// firstLeaf is the first leaf column of each of columns, and leafValues collects a row's
+	// values per leaf column
+	firstLeaf  []int
+	leafValues [][]parquet.Value
+	rows       []parquet.Row
+}
+
+// newParquetRecordSink returns a sink writing Parquet to output, which it closes when done
+func newParquetRecordSink(output io.WriteCloser, config ParquetOptions, options FileWriterOptions) (RecordSink, error) {
+	codec, err := parquetCodec(config.Compression)
+	if err != nil {
+		output.Close()
+		return nil, err
+	}
+	if config.RowGroupSize <= 0 {
+		config.RowGroupSize = defaultParquetRowGroupSize
+	}
+
+	return &parquetRecordSink{
+		output:  output,
+		config:  config,
+		options: options,
+		codec:   codec,
+	}, nil
+}
+
+// resolveColumns builds the Parquet schema and writer from the configured columns and the
+// schema of the first batch
+func (s *parquetRecordSink) resolveColumns(schema []Column) {
+	columns := append([]Column(nil), s.options.Columns...)
+	if len(columns) == 0 {
+		columns = append(columns, schema...)
+	}
+
+	group := parquet.Group{}
+	fields := make([]parquet.Field, len(columns))
+	s.writers = make([]parquetWriteColumn, len(columns))
+	s.sourceIndex = make([]int, len(columns))
+	s.firstLeaf = make([]int, len(columns))
+	leaves := 0
+	for i := range columns {
+		s.sourceIndex[i] = columnIndex(schema, columns[i].Name)
+		if columns[i].Type == "" {
+			columns[i].Type = "Nullable(String)"
+			if idx := s.sourceIndex[i]; idx >= 0 {
+				columns[i].Type = schema[idx].Type
+			}
+		}
+		s.writers[i] = newParquetWriteColumn(columns[i].Type)
+		group[columns[i].Name] = s.writers[i].node
+		fields[i] = parquetField{Node: s.writers[i].node, name: columns[i].Name}
+		s.firstLeaf[i] = leaves
+		leaves += s.writers[i].leafCount()
+	}
+	s.leafValues = make([][]parquet.Value, leaves)
+
+	s.columns = columns
+	s.writer = parquet.NewWriter(s.output,
+		parquet.NewSchema("schema", orderedGroup{Group: group, fields: fields}),
+		parquet.Compression(s.codec),
+		parquet.MaxRowsPerRowGroup(s.config.RowGroupSize),
+	)
+}
+
+// WriteBatch converts a batch to the output column types and writes it
+func (s *parquetRecordSink) WriteBatch(batch *RecordBatch) error {
+	if s.writer == nil {
+		s.resolveColumns(batch.Schema)
+	}
+
+	s.rows = s.rows[:0]
+	for _, source := range batch.Rows {
+		for i, col := range s.columns {
+			var value interface{}
+			var sourceType string
+			if idx := s.sourceIndex[i]; idx >= 0 && idx < len(source) {
+				value, sourceType = source[idx], batch.Schema[idx].Type
+			}
+			value, err := castValue(value, sourceType, col, s.options.NullOnParseError)
+			if err != nil {
+				return err
+			}
+			if _, nullable := unwrapType(col.Type); value == nil && !nullable {
+				return fmt.Errorf("column %s: NULL in a non-Nullable column", col.Name)
+			}
+			if err := s.writers[i].writeValue(s.leafValues[s.firstLeaf[i]:], value, 0, 0, 0); err != nil {
+				return fmt.Errorf("column %s: %w", col.Name, err)
+			}
+		}
+
+		// Rows hold their values grouped by leaf column
+		row := make(parquet.Row, 0, len(s.leafValues))
+		for leaf, values := range s.leafValues {
+			for _, v := range values {
+				row = append(row, v.Level(v.RepetitionLevel(), v.DefinitionLevel(), leaf))
+			}
+			s.leafValues[leaf] = values[:0]
+		}
+		s.rows = append(s.rows, row)
+	}
+
+	if _, err := s.writer.WriteRows(s.rows); err != nil {
+		return fmt.Errorf("failed to write Parquet rows: %w", err)
+	}
+	return nil
+}
+
+// Flush writes the remaining row group and the file footer
+func (s *parquetRecordSink) Flush() error {
+	if s.writer == nil {
+		s.resolveColumns(nil)
+	}
+	if err := s.writer.Close(); err != nil {
+		return fmt.Errorf("failed to finish Parquet file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the underlying file, finishing any compressed stream
+func (s *parquetRecordSink) Close() error {
+	return s.output.Close()
+}
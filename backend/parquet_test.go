@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+func TestParquetWriterOptions(t *testing.T) {
+	schema := []Column{{Name: "id", Type: "Int64"}, {Name: "name", Type: "String"}}
+	rows := [][]interface{}{{int64(1), "a"}, {int64(2), "b"}, {int64(3), "c"}}
+	tests := []struct {
+		name          string
+		options       ParquetOptions
+		wantCodec     format.CompressionCodec
+		wantRowGroups int
+	}{
+		{name: "defaults", wantCodec: format.Snappy, wantRowGroups: 1},
+		{name: "gzip", options: ParquetOptions{Compression: "gzip"}, wantCodec: format.Gzip, wantRowGroups: 1},
+		{name: "zstd", options: ParquetOptions{Compression: "zstd"}, wantCodec: format.Zstd, wantRowGroups: 1},
+		{name: "lz4", options: ParquetOptions{Compression: "lz4"}, wantCodec: format.Lz4Raw, wantRowGroups: 1},
+		{name: "brotli", options: ParquetOptions{Compression: "brotli"}, wantCodec: format.Brotli, wantRowGroups: 1},
+		{name: "uncompressed", options: ParquetOptions{Compression: "none"}, wantCodec: format.Uncompressed, wantRowGroups: 1},
+		{name: "row group per row", options: ParquetOptions{RowGroupSize: 1}, wantCodec: format.Snappy, wantRowGroups: 3},
+		{name: "row groups of two", options: ParquetOptions{RowGroupSize: 2}, wantCodec: format.Snappy, wantRowGroups: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := FlatFileConfig{FileName: filepath.Join(t.TempDir(), "rows.parquet"), Parquet: tt.options}
+			writeFlatFile(t, config, schema, rows)
+			_, got := readFlatFile(t, config, nil)
+			assertRows(t, got, rows)
+
+			pf := openTestParquetFile(t, config.FileName)
+			if groups := len(pf.RowGroups()); groups != tt.wantRowGroups {
+				t.Errorf("wrote %d row groups, want %d", groups, tt.wantRowGroups)
+			}
+			if codec := pf.Metadata().RowGroups[0].Columns[0].MetaData.Codec; codec != tt.wantCodec {
+				t.Errorf("codec = %v, want %v", codec, tt.wantCodec)
+			}
+		})
+	}
+}
+
+func TestParquetLogicalTypes(t *testing.T) {
+	tests := []struct {
+		chType string
+		value  interface{}
+		// wantType is the type read back, wantKind the physical type of the first leaf
+		wantType string
+		wantKind parquet.Kind
+	}{
+		{"UInt16", uint16(65535), "UInt16", parquet.Int32},
+		{"Int8", int8(-128), "Int8", parquet.Int32},
+		{"Float32", float32(0.25), "Float32", parquet.Float},
+		{"Date", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), "Date32", parquet.Int32},
+		{"DateTime", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "DateTime64(3)", parquet.Int64},
+		{"DateTime64(6)", time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC), "DateTime64(6)", parquet.Int64},
+		{"DateTime64(9)", time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC), "DateTime64(9)", parquet.Int64},
+		{"Decimal(9, 2)", "1.25", "Decimal(9, 2)", parquet.Int32},
+		{"Decimal64(4)", "1.2500", "Decimal(18, 4)", parquet.Int64},
+		{"Decimal(38, 10)", "1.25", "Decimal(38, 10)", parquet.FixedLenByteArray},
+		{"UUID", uuid.MustParse("123e4567-e89b-12d3-a456-426614174000"), "UUID", parquet.FixedLenByteArray},
+		{"Nullable(String)", "x", "Nullable(String)", parquet.ByteArray},
+		{"Array(Date)", []interface{}{time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}, "Array(Date32)", parquet.Int32},
+		{"Map(String, Float64)", map[string]interface{}{"k": 1.5}, "Map(String, Float64)", parquet.ByteArray},
+	}
+	for _, tt := range tests {
+		t.Run(tt.chType, func(t *testing.T) {
+			config := FlatFileConfig{FileName: filepath.Join(t.TempDir(), "types.parquet")}
+			writeFlatFile(t, config, []Column{{Name: "v", Type: tt.chType}}, [][]interface{}{{tt.value}})
+
+			gotSchema, _ := readFlatFile(t, config, nil)
+			if gotSchema[0].Type != tt.wantType {
+				t.Errorf("read back as %s, want %s", gotSchema[0].Type, tt.wantType)
+			}
+			leaf := parquet.Node(openTestParquetFile(t, config.FileName).Schema())
+			for !leaf.Leaf() {
+				leaf = leaf.Fields()[0]
+			}
+			if kind := leaf.Type().Kind(); kind != tt.wantKind {
+				t.Errorf("stored as %v, want %v", kind, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestParquetNestedTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		column Column
+		values []interface{}
+		want   []interface{}
+	}{
+		{
+			name:   "array of nullable",
+			column: Column{Name: "v", Type: "Array(Nullable(Int64))"},
+			values: []interface{}{[]interface{}{int64(1), nil, int64(3)}, []interface{}{}, []interface{}{nil}},
+		},
+		{
+			name:   "array of arrays",
+			column: Column{Name: "v", Type: "Array(Array(String))"},
+			values: []interface{}{
+				[]interface{}{[]interface{}{"a", "b"}, []interface{}{}, []interface{}{"c"}},
+				[]interface{}{},
+				[]interface{}{[]interface{}{}},
+			},
+		},
+		{
+			name:   "map of arrays",
+			column: Column{Name: "v", Type: "Map(String, Array(Int32))"},
+			values: []interface{}{
+				map[string]interface{}{"a": []interface{}{int32(1), int32(2)}, "b": []interface{}{}},
+				map[string]interface{}{},
+			},
+		},
+		{
+			name:   "map with integer keys",
+			column: Column{Name: "v", Type: "Map(Int64, Nullable(String))"},
+			values: []interface{}{map[int64]interface{}{2: "two", 1: nil}},
+			want:   []interface{}{map[string]interface{}{"1": nil, "2": "two"}},
+		},
+		{
+			name:   "named tuple",
+			column: Column{Name: "v", Type: "Tuple(`id` Int64, `label` Nullable(String))"},
+			values: []interface{}{map[string]interface{}{"id": int64(1), "label": "x"}, []interface{}{int64(2), nil}},
+			want:   []interface{}{map[string]interface{}{"id": int64(1), "label": "x"}, map[string]interface{}{"id": int64(2), "label": nil}},
+		},
+		{
+			name:   "array of tuples",
+			column: Column{Name: "v", Type: "Array(Tuple(`k` String, `n` Float64))"},
+			values: []interface{}{
+				[]interface{}{map[string]interface{}{"k": "a", "n": 1.5}, map[string]interface{}{"k": "b", "n": 2.5}},
+				[]interface{}{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A flat column on either side checks the nested one keeps to its rows
+			schema := []Column{{Name: "before", Type: "Int64"}, tt.column, {Name: "after", Type: "String"}}
+			var rows, want [][]interface{}
+			for i, value := range tt.values {
+				rows = append(rows, []interface{}{int64(i), value, fmt.Sprint(i)})
+				if tt.want != nil {
+					value = tt.want[i]
+				}
+				want = append(want, []interface{}{int64(i), value, fmt.Sprint(i)})
+			}
+			config := FlatFileConfig{FileName: filepath.Join(t.TempDir(), "nested.parquet"), Parquet: ParquetOptions{RowGroupSize: 2}}
+			writeFlatFile(t, config, schema, rows)
+
+			gotSchema, got := readFlatFile(t, config, nil)
+			if gotSchema[1].Type != tt.column.Type {
+				t.Errorf("type = %s, want %s", gotSchema[1].Type, tt.column.Type)
+			}
+			assertRows(t, got, want)
+
+			// Selecting only the nested column reads it on its own
+			_, got = readFlatFile(t, config, []string{"v"})
+			for i := range want {
+				want[i] = want[i][1:2]
+			}
+			assertRows(t, got, want)
+		})
+	}
+}
+
+func TestParquetDecimalRange(t *testing.T) {
+	tests := []struct {
+		chType    string
+		value     string
+		wantError bool
+	}{
+		{"Decimal(9, 2)", "9999999.99", false},
+		{"Decimal(9, 2)", "-9999999.99", false},
+		{"Decimal(9, 2)", "10000000.00", true},
+		{"Decimal(18, 0)", "999999999999999999", false},
+		{"Decimal(18, 0)", "9999999999999999999", true},
+		{"Decimal(38, 2)", "-999999999999999999999999999999999999.99", false},
+		{"Decimal(38, 2)", "1000000000000000000000000000000000000.00", true},
+	}
+	for _, tt := range tests {
+		col := newParquetWriteColumn(tt.chType)
+		if _, err := col.convert(tt.value); (err != nil) != tt.wantError {
+			t.Errorf("writing %s as %s: error = %v, want an error: %v", tt.value, tt.chType, err, tt.wantError)
+		}
+	}
+}
+
+// badListNode is a group marked as a LIST without the repeated group a list needs
+type badListNode struct{ parquet.Group }
+
+func (badListNode) Type() parquet.Type { return parquet.List(parquet.String()).Type() }
+
+func TestParquetUnsupportedLayout(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "bad.parquet")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := parquet.NewSchema("schema", parquet.Group{
+		"id":  parquet.Int(64),
+		"bad": badListNode{parquet.Group{"a": parquet.String(), "b": parquet.String()}},
+	})
+	writer := parquet.NewWriter(file, schema)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	client := NewFlatFileClient(FlatFileConfig{FileName: name})
+	if _, err := client.parquetSchema(); err == nil || !strings.Contains(err.Error(), "column bad") {
+		t.Errorf("schema error = %v, want one naming column bad", err)
+	}
+	if _, err := client.streamParquet(nil); err == nil || !strings.Contains(err.Error(), "column bad") {
+		t.Errorf("stream error = %v, want one naming column bad", err)
+	}
+}
+
+func TestParquetCodec(t *testing.T) {
+	tests := []struct {
+		name      string
+		wantError bool
+	}{
+		{"", false},
+		{"SNAPPY", false},
+		{"uncompressed", false},
+		{"lzo", true},
+	}
+	for _, tt := range tests {
+		if _, err := parquetCodec(tt.name); (err != nil) != tt.wantError {
+			t.Errorf("parquetCodec(%q) error = %v, want an error: %v", tt.name, err, tt.wantError)
+		}
+	}
+}
+
+// openTestParquetFile opens the Parquet file at path for the rest of the test
+func openTestParquetFile(t *testing.T, path string) *parquet.File {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := parquet.OpenFile(file, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pf
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// RecordIterator streams record batches from a data source
+type RecordIterator interface {
+	// Schema returns the ordered, typed columns shared by every batch
+	Schema() []Column
+	// Next returns the next batch, or io.EOF once the source is exhausted
+	Next() (*RecordBatch, error)
+	Close() error
+}
+
+// RecordSink consumes record batches and writes them to a data target
+type RecordSink interface {
+	WriteBatch(batch *RecordBatch) error
+	// Flush commits any rows still buffered by the sink
+	Flush() error
+	Close() error
+}
+
+// CopyRecords streams every batch from src into dst and returns the number of rows written.
+// Only one source batch is held in memory at a time; the sink decides how much it buffers.
+func CopyRecords(ctx context.Context, src RecordIterator, dst RecordSink) (int, error) {
+	recordCount := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return recordCount, err
+		}
+
+		batch, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return recordCount, fmt.Errorf("failed to read rows: %w", err)
+		}
+
+		if err := dst.WriteBatch(batch); err != nil {
+			return recordCount, fmt.Errorf("failed to write rows: %w", err)
+		}
+		recordCount += batch.Len()
+	}
+
+	if err := dst.Flush(); err != nil {
+		return recordCount, fmt.Errorf("failed to flush rows: %w", err)
+	}
+
+	return recordCount, nil
+}
+
+// collectRows drains up to limit rows from an iterator into memory (limit <= 0 reads everything).
+// It is meant for previews and small results, not for ingestion.
+func collectRows(it RecordIterator, limit int) ([]map[string]interface{}, error) {
+	data := []map[string]interface{}{}
+	for limit <= 0 || len(data) < limit {
+		batch, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < batch.Len() && (limit <= 0 || len(data) < limit); i++ {
+			data = append(data, batch.RowMap(i))
+		}
+	}
+
+	return data, nil
+}
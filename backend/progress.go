@@ -0,0 +1,235 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressEventInterval is how often progress events are pushed to SSE clients
+const progressEventInterval = 500 * time.Millisecond
+
+// Progress tracks the live counters of a running ingestion.
+// All methods are safe for concurrent use and are no-ops on a nil *Progress.
+type Progress struct {
+	startedAt     time.Time
+	rowsRead      atomic.Int64
+	rowsWritten   atomic.Int64
+	bytesRead     atomic.Int64
+	bytesWritten  atomic.Int64
+	totalBytes    atomic.Int64
+	totalRows     atomic.Int64
+	currentBatch  atomic.Int64
+	rowsCommitted atomic.Int64
+
+	mu      sync.Mutex
+	batches []BatchResult
+	failed  int64
+}
+
+// ProgressSnapshot is a point-in-time view of a Progress
+type ProgressSnapshot struct {
+	RowsRead       int64    `json:"rowsRead"`
+	RowsWritten    int64    `json:"rowsWritten"`
+	BytesRead      int64    `json:"bytesRead"`
+	BytesWritten   int64    `json:"bytesWritten"`
+	BytesProcessed int64    `json:"bytesProcessed"`
+	TotalBytes     int64    `json:"totalBytes,omitempty"`
+	TotalRows      int64    `json:"totalRows,omitempty"`
+	CurrentBatch   int64    `json:"currentBatch"`
+	RowsCommitted  int64    `json:"rowsCommitted"`
+	BatchesFailed  int64    `json:"batchesFailed"`
+	Percent        *float64 `json:"percent,omitempty"`
+	ElapsedSeconds float64  `json:"elapsedSeconds"`
+	RowsPerSecond  float64  `json:"rowsPerSecond"`
+	ETASeconds     *float64 `json:"etaSeconds,omitempty"`
+}
+
+// NewProgress creates a progress tracker starting now
+func NewProgress() *Progress {
+	return &Progress{startedAt: time.Now()}
+}
+
+// AddRowsRead records rows read from the source
+func (p *Progress) AddRowsRead(n int64) {
+	if p != nil {
+		p.rowsRead.Add(n)
+	}
+}
+
+// AddRowsWritten records rows handed to the target
+func (p *Progress) AddRowsWritten(n int64) {
+	if p != nil {
+		p.rowsWritten.Add(n)
+	}
+}
+
+// AddBytesRead records bytes read from a source file
+func (p *Progress) AddBytesRead(n int64) {
+	if p != nil {
+		p.bytesRead.Add(n)
+	}
+}
+
+// AddBytesWritten records bytes written to a target file
+func (p *Progress) AddBytesWritten(n int64) {
+	if p != nil {
+		p.bytesWritten.Add(n)
+	}
+}
+
+// SetTotalBytes sets the expected number of source bytes, used for the ETA
+func (p *Progress) SetTotalBytes(n int64) {
+	if p != nil {
+		p.totalBytes.Store(n)
+	}
+}
+
+// SetTotalRows sets the expected number of rows, used for the ETA when the byte size is unknown
+func (p *Progress) SetTotalRows(n int64) {
+	if p != nil {
+		p.totalRows.Store(n)
+	}
+}
+
+// SetBatch records the number of the batch currently being written
+func (p *Progress) SetBatch(n int64) {
+	if p != nil {
+		p.currentBatch.Store(n)
+	}
+}
+
+// RecordBatch records the outcome of an INSERT batch
+func (p *Progress) RecordBatch(result BatchResult) {
+	if p == nil {
+		return
+	}
+	if result.Committed {
+		p.rowsCommitted.Add(int64(result.Rows))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.batches = append(p.batches, result)
+	if !result.Committed {
+		p.failed++
+	}
+}
+
+// Batches returns the outcome of every INSERT batch so far
+func (p *Progress) Batches() []BatchResult {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]BatchResult(nil), p.batches...)
+}
+
+// RowsWritten returns the number of rows handed to the target so far
+func (p *Progress) RowsWritten() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.rowsWritten.Load()
+}
+
+// Snapshot returns the current counters along with throughput and ETA estimates
+func (p *Progress) Snapshot() ProgressSnapshot {
+	if p == nil {
+		return ProgressSnapshot{}
+	}
+
+	snap := ProgressSnapshot{
+		RowsRead:       p.rowsRead.Load(),
+		RowsWritten:    p.rowsWritten.Load(),
+		BytesRead:      p.bytesRead.Load(),
+		BytesWritten:   p.bytesWritten.Load(),
+		TotalBytes:     p.totalBytes.Load(),
+		TotalRows:      p.totalRows.Load(),
+		CurrentBatch:   p.currentBatch.Load(),
+		RowsCommitted:  p.rowsCommitted.Load(),
+		ElapsedSeconds: time.Since(p.startedAt).Seconds(),
+	}
+	p.mu.Lock()
+	snap.BatchesFailed = p.failed
+	p.mu.Unlock()
+
+	snap.BytesProcessed = snap.BytesRead + snap.BytesWritten
+	if snap.ElapsedSeconds > 0 {
+		snap.RowsPerSecond = float64(snap.RowsWritten) / snap.ElapsedSeconds
+	}
+
+	// Prefer bytes for the estimate since file sizes are exact; fall back to row counts
+	done, total := snap.BytesRead, snap.TotalBytes
+	if total <= 0 {
+		done, total = snap.RowsRead, snap.TotalRows
+	}
+	if total > 0 && done > 0 {
+		if done > total {
+			done = total
+		}
+		percent := float64(done) / float64(total) * 100
+		eta := snap.ElapsedSeconds * float64(total-done) / float64(done)
+		snap.Percent = &percent
+		snap.ETASeconds = &eta
+	}
+
+	return snap
+}
+
+// countingIterator reports every row read through it
+type countingIterator struct {
+	RecordIterator
+	progress *Progress
+}
+
+// Next reads the next batch and reports its rows
+func (it countingIterator) Next() (*RecordBatch, error) {
+	batch, err := it.RecordIterator.Next()
+	if err == nil {
+		it.progress.AddRowsRead(int64(batch.Len()))
+	}
+	return batch, err
+}
+
+// countingSink reports every row written through it
+type countingSink struct {
+	RecordSink
+	progress *Progress
+}
+
+// WriteBatch writes the batch and then reports its rows
+func (s countingSink) WriteBatch(batch *RecordBatch) error {
+	if err := s.RecordSink.WriteBatch(batch); err != nil {
+		return err
+	}
+	s.progress.AddRowsWritten(int64(batch.Len()))
+	return nil
+}
+
+// countingReader reports the bytes read through it
+type countingReader struct {
+	r        io.Reader
+	progress *Progress
+}
+
+func (c countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.progress.AddBytesRead(int64(n))
+	return n, err
+}
+
+// countingWriter reports the bytes written through it
+type countingWriter struct {
+	w        io.Writer
+	progress *Progress
+}
+
+func (c countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.progress.AddBytesWritten(int64(n))
+	return n, err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressSnapshot(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(p *Progress)
+		// wantPercent is 0 when no estimate can be made
+		wantPercent float64
+		// wantETA is the remaining time as a multiple of the elapsed time
+		wantETA float64
+	}{
+		{name: "no totals", record: func(p *Progress) { p.AddRowsRead(10) }},
+		{
+			name: "bytes",
+			record: func(p *Progress) {
+				p.SetTotalBytes(400)
+				p.AddBytesRead(100)
+				p.SetTotalRows(10)
+				p.AddRowsRead(9)
+			},
+			wantPercent: 25.0,
+			wantETA:     3,
+		},
+		{
+			name: "rows without a byte total",
+			record: func(p *Progress) {
+				p.SetTotalRows(10)
+				p.AddRowsRead(8)
+			},
+			wantPercent: 80.0,
+			wantETA:     0.25,
+		},
+		{
+			name: "more read than estimated",
+			record: func(p *Progress) {
+				p.SetTotalRows(10)
+				p.AddRowsRead(12)
+			},
+			wantPercent: 100.0,
+		},
+		{name: "nothing read yet", record: func(p *Progress) { p.SetTotalRows(10) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProgress()
+			p.startedAt = time.Now().Add(-10 * time.Second)
+			tt.record(p)
+			snap := p.Snapshot()
+
+			if tt.wantPercent == 0 {
+				if snap.Percent != nil || snap.ETASeconds != nil {
+					t.Errorf("percent = %v, ETA = %v, want no estimate", snap.Percent, snap.ETASeconds)
+				}
+				return
+			}
+			if snap.Percent == nil || *snap.Percent != tt.wantPercent {
+				t.Fatalf("percent = %v, want %v", snap.Percent, tt.wantPercent)
+			}
+			if want := tt.wantETA * snap.ElapsedSeconds; snap.ETASeconds == nil || math.Abs(*snap.ETASeconds-want) > 1e-6 {
+				t.Errorf("ETA = %v, want %v", snap.ETASeconds, want)
+			}
+		})
+	}
+}
+
+func TestProgressCounters(t *testing.T) {
+	p := NewProgress()
+	p.startedAt = time.Now().Add(-2 * time.Second)
+	schema := []Column{{Name: "v", Type: "String"}}
+	batch := NewRecordBatch(schema, 3)
+	for _, v := range []string{"a", "b", "c"} {
+		batch.Append([]interface{}{v})
+	}
+
+	it := countingIterator{RecordIterator: &batchIterator{schema: schema, batches: []*RecordBatch{batch}}, progress: p}
+	for {
+		batch, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := (countingSink{RecordSink: discardSink{}, progress: p}).WriteBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := io.Copy(countingWriter{w: io.Discard, progress: p}, countingReader{r: strings.NewReader("12345"), progress: p}); err != nil {
+		t.Fatal(err)
+	}
+	p.SetBatch(2)
+	p.RecordBatch(BatchResult{Number: 1, Rows: 2, Committed: true})
+	p.RecordBatch(BatchResult{Number: 2, Rows: 1, Error: "failed"})
+
+	snap := p.Snapshot()
+	want := ProgressSnapshot{RowsRead: 3, RowsWritten: 3, BytesRead: 5, BytesWritten: 5, BytesProcessed: 10, CurrentBatch: 2, RowsCommitted: 2, BatchesFailed: 1}
+	got := snap
+	got.ElapsedSeconds, got.RowsPerSecond = 0, 0
+	if got != want {
+		t.Errorf("snapshot = %+v, want %+v", got, want)
+	}
+	if rate := snap.RowsPerSecond * snap.ElapsedSeconds; math.Abs(rate-3) > 1e-6 {
+		t.Errorf("rows per second = %v over %vs, want 3 rows in all", snap.RowsPerSecond, snap.ElapsedSeconds)
+	}
+	if batches := p.Batches(); len(batches) != 2 || batches[1].Committed {
+		t.Errorf("batches = %+v", batches)
+	}
+
+	// A nil Progress ignores updates so optional tracking needs no checks
+	var none *Progress
+	none.AddRowsRead(1)
+	none.RecordBatch(BatchResult{})
+	if snap := none.Snapshot(); snap != (ProgressSnapshot{}) || none.Batches() != nil {
+		t.Errorf("nil progress reported %+v", snap)
+	}
+	if _, err := io.Copy(countingWriter{w: &bytes.Buffer{}}, strings.NewReader("x")); err != nil {
+		t.Errorf("counting without progress: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRecordBatchRows is the number of rows sources put into each RecordBatch
+const defaultRecordBatchRows = 1024
+
+// RecordBatch is a group of rows that share an ordered, typed schema.
+// Rows[i][j] holds the value of Schema[j] in row i; nil is NULL.
+type RecordBatch struct {
+	Schema []Column
+	Rows   [][]interface{}
+}
+
+// NewRecordBatch creates an empty batch for schema with room for capacity rows
+func NewRecordBatch(schema []Column, capacity int) *RecordBatch {
+	return &RecordBatch{
+		Schema: schema,
+		Rows:   make([][]interface{}, 0, capacity),
+	}
+}
+
+// Len returns the number of rows in the batch
+func (b *RecordBatch) Len() int {
+	return len(b.Rows)
+}
+
+// Append adds a row whose values are in schema order
+func (b *RecordBatch) Append(values []interface{}) {
+	b.Rows = append(b.Rows, values)
+}
+
+// ColumnIndex returns the position of the named column in the schema, or -1
+func (b *RecordBatch) ColumnIndex(name string) int {
+	return columnIndex(b.Schema, name)
+}
+
+// RowMap returns row i keyed by column name, for JSON responses
+func (b *RecordBatch) RowMap(i int) map[string]interface{} {
+	row := make(map[string]interface{}, len(b.Schema))
+	for j, col := range b.Schema {
+		row[col.Name] = b.Rows[i][j]
+	}
+	return row
+}
+
+// columnIndex returns the position of the named column in columns, or -1
+func columnIndex(columns []Column, name string) int {
+	for i, col := range columns {
+		if col.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// columnNames returns the names of columns in order
+func columnNames(columns []Column) []string {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// stringColumns returns a schema of String columns with the given names
+func stringColumns(names []string) []Column {
+	columns := make([]Column, len(names))
+	for i, name := range names {
+		columns[i] = Column{Name: name, Type: "String"}
+	}
+	return columns
+}
+
+// castValue converts a value of sourceType to the Go type the driver expects for col. Strings from
+// text sources are parsed, typed values bound for String columns are formatted, and other typed
+// values pass through untouched. With nullOnParseError, strings that do not parse as a Nullable
+// column's type become NULL.
+func castValue(value interface{}, sourceType string, col Column, nullOnParseError bool) (interface{}, error) {
+	raw, ok := value.(string)
+	if !ok {
+		if base, _ := unwrapType(col.Type); value != nil && (base == "String" || strings.HasPrefix(base, "FixedString")) {
+			return formatFlatValue(value, sourceType), nil
+		}
+		return value, nil
+	}
+
+	converted, err := convertFlatValue(raw, col.Type)
+	if err != nil {
+		if _, nullable := unwrapType(col.Type); nullable && nullOnParseError {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("column %s: %w", col.Name, err)
+	}
+	return converted, nil
+}
+
+// formatFlatValue renders a value as text for a flat file, using chType to pick date and time layouts
+func formatFlatValue(value interface{}, chType string) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return formatTimeValue(v, chType)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// formatTimeValue renders a time in the layout ClickHouse uses for chType
+func formatTimeValue(t time.Time, chType string) string {
+	base, _ := unwrapType(chType)
+	switch {
+	case base == "Date" || base == "Date32":
+		return t.Format(dateLayout)
+	case strings.HasPrefix(base, "DateTime64("):
+		if p := dateTimePrecision(base); p > 0 {
+			return t.Format("2006-01-02 15:04:05." + strings.Repeat("0", p))
+		}
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// dateTimePrecision returns the number of fractional second digits of a DateTime64 type, or 0
+func dateTimePrecision(base string) int {
+	if !strings.HasPrefix(base, "DateTime64(") {
+		return 0
+	}
+	precision := strings.TrimSpace(strings.SplitN(strings.TrimSuffix(base[len("DateTime64("):], ")"), ",", 2)[0])
+	p, err := strconv.Atoi(precision)
+	if err != nil {
+		return 0
+	}
+	return p
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCastValue(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 600e6, time.UTC)
+	key := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+	tests := []struct {
+		name             string
+		value            interface{}
+		sourceType       string
+		column           Column
+		nullOnParseError bool
+		want             interface{}
+		wantError        string
+	}{
+		{name: "text parsed to the column type", value: "42", column: Column{Name: "n", Type: "UInt8"}, want: uint8(42)},
+		{name: "typed value passes through", value: int64(42), sourceType: "Int64", column: Column{Name: "n", Type: "Int32"}, want: int64(42)},
+		{name: "NULL passes through", value: nil, column: Column{Name: "n", Type: "Nullable(Int32)"}, want: nil},
+		{name: "integer to text", value: int64(-7), sourceType: "Int64", column: Column{Name: "s", Type: "String"}, want: "-7"},
+		{name: "date to text", value: at, sourceType: "Date", column: Column{Name: "s", Type: "Nullable(String)"}, want: "2024-01-02"},
+		{name: "time to text", value: at, sourceType: "DateTime64(3)", column: Column{Name: "s", Type: "String"}, want: "2024-01-02 03:04:05.600"},
+		{name: "UUID to fixed string", value: key, sourceType: "UUID", column: Column{Name: "s", Type: "FixedString(36)"}, want: key.String()},
+		{name: "bad text", value: "x", column: Column{Name: "n", Type: "Int64"}, wantError: "column n: invalid Int64 value"},
+		{name: "bad text in a Nullable column", value: "x", column: Column{Name: "n", Type: "Nullable(Int64)"}, wantError: "column n"},
+		{name: "bad text as NULL", value: "x", column: Column{Name: "n", Type: "Nullable(Int64)"}, nullOnParseError: true, want: nil},
+		{name: "bad text in a required column", value: "x", column: Column{Name: "n", Type: "Int64"}, nullOnParseError: true, wantError: "column n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := castValue(tt.value, tt.sourceType, tt.column, tt.nullOnParseError)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("error = %v, want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprintf("%T %v", got, got) != fmt.Sprintf("%T %v", tt.want, tt.want) {
+				t.Errorf("castValue = %T %v, want %T %v", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordBatchLookups(t *testing.T) {
+	batch := NewRecordBatch([]Column{{Name: "b", Type: "String"}, {Name: "a", Type: "Int64"}}, 1)
+	batch.Append([]interface{}{"x", int64(1)})
+	if got := batch.ColumnIndex("a"); got != 1 {
+		t.Errorf("ColumnIndex(a) = %d, want 1", got)
+	}
+	if got := batch.ColumnIndex("missing"); got != -1 {
+		t.Errorf("ColumnIndex(missing) = %d, want -1", got)
+	}
+	if got := fmt.Sprint(batch.RowMap(0)); got != "map[a:1 b:x]" {
+		t.Errorf("RowMap(0) = %s", got)
+	}
+}
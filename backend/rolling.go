@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"path/filepath"
+	"strings"
+)
+
+// SplitOptions rolls an export over into numbered part files. A part is closed once it holds
+// MaxRows rows or MaxBytes bytes on disk, whichever comes first. Bytes are counted as they reach
+// the file, so parts overshoot MaxBytes by what writers buffer: a few kilobytes for text
+// formats, more when compressed, and one row group for Parquet.
+type SplitOptions struct {
+	MaxRows  int64 `json:"maxRows"`
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// enabled reports whether any limit is set
+func (o SplitOptions) enabled() bool {
+	return o.MaxRows > 0 || o.MaxBytes > 0
+}
+
+// validate rejects limits that cannot apply to a file of format
+func (o SplitOptions) validate(format FileFormat) error {
+	if o.MaxRows < 0 || o.MaxBytes < 0 {
+		return fmt.Errorf("split limits cannot be negative")
+	}
+	if o.MaxBytes > 0 && format == FormatXLSX {
+		// Workbooks are only written out when they are closed
+		return fmt.Errorf("xlsx exports can only be split by maxRows")
+	}
+	return nil
+}
+
+// ManifestPart describes one part file of a split export
+type ManifestPart struct {
+	// FileName is relative to the manifest's directory
+	FileName string `json:"fileName"`
+	Rows     int64  `json:"rows"`
+	Bytes    int64  `json:"bytes"`
+	SHA256   string `json:"sha256"`
+}
+
+// Manifest lists the parts of a split export in order
+type Manifest struct {
+	Parts      []ManifestPart `json:"parts"`
+	TotalRows  int64          `json:"totalRows"`
+	TotalBytes int64          `json:"totalBytes"`
+}
+
+// splitPartName numbers a part before the file's extensions: "out/sales.csv.gz" becomes
+// "out/sales-00001.csv.gz"
+func splitPartName(fileName string, n int) string {
+	stem, ext := splitFileExtensions(fileName)
+	return fmt.Sprintf("%s-%05d%s", stem, n, ext)
+}
+
+// splitManifestName returns the manifest path for a split export: "out/sales.manifest.json"
+func splitManifestName(fileName string) string {
+	stem, _ := splitFileExtensions(fileName)
+	return stem + ".manifest.json"
+}
+
+// splitFileExtensions splits a path before the first dot of its base name
+func splitFileExtensions(fileName string) (string, string) {
+	dir, base := filepath.Split(fileName)
+	if i := strings.Index(base, "."); i > 0 {
+		return dir + base[:i], base[i:]
+	}
+	return fileName, ""
+}
+
+// partMeter counts and hashes the bytes written to a part file
+type partMeter struct {
+	bytes int64
+	hash  hash.Hash
+}
+
+func (m *partMeter) Write(b []byte) (int, error) {
+	m.bytes += int64(len(b))
+	return m.hash.Write(b)
+}
+
+// rollingRecordSink writes batches through a sink per part file, starting a new part when the
+// current one is full, and writes the manifest on Flush
+type rollingRecordSink struct {
+	client  *FlatFileClient
+	options FileWriterOptions
+
+	current RecordSink
+	meter   *partMeter
+	parts   []ManifestPart
+}
+
+// newRollingRecordSink opens the first part up front, so an empty export still has one
+func newRollingRecordSink(client *FlatFileClient, options FileWriterOptions) (*rollingRecordSink, error) {
+	s := &rollingRecordSink{client: client, options: options}
+	if err := s.roll(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// full reports whether the current part has reached a limit
+func (s *rollingRecordSink) full() bool {
+	limits := s.client.config.Split
+	part := s.parts[len(s.parts)-1]
+	if part.Rows == 0 {
+		return false
+	}
+	return (limits.MaxRows > 0 && part.Rows >= limits.MaxRows) || (limits.MaxBytes > 0 && s.meter.bytes >= limits.MaxBytes)
+}
+
+// roll finishes the current part, if any, and opens the next one
+func (s *rollingRecordSink) roll() error {
+	if s.current != nil {
+		if err := s.finish(); err != nil {
+			return err
+		}
+	}
+
+	config := s.client.config
+	config.FileName = splitPartName(s.client.config.FileName, len(s.parts)+1)
+	config.FileID, config.Split = "", SplitOptions{}
+	meter := &partMeter{hash: sha256.New()}
+	part := NewFlatFileClient(config)
+	part.progress = s.client.progress
+	part.tee = meter
+	part.outcome = s.client.outcome
+
+	sink, err := part.NewWriter(s.options)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", config.FileName, err)
+	}
+	s.current, s.meter = sink, meter
+	s.parts = append(s.parts, ManifestPart{FileName: filepath.Base(config.FileName)})
+	return nil
+}
+
+// finish flushes and closes the current part and records its size and checksum
+func (s *rollingRecordSink) finish() error {
+	err := s.current.Flush()
+	if cerr := s.current.Close(); err == nil {
+		err = cerr
+	}
+	s.current = nil
+	part := &s.parts[len(s.parts)-1]
+	if err != nil {
+		return fmt.Errorf("failed to finish %s: %w", part.FileName, err)
+	}
+	part.Bytes = s.meter.bytes
+	part.SHA256 = hex.EncodeToString(s.meter.hash.Sum(nil))
+	return nil
+}
+
+// WriteBatch writes the batch's rows, splitting it across parts where a row limit falls inside it
+func (s *rollingRecordSink) WriteBatch(batch *RecordBatch) error {
+	maxRows := s.client.config.Split.MaxRows
+	for start := 0; start < batch.Len(); {
+		if s.current == nil || s.full() {
+			if err := s.roll(); err != nil {
+				return err
+			}
+		}
+		part := &s.parts[len(s.parts)-1]
+
+		end := batch.Len()
+		if maxRows > 0 && int64(end-start) > maxRows-part.Rows {
+			end = start + int(maxRows-part.Rows)
+		}
+		chunk := batch
+		if start > 0 || end < batch.Len() {
+			chunk = &RecordBatch{Schema: batch.Schema, Rows: batch.Rows[start:end]}
+		}
+		if err := s.current.WriteBatch(chunk); err != nil {
+			return err
+		}
+		part.Rows += int64(end - start)
+		start = end
+	}
+	return nil
+}
+
+// Flush finishes the current part and writes the manifest of all parts so far
+func (s *rollingRecordSink) Flush() error {
+	if s.current != nil {
+		if err := s.finish(); err != nil {
+			return err
+		}
+	}
+
+	manifest := Manifest{Parts: s.parts}
+	for _, part := range s.parts {
+		manifest.TotalRows += part.Rows
+		manifest.TotalBytes += part.Bytes
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	name := splitManifestName(s.client.config.FileName)
+	if err := writeExportFile(name, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// writeExportFile writes data to name, staged like any other export file
+func writeExportFile(name string, data []byte) error {
+	file, err := createExportFile(name)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if ferr := finishExportFile(file, name, err != nil); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Close closes a part still open after a failed export; its rows are not in the manifest
+func (s *rollingRecordSink) Close() error {
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.current = nil
+	return err
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitFileNames(t *testing.T) {
+	tests := []struct {
+		fileName, part, manifest string
+	}{
+		{"out/sales.csv", "out/sales-00002.csv", "out/sales.manifest.json"},
+		{"out/sales.csv.gz", "out/sales-00002.csv.gz", "out/sales.manifest.json"},
+		{"sales", "sales-00002", "sales.manifest.json"},
+		{"v1.2/sales.parquet", "v1.2/sales-00002.parquet", "v1.2/sales.manifest.json"},
+		{".sales", ".sales-00002", ".sales.manifest.json"},
+	}
+	for _, tt := range tests {
+		if got := splitPartName(tt.fileName, 2); got != tt.part {
+			t.Errorf("splitPartName(%q, 2) = %q, want %q", tt.fileName, got, tt.part)
+		}
+		if got := splitManifestName(tt.fileName); got != tt.manifest {
+			t.Errorf("splitManifestName(%q) = %q, want %q", tt.fileName, got, tt.manifest)
+		}
+	}
+}
+
+func TestRollingRecordSink(t *testing.T) {
+	schema := []Column{{Name: "id", Type: "Int64"}, {Name: "name", Type: "String"}}
+	tests := []struct {
+		name      string
+		fileName  string
+		split     SplitOptions
+		rows      int
+		batchSize int
+		// wantRows are the rows of each part; nil when only the byte limit decides
+		wantRows []int64
+	}{
+		{name: "row limit inside batches", fileName: "out.csv", split: SplitOptions{MaxRows: 3}, rows: 10, batchSize: 4, wantRows: []int64{3, 3, 3, 1}},
+		{name: "row limit on batch boundaries", fileName: "out.csv", split: SplitOptions{MaxRows: 4}, rows: 8, batchSize: 2, wantRows: []int64{4, 4}},
+		{name: "row limit above the row count", fileName: "out.csv", split: SplitOptions{MaxRows: 100}, rows: 10, batchSize: 4, wantRows: []int64{10}},
+		{name: "empty export", fileName: "out.csv", split: SplitOptions{MaxRows: 3}, wantRows: []int64{0}},
+		{name: "compressed parts", fileName: "out.ndjson.gz", split: SplitOptions{MaxRows: 5}, rows: 12, batchSize: 5, wantRows: []int64{5, 5, 2}},
+		{name: "byte limit", fileName: "out.csv", split: SplitOptions{MaxBytes: 8 << 10}, rows: 3000, batchSize: 100},
+		{name: "row and byte limits", fileName: "out.ndjson", split: SplitOptions{MaxRows: 2, MaxBytes: 1 << 20}, rows: 5, batchSize: 5, wantRows: []int64{2, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := FlatFileConfig{FileName: filepath.Join(dir, tt.fileName), Split: tt.split}
+			var rows [][]interface{}
+			for i := 0; i < tt.rows; i++ {
+				rows = append(rows, []interface{}{int64(i), fmt.Sprintf("row %d", i)})
+			}
+
+			sink, err := NewFlatFileClient(config).NewWriter(FileWriterOptions{Columns: schema})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for start := 0; start < len(rows); start += tt.batchSize {
+				batch := NewRecordBatch(schema, tt.batchSize)
+				for _, row := range rows[start:min(start+tt.batchSize, len(rows))] {
+					batch.Append(row)
+				}
+				if err := sink.WriteBatch(batch); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sink.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(splitManifestName(config.FileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var manifest Manifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				t.Fatal(err)
+			}
+
+			var partRows []int64
+			var totalBytes int64
+			var got [][]interface{}
+			for i, part := range manifest.Parts {
+				if want := filepath.Base(splitPartName(config.FileName, i+1)); part.FileName != want {
+					t.Errorf("part %d is %s, want %s", i+1, part.FileName, want)
+				}
+				content, err := os.ReadFile(filepath.Join(dir, part.FileName))
+				if err != nil {
+					t.Fatal(err)
+				}
+				sum := sha256.Sum256(content)
+				if part.SHA256 != hex.EncodeToString(sum[:]) || part.Bytes != int64(len(content)) {
+					t.Errorf("%s: manifest has %d bytes with sha256 %s, file has %d bytes with sha256 %x",
+						part.FileName, part.Bytes, part.SHA256, len(content), sum)
+				}
+				if tt.split.MaxBytes > 0 && i < len(manifest.Parts)-1 && part.Bytes < tt.split.MaxBytes && part.Rows != tt.split.MaxRows {
+					t.Errorf("%s was closed at %d bytes, under the %d byte limit", part.FileName, part.Bytes, tt.split.MaxBytes)
+				}
+
+				partConfig := config
+				partConfig.FileName, partConfig.Split = filepath.Join(dir, part.FileName), SplitOptions{}
+				if part.Rows > 0 {
+					_, partData := readFlatFile(t, partConfig, nil)
+					if int64(len(partData)) != part.Rows {
+						t.Errorf("%s holds %d rows, manifest says %d", part.FileName, len(partData), part.Rows)
+					}
+					got = append(got, partData...)
+				}
+				partRows = append(partRows, part.Rows)
+				totalBytes += part.Bytes
+			}
+
+			if tt.wantRows != nil && !reflect.DeepEqual(partRows, tt.wantRows) {
+				t.Errorf("part rows = %v, want %v", partRows, tt.wantRows)
+			}
+			if tt.wantRows == nil && len(manifest.Parts) < 2 {
+				t.Errorf("wrote %d part, want the byte limit to split the export", len(manifest.Parts))
+			}
+			if manifest.TotalRows != int64(tt.rows) || manifest.TotalBytes != totalBytes {
+				t.Errorf("manifest totals %d rows and %d bytes, want %d and %d", manifest.TotalRows, manifest.TotalBytes, tt.rows, totalBytes)
+			}
+			if len(got) != len(rows) {
+				t.Fatalf("read %d rows back, want %d", len(got), len(rows))
+			}
+			for i, row := range got {
+				if fmt.Sprint(row) != fmt.Sprint(rows[i]) {
+					t.Errorf("row %d = %v, want %v", i, row, rows[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSplitOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		split     SplitOptions
+		format    FileFormat
+		wantError bool
+	}{
+		{"rows", SplitOptions{MaxRows: 10}, FormatCSV, false},
+		{"bytes", SplitOptions{MaxBytes: 10}, FormatParquet, false},
+		{"negative rows", SplitOptions{MaxRows: -1}, FormatCSV, true},
+		{"negative bytes", SplitOptions{MaxBytes: -1}, FormatCSV, true},
+		{"xlsx rows", SplitOptions{MaxRows: 10}, FormatXLSX, false},
+		{"xlsx bytes", SplitOptions{MaxBytes: 10}, FormatXLSX, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.split.validate(tt.format); (err != nil) != tt.wantError {
+				t.Errorf("validate = %v, want an error: %v", err, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,848 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/xuri/excelize/v2"
+)
+
+// XLSXOptions selects the part of a workbook that is read and lays out exported workbooks
+type XLSXOptions struct {
+	// Sheet is the worksheet to read or write; empty reads the active sheet and writes "Sheet1"
+	Sheet string `json:"sheet"`
+	// HeaderRow is the 1-based row holding the column names; 0 uses the first row of Range
+	HeaderRow int `json:"headerRow"`
+	// Range limits reading to a cell range such as "B2:F500" or a column span such as "B:F"
+	Range string `json:"range"`
+	// SheetPerTable exports each selected ClickHouse table to its own sheet instead of a join
+	SheetPerTable bool `json:"sheetPerTable"`
+}
+
+// xlsxRange is a block of cells with 1-based bounds; a zero last bound is open-ended
+type xlsxRange struct {
+	firstCol, lastCol int
+	firstRow, lastRow int
+}
+
+// xlsxColumnSpan matches a column-only range such as "B:F"
+var xlsxColumnSpan = regexp.MustCompile(`^([A-Za-z]+):([A-Za-z]+)$`)
+
+// parseXLSXRange parses "B2:F500", "B:F" or a single cell; empty selects the whole sheet
+func parseXLSXRange(spec string) (xlsxRange, error) {
+	r := xlsxRange{firstCol: 1, firstRow: 1}
+	spec = strings.ReplaceAll(strings.TrimSpace(spec), "$", "")
+	if spec == "" {
+		return r, nil
+	}
+
+	if m := xlsxColumnSpan.FindStringSubmatch(spec); m != nil {
+		first, err := excelize.ColumnNameToNumber(m[1])
+		if err != nil {
+			return r, fmt.Errorf("invalid range %q: %w", spec, err)
+		}
+		last, err := excelize.ColumnNameToNumber(m[2])
+		if err != nil {
+			return r, fmt.Errorf("invalid range %q: %w", spec, err)
+		}
+		r.firstCol, r.lastCol = min(first, last), max(first, last)
+		return r, nil
+	}
+
+	from, to, found := strings.Cut(spec, ":")
+	if !found {
+		to = from
+	}
+	firstCol, firstRow, err := excelize.CellNameToCoordinates(from)
+	if err != nil {
+		return r, fmt.Errorf("invalid range %q: %w", spec, err)
+	}
+	lastCol, lastRow, err := excelize.CellNameToCoordinates(to)
+	if err != nil {
+		return r, fmt.Errorf("invalid range %q: %w", spec, err)
+	}
+	r.firstCol, r.lastCol = min(firstCol, lastCol), max(firstCol, lastCol)
+	r.firstRow, r.lastRow = min(firstRow, lastRow), max(firstRow, lastRow)
+	return r, nil
+}
+
+// xlsxSheet is a worksheet loaded for reading, with the header row and data rows located
+type xlsxSheet struct {
+	book *excelize.File
+	name string
+	// rows holds the raw cell values of the whole sheet
+	rows      [][]string
+	bounds    xlsxRange
+	headerRow int
+	date1904  bool
+	// dateStyles caches whether a style index formats numbers as dates
+	dateStyles map[int]bool
+}
+
+// xlsxColumn is a worksheet column read as a ClickHouse column
+type xlsxColumn struct {
+	name   string
+	chType string
+	// index is the 1-based column number in the sheet
+	index int
+}
+
+// openXLSXSheet loads the configured worksheet of the workbook
+func (f *FlatFileClient) openXLSXSheet() (*xlsxSheet, error) {
+	bounds, err := parseXLSXRange(f.config.XLSX.Range)
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := f.openInput(false)
+	if err != nil {
+		return nil, err
+	}
+	book, err := excelize.OpenReader(input)
+	input.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workbook: %w", err)
+	}
+
+	name := f.config.XLSX.Sheet
+	if name == "" {
+		name = book.GetSheetName(book.GetActiveSheetIndex())
+	}
+	if idx, err := book.GetSheetIndex(name); err != nil || idx < 0 {
+		book.Close()
+		return nil, fmt.Errorf("sheet %q not found (sheets: %s)", name, strings.Join(book.GetSheetList(), ", "))
+	}
+
+	rows, err := book.GetRows(name, excelize.Options{RawCellValue: true})
+	if err != nil {
+		book.Close()
+		return nil, fmt.Errorf("failed to read sheet %s: %w", name, err)
+	}
+
+	sheet := &xlsxSheet{
+		book:       book,
+		name:       name,
+		rows:       rows,
+		bounds:     bounds,
+		headerRow:  bounds.firstRow,
+		dateStyles: map[int]bool{},
+	}
+	if props, err := book.GetWorkbookProps(); err == nil && props.Date1904 != nil {
+		sheet.date1904 = *props.Date1904
+	}
+
+	if !f.config.hasHeader() {
+		// Data starts at the first row of the range
+		sheet.headerRow = bounds.firstRow - 1
+	}
+	if header := f.config.XLSX.HeaderRow; header > 0 {
+		if header < bounds.firstRow || (bounds.lastRow > 0 && header > bounds.lastRow) {
+			book.Close()
+			return nil, fmt.Errorf("header row %d is outside the range %s", header, f.config.XLSX.Range)
+		}
+		sheet.headerRow = header
+	}
+	return sheet, nil
+}
+
+// Close releases the workbook
+func (s *xlsxSheet) Close() error {
+	return s.book.Close()
+}
+
+// lastCol returns the last column number read
+func (s *xlsxSheet) lastCol() int {
+	if s.bounds.lastCol > 0 {
+		return s.bounds.lastCol
+	}
+	last := 0
+	for _, row := range s.rows {
+		last = max(last, len(row))
+	}
+	return last
+}
+
+// lastRow returns the last row number read
+func (s *xlsxSheet) lastRow() int {
+	if s.bounds.lastRow > 0 {
+		return min(s.bounds.lastRow, len(s.rows))
+	}
+	return len(s.rows)
+}
+
+// raw returns the raw text of a cell given 1-based coordinates
+func (s *xlsxSheet) raw(row, col int) string {
+	if row < 1 || row > len(s.rows) || col < 1 || col > len(s.rows[row-1]) {
+		return ""
+	}
+	return s.rows[row-1][col-1]
+}
+
+// emptyRow reports whether every cell of a row within the range is blank
+func (s *xlsxSheet) emptyRow(row int, lastCol int) bool {
+	for col := s.bounds.firstCol; col <= lastCol; col++ {
+		if strings.TrimSpace(s.raw(row, col)) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// columns returns the columns named by the header row, typed from up to sampleSize data rows
+func (s *xlsxSheet) columns(sampleSize int) ([]xlsxColumn, error) {
+	lastCol := s.lastCol()
+	var columns []xlsxColumn
+	for col := s.bounds.firstCol; col <= lastCol; col++ {
+		name := strings.TrimSpace(s.raw(s.headerRow, col))
+		if name == "" {
+			// Unnamed columns are named after their column letter
+			letter, err := excelize.ColumnNumberToName(col)
+			if err != nil {
+				return nil, err
+			}
+			name = letter
+		}
+		columns = append(columns, xlsxColumn{name: name, index: col})
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("sheet %s has no columns in the selected range", s.name)
+	}
+
+	samples := make([]xlsxKinds, len(columns))
+	sampled := 0
+	for row := s.headerRow + 1; row <= s.lastRow() && sampled < sampleSize; row++ {
+		if s.emptyRow(row, lastCol) {
+			continue
+		}
+		for i, col := range columns {
+			value, err := s.cell(row, col.index)
+			if err != nil {
+				return nil, err
+			}
+			samples[i].add(value)
+		}
+		sampled++
+	}
+
+	for i := range columns {
+		columns[i].chType = samples[i].chType()
+	}
+	return columns, nil
+}
+
+// nameXLSXColumns renames the columns of a sheet without a header, or with declared names, and
+// applies declared types
+func (f *FlatFileClient) nameXLSXColumns(columns []xlsxColumn) error {
+	declared, err := f.declaredColumns()
+	if err != nil {
+		return err
+	}
+	if declared == nil && f.config.hasHeader() {
+		return nil
+	}
+	if declared != nil && len(declared) != len(columns) {
+		return fmt.Errorf("%d columns are declared but the sheet has %d", len(declared), len(columns))
+	}
+
+	names := generatedColumnNames(len(columns))
+	for i := range columns {
+		columns[i].name = names[i]
+		if declared != nil {
+			columns[i].name = declared[i].Name
+			if declared[i].Type != "" {
+				columns[i].chType = declared[i].Type
+			}
+		}
+	}
+	return nil
+}
+
+// cell returns the typed value of a cell: nil, bool, float64, time.Time or string
+func (s *xlsxSheet) cell(row, col int) (interface{}, error) {
+	raw := s.raw(row, col)
+	if raw == "" {
+		return nil, nil
+	}
+
+	ref, err := excelize.CoordinatesToCellName(col, row)
+	if err != nil {
+		return nil, err
+	}
+	cellType, err := s.book.GetCellType(s.name, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cellType {
+	case excelize.CellTypeBool:
+		return raw == "1" || strings.EqualFold(raw, "TRUE"), nil
+	case excelize.CellTypeError:
+		return nil, nil
+	case excelize.CellTypeDate:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, raw); err == nil {
+				return t, nil
+			}
+		}
+		return raw, nil
+	case excelize.CellTypeNumber, excelize.CellTypeUnset:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return raw, nil
+		}
+		if s.isDateCell(ref) {
+			if t, err := excelTime(n, s.date1904); err == nil {
+				return t, nil
+			}
+		}
+		return n, nil
+	}
+	return raw, nil
+}
+
+// displayed returns a cell's value as Excel shows it, with its number format applied
+func (s *xlsxSheet) displayed(row, col int) string {
+	ref, err := excelize.CoordinatesToCellName(col, row)
+	if err != nil {
+		return s.raw(row, col)
+	}
+	value, err := s.book.GetCellValue(s.name, ref)
+	if err != nil {
+		return s.raw(row, col)
+	}
+	return value
+}
+
+// isDateCell reports whether a numeric cell is formatted as a date or time
+func (s *xlsxSheet) isDateCell(ref string) bool {
+	styleID, err := s.book.GetCellStyle(s.name, ref)
+	if err != nil || styleID == 0 {
+		return false
+	}
+	if isDate, ok := s.dateStyles[styleID]; ok {
+		return isDate
+	}
+
+	isDate := false
+	if style, err := s.book.GetStyle(styleID); err == nil {
+		if style.CustomNumFmt != nil {
+			isDate = isDateNumFmt(*style.CustomNumFmt)
+		} else {
+			isDate = isBuiltInDateNumFmt(style.NumFmt)
+		}
+	}
+	s.dateStyles[styleID] = isDate
+	return isDate
+}
+
+// isBuiltInDateNumFmt reports whether a built-in number format ID is a date or time format,
+// including the locale-specific ones
+func isBuiltInDateNumFmt(id int) bool {
+	return (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58)
+}
+
+// numFmtLiterals matches the quoted text, escapes and bracketed colors or locales of a number format
+var numFmtLiterals = regexp.MustCompile(`"[^"]*"|\\.|\[[^\]]*\]`)
+
+// isDateNumFmt reports whether a custom number format code formats dates or times
+func isDateNumFmt(code string) bool {
+	code = strings.ToLower(numFmtLiterals.ReplaceAllString(code, ""))
+	return strings.ContainsAny(code, "ydhs")
+}
+
+// xlsxKinds tallies the kinds of value sampled from a column
+type xlsxKinds struct {
+	nulls, bools, ints, floats, dates, dateTimes, strings int
+}
+
+// add records the kind of one cell value
+func (k *xlsxKinds) add(value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		k.nulls++
+	case bool:
+		k.bools++
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			k.ints++
+		} else {
+			k.floats++
+		}
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 && v.Nanosecond() == 0 {
+			k.dates++
+		} else {
+			k.dateTimes++
+		}
+	default:
+		k.strings++
+	}
+}
+
+// chType picks the ClickHouse type holding every sampled kind, falling back to String
+func (k xlsxKinds) chType() string {
+	var chType string
+	switch {
+	case k.strings > 0:
+		chType = "String"
+	case k.bools > 0:
+		chType = "Bool"
+		if k.ints+k.floats+k.dates+k.dateTimes > 0 {
+			chType = "String"
+		}
+	case k.dates+k.dateTimes > 0:
+		chType = "Date32"
+		if k.dateTimes > 0 {
+			chType = "DateTime64(3)"
+		}
+		if k.ints+k.floats > 0 {
+			chType = "String"
+		}
+	case k.floats > 0:
+		chType = "Float64"
+	case k.ints > 0:
+		chType = "Int64"
+	default:
+		chType = "String"
+	}
+
+	if k.nulls > 0 {
+		return nullableType(chType)
+	}
+	return chType
+}
+
+// value converts a cell to the Go type of chType. Cells that do not fit are passed on as Excel
+// displays them, for the target to parse.
+func (s *xlsxSheet) value(row, col int, chType string) (interface{}, error) {
+	value, err := s.cell(row, col)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	base, _ := unwrapType(chType)
+	switch v := value.(type) {
+	case bool:
+		if base == "Bool" {
+			return v, nil
+		}
+	case float64:
+		if base == "Int64" && v == math.Trunc(v) {
+			return int64(v), nil
+		}
+		if base == "Float64" {
+			return v, nil
+		}
+	case time.Time:
+		if base == "Date32" || strings.HasPrefix(base, "DateTime64") {
+			return v, nil
+		}
+	case string:
+		if base == "String" {
+			return v, nil
+		}
+	}
+	return s.displayed(row, col), nil
+}
+
+// excelTime converts an Excel serial date to a time, keeping the milliseconds that
+// excelize.ExcelDateToTime rounds away
+func excelTime(serial float64, date1904 bool) (time.Time, error) {
+	days := math.Floor(serial)
+	t, err := excelize.ExcelDateToTime(days, date1904)
+	if err != nil {
+		return t, err
+	}
+	ms := math.Round((serial - days) * 24 * 60 * 60 * 1000)
+	return t.Add(time.Duration(ms) * time.Millisecond), nil
+}
+
+// xlsxSchema returns the typed columns of the configured sheet and range
+func (f *FlatFileClient) xlsxSchema() ([]Column, error) {
+	sheet, err := f.openXLSXSheet()
+	if err != nil {
+		return nil, err
+	}
+	defer sheet.Close()
+
+	columns, err := sheet.columns(f.config.InferSampleSize)
+	if err == nil {
+		err = f.nameXLSXColumns(columns)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	schema := make([]Column, len(columns))
+	for i, col := range columns {
+		schema[i] = Column{Name: col.name, Type: col.chType}
+	}
+	return schema, nil
+}
+
+// xlsxRecordIterator streams the data rows of a worksheet as record batches
+type xlsxRecordIterator struct {
+	sheet   *xlsxSheet
+	schema  []Column
+	columns []xlsxColumn
+	// row is the next sheet row to read
+	row     int
+	lastRow int
+	lastCol int
+}
+
+// Schema returns the selected columns in selection order
+func (it *xlsxRecordIterator) Schema() []Column {
+	return it.schema
+}
+
+// Next reads up to defaultRecordBatchRows non-empty rows into a batch
+func (it *xlsxRecordIterator) Next() (*RecordBatch, error) {
+	batch := NewRecordBatch(it.schema, defaultRecordBatchRows)
+	for ; batch.Len() < defaultRecordBatchRows && it.row <= it.lastRow; it.row++ {
+		if it.sheet.emptyRow(it.row, it.lastCol) {
+			continue
+		}
+
+		values := make([]interface{}, len(it.columns))
+		for i, col := range it.columns {
+			value, err := it.sheet.value(it.row, col.index, col.chType)
+			if err != nil {
+				return nil, fmt.Errorf("error reading row %d: %w", it.row, err)
+			}
+			values[i] = value
+		}
+		batch.Append(values)
+	}
+
+	if batch.Len() == 0 {
+		return nil, io.EOF
+	}
+	return batch, nil
+}
+
+// Close releases the workbook
+func (it *xlsxRecordIterator) Close() error {
+	return it.sheet.Close()
+}
+
+// streamXLSX returns an iterator over the selected columns of a worksheet, in the order they
+// were selected. Selected columns missing from the header row are skipped.
+func (f *FlatFileClient) streamXLSX(selectedColumns []string) (RecordIterator, error) {
+	sheet, err := f.openXLSXSheet()
+	if err != nil {
+		return nil, err
+	}
+
+	available, err := sheet.columns(f.config.InferSampleSize)
+	if err == nil {
+		err = f.nameXLSXColumns(available)
+	}
+	if err != nil {
+		sheet.Close()
+		return nil, err
+	}
+
+	columns := available
+	if len(selectedColumns) > 0 {
+		columns = nil
+	}
+	for _, name := range selectedColumns {
+		for _, col := range available {
+			if col.name == name {
+				columns = append(columns, col)
+				break
+			}
+		}
+	}
+
+	schema := make([]Column, len(columns))
+	for i, col := range columns {
+		schema[i] = Column{Name: col.name, Type: col.chType}
+	}
+
+	it := &xlsxRecordIterator{
+		sheet:   sheet,
+		schema:  schema,
+		columns: columns,
+		row:     sheet.headerRow + 1,
+		lastRow: sheet.lastRow(),
+		lastCol: sheet.lastCol(),
+	}
+	if f.progress != nil {
+		f.progress.SetTotalRows(int64(max(it.lastRow-sheet.headerRow, 0)))
+	}
+	return it, nil
+}
+
+// sheetSink is a sink that can move on to a new worksheet, so each table of a multi-table
+// export gets a sheet of its own
+type sheetSink interface {
+	RecordSink
+	// StartSheet finishes the current sheet and writes later batches to a new one laid out
+	// by columns, or by the first batch when columns is empty
+	StartSheet(name string, columns []Column) error
+}
+
+// xlsxRecordSink streams record batches into the worksheets of a new workbook, which is
+// written to the output when the sink is closed
+type xlsxRecordSink struct {
+	output  io.WriteCloser
+	book    *excelize.File
+	options FileWriterOptions
+	// sheet is the worksheet being written and sheets the number started so far
+	sheet  string
+	sheets int
+	stream *excelize.StreamWriter
+	// row is the next sheet row to write
+	row     int
+	columns []Column
+	// sourceIndex maps each of columns to its position in the incoming schema, or -1
+	sourceIndex    []int
+	dateStyle      int
+	dateTimeStyle  int
+	wroteWorkbook  bool
+	finishedStream bool
+	// omitHeader starts each sheet with data instead of a header row
+	omitHeader bool
+}
+
+// newXLSXRecordSink returns a sink writing a workbook to output, which it closes when done
+func newXLSXRecordSink(output io.WriteCloser, config XLSXOptions, header bool, options FileWriterOptions) (RecordSink, error) {
+	book := excelize.NewFile()
+	sink := &xlsxRecordSink{output: output, book: book, options: options, omitHeader: !header}
+
+	var err error
+	if sink.dateStyle, err = book.NewStyle(&excelize.Style{NumFmt: 14}); err == nil {
+		sink.dateTimeStyle, err = book.NewStyle(&excelize.Style{NumFmt: 22})
+	}
+	if err == nil {
+		name := config.Sheet
+		if name == "" {
+			name = book.GetSheetName(0)
+		}
+		err = sink.StartSheet(name, options.Columns)
+	}
+	if err != nil {
+		book.Close()
+		output.Close()
+		return nil, err
+	}
+	return sink, nil
+}
+
+// xlsxSheetName makes a valid worksheet name: at most 31 characters and none of []:*?/\
+func xlsxSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > excelize.MaxSheetNameLength {
+		name = string(runes[:excelize.MaxSheetNameLength])
+	}
+	if strings.TrimSpace(name) == "" {
+		name = "Sheet"
+	}
+	return name
+}
+
+// StartSheet finishes the current sheet and starts a new one. A sheet nothing was written to
+// yet, such as the one a new workbook comes with, is renamed and reused instead.
+func (s *xlsxRecordSink) StartSheet(name string, columns []Column) error {
+	name = xlsxSheetName(name)
+	if s.sheets == 0 || (s.stream == nil && !s.finishedStream) {
+		current := s.sheet
+		if s.sheets == 0 {
+			current = s.book.GetSheetName(0)
+			s.sheets++
+		}
+		if err := s.book.SetSheetName(current, name); err != nil {
+			return fmt.Errorf("failed to name sheet %s: %w", name, err)
+		}
+	} else {
+		if err := s.finishSheet(); err != nil {
+			return err
+		}
+		if _, err := s.book.NewSheet(name); err != nil {
+			return fmt.Errorf("failed to add sheet %s: %w", name, err)
+		}
+		s.sheets++
+	}
+
+	s.sheet = name
+	s.options.Columns = columns
+	s.columns = nil
+	s.stream = nil
+	s.finishedStream = false
+	return nil
+}
+
+// resolveColumns settles the sheet's columns from the configured columns and the first batch,
+// and writes the header row
+func (s *xlsxRecordSink) resolveColumns(schema []Column) error {
+	columns := append([]Column(nil), s.options.Columns...)
+	if len(columns) == 0 {
+		columns = append(columns, schema...)
+	}
+	s.sourceIndex = make([]int, len(columns))
+	for i := range columns {
+		s.sourceIndex[i] = columnIndex(schema, columns[i].Name)
+		if columns[i].Type == "" && s.sourceIndex[i] >= 0 {
+			columns[i].Type = schema[s.sourceIndex[i]].Type
+		}
+	}
+	s.columns = columns
+
+	stream, err := s.book.NewStreamWriter(s.sheet)
+	if err != nil {
+		return fmt.Errorf("failed to write sheet %s: %w", s.sheet, err)
+	}
+	s.stream = stream
+
+	s.row = 1
+	if s.omitHeader {
+		return nil
+	}
+	header := make([]interface{}, len(columns))
+	for i, col := range columns {
+		header[i] = col.Name
+	}
+	if err := stream.SetRow("A1", header); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	s.row = 2
+	return nil
+}
+
+// WriteBatch writes a batch's rows in column order
+func (s *xlsxRecordSink) WriteBatch(batch *RecordBatch) error {
+	if s.stream == nil {
+		if err := s.resolveColumns(batch.Schema); err != nil {
+			return err
+		}
+	}
+
+	cells := make([]interface{}, len(s.columns))
+	for _, source := range batch.Rows {
+		if s.row > excelize.TotalRows {
+			return fmt.Errorf("sheet %s is full: Excel sheets hold at most %d rows", s.sheet, excelize.TotalRows)
+		}
+
+		for i, col := range s.columns {
+			var value interface{}
+			var sourceType string
+			if idx := s.sourceIndex[i]; idx >= 0 && idx < len(source) {
+				value, sourceType = source[idx], batch.Schema[idx].Type
+			}
+			if col.Type != "" {
+				cast, err := castValue(value, sourceType, col, s.options.NullOnParseError)
+				if err != nil {
+					return err
+				}
+				value, sourceType = cast, col.Type
+			}
+			cells[i] = s.cellValue(value, sourceType)
+		}
+
+		ref, err := excelize.CoordinatesToCellName(1, s.row)
+		if err != nil {
+			return err
+		}
+		if err := s.stream.SetRow(ref, cells); err != nil {
+			return fmt.Errorf("failed to write row %d: %w", s.row, err)
+		}
+		s.row++
+	}
+	return nil
+}
+
+// cellValue converts a ClickHouse value of chType into a value Excel can store natively
+func (s *xlsxRecordSink) cellValue(value interface{}, chType string) interface{} {
+	switch v := value.(type) {
+	case string:
+		// Decimals parsed from text stay strings for the driver; Excel stores them as numbers
+		if base, _ := unwrapType(chType); strings.HasPrefix(base, "Decimal") {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+		return v
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32:
+		return v
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+		return v
+	case time.Time:
+		base, _ := unwrapType(chType)
+		if base == "Date" || base == "Date32" {
+			return excelize.Cell{StyleID: s.dateStyle, Value: v}
+		}
+		return excelize.Cell{StyleID: s.dateTimeStyle, Value: v}
+	case decimal.Decimal:
+		return v.InexactFloat64()
+	case *big.Int:
+		return v.String()
+	case []byte:
+		return string(v)
+	}
+
+	// Arrays, maps and tuples are stored as JSON text
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if data, err := json.Marshal(toJSONValue(value, chType)); err == nil {
+			return string(data)
+		}
+	}
+	return formatFlatValue(value, chType)
+}
+
+// finishSheet flushes the current sheet, writing just a header when no rows arrived
+func (s *xlsxRecordSink) finishSheet() error {
+	if s.sheets == 0 || s.finishedStream {
+		return nil
+	}
+	if s.stream == nil {
+		if err := s.resolveColumns(nil); err != nil {
+			return err
+		}
+	}
+	if err := s.stream.Flush(); err != nil {
+		return fmt.Errorf("failed to finish sheet %s: %w", s.sheet, err)
+	}
+	s.finishedStream = true
+	return nil
+}
+
+// Flush finishes the current sheet
+func (s *xlsxRecordSink) Flush() error {
+	return s.finishSheet()
+}
+
+// Close writes the workbook and closes the underlying file
+func (s *xlsxRecordSink) Close() error {
+	err := s.finishSheet()
+	if err == nil && !s.wroteWorkbook {
+		s.wroteWorkbook = true
+		if werr := s.book.Write(s.output); werr != nil {
+			err = fmt.Errorf("failed to write workbook: %w", werr)
+		}
+	}
+	s.book.Close()
+	if cerr := s.output.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
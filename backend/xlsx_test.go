@@ -0,0 +1,203 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestXLSXRoundTrip(t *testing.T) {
+	schema := []Column{
+		{Name: "id", Type: "Int64"},
+		{Name: "name", Type: "String"},
+		{Name: "score", Type: "Nullable(Float64)"},
+		{Name: "ok", Type: "Bool"},
+		{Name: "day", Type: "Date"},
+		{Name: "at", Type: "DateTime64(3)"},
+		{Name: "tags", Type: "Array(String)"},
+		{Name: "price", Type: "Decimal(18, 2)"},
+	}
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6e6, time.UTC)
+	rows := [][]interface{}{
+		{int64(1), "ada", 1.5, true, day, at, []interface{}{"a", "b"}, "12.50"},
+		{int64(-2), "", nil, false, day, at.Add(999 * time.Millisecond), []interface{}{}, "0.01"},
+	}
+	// Cells are typed by what they hold: empty text is a blank cell and decimals are numbers
+	wantSchema := []Column{
+		{Name: "id", Type: "Int64"},
+		{Name: "name", Type: "Nullable(String)"},
+		{Name: "score", Type: "Nullable(Float64)"},
+		{Name: "ok", Type: "Bool"},
+		{Name: "day", Type: "Date32"},
+		{Name: "at", Type: "DateTime64(3)"},
+		{Name: "tags", Type: "String"},
+		{Name: "price", Type: "Float64"},
+	}
+	want := [][]interface{}{
+		{int64(1), "ada", 1.5, true, day, at, `["a","b"]`, 12.5},
+		{int64(-2), nil, nil, false, day, at.Add(999 * time.Millisecond), `[]`, 0.01},
+	}
+
+	tests := []struct {
+		name    string
+		options XLSXOptions
+	}{
+		{name: "default sheet"},
+		{name: "named sheet", options: XLSXOptions{Sheet: "Export"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := FlatFileConfig{FileName: filepath.Join(t.TempDir(), "rows.xlsx"), XLSX: tt.options}
+			writeFlatFile(t, config, schema, rows)
+
+			gotSchema, got := readFlatFile(t, config, nil)
+			if !reflect.DeepEqual(gotSchema, wantSchema) {
+				t.Errorf("schema = %v, want %v", gotSchema, wantSchema)
+			}
+			assertRows(t, got, want)
+		})
+	}
+}
+
+func TestXLSXSheetPerTable(t *testing.T) {
+	config := FlatFileConfig{FileName: filepath.Join(t.TempDir(), "tables.xlsx")}
+	sink, err := NewFlatFileClient(config).NewWriter(FileWriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheets := sink.(sheetSink)
+
+	tables := []struct {
+		name   string
+		schema []Column
+		rows   [][]interface{}
+	}{
+		{"orders", []Column{{Name: "id", Type: "Int64"}, {Name: "total", Type: "Float64"}}, [][]interface{}{{int64(1), 9.5}, {int64(2), 3.25}}},
+		{"customers", []Column{{Name: "name", Type: "String"}}, [][]interface{}{{"ada"}}},
+		{"empty", []Column{{Name: "id", Type: "Int64"}}, nil},
+	}
+	for _, table := range tables {
+		if err := sheets.StartSheet(table.name, table.schema); err != nil {
+			t.Fatal(err)
+		}
+		batch := NewRecordBatch(table.schema, len(table.rows))
+		for _, row := range table.rows {
+			batch.Append(row)
+		}
+		if err := sink.WriteBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range tables {
+		sheetConfig := config
+		sheetConfig.XLSX.Sheet = table.name
+		gotSchema, got := readFlatFile(t, sheetConfig, nil)
+		if !reflect.DeepEqual(columnNames(gotSchema), columnNames(table.schema)) {
+			t.Errorf("sheet %s has columns %v, want %v", table.name, gotSchema, table.schema)
+		}
+		assertRows(t, got, table.rows)
+	}
+}
+
+func TestXLSXRangeAndHeaderRow(t *testing.T) {
+	// A report with a title, a blank row and a notes column beside the table
+	book := excelize.NewFile()
+	cells := map[string]interface{}{
+		"A1": "Quarterly report",
+		"B3": "region", "C3": "sales", "D3": "notes",
+		"B4": "north", "C4": 10, "D4": "x",
+		"B5": "south", "C5": 20.5,
+		"B6": "east", "C6": 30,
+	}
+	for cell, value := range cells {
+		if err := book.SetCellValue("Sheet1", cell, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := filepath.Join(t.TempDir(), "report.xlsx")
+	if err := book.SaveAs(name); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		options    XLSXOptions
+		wantSchema []Column
+		want       [][]interface{}
+	}{
+		{
+			name:       "cell range",
+			options:    XLSXOptions{Range: "B3:C5"},
+			wantSchema: []Column{{Name: "region", Type: "String"}, {Name: "sales", Type: "Float64"}},
+			want:       [][]interface{}{{"north", 10.0}, {"south", 20.5}},
+		},
+		{
+			name:       "column span with header row",
+			options:    XLSXOptions{Range: "B:C", HeaderRow: 3},
+			wantSchema: []Column{{Name: "region", Type: "String"}, {Name: "sales", Type: "Float64"}},
+			want:       [][]interface{}{{"north", 10.0}, {"south", 20.5}, {"east", 30.0}},
+		},
+		{
+			name:       "whole numbers",
+			options:    XLSXOptions{Range: "C3:C4"},
+			wantSchema: []Column{{Name: "sales", Type: "Int64"}},
+			want:       [][]interface{}{{int64(10)}},
+		},
+		{
+			name:       "sparse column",
+			options:    XLSXOptions{Range: "B3:D6"},
+			wantSchema: []Column{{Name: "region", Type: "String"}, {Name: "sales", Type: "Float64"}, {Name: "notes", Type: "Nullable(String)"}},
+			want:       [][]interface{}{{"north", 10.0, "x"}, {"south", 20.5, nil}, {"east", 30.0, nil}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := FlatFileConfig{FileName: name, XLSX: tt.options}
+			gotSchema, got := readFlatFile(t, config, nil)
+			if !reflect.DeepEqual(gotSchema, tt.wantSchema) {
+				t.Errorf("schema = %v, want %v", gotSchema, tt.wantSchema)
+			}
+			assertRows(t, got, tt.want)
+		})
+	}
+}
+
+func TestParseXLSXRange(t *testing.T) {
+	tests := []struct {
+		spec      string
+		want      xlsxRange
+		wantError bool
+	}{
+		{spec: "", want: xlsxRange{firstCol: 1, firstRow: 1}},
+		{spec: "B2:F500", want: xlsxRange{firstCol: 2, lastCol: 6, firstRow: 2, lastRow: 500}},
+		{spec: "$B$2:$F$500", want: xlsxRange{firstCol: 2, lastCol: 6, firstRow: 2, lastRow: 500}},
+		{spec: "F500:B2", want: xlsxRange{firstCol: 2, lastCol: 6, firstRow: 2, lastRow: 500}},
+		{spec: "B:F", want: xlsxRange{firstCol: 2, lastCol: 6, firstRow: 1}},
+		{spec: "C3", want: xlsxRange{firstCol: 3, lastCol: 3, firstRow: 3, lastRow: 3}},
+		{spec: "B2:", wantError: true},
+		{spec: "2:5", wantError: true},
+	}
+	for _, tt := range tests {
+		got, err := parseXLSXRange(tt.spec)
+		if tt.wantError {
+			if err == nil {
+				t.Errorf("parseXLSXRange(%q) = %+v, want an error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseXLSXRange(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+}
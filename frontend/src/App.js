@@ -39,10 +39,13 @@ function App() {
   const [status, setStatus] = useState('idle'); // idle, connecting, fetching, ingesting, completed, error
   const [message, setMessage] = useState('');
   const [recordCount, setRecordCount] = useState(0);
+  const [jobId, setJobId] = useState('');
+  const [progress, setProgress] = useState(null);
   const [isMultiTableJoin, setIsMultiTableJoin] = useState(false);
 
   // Target table name
   const [targetTableName, setTargetTableName] = useState('');
+  const [writeMode, setWriteMode] = useState('append');
 
   // Update source or target
   const handleSourceChange = (e) => {
@@ -299,6 +302,7 @@ function App() {
     try {
       setStatus('ingesting');
       setMessage('Starting data ingestion...');
+      setProgress(null);
 
       const requestBody = {
         source,
@@ -307,6 +311,7 @@ function App() {
         flatFileConfig,
         tableName,
         targetTableName,
+        writeMode,
         selectedTables,
         joinCondition,
         selectedColumns,
@@ -324,9 +329,9 @@ function App() {
       const data = await response.json();
 
       if (data.success) {
-        setStatus('completed');
-        setRecordCount(data.count);
-        setMessage(`Ingestion completed successfully. ${data.count} records processed.`);
+        setJobId(data.data.id);
+        setMessage(`Ingestion job ${data.data.id} started...`);
+        await watchIngestionJob(data.data.id);
       } else {
         setStatus('error');
         setMessage(`Ingestion error: ${data.error}`);
@@ -337,6 +342,67 @@ function App() {
     }
   };
 
+  // Follow an ingestion job's progress events until it reaches a terminal state
+  const watchIngestionJob = (id) => new Promise((resolve) => {
+    const events = new EventSource(`http://localhost:8080/api/jobs/${id}/events`);
+
+    const update = (job) => {
+      setProgress(job.progress || null);
+      setRecordCount(job.rowsProcessed);
+    };
+
+    events.addEventListener('progress', (e) => {
+      const job = JSON.parse(e.data);
+      update(job);
+      setMessage(`Ingestion ${job.state}... ${job.rowsProcessed} records processed.`);
+    });
+
+    events.addEventListener('done', (e) => {
+      const job = JSON.parse(e.data);
+      update(job);
+      events.close();
+
+      if (job.state === 'succeeded') {
+        setStatus('completed');
+        setMessage(`Ingestion completed successfully. ${job.rowsProcessed} records processed.`);
+      } else if (job.state === 'cancelled') {
+        setStatus('error');
+        setMessage(`Ingestion cancelled after ${job.rowsProcessed} records.`);
+      } else {
+        setStatus('error');
+        setMessage(`Ingestion error: ${job.error}`);
+      }
+      resolve();
+    });
+
+    events.onerror = () => {
+      events.close();
+      setStatus('error');
+      setMessage('Lost connection to the ingestion progress stream.');
+      resolve();
+    };
+  });
+
+  // Cancel the running ingestion job
+  const handleCancelIngestion = async () => {
+    if (!jobId) {
+      return;
+    }
+
+    try {
+      const response = await fetch(`http://localhost:8080/api/jobs/${jobId}/cancel`, {
+        method: 'POST',
+      });
+      const data = await response.json();
+
+      if (!data.success) {
+        setMessage(`Cancel error: ${data.error}`);
+      }
+    } catch (error) {
+      setMessage(`Error: ${error.message}`);
+    }
+  };
+
   // Toggle multi-table join mode
   const handleToggleJoinMode = () => {
     setIsMultiTableJoin(!isMultiTableJoin);
@@ -709,6 +775,20 @@ function App() {
                       Enter the name of the table where data will be ingested. Table will be created if it doesn't exist.
                     </p>
                   </div>
+
+                  <div className="col-span-2">
+                    <label className="block text-sm font-medium text-gray-700">If Table Exists</label>
+                    <select
+                      value={writeMode}
+                      onChange={(e) => setWriteMode(e.target.value)}
+                      className="mt-1 block w-full rounded-md border-gray-300 shadow-sm focus:border-indigo-300 focus:ring focus:ring-indigo-200 focus:ring-opacity-50"
+                    >
+                      <option value="append">Append rows</option>
+                      <option value="fail">Fail</option>
+                      <option value="truncate">Truncate, then insert</option>
+                      <option value="recreate">Drop and recreate</option>
+                    </select>
+                  </div>
                 </div>
               )}
 
@@ -827,6 +907,14 @@ function App() {
                 >
                   Start Ingestion
                 </button>
+                {status === 'ingesting' && jobId && (
+                  <button
+                    onClick={handleCancelIngestion}
+                    className="mt-2 w-full inline-flex justify-center py-2 px-4 border border-transparent shadow-sm text-sm font-medium rounded-md text-white bg-red-600 hover:bg-red-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-red-500"
+                  >
+                    Cancel Ingestion
+                  </button>
+                )}
               </div>
             </div>
           )}
@@ -879,6 +967,25 @@ function App() {
                   <div className="mt-1 text-sm text-gray-700">
                     {message}
                   </div>
+                  {progress && (status === 'ingesting' || status === 'completed') && (
+                    <div className="mt-2">
+                      {progress.percent !== undefined && (
+                        <div className="w-full bg-gray-200 rounded-full h-2.5">
+                          <div
+                            className="bg-green-600 h-2.5 rounded-full"
+                            style={{ width: `${Math.min(progress.percent, 100).toFixed(1)}%` }}
+                          ></div>
+                        </div>
+                      )}
+                      <div className="mt-1 text-xs text-gray-600">
+                        Read {progress.rowsRead} rows · Written {progress.rowsWritten} rows ·{' '}
+                        {(progress.bytesProcessed / (1024 * 1024)).toFixed(1)} MB
+                        {progress.currentBatch > 0 && ` · Batch ${progress.currentBatch}`}
+                        {progress.etaSeconds !== undefined && status === 'ingesting' &&
+                          ` · ETA ${Math.ceil(progress.etaSeconds)}s`}
+                      </div>
+                    </div>
+                  )}
                   {status === 'completed' && recordCount > 0 && (
                     <div className="mt-2 text-sm font-semibold text-green-700">
                       Total Records Processed: {recordCount}